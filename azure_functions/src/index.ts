@@ -1,5 +0,0 @@
-import { app } from '@azure/functions';
-
-app.setup({
-    enableHttpStream: true,
-});
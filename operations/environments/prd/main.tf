@@ -30,5 +30,4 @@ module "template" {
 
   environment = "prd"
   deployer_id = "f5feabe7-5d37-40ba-94f2-e5c0760b4561" //github app registration in Flexion Azure Entra
-  cron        = "* * * 30 Feb *"                       //run every second of February 30th, which never happens and is the equivalent of never running
 }
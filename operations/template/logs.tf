@@ -86,15 +86,3 @@ resource "azurerm_monitor_diagnostic_setting" "prelive_slot_to_logs" {
     category = "AppServicePlatformLogs"
   }
 }
-
-resource "azurerm_monitor_diagnostic_setting" "functionapp_to_logs" {
-  name                       = "rs-sftp-function-app-to-logs-${var.environment}"
-  target_resource_id         = azurerm_linux_function_app.polling_trigger_function_app.id
-  log_analytics_workspace_id = azurerm_log_analytics_workspace.logs_workspace.id
-
-  log_analytics_destination_type = "Dedicated"
-
-  enabled_log {
-    category = "FunctionAppLogs"
-  }
-}
@@ -17,3 +17,33 @@ resource "azurerm_storage_queue" "polling_trigger_dead_letter_queue" {
   name                 = "polling-trigger-dead-letter-queue"
   storage_account_name = azurerm_storage_account.storage.name
 }
+
+resource "azurerm_storage_queue" "submission_status_queue" {
+  name                 = "submission-status-queue"
+  storage_account_name = azurerm_storage_account.storage.name
+}
+
+resource "azurerm_storage_queue" "submission_status_dead_letter_queue" {
+  name                 = "submission-status-dead-letter-queue"
+  storage_account_name = azurerm_storage_account.storage.name
+}
+
+resource "azurerm_storage_queue" "zip_reprocess_queue" {
+  name                 = "zip-reprocess-queue"
+  storage_account_name = azurerm_storage_account.storage.name
+}
+
+resource "azurerm_storage_queue" "zip_reprocess_dead_letter_queue" {
+  name                 = "zip-reprocess-dead-letter-queue"
+  storage_account_name = azurerm_storage_account.storage.name
+}
+
+resource "azurerm_storage_queue" "acknowledgement_queue" {
+  name                 = "acknowledgement-queue"
+  storage_account_name = azurerm_storage_account.storage.name
+}
+
+resource "azurerm_storage_queue" "acknowledgement_dead_letter_queue" {
+  name                 = "acknowledgement-dead-letter-queue"
+  storage_account_name = azurerm_storage_account.storage.name
+}
@@ -7,9 +7,3 @@ variable "deployer_id" {
   type     = string
   nullable = false
 }
-
-
-variable "cron" {
-  type     = string
-  nullable = false
-}
\ No newline at end of file
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// uploadAudience is the `aud` partners put in their tokens, so a token meant for another service doesn't work here
+const uploadAudience = "reportstream-sftp-ingestion"
+
+// maxTokenLifetime stops a leaked token being useful for long, like the five minute tokens we send to ReportStream
+const maxTokenLifetime = time.Hour
+
+var errMissingBearerToken = errors.New("missing bearer token")
+
+// authenticate checks the request has a bearer token the partner signed with the private key for the public key we
+// have for them in Key Vault. The token's issuer is the partner ID, and it has to expire within maxTokenLifetime. We
+// read the public key on every request so rotated keys take effect straight away
+func (receiver UploadHandler) authenticate(request *http.Request, partnerId string) error {
+	token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return errMissingBearerToken
+	}
+
+	publicKeyName := partnerId + "-api-public-key-" + utils.EnvironmentName() // pragma: allowlist secret
+	publicKeyPem, err := receiver.credentialGetter.GetSecret(publicKeyName)
+	if err != nil {
+		slog.Error("Unable to get partner's upload API public key", slog.String("KeyName", publicKeyName), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(publicKeyPem))
+	if err != nil {
+		slog.Error("Unable to parse partner's upload API public key", slog.String("KeyName", publicKeyName), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return publicKey, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodRS384.Alg(), jwt.SigningMethodRS512.Alg()}),
+		jwt.WithIssuer(partnerId),
+		jwt.WithAudience(uploadAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return err
+	}
+
+	if claims.ExpiresAt.Sub(time.Now()) > maxTokenLifetime {
+		return errors.New("token expires more than an hour from now")
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/rsa"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_authenticate_TokenSignedByPartner_ReturnsNil(t *testing.T) {
+	privateKey := newPrivateKey(t)
+	uploadHandler := newAuthTestHandler(t, privateKey)
+
+	err := uploadHandler.authenticate(newAuthRequest(newToken(t, privateKey, partnerId, 5*time.Minute)), partnerId)
+
+	assert.NoError(t, err)
+}
+
+func Test_authenticate_TokenIssuedByAnotherPartner_ReturnsError(t *testing.T) {
+	privateKey := newPrivateKey(t)
+	uploadHandler := newAuthTestHandler(t, privateKey)
+
+	err := uploadHandler.authenticate(newAuthRequest(newToken(t, privateKey, "ca-phl", 5*time.Minute)), partnerId)
+
+	assert.Error(t, err)
+}
+
+func Test_authenticate_TokenHasExpired_ReturnsError(t *testing.T) {
+	privateKey := newPrivateKey(t)
+	uploadHandler := newAuthTestHandler(t, privateKey)
+
+	err := uploadHandler.authenticate(newAuthRequest(newToken(t, privateKey, partnerId, -time.Minute)), partnerId)
+
+	assert.Error(t, err)
+}
+
+func Test_authenticate_TokenLastsTooLong_ReturnsError(t *testing.T) {
+	privateKey := newPrivateKey(t)
+	uploadHandler := newAuthTestHandler(t, privateKey)
+
+	err := uploadHandler.authenticate(newAuthRequest(newToken(t, privateKey, partnerId, 24*time.Hour)), partnerId)
+
+	assert.ErrorContains(t, err, "more than an hour")
+}
+
+func Test_authenticate_NoBearerToken_ReturnsError(t *testing.T) {
+	uploadHandler := newAuthTestHandler(t, newPrivateKey(t))
+
+	err := uploadHandler.authenticate(httptest.NewRequest("GET", "/partners/flexion/files/"+trackingId, nil), partnerId)
+
+	assert.ErrorContains(t, err, "missing bearer token")
+}
+
+func Test_authenticate_UnableToGetPublicKey_ReturnsError(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", apiPublicKeyName).Return("", errors.New("key vault is down"))
+	uploadHandler := UploadHandler{credentialGetter: mockCredentialGetter}
+
+	privateKey := newPrivateKey(t)
+	err := uploadHandler.authenticate(newAuthRequest(newToken(t, privateKey, partnerId, 5*time.Minute)), partnerId)
+
+	assert.ErrorContains(t, err, "key vault is down")
+}
+
+func newAuthTestHandler(t *testing.T, privateKey *rsa.PrivateKey) UploadHandler {
+	return UploadHandler{credentialGetter: newMockCredentialGetter(t, privateKey)}
+}
+
+func newAuthRequest(token string) *http.Request {
+	request := httptest.NewRequest("GET", "/partners/flexion/files/"+trackingId, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	return request
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/orchestration"
+	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+var errWrongApiKey = errors.New("bearer token isn't the polling API key")
+
+// PollHandler lets us poll a partner's SFTP server on demand, outside their polling schedule. It's for us rather than
+// partners, so it uses our own API key instead of a partner's token
+type PollHandler struct {
+	credentialGetter secrets.CredentialGetter
+	pollingQueue     usecases.PollingQueue
+}
+
+type pollResponse struct {
+	PartnerId string `json:"partnerId"`
+}
+
+func NewPollHandler() (PollHandler, error) {
+	credentialGetter, err := secrets.GetCredentialGetter()
+	if err != nil {
+		slog.Error("Unable to initialize credential getter", slog.Any(utils.ErrorKey, err))
+		return PollHandler{}, err
+	}
+
+	pollingQueue, err := orchestration.NewPollingQueueClient()
+	if err != nil {
+		slog.Error("Failed to create polling queue client", slog.Any(utils.ErrorKey, err))
+		return PollHandler{}, err
+	}
+
+	return PollHandler{credentialGetter: credentialGetter, pollingQueue: pollingQueue}, nil
+}
+
+// Register adds the API's routes to mux
+func (receiver PollHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /partners/{partnerId}/polls", receiver.triggerPoll)
+}
+
+// triggerPoll queues a poll like the scheduler does, so it runs on whichever replica picks up the message
+func (receiver PollHandler) triggerPoll(response http.ResponseWriter, request *http.Request) {
+	partnerId := request.PathValue("partnerId")
+
+	err := receiver.authenticate(request)
+	if err != nil {
+		slog.Warn("Poll request isn't authenticated", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId), slog.String("remoteAddress", request.RemoteAddr))
+		writeJson(response, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+		return
+	}
+
+	partnerConfig := config.Configs[partnerId]
+	if partnerConfig == nil || !partnerConfig.PartnerSettings.IsActive {
+		writeJson(response, http.StatusNotFound, errorResponse{Error: "partner not found"})
+		return
+	}
+
+	err = receiver.pollingQueue.QueuePoll(partnerId)
+	if err != nil {
+		writeJson(response, http.StatusInternalServerError, errorResponse{Error: "failed to queue poll"})
+		return
+	}
+
+	slog.Info("Queued on-demand poll", slog.String("partnerId", partnerId))
+	writeJson(response, http.StatusAccepted, pollResponse{PartnerId: partnerId})
+}
+
+// authenticate checks the request's bearer token is our polling API key
+func (receiver PollHandler) authenticate(request *http.Request) error {
+	token, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return errMissingBearerToken
+	}
+
+	apiKeyName := "polling-api-key-" + utils.EnvironmentName() // pragma: allowlist secret
+	apiKey, err := receiver.credentialGetter.GetSecret(apiKeyName)
+	if err != nil {
+		slog.Error("Unable to get polling API key", slog.String("KeyName", apiKeyName), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	// Secrets kept in files, like our local ones, often end with a newline
+	apiKey = strings.TrimSpace(apiKey)
+	if apiKey == "" || subtle.ConstantTimeCompare([]byte(token), []byte(apiKey)) != 1 {
+		return errWrongApiKey
+	}
+	return nil
+}
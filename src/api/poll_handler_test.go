@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config/configtest"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"testing"
+)
+
+const pollingApiKeyName = "polling-api-key-local" // pragma: allowlist secret
+
+func Test_triggerPoll_QueuesPoll(t *testing.T) {
+	setUpUploadApi(t, false)
+	mockPollingQueue := &MockPollingQueue{}
+	mockPollingQueue.On("QueuePoll", partnerId).Return(nil)
+
+	response := sendRequest(t, newPollTestHandler(mockPollingQueue), http.MethodPost, "/partners/flexion/polls", "", "our-api-key")
+
+	assert.Equal(t, http.StatusAccepted, response.Code)
+	mockPollingQueue.AssertCalled(t, "QueuePoll", partnerId)
+}
+
+func Test_triggerPoll_WrongApiKey_ReturnsUnauthorized(t *testing.T) {
+	setUpUploadApi(t, false)
+	mockPollingQueue := &MockPollingQueue{}
+
+	response := sendRequest(t, newPollTestHandler(mockPollingQueue), http.MethodPost, "/partners/flexion/polls", "", "not-our-api-key")
+
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	mockPollingQueue.AssertNotCalled(t, "QueuePoll", mock.Anything)
+}
+
+func Test_triggerPoll_PartnerIsNotActive_ReturnsNotFound(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{partnerId: {}})
+	mockPollingQueue := &MockPollingQueue{}
+
+	response := sendRequest(t, newPollTestHandler(mockPollingQueue), http.MethodPost, "/partners/flexion/polls", "", "our-api-key")
+
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	mockPollingQueue.AssertNotCalled(t, "QueuePoll", mock.Anything)
+}
+
+func Test_triggerPoll_QueueIsDown_ReturnsServerError(t *testing.T) {
+	setUpUploadApi(t, false)
+	mockPollingQueue := &MockPollingQueue{}
+	mockPollingQueue.On("QueuePoll", partnerId).Return(errors.New("queue is down"))
+
+	response := sendRequest(t, newPollTestHandler(mockPollingQueue), http.MethodPost, "/partners/flexion/polls", "", "our-api-key")
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+}
+
+func newPollTestHandler(pollingQueue *MockPollingQueue) *http.ServeMux {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", pollingApiKeyName).Return("our-api-key\n", nil)
+
+	mux := http.NewServeMux()
+	PollHandler{credentialGetter: mockCredentialGetter, pollingQueue: pollingQueue}.Register(mux)
+	return mux
+}
+
+type MockPollingQueue struct {
+	mock.Mock
+}
+
+func (receiver *MockPollingQueue) QueuePoll(partnerId string) error {
+	args := receiver.Called(partnerId)
+	return args.Error(0)
+}
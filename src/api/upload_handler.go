@@ -0,0 +1,248 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/orchestration"
+	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
+	"github.com/CDCgov/reportstream-sftp-ingestion/sftp"
+	"github.com/CDCgov/reportstream-sftp-ingestion/storage"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// maxUploadBytes matches what we accept on our SFTP server
+const maxUploadBytes = 1024 * 1024 * 1024
+
+// Statuses of an uploaded file. An upload's overall status is `failed` if any of its files failed, then `processing`
+// if any are still on their way, and otherwise `sent`
+const (
+	UploadStatusProcessing = "processing"
+	UploadStatusUnzipped   = "unzipped"
+	UploadStatusSent       = "sent"
+	UploadStatusFailed     = "failed"
+)
+
+// uploadFolders are the partner's folders an uploaded file can be in, and what being there means. Each has a folder
+// named after the upload's tracking ID, see sftp.SftpHandler.ImportUpload
+var uploadFolders = []struct {
+	folder string
+	status string
+}{
+	{utils.MessageStartingFolderPath, UploadStatusProcessing},
+	{utils.UnzipFolder, UploadStatusProcessing},
+	{path.Join(utils.UnzipFolder, utils.SuccessFolder), UploadStatusUnzipped},
+	{path.Join(utils.UnzipFolder, utils.FailureFolder), UploadStatusFailed},
+	{utils.SuccessFolder, UploadStatusSent},
+	{path.Join(utils.SuccessFolder, utils.DeliveryFailureFolder), UploadStatusFailed},
+	{utils.FailureFolder, UploadStatusFailed},
+}
+
+// FileImporter is what the upload API needs to send an uploaded file through the same `unzip` and `import` routing as
+// the files we copy from partners' SFTP servers
+type FileImporter interface {
+	ImportUpload(trackingId string, fileName string, fileBytes []byte) error
+}
+
+// UploadHandler is our HTTPS API for partners who can't use SFTP. Partners upload a file, get a tracking ID back, and
+// use it to check on the file later
+type UploadHandler struct {
+	credentialGetter secrets.CredentialGetter
+	blobHandler      usecases.BlobHandler
+	newFileImporter  func(partnerId string) (FileImporter, error)
+}
+
+type uploadResponse struct {
+	TrackingId string `json:"trackingId"`
+	StatusUrl  string `json:"statusUrl"`
+}
+
+type statusResponse struct {
+	TrackingId string       `json:"trackingId"`
+	Status     string       `json:"status"`
+	Files      []fileStatus `json:"files"`
+}
+
+type fileStatus struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func NewUploadHandler() (UploadHandler, error) {
+	credentialGetter, err := secrets.GetCredentialGetter()
+	if err != nil {
+		slog.Error("Unable to initialize credential getter", slog.Any(utils.ErrorKey, err))
+		return UploadHandler{}, err
+	}
+
+	blobHandler, err := storage.NewAzureBlobHandler()
+	if err != nil {
+		slog.Error("Failed to init Azure blob client", slog.Any(utils.ErrorKey, err))
+		return UploadHandler{}, err
+	}
+
+	acknowledgementQueue, err := orchestration.NewAcknowledgementQueueClient()
+	if err != nil {
+		slog.Error("Failed to create acknowledgement queue client", slog.Any(utils.ErrorKey, err))
+		return UploadHandler{}, err
+	}
+
+	return UploadHandler{
+		credentialGetter: credentialGetter,
+		blobHandler:      blobHandler,
+		newFileImporter: func(partnerId string) (FileImporter, error) {
+			return sftp.NewPushedFileHandler(credentialGetter, partnerId, acknowledgementQueue)
+		},
+	}, nil
+}
+
+// Register adds the API's routes to mux
+func (receiver UploadHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /partners/{partnerId}/files", receiver.uploadFile)
+	mux.HandleFunc("GET /partners/{partnerId}/files/{trackingId}", receiver.getUploadStatus)
+}
+
+// uploadFile takes the request body as the file, named by the `name` query parameter, e.g.
+// `POST /partners/ca-phl/files?name=results.zip`
+func (receiver UploadHandler) uploadFile(response http.ResponseWriter, request *http.Request) {
+	partnerId, ok := receiver.authorizePartner(response, request)
+	if !ok {
+		return
+	}
+
+	fileName := request.URL.Query().Get("name")
+	if !isPlainFileName(fileName) {
+		writeJson(response, http.StatusBadRequest, errorResponse{Error: "name must be a file name without a directory"})
+		return
+	}
+
+	fileBytes, err := io.ReadAll(http.MaxBytesReader(response, request.Body, maxUploadBytes))
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		writeJson(response, http.StatusRequestEntityTooLarge, errorResponse{Error: "file is bigger than the 1 GiB we accept"})
+		return
+	}
+	if err != nil {
+		slog.Warn("Failed to read uploaded file", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+		writeJson(response, http.StatusBadRequest, errorResponse{Error: "unable to read file"})
+		return
+	}
+
+	importer, err := receiver.newFileImporter(partnerId)
+	if err != nil {
+		slog.Error("Failed to create file importer for upload", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+		writeJson(response, http.StatusInternalServerError, errorResponse{Error: "failed to import file, please upload it again"})
+		return
+	}
+
+	trackingId := uuid.NewString()
+	err = importer.ImportUpload(trackingId, fileName, fileBytes)
+	if err != nil {
+		slog.Error("Failed to import uploaded file", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId), slog.String(utils.FileNameKey, fileName))
+		writeJson(response, http.StatusInternalServerError, errorResponse{Error: "failed to import file, please upload it again"})
+		return
+	}
+
+	slog.Info("Imported uploaded file", slog.String("partnerId", partnerId), slog.String(utils.FileNameKey, fileName), slog.String("trackingId", trackingId), slog.Int("size", len(fileBytes)))
+	writeJson(response, http.StatusAccepted, uploadResponse{
+		TrackingId: trackingId,
+		StatusUrl:  "/partners/" + partnerId + "/files/" + trackingId,
+	})
+}
+
+// getUploadStatus looks for the upload's files in each of the partner's folders. Archives show up alongside the files
+// we extracted from them
+func (receiver UploadHandler) getUploadStatus(response http.ResponseWriter, request *http.Request) {
+	partnerId, ok := receiver.authorizePartner(response, request)
+	if !ok {
+		return
+	}
+
+	trackingId := request.PathValue("trackingId")
+	if uuid.Validate(trackingId) != nil {
+		writeJson(response, http.StatusNotFound, errorResponse{Error: "upload not found"})
+		return
+	}
+
+	var files []fileStatus
+	for _, uploadFolder := range uploadFolders {
+		prefix := path.Join(partnerId, uploadFolder.folder, trackingId) + "/"
+		blobPaths, err := receiver.blobHandler.ListFiles(prefix)
+		if err != nil {
+			slog.Error("Failed to list uploaded files", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId), slog.String("trackingId", trackingId))
+			writeJson(response, http.StatusInternalServerError, errorResponse{Error: "unable to get upload status"})
+			return
+		}
+
+		for _, blobPath := range blobPaths {
+			files = append(files, fileStatus{Name: strings.TrimPrefix(blobPath, prefix), Status: uploadFolder.status})
+		}
+	}
+
+	if len(files) == 0 {
+		writeJson(response, http.StatusNotFound, errorResponse{Error: "upload not found"})
+		return
+	}
+
+	writeJson(response, http.StatusOK, statusResponse{TrackingId: trackingId, Status: uploadStatus(files), Files: files})
+}
+
+// authorizePartner checks the partner can use the upload API and that the request's token is theirs. When they can't,
+// it writes the error response and returns false
+func (receiver UploadHandler) authorizePartner(response http.ResponseWriter, request *http.Request) (string, bool) {
+	partnerId := request.PathValue("partnerId")
+
+	partnerConfig := config.Configs[partnerId]
+	if partnerConfig == nil || !partnerConfig.PartnerSettings.IsActive || !partnerConfig.PartnerSettings.HttpUpload.Enabled {
+		slog.Warn("Upload API request for a partner who can't use it", slog.String("partnerId", partnerId), slog.String("remoteAddress", request.RemoteAddr))
+		writeJson(response, http.StatusNotFound, errorResponse{Error: "partner not found"})
+		return "", false
+	}
+
+	err := receiver.authenticate(request, partnerId)
+	if err != nil {
+		slog.Warn("Upload API request isn't authenticated", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId), slog.String("remoteAddress", request.RemoteAddr))
+		writeJson(response, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+		return "", false
+	}
+
+	return partnerId, true
+}
+
+func uploadStatus(files []fileStatus) string {
+	status := UploadStatusSent
+	for _, file := range files {
+		if file.Status == UploadStatusFailed {
+			return UploadStatusFailed
+		}
+		if file.Status == UploadStatusProcessing {
+			status = UploadStatusProcessing
+		}
+	}
+	return status
+}
+
+func isPlainFileName(fileName string) bool {
+	return fileName != "" && fileName != "." && fileName != ".." && !strings.ContainsAny(fileName, `/\`)
+}
+
+func writeJson(response http.ResponseWriter, statusCode int, body any) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(statusCode)
+
+	err := json.NewEncoder(response).Encode(body)
+	if err != nil {
+		slog.Error("Failed to write upload API response", slog.Any(utils.ErrorKey, err))
+	}
+}
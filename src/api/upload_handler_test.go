@@ -0,0 +1,197 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config/configtest"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const partnerId = "flexion"
+const apiPublicKeyName = "flexion-api-public-key-local" // pragma: allowlist secret
+const trackingId = "0b9f3c1e-8f4e-4e55-a1d6-7c6f2f1a2b3c"
+
+func Test_uploadFile_ImportsFileAndReturnsTrackingId(t *testing.T) {
+	privateKey := setUpUploadApi(t, true)
+	mockFileImporter := &MockFileImporter{}
+	mockFileImporter.On("ImportUpload", mock.Anything, "results.zip", []byte("PK\x03\x04")).Return(nil)
+
+	response := sendRequest(t, newTestHandler(t, privateKey, &mocks.MockBlobHandler{}, mockFileImporter),
+		http.MethodPost, "/partners/flexion/files?name=results.zip", "PK\x03\x04", newToken(t, privateKey, partnerId, 5*time.Minute))
+
+	assert.Equal(t, http.StatusAccepted, response.Code)
+	var body uploadResponse
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	mockFileImporter.AssertCalled(t, "ImportUpload", body.TrackingId, "results.zip", []byte("PK\x03\x04"))
+	assert.Equal(t, "/partners/flexion/files/"+body.TrackingId, body.StatusUrl)
+}
+
+func Test_uploadFile_ImportFails_ReturnsServerError(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	privateKey := setUpUploadApi(t, true)
+	mockFileImporter := &MockFileImporter{}
+	mockFileImporter.On("ImportUpload", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("blob storage is down"))
+
+	response := sendRequest(t, newTestHandler(t, privateKey, &mocks.MockBlobHandler{}, mockFileImporter),
+		http.MethodPost, "/partners/flexion/files?name=order.hl7", "MSH|order", newToken(t, privateKey, partnerId, 5*time.Minute))
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Contains(t, response.Body.String(), "failed to import file")
+	assert.Contains(t, buffer.String(), "Failed to import uploaded file")
+}
+
+func Test_uploadFile_NameHasDirectory_ReturnsBadRequest(t *testing.T) {
+	privateKey := setUpUploadApi(t, true)
+	mockFileImporter := &MockFileImporter{}
+
+	response := sendRequest(t, newTestHandler(t, privateKey, &mocks.MockBlobHandler{}, mockFileImporter),
+		http.MethodPost, "/partners/flexion/files?name=../ca-phl/order.hl7", "MSH|order", newToken(t, privateKey, partnerId, 5*time.Minute))
+
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	mockFileImporter.AssertNotCalled(t, "ImportUpload", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_uploadFile_PartnerHasNotEnabledUploads_ReturnsNotFound(t *testing.T) {
+	privateKey := setUpUploadApi(t, false)
+	mockFileImporter := &MockFileImporter{}
+
+	response := sendRequest(t, newTestHandler(t, privateKey, &mocks.MockBlobHandler{}, mockFileImporter),
+		http.MethodPost, "/partners/flexion/files?name=order.hl7", "MSH|order", newToken(t, privateKey, partnerId, 5*time.Minute))
+
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	mockFileImporter.AssertNotCalled(t, "ImportUpload", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_uploadFile_TokenSignedWithAnotherKey_ReturnsUnauthorized(t *testing.T) {
+	privateKey := setUpUploadApi(t, true)
+	mockFileImporter := &MockFileImporter{}
+
+	response := sendRequest(t, newTestHandler(t, privateKey, &mocks.MockBlobHandler{}, mockFileImporter),
+		http.MethodPost, "/partners/flexion/files?name=order.hl7", "MSH|order", newToken(t, newPrivateKey(t), partnerId, 5*time.Minute))
+
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	mockFileImporter.AssertNotCalled(t, "ImportUpload", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_getUploadStatus_FilesInSeveralFolders_ReturnsEachFile(t *testing.T) {
+	privateKey := setUpUploadApi(t, true)
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("ListFiles", "flexion/unzip/success/"+trackingId+"/").Return([]string{"flexion/unzip/success/" + trackingId + "/results.zip"}, nil)
+	mockBlobHandler.On("ListFiles", "flexion/success/"+trackingId+"/").Return([]string{"flexion/success/" + trackingId + "/order.hl7"}, nil)
+	mockBlobHandler.On("ListFiles", "flexion/import/"+trackingId+"/").Return([]string{"flexion/import/" + trackingId + "/result.hl7"}, nil)
+	mockBlobHandler.On("ListFiles", mock.Anything).Return([]string(nil), nil)
+
+	response := sendRequest(t, newTestHandler(t, privateKey, mockBlobHandler, &MockFileImporter{}),
+		http.MethodGet, "/partners/flexion/files/"+trackingId, "", newToken(t, privateKey, partnerId, 5*time.Minute))
+
+	assert.Equal(t, http.StatusOK, response.Code)
+	var body statusResponse
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &body))
+	assert.Equal(t, UploadStatusProcessing, body.Status)
+	assert.Equal(t, []fileStatus{
+		{Name: "result.hl7", Status: UploadStatusProcessing},
+		{Name: "results.zip", Status: UploadStatusUnzipped},
+		{Name: "order.hl7", Status: UploadStatusSent},
+	}, body.Files)
+}
+
+func Test_getUploadStatus_NoFiles_ReturnsNotFound(t *testing.T) {
+	privateKey := setUpUploadApi(t, true)
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("ListFiles", mock.Anything).Return([]string(nil), nil)
+
+	response := sendRequest(t, newTestHandler(t, privateKey, mockBlobHandler, &MockFileImporter{}),
+		http.MethodGet, "/partners/flexion/files/"+trackingId, "", newToken(t, privateKey, partnerId, 5*time.Minute))
+
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func Test_uploadStatus(t *testing.T) {
+	assert.Equal(t, UploadStatusSent, uploadStatus([]fileStatus{{Status: UploadStatusUnzipped}, {Status: UploadStatusSent}}))
+	assert.Equal(t, UploadStatusProcessing, uploadStatus([]fileStatus{{Status: UploadStatusSent}, {Status: UploadStatusProcessing}}))
+	assert.Equal(t, UploadStatusFailed, uploadStatus([]fileStatus{{Status: UploadStatusProcessing}, {Status: UploadStatusFailed}}))
+}
+
+func setUpUploadApi(t *testing.T, enabled bool) *rsa.PrivateKey {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{partnerId: {
+		IsActive:   true,
+		HttpUpload: config.HttpUploadSettings{Enabled: enabled},
+	}})
+
+	return newPrivateKey(t)
+}
+
+// newTestHandler serves an UploadHandler's routes, with privateKey's public key as the partner's upload API public key
+func newTestHandler(t *testing.T, privateKey *rsa.PrivateKey, blobHandler *mocks.MockBlobHandler, fileImporter FileImporter) *http.ServeMux {
+	uploadHandler := UploadHandler{
+		credentialGetter: newMockCredentialGetter(t, privateKey),
+		blobHandler:      blobHandler,
+		newFileImporter: func(partnerId string) (FileImporter, error) {
+			return fileImporter, nil
+		},
+	}
+
+	mux := http.NewServeMux()
+	uploadHandler.Register(mux)
+	return mux
+}
+
+// newMockCredentialGetter has privateKey's public key as the partner's upload API public key
+func newMockCredentialGetter(t *testing.T, privateKey *rsa.PrivateKey) *mocks.MockCredentialGetter {
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.NoError(t, err)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", apiPublicKeyName).Return(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})), nil)
+	return mockCredentialGetter
+}
+
+func sendRequest(t *testing.T, handler http.Handler, method string, target string, body string, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+func newPrivateKey(t *testing.T) *rsa.PrivateKey {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	return privateKey
+}
+
+func newToken(t *testing.T, privateKey *rsa.PrivateKey, issuer string, lifetime time.Duration) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, &jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Audience:  jwt.ClaimStrings{uploadAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(lifetime)),
+	}).SignedString(privateKey)
+	assert.NoError(t, err)
+	return token
+}
+
+type MockFileImporter struct {
+	mock.Mock
+}
+
+func (receiver *MockFileImporter) ImportUpload(trackingId string, fileName string, fileBytes []byte) error {
+	args := receiver.Called(trackingId, fileName, fileBytes)
+	return args.Error(0)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"github.com/CDCgov/reportstream-sftp-ingestion/api"
 	"github.com/CDCgov/reportstream-sftp-ingestion/orchestration"
+	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
+	"github.com/CDCgov/reportstream-sftp-ingestion/sftp"
 	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"github.com/google/uuid"
 	"io"
@@ -19,6 +22,10 @@ func main() {
 
 	go setupHealthCheck()
 
+	go setupSftpServer()
+
+	go setupPollingScheduler()
+
 	setUpQueues()
 
 	// This loop keeps the app alive. This lets the pre-live deployment slot remain healthy
@@ -33,9 +40,13 @@ func main() {
 
 func setUpQueues() {
 	// Set up the polling message handler and queue listener
-	pollingMessageHandler := orchestration.PollingMessageHandler{}
+	pollingMessageHandler, err := orchestration.NewPollingMessageHandler()
+	if err != nil {
+		slog.Warn("Failed to create pollingMessageHandler", slog.Any(utils.ErrorKey, err))
+		return
+	}
 
-	pollingQueueHandler, err := orchestration.NewQueueHandler(pollingMessageHandler, "polling-trigger")
+	pollingQueueHandler, err := orchestration.NewQueueHandler(pollingMessageHandler, orchestration.PollingQueueBaseName)
 	if err != nil {
 		slog.Warn("Failed to create pollingQueueHandler", slog.Any(utils.ErrorKey, err))
 		return
@@ -44,8 +55,41 @@ func setUpQueues() {
 		pollingQueueHandler.ListenToQueue()
 	}()
 
+	// Set up the zip re-process message handler and queue listener
+	zipReprocessQueueHandler, err := orchestration.NewQueueHandler(orchestration.NewZipReprocessMessageHandler(), orchestration.ZipReprocessQueueBaseName)
+	if err != nil {
+		slog.Warn("Failed to create zipReprocessQueueHandler", slog.Any(utils.ErrorKey, err))
+		return
+	}
+	go func() {
+		zipReprocessQueueHandler.ListenToQueue()
+	}()
+
+	// Set up the queue we use to schedule checks on ReportStream's submission history
+	submissionStatusQueue, err := orchestration.NewSubmissionStatusQueueClient()
+	if err != nil {
+		slog.Warn("Failed to create submissionStatusQueue", slog.Any(utils.ErrorKey, err))
+		return
+	}
+
+	// Set up the queue we use to schedule acknowledgements to partners, and the listener that writes them to the
+	// partners' SFTP servers
+	acknowledgementQueue, err := orchestration.NewAcknowledgementQueueClient()
+	if err != nil {
+		slog.Warn("Failed to create acknowledgementQueue", slog.Any(utils.ErrorKey, err))
+		return
+	}
+	acknowledgementQueueHandler, err := orchestration.NewQueueHandler(orchestration.NewAcknowledgementMessageHandler(), orchestration.AcknowledgementQueueBaseName)
+	if err != nil {
+		slog.Warn("Failed to create acknowledgementQueueHandler", slog.Any(utils.ErrorKey, err))
+		return
+	}
+	go func() {
+		acknowledgementQueueHandler.ListenToQueue()
+	}()
+
 	// Set up the import message handler and queue listener
-	importMessageHandler, err := orchestration.NewImportMessageHandler()
+	importMessageHandler, err := orchestration.NewImportMessageHandler(submissionStatusQueue, acknowledgementQueue)
 	if err != nil {
 		slog.Warn("Failed to create importMessageHandler", slog.Any(utils.ErrorKey, err))
 		return
@@ -59,6 +103,28 @@ func setUpQueues() {
 	go func() {
 		importQueueHandler.ListenToQueue()
 	}()
+
+	// Submission history only exists when we're sending to ReportStream
+	if os.Getenv("REPORT_STREAM_URL_PREFIX") == "" {
+		slog.Info("REPORT_STREAM_URL_PREFIX not set, not checking submission status")
+		return
+	}
+
+	// Set up the submission status message handler and queue listener
+	submissionStatusMessageHandler, err := orchestration.NewSubmissionStatusMessageHandler(submissionStatusQueue, acknowledgementQueue)
+	if err != nil {
+		slog.Warn("Failed to create submissionStatusMessageHandler", slog.Any(utils.ErrorKey, err))
+		return
+	}
+	submissionStatusQueueHandler, err := orchestration.NewQueueHandler(submissionStatusMessageHandler, orchestration.SubmissionStatusQueueBaseName)
+	if err != nil {
+		slog.Warn("Failed to create submissionStatusQueueHandler", slog.Any(utils.ErrorKey, err))
+		return
+	}
+
+	go func() {
+		submissionStatusQueueHandler.ListenToQueue()
+	}()
 }
 
 func setupLogging() {
@@ -89,8 +155,75 @@ func setupHealthCheck() {
 		}
 	})
 
-	err := http.ListenAndServe(":8080", nil)
+	// The upload and polling APIs share the health check's server. Their routes are more specific than `/`, so they
+	// take priority
+	uploadHandler, err := api.NewUploadHandler()
+	if err != nil {
+		slog.Warn("Failed to create upload API handler", slog.Any(utils.ErrorKey, err))
+	} else {
+		uploadHandler.Register(http.DefaultServeMux)
+	}
+
+	pollHandler, err := api.NewPollHandler()
+	if err != nil {
+		slog.Warn("Failed to create on-demand polling handler", slog.Any(utils.ErrorKey, err))
+	} else {
+		pollHandler.Register(http.DefaultServeMux)
+	}
+
+	err = http.ListenAndServe(":8080", nil)
 	if err != nil {
 		slog.Error("Failed to start health check", slog.Any(utils.ErrorKey, err))
 	}
 }
+
+// setupSftpServer starts our own SFTP server for partners who push files to us. It only runs when
+// SFTP_SERVER_ADDRESS is set, e.g. to `:2222`
+func setupSftpServer() {
+	address := os.Getenv("SFTP_SERVER_ADDRESS")
+	if address == "" {
+		slog.Info("SFTP_SERVER_ADDRESS not set, not starting SFTP server")
+		return
+	}
+
+	credentialGetter, err := secrets.GetCredentialGetter()
+	if err != nil {
+		slog.Error("Unable to initialize credential getter", slog.Any(utils.ErrorKey, err))
+		return
+	}
+
+	acknowledgementQueue, err := orchestration.NewAcknowledgementQueueClient()
+	if err != nil {
+		slog.Error("Failed to create acknowledgement queue client", slog.Any(utils.ErrorKey, err))
+		return
+	}
+
+	sftpServer, err := sftp.NewSftpServer(credentialGetter, acknowledgementQueue)
+	if err != nil {
+		slog.Error("Failed to create SFTP server", slog.Any(utils.ErrorKey, err))
+		return
+	}
+
+	err = sftpServer.ListenAndServe(address)
+	if err != nil {
+		slog.Error("Failed to start SFTP server", slog.Any(utils.ErrorKey, err))
+	}
+}
+
+// setupPollingScheduler queues polls on each partner's polling schedule. Every replica runs it, but only the one
+// holding the scheduler lease queues polls
+func setupPollingScheduler() {
+	pollingQueue, err := orchestration.NewPollingQueueClient()
+	if err != nil {
+		slog.Error("Failed to create polling queue client", slog.Any(utils.ErrorKey, err))
+		return
+	}
+
+	pollingScheduler, err := orchestration.NewPollingScheduler(pollingQueue)
+	if err != nil {
+		slog.Error("Failed to create polling scheduler", slog.Any(utils.ErrorKey, err))
+		return
+	}
+
+	pollingScheduler.Run()
+}
@@ -3,20 +3,204 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/cron"
 	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"log/slog"
+	"path"
+	"regexp"
 	"slices"
+	"strings"
 )
 
 /*
 The below struct is the struct for the values of partner configs. If adding new configs add to this struct
 */
 type PartnerSettings struct {
-	DisplayName              string `json:"displayName"` // full name if we need pretty names
-	IsActive                 bool   `json:"isActive"`
-	IsExternalSftpConnection bool   `json:"isExternalSftpConnection"`
-	HasZipPassword           bool   `json:"hasZipPassword"`
-	DefaultEncoding          string `json:"defaultEncoding"`
+	DisplayName              string                  `json:"displayName"` // full name if we need pretty names
+	IsActive                 bool                    `json:"isActive"`
+	IsExternalSftpConnection bool                    `json:"isExternalSftpConnection"`
+	HasZipPassword           bool                    `json:"hasZipPassword"`
+	RequireManifest          bool                    `json:"requireManifest"`
+	DefaultEncoding          string                  `json:"defaultEncoding"`
+	Senders                  SenderSettings          `json:"senders"`
+	ZipLimits                ZipLimitSettings        `json:"zipLimits"`
+	StableFile               StableFileSettings      `json:"stableFile"`
+	RemoteFiles              RemoteFileSettings      `json:"remoteFiles"`
+	PostCopy                 PostCopySettings        `json:"postCopy"`
+	Acknowledgements         AcknowledgementSettings `json:"acknowledgements"`
+	SftpAuth                 SftpAuthSettings        `json:"sftpAuth"`
+	SftpConnection           SftpConnectionSettings  `json:"sftpConnection"`
+	Pgp                      PgpSettings             `json:"pgp"`
+	SftpServer               SftpServerSettings      `json:"sftpServer"`
+	HttpUpload               HttpUploadSettings      `json:"httpUpload"`
+	Polling                  PollingSettings         `json:"polling"`
+}
+
+// PollingSettings decide when we poll the partner's SFTP server
+type PollingSettings struct {
+	// Cron is an NCRONTAB expression in UTC, the format Azure Functions timers use, e.g. `0 */15 * * * *` for every
+	// 15 minutes. Empty means we only poll when it's triggered on demand
+	Cron string `json:"cron"`
+}
+
+// HttpUploadSettings are for partners who upload files to our HTTPS API because they can't use SFTP
+type HttpUploadSettings struct {
+	// Enabled lets the partner upload files with a JWT they sign with the private key for the public key in Key Vault,
+	// see SECRETS.md
+	Enabled bool `json:"enabled"`
+}
+
+// SftpServerSettings are for partners who push files to our SFTP server instead of us polling theirs
+type SftpServerSettings struct {
+	// Enabled lets the partner log in to our SFTP server as their partner ID, with the public keys in Key Vault, see
+	// SECRETS.md
+	Enabled bool `json:"enabled"`
+}
+
+// PgpSettings are for partners who PGP-encrypt their files on their SFTP server. Our private key, its passphrase, and
+// the partner's public key come from Key Vault, see SECRETS.md
+type PgpSettings struct {
+	// Decrypt turns on decryption for files named `.pgp`, `.gpg`, or `.asc`, or that look encrypted
+	Decrypt bool `json:"decrypt"`
+	// RequireSignature rejects files that aren't signed with the partner's key
+	RequireSignature bool `json:"requireSignature"`
+}
+
+// SftpConnectionSettings tune our connection to the partner's SFTP server. Zero values use the defaults in the sftp
+// package, and empty algorithm lists use the ssh package's defaults
+type SftpConnectionSettings struct {
+	// DialTimeoutSeconds covers connecting and the SSH handshake, including through the jump host
+	DialTimeoutSeconds int `json:"dialTimeoutSeconds"`
+	// OperationTimeoutSeconds is how long a single SFTP request, like listing a directory or reading part of a file,
+	// can take before we give up on it. Each file has its own SFTP session, so this doesn't affect the partner's other
+	// files unless the whole connection has stopped responding
+	OperationTimeoutSeconds int `json:"operationTimeoutSeconds"`
+	// KeepaliveSeconds sends an SSH keepalive this often. Zero doesn't send any
+	KeepaliveSeconds int `json:"keepaliveSeconds"`
+	// Ciphers, KeyExchanges, and MACs replace the ssh package's algorithms, e.g. to allow older ones for legacy servers
+	Ciphers      []string `json:"ciphers"`
+	KeyExchanges []string `json:"keyExchanges"`
+	MACs         []string `json:"macs"`
+	// UseJumpHost connects through a bastion host first. Its address, user, and host key are secrets, see SECRETS.md
+	UseJumpHost bool `json:"useJumpHost"`
+	// The remaining settings are pkg/sftp client options
+	MaxPacketBytes               int  `json:"maxPacketBytes"` // up to 32768
+	MaxConcurrentRequestsPerFile int  `json:"maxConcurrentRequestsPerFile"`
+	DisableConcurrentReads       bool `json:"disableConcurrentReads"`
+}
+
+// SftpAuthSettings decide how we log in to the partner's SFTP server. The private key and password come from Key
+// Vault, see SECRETS.md
+type SftpAuthSettings struct {
+	// Mode is `publicKey`, `password`, `publicKeyAndPassword`, or `keyboardInteractive`. Empty means `publicKey`
+	Mode string `json:"mode"`
+}
+
+// AcknowledgementSettings are for acknowledgement files we write to the partner's SFTP server once each of their files
+// has been sent or rejected. We don't write any when Format is empty
+type AcknowledgementSettings struct {
+	Format string `json:"format"` // `csv`, `json`, or `hl7`
+	// Directory is where we write acknowledgements on the partner's SFTP server
+	Directory string `json:"directory"`
+}
+
+// PostCopySettings decide what happens to a file on the partner's SFTP server once we've copied it
+type PostCopySettings struct {
+	// Action is `delete`, `rename`, `move`, or `leave`. Empty means `delete`
+	Action string `json:"action"`
+	// Directory is where `move` puts files. Relative paths are from the starting directory
+	Directory string `json:"directory"`
+	// Suffix is what `rename` adds to the file name. Empty uses the default in the sftp package
+	Suffix string `json:"suffix"`
+	// AddTimestamp adds when we copied the file to the new name for `rename` and `move`, e.g.
+	// `results_20240601T120000Z.zip`
+	AddTimestamp bool `json:"addTimestamp"`
+}
+
+// RemoteFileSettings pick which files we copy from the partner's SFTP server
+type RemoteFileSettings struct {
+	// Include and Exclude patterns are globs, or regular expressions when they start with `regex:`. A file is copied
+	// when it matches an include pattern (or there aren't any) and doesn't match an exclude pattern
+	Include   []string `json:"include"`
+	Exclude   []string `json:"exclude"`
+	Recursive bool     `json:"recursive"`
+	// MaxDepth is how many levels of subdirectories we go down when Recursive is set. Zero uses the default in the
+	// sftp package
+	MaxDepth int `json:"maxDepth"`
+	// KeepDirectoryStructure puts files from subdirectories in the same subdirectories in blob storage, e.g.
+	// `ca-phl/import/2024/06/results.hl7`
+	KeepDirectoryStructure bool `json:"keepDirectoryStructure"`
+}
+
+// StableFileSettings decide when a file on the partner's SFTP server has finished uploading. Every setting that's set
+// has to pass, and files that don't are left for the next poll
+type StableFileSettings struct {
+	MinAgeSeconds int `json:"minAgeSeconds"` // since the file was last modified
+	// UnchangedSizeSeconds is how long we wait before listing the directory again. Files whose size or modified time
+	// changed in between are still being written
+	UnchangedSizeSeconds int `json:"unchangedSizeSeconds"`
+	// DoneMarkerExtensions, e.g. `.done` or `.ok`, are for a marker file the partner writes next to a finished file,
+	// e.g. `results.zip.done`
+	DoneMarkerExtensions []string `json:"doneMarkerExtensions"`
+	// TempNamePatterns, e.g. `*.tmp` or `.*`, match the names of files the partner renames once they're finished
+	TempNamePatterns []string `json:"tempNamePatterns"`
+}
+
+// ZipLimitSettings protect us from zip bombs. Zero values use the defaults in the zip package
+type ZipLimitSettings struct {
+	MaxUncompressedBytes int64   `json:"maxUncompressedBytes"` // across all files in the zip
+	MaxCompressionRatio  float64 `json:"maxCompressionRatio"`  // uncompressed size / compressed size, for each file
+	MaxEntries           int     `json:"maxEntries"`
+	// MaxNestingDepth counts the zip we received as 1, so a zip inside it is at depth 2
+	MaxNestingDepth int `json:"maxNestingDepth"`
+}
+
+// SenderSettings lists where a partner's messages are delivered. When there are no destinations, we send to the
+// default destination (ReportStream, or the local file sender when REPORT_STREAM_URL_PREFIX isn't set)
+type SenderSettings struct {
+	// Mode is either `allMustSucceed` or `primaryPlusBestEffort`. The first destination is the primary one
+	Mode         string                `json:"mode"`
+	Destinations []DestinationSettings `json:"destinations"`
+}
+
+type DestinationSettings struct {
+	Name string `json:"name"` // used in logs and in secret names
+	Type string `json:"type"` // e.g. `reportStream` or `file`
+	// Url overrides REPORT_STREAM_URL_PREFIX for `reportStream` destinations, e.g. to send to a second environment.
+	// For `webhook` destinations, it's the full URL we post messages to
+	Url string `json:"url"`
+	// Directory overrides FILE_SENDER_DIRECTORY for `file` destinations
+	Directory string `json:"directory"`
+	// Address is the `host:port` for `mllp` destinations
+	Address        string `json:"address"`
+	Tls            bool   `json:"tls"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+	// The remaining settings are for `webhook` destinations
+	Headers     map[string]string   `json:"headers"`
+	ContentType string              `json:"contentType"` // defaults to `text/plain`
+	Auth        WebhookAuthSettings `json:"auth"`
+	// StatusRules are checked in order before the default rules (2xx is a success, 408, 429 and 5xx are transient,
+	// anything else is permanent)
+	StatusRules []StatusRule `json:"statusRules"`
+}
+
+// WebhookAuthSettings only holds non-secret values. Tokens, client secrets, and keys come from Key Vault, see SECRETS.md
+type WebhookAuthSettings struct {
+	// Type is `none`, `bearerToken`, `oauthClientCredentials`, or `mutualTls`. Empty means `none`
+	Type     string `json:"type"`
+	TokenUrl string `json:"tokenUrl"`
+	ClientId string `json:"clientId"`
+	Scope    string `json:"scope"`
+	// UsePrivateKeyJwt signs a client assertion with our private key instead of sending a client secret, the way we
+	// authenticate to ReportStream
+	UsePrivateKeyJwt bool `json:"usePrivateKeyJwt"`
+}
+
+// StatusRule maps the HTTP status codes from MinStatus to MaxStatus (inclusive) to `success`, `transient`, or `permanent`
+type StatusRule struct {
+	MinStatus int    `json:"minStatus"`
+	MaxStatus int    `json:"maxStatus"`
+	Result    string `json:"result"`
 }
 
 func populatePartnerSettings(input []byte, partnerId string) (PartnerSettings, error) {
@@ -35,6 +219,66 @@ func populatePartnerSettings(input []byte, partnerId string) (PartnerSettings, e
 		return PartnerSettings{}, err
 	}
 
+	err = validateSenderSettings(partnerSettings.Senders)
+	if err != nil {
+		slog.Error("Invalid sender settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validateZipLimits(partnerSettings.ZipLimits)
+	if err != nil {
+		slog.Error("Invalid zip limits found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validateStableFileSettings(partnerSettings.StableFile)
+	if err != nil {
+		slog.Error("Invalid stable file settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validateRemoteFileSettings(partnerSettings.RemoteFiles)
+	if err != nil {
+		slog.Error("Invalid remote file settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validatePostCopySettings(partnerSettings.PostCopy)
+	if err != nil {
+		slog.Error("Invalid post-copy settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validateAcknowledgementSettings(partnerSettings.Acknowledgements)
+	if err != nil {
+		slog.Error("Invalid acknowledgement settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validateSftpAuthSettings(partnerSettings.SftpAuth)
+	if err != nil {
+		slog.Error("Invalid SFTP auth settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validateSftpConnectionSettings(partnerSettings.SftpConnection)
+	if err != nil {
+		slog.Error("Invalid SFTP connection settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validatePgpSettings(partnerSettings.Pgp)
+	if err != nil {
+		slog.Error("Invalid PGP settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
+	err = validatePollingSettings(partnerSettings.Polling)
+	if err != nil {
+		slog.Error("Invalid polling settings found", slog.Any(utils.ErrorKey, err), slog.String("Partner ID", partnerId))
+		return PartnerSettings{}, err
+	}
+
 	// TODO - any other validation?
 
 	return partnerSettings, nil
@@ -46,3 +290,165 @@ func validateDefaultEncoding(input string) error {
 	}
 	return errors.New("Invalid encoding found: " + input)
 }
+
+func validateZipLimits(zipLimits ZipLimitSettings) error {
+	if zipLimits.MaxUncompressedBytes < 0 || zipLimits.MaxCompressionRatio < 0 || zipLimits.MaxEntries < 0 || zipLimits.MaxNestingDepth < 0 {
+		return errors.New("zip limits can't be negative")
+	}
+	return nil
+}
+
+func validateStableFileSettings(stableFile StableFileSettings) error {
+	if stableFile.MinAgeSeconds < 0 || stableFile.UnchangedSizeSeconds < 0 {
+		return errors.New("stable file wait times can't be negative")
+	}
+
+	for _, extension := range stableFile.DoneMarkerExtensions {
+		if !strings.HasPrefix(extension, ".") {
+			return errors.New("done marker extension doesn't start with a dot: " + extension)
+		}
+	}
+
+	for _, pattern := range stableFile.TempNamePatterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return errors.New("Invalid temp name pattern found: " + pattern)
+		}
+	}
+
+	return nil
+}
+
+func validateRemoteFileSettings(remoteFiles RemoteFileSettings) error {
+	if remoteFiles.MaxDepth < 0 {
+		return errors.New("remote file max depth can't be negative")
+	}
+
+	for _, pattern := range slices.Concat(remoteFiles.Include, remoteFiles.Exclude) {
+		var err error
+		if expression, isRegex := strings.CutPrefix(pattern, RegexPatternPrefix); isRegex {
+			_, err = regexp.Compile(expression)
+		} else {
+			_, err = path.Match(pattern, "")
+		}
+		if err != nil {
+			return errors.New("Invalid remote file pattern found: " + pattern)
+		}
+	}
+
+	return nil
+}
+
+func validatePostCopySettings(postCopy PostCopySettings) error {
+	if postCopy.Action != "" && !slices.Contains(allowedPostCopyActionList, postCopy.Action) {
+		return errors.New("Invalid post-copy action found: " + postCopy.Action)
+	}
+	if postCopy.Action == PostCopyMove && postCopy.Directory == "" {
+		return errors.New("post-copy move is missing a directory")
+	}
+	if strings.Contains(postCopy.Suffix, "/") {
+		return errors.New("post-copy suffix can't contain a slash: " + postCopy.Suffix)
+	}
+	return nil
+}
+
+func validateAcknowledgementSettings(acknowledgements AcknowledgementSettings) error {
+	if acknowledgements.Format == "" {
+		return nil
+	}
+	if !slices.Contains(allowedAcknowledgementFormatList, acknowledgements.Format) {
+		return errors.New("Invalid acknowledgement format found: " + acknowledgements.Format)
+	}
+	if acknowledgements.Directory == "" {
+		return errors.New("acknowledgements are missing a directory")
+	}
+	return nil
+}
+
+func validateSenderSettings(senderSettings SenderSettings) error {
+	if len(senderSettings.Destinations) == 0 {
+		return nil
+	}
+
+	if !slices.Contains(allowedSenderModeList, senderSettings.Mode) {
+		return errors.New("Invalid sender mode found: " + senderSettings.Mode)
+	}
+
+	for _, destination := range senderSettings.Destinations {
+		if destination.Name == "" {
+			return errors.New("sender destination is missing a name")
+		}
+		if !slices.Contains(allowedDestinationTypeList, destination.Type) {
+			return errors.New("Invalid destination type found: " + destination.Type)
+		}
+		if destination.Type == DestinationTypeMllp && destination.Address == "" {
+			return errors.New("mllp destination is missing an address: " + destination.Name)
+		}
+		if destination.Type == DestinationTypeWebhook {
+			err := validateWebhookSettings(destination)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateWebhookSettings(destination DestinationSettings) error {
+	if !strings.HasPrefix(destination.Url, "https://") {
+		return errors.New("webhook destination needs an https url: " + destination.Name)
+	}
+
+	if destination.Auth.Type != "" && !slices.Contains(allowedWebhookAuthTypeList, destination.Auth.Type) {
+		return errors.New("Invalid webhook auth type found: " + destination.Auth.Type)
+	}
+
+	if destination.Auth.Type == WebhookAuthOauthClientCredentials && (destination.Auth.TokenUrl == "" || destination.Auth.ClientId == "") {
+		return errors.New("webhook destination using oauthClientCredentials needs a tokenUrl and clientId: " + destination.Name)
+	}
+
+	for _, rule := range destination.StatusRules {
+		if !slices.Contains(allowedStatusRuleResultList, rule.Result) {
+			return errors.New("Invalid status rule result found: " + rule.Result)
+		}
+		if rule.MinStatus > rule.MaxStatus {
+			return errors.New("status rule minStatus is greater than maxStatus for destination: " + destination.Name)
+		}
+	}
+
+	return nil
+}
+
+func validateSftpAuthSettings(sftpAuth SftpAuthSettings) error {
+	if sftpAuth.Mode == "" || slices.Contains(allowedSftpAuthModeList, sftpAuth.Mode) {
+		return nil
+	}
+	return errors.New("Invalid SFTP auth mode found: " + sftpAuth.Mode)
+}
+
+func validateSftpConnectionSettings(sftpConnection SftpConnectionSettings) error {
+	if sftpConnection.DialTimeoutSeconds < 0 || sftpConnection.OperationTimeoutSeconds < 0 || sftpConnection.KeepaliveSeconds < 0 ||
+		sftpConnection.MaxPacketBytes < 0 || sftpConnection.MaxConcurrentRequestsPerFile < 0 {
+		return errors.New("SFTP connection settings can't be negative")
+	}
+	if sftpConnection.MaxPacketBytes > maxSftpPacketBytes {
+		return errors.New("maxPacketBytes can't be more than 32768")
+	}
+	return nil
+}
+
+func validatePgpSettings(pgp PgpSettings) error {
+	if pgp.RequireSignature && !pgp.Decrypt {
+		return errors.New("PGP signatures can only be required when decrypt is set")
+	}
+	return nil
+}
+
+func validatePollingSettings(polling PollingSettings) error {
+	if polling.Cron == "" {
+		return nil
+	}
+	_, err := cron.Parse(polling.Cron)
+	return err
+}
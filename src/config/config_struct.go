@@ -8,7 +8,7 @@ import (
 )
 
 type Config struct {
-	// PartnerId is a unique name to identify a partner. It's put in queue message from polling function and used in blob paths
+	// PartnerId is a unique name to identify a partner. It's put in queue message from polling scheduler and used in blob paths
 	PartnerId       string
 	lastRetrieved   time.Time
 	PartnerSettings PartnerSettings
@@ -16,9 +16,57 @@ type Config struct {
 
 // TODO confirm if these should stay here in config or move to constants
 var allowedEncodingList = []string{"ISO-8859-1", "UTF-8"}
+var allowedSenderModeList = []string{SenderModeAllMustSucceed, SenderModePrimaryPlusBestEffort}
+var allowedDestinationTypeList = []string{DestinationTypeReportStream, DestinationTypeFile, DestinationTypeMllp, DestinationTypeWebhook}
+var allowedWebhookAuthTypeList = []string{WebhookAuthNone, WebhookAuthBearerToken, WebhookAuthOauthClientCredentials, WebhookAuthMutualTls}
+var allowedStatusRuleResultList = []string{StatusRuleSuccess, StatusRuleTransient, StatusRulePermanent}
+var allowedPostCopyActionList = []string{PostCopyDelete, PostCopyRename, PostCopyMove, PostCopyLeave}
+var allowedAcknowledgementFormatList = []string{AcknowledgementFormatCsv, AcknowledgementFormatJson, AcknowledgementFormatHl7}
+var allowedSftpAuthModeList = []string{SftpAuthPublicKey, SftpAuthPassword, SftpAuthPublicKeyAndPassword, SftpAuthKeyboardInteractive}
 var KnownPartnerIds = []string{utils.CA_PHL, utils.FLEXION}
 var Configs = make(map[string]*Config)
 
+// Sender modes: with `allMustSucceed`, a message only succeeds when every destination accepts it. With
+// `primaryPlusBestEffort`, only the first destination's result counts and failures elsewhere are logged
+const SenderModeAllMustSucceed = "allMustSucceed"
+const SenderModePrimaryPlusBestEffort = "primaryPlusBestEffort"
+
+const DestinationTypeReportStream = "reportStream"
+const DestinationTypeFile = "file"
+const DestinationTypeMllp = "mllp"
+const DestinationTypeWebhook = "webhook"
+
+const WebhookAuthNone = "none"
+const WebhookAuthBearerToken = "bearerToken"
+const WebhookAuthOauthClientCredentials = "oauthClientCredentials"
+const WebhookAuthMutualTls = "mutualTls"
+
+const StatusRuleSuccess = "success"
+const StatusRuleTransient = "transient"
+const StatusRulePermanent = "permanent"
+
+// Post-copy actions for files on a partner's SFTP server
+const PostCopyDelete = "delete"
+const PostCopyRename = "rename"
+const PostCopyMove = "move"
+const PostCopyLeave = "leave"
+
+const AcknowledgementFormatCsv = "csv"
+const AcknowledgementFormatJson = "json"
+const AcknowledgementFormatHl7 = "hl7"
+
+// SFTP auth modes: `publicKeyAndPassword` is for servers that need both, one after the other
+const SftpAuthPublicKey = "publicKey"
+const SftpAuthPassword = "password"
+const SftpAuthPublicKeyAndPassword = "publicKeyAndPassword"
+const SftpAuthKeyboardInteractive = "keyboardInteractive"
+
+// maxSftpPacketBytes is the largest packet the SFTP spec requires servers to accept
+const maxSftpPacketBytes = 32768
+
+// RegexPatternPrefix marks a remote file pattern as a regular expression rather than a glob
+const RegexPatternPrefix = "regex:"
+
 func init() {
 	for _, partnerId := range KnownPartnerIds {
 		partnerConfig, err := NewConfig(partnerId)
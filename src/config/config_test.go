@@ -77,3 +77,274 @@ func Test_validateDefaultEncoding_errors(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+func Test_populatePartnerSettings_populatesSenders(t *testing.T) {
+	jsonInput := []byte(`{
+	"isActive": true,
+	"defaultEncoding": "ISO-8859-1",
+	"senders": {
+		"mode": "primaryPlusBestEffort",
+		"destinations": [
+			{"name": "reportstream", "type": "reportStream"},
+			{"name": "archive", "type": "file"}
+		]
+	}
+}`)
+
+	partnerSettings, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.NoError(t, err)
+	assert.Equal(t, SenderModePrimaryPlusBestEffort, partnerSettings.Senders.Mode)
+	assert.Len(t, partnerSettings.Senders.Destinations, 2)
+	assert.Equal(t, DestinationTypeFile, partnerSettings.Senders.Destinations[1].Type)
+}
+
+func Test_populatePartnerSettings_errors_whenSenderModeInvalid(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"senders": {
+		"mode": "whenever",
+		"destinations": [{"name": "reportstream", "type": "reportStream"}]
+	}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid sender settings found")
+}
+
+func Test_validateSenderSettings_errors_whenDestinationInvalid(t *testing.T) {
+	err := validateSenderSettings(SenderSettings{Mode: SenderModeAllMustSucceed, Destinations: []DestinationSettings{{Name: "pigeon", Type: "carrier pigeon"}}})
+	assert.Error(t, err)
+
+	err = validateSenderSettings(SenderSettings{Mode: SenderModeAllMustSucceed, Destinations: []DestinationSettings{{Type: DestinationTypeFile}}})
+	assert.Error(t, err)
+}
+
+func Test_validateSenderSettings_errors_whenWebhookInvalid(t *testing.T) {
+	err := validateSenderSettings(SenderSettings{Mode: SenderModeAllMustSucceed, Destinations: []DestinationSettings{{Name: "lab", Type: DestinationTypeWebhook, Url: "http://lab.example.com"}}})
+	assert.Error(t, err)
+
+	err = validateSenderSettings(SenderSettings{Mode: SenderModeAllMustSucceed, Destinations: []DestinationSettings{{Name: "lab", Type: DestinationTypeWebhook, Url: "https://lab.example.com", Auth: WebhookAuthSettings{Type: "carrier pigeon"}}}})
+	assert.Error(t, err)
+
+	err = validateSenderSettings(SenderSettings{Mode: SenderModeAllMustSucceed, Destinations: []DestinationSettings{{Name: "lab", Type: DestinationTypeWebhook, Url: "https://lab.example.com", Auth: WebhookAuthSettings{Type: WebhookAuthOauthClientCredentials}}}})
+	assert.Error(t, err)
+
+	err = validateSenderSettings(SenderSettings{Mode: SenderModeAllMustSucceed, Destinations: []DestinationSettings{{Name: "lab", Type: DestinationTypeWebhook, Url: "https://lab.example.com", StatusRules: []StatusRule{{MinStatus: 500, MaxStatus: 400, Result: StatusRuleTransient}}}}})
+	assert.Error(t, err)
+
+	err = validateSenderSettings(SenderSettings{Mode: SenderModeAllMustSucceed, Destinations: []DestinationSettings{{Name: "lab", Type: DestinationTypeWebhook, Url: "https://lab.example.com", StatusRules: []StatusRule{{MinStatus: 409, MaxStatus: 409, Result: "maybe"}}}}})
+	assert.Error(t, err)
+}
+
+func Test_validateSenderSettings_succeeds_whenWebhookValid(t *testing.T) {
+	err := validateSenderSettings(SenderSettings{Mode: SenderModeAllMustSucceed, Destinations: []DestinationSettings{{
+		Name:        "lab",
+		Type:        DestinationTypeWebhook,
+		Url:         "https://lab.example.com",
+		Auth:        WebhookAuthSettings{Type: WebhookAuthOauthClientCredentials, TokenUrl: "https://lab.example.com/token", ClientId: "client"},
+		StatusRules: []StatusRule{{MinStatus: 409, MaxStatus: 409, Result: StatusRuleSuccess}},
+	}}})
+	assert.NoError(t, err)
+}
+
+func Test_populatePartnerSettings_errors_whenZipLimitsNegative(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"zipLimits": {"maxEntries": -1}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid zip limits found")
+}
+
+func Test_populatePartnerSettings_errors_whenTempNamePatternInvalid(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"stableFile": {"tempNamePatterns": ["[*.tmp"]}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid stable file settings found")
+}
+
+func Test_validateStableFileSettings_DoneMarkerHasNoDot_ReturnsError(t *testing.T) {
+	err := validateStableFileSettings(StableFileSettings{DoneMarkerExtensions: []string{"done"}})
+
+	assert.Error(t, err)
+}
+
+func Test_validateStableFileSettings_SettingsAreValid_ReturnsNil(t *testing.T) {
+	err := validateStableFileSettings(StableFileSettings{MinAgeSeconds: 60, UnchangedSizeSeconds: 5, DoneMarkerExtensions: []string{".done", ".ok"}, TempNamePatterns: []string{"*.tmp", ".*"}})
+
+	assert.NoError(t, err)
+}
+
+func Test_validateRemoteFileSettings_RegexIsInvalid_ReturnsError(t *testing.T) {
+	err := validateRemoteFileSettings(RemoteFileSettings{Exclude: []string{"regex:(unclosed"}})
+
+	assert.Error(t, err)
+}
+
+func Test_validateRemoteFileSettings_SettingsAreValid_ReturnsNil(t *testing.T) {
+	err := validateRemoteFileSettings(RemoteFileSettings{Include: []string{"*.hl7", `regex:^results_\d+\.zip$`}, Exclude: []string{".DS_Store"}, Recursive: true, MaxDepth: 2})
+
+	assert.NoError(t, err)
+}
+
+func Test_populatePartnerSettings_errors_whenPostCopyActionInvalid(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"postCopy": {"action": "shred"}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid post-copy settings found")
+}
+
+func Test_validatePostCopySettings_MoveHasNoDirectory_ReturnsError(t *testing.T) {
+	err := validatePostCopySettings(PostCopySettings{Action: PostCopyMove})
+
+	assert.Error(t, err)
+}
+
+func Test_validatePostCopySettings_SettingsAreValid_ReturnsNil(t *testing.T) {
+	assert.NoError(t, validatePostCopySettings(PostCopySettings{}))
+	assert.NoError(t, validatePostCopySettings(PostCopySettings{Action: PostCopyMove, Directory: "processed", AddTimestamp: true}))
+	assert.NoError(t, validatePostCopySettings(PostCopySettings{Action: PostCopyRename, Suffix: ".done"}))
+}
+
+func Test_populatePartnerSettings_errors_whenAcknowledgementFormatInvalid(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"acknowledgements": {"format": "xml", "directory": "acks"}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid acknowledgement settings found")
+}
+
+func Test_validateAcknowledgementSettings_HasNoDirectory_ReturnsError(t *testing.T) {
+	err := validateAcknowledgementSettings(AcknowledgementSettings{Format: AcknowledgementFormatCsv})
+
+	assert.Error(t, err)
+}
+
+func Test_validateAcknowledgementSettings_SettingsAreValid_ReturnsNil(t *testing.T) {
+	assert.NoError(t, validateAcknowledgementSettings(AcknowledgementSettings{}))
+	assert.NoError(t, validateAcknowledgementSettings(AcknowledgementSettings{Format: AcknowledgementFormatHl7, Directory: "/outbound/acks"}))
+}
+
+func Test_populatePartnerSettings_errors_whenSftpAuthModeInvalid(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"sftpAuth": {"mode": "telepathy"}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid SFTP auth settings found")
+}
+
+func Test_validateSftpAuthSettings_SettingsAreValid_ReturnsNil(t *testing.T) {
+	assert.NoError(t, validateSftpAuthSettings(SftpAuthSettings{}))
+	assert.NoError(t, validateSftpAuthSettings(SftpAuthSettings{Mode: SftpAuthKeyboardInteractive}))
+}
+
+func Test_populatePartnerSettings_errors_whenSftpConnectionSettingsInvalid(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"sftpConnection": {"dialTimeoutSeconds": -1}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid SFTP connection settings found")
+}
+
+func Test_validateSftpConnectionSettings_PacketIsTooBig_ReturnsError(t *testing.T) {
+	err := validateSftpConnectionSettings(SftpConnectionSettings{MaxPacketBytes: 65536})
+
+	assert.Error(t, err)
+}
+
+func Test_validateSftpConnectionSettings_SettingsAreValid_ReturnsNil(t *testing.T) {
+	assert.NoError(t, validateSftpConnectionSettings(SftpConnectionSettings{}))
+	assert.NoError(t, validateSftpConnectionSettings(SftpConnectionSettings{
+		DialTimeoutSeconds: 10, OperationTimeoutSeconds: 60, KeepaliveSeconds: 30,
+		Ciphers: []string{"aes128-cbc"}, UseJumpHost: true, MaxPacketBytes: 32768, MaxConcurrentRequestsPerFile: 8,
+	}))
+}
+
+func Test_populatePartnerSettings_errors_whenPgpSettingsInvalid(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"pgp": {"requireSignature": true}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid PGP settings found")
+}
+
+func Test_validatePgpSettings_SettingsAreValid_ReturnsNil(t *testing.T) {
+	assert.NoError(t, validatePgpSettings(PgpSettings{}))
+	assert.NoError(t, validatePgpSettings(PgpSettings{Decrypt: true, RequireSignature: true}))
+}
+
+func Test_populatePartnerSettings_errors_whenPollingCronInvalid(t *testing.T) {
+	jsonInput := []byte(`{
+	"defaultEncoding": "ISO-8859-1",
+	"polling": {"cron": "every 15 minutes"}
+}`)
+
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	_, err := populatePartnerSettings(jsonInput, partnerId)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "Invalid polling settings found")
+}
+
+func Test_validatePollingSettings_SettingsAreValid_ReturnsNil(t *testing.T) {
+	assert.NoError(t, validatePollingSettings(PollingSettings{}))
+	assert.NoError(t, validatePollingSettings(PollingSettings{Cron: "0 */15 * * * *"}))
+}
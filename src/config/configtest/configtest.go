@@ -0,0 +1,21 @@
+// Package configtest sets up partner configs for tests. Only tests import it, so it isn't built into the app
+package configtest
+
+import (
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"testing"
+)
+
+// SetUpConfigs replaces config.Configs with configs built from partnerSettings for the length of a test, so tests
+// don't see the configs loaded at startup or leave their own behind. The original configs are put back when the test
+// ends
+func SetUpConfigs(t testing.TB, partnerSettings map[string]config.PartnerSettings) {
+	previousConfigs := config.Configs
+	config.Configs = make(map[string]*config.Config, len(partnerSettings))
+	for partnerId, settings := range partnerSettings {
+		config.Configs[partnerId] = &config.Config{PartnerId: partnerId, PartnerSettings: settings}
+	}
+	t.Cleanup(func() {
+		config.Configs = previousConfigs
+	})
+}
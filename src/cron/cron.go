@@ -0,0 +1,190 @@
+package cron
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed NCRONTAB expression, the format Azure Functions timers use:
+// `{second} {minute} {hour} {day} {month} {day of week}`, e.g. `0 */15 * * * *` for every 15 minutes. The seconds
+// field can be left off. Fields can be `*`, numbers, names like `Feb` or `Mon`, ranges like `1-5`, steps like `*/15`
+// or `0-30/10`, and lists of those separated by commas. Times are in UTC
+type Schedule struct {
+	seconds  uint64
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// Like standard cron, when both days and weekdays are restricted a time only has to match one of them
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+type field struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+var secondField = field{name: "second", min: 0, max: 59}
+var minuteField = field{name: "minute", min: 0, max: 59}
+var hourField = field{name: "hour", min: 0, max: 23}
+var dayField = field{name: "day", min: 1, max: 31}
+var monthField = field{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+var weekdayField = field{name: "day of week", min: 0, max: 6, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+
+// maxSearch stops Next looking forever for a time that never comes, like February 30th
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+func Parse(expression string) (Schedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	}
+	if len(fields) != 6 {
+		return Schedule{}, errors.New("cron expression must have 5 or 6 fields: " + expression)
+	}
+
+	var schedule Schedule
+	var err error
+	parsers := []struct {
+		field  field
+		bits   *uint64
+		values string
+	}{
+		{secondField, &schedule.seconds, fields[0]},
+		{minuteField, &schedule.minutes, fields[1]},
+		{hourField, &schedule.hours, fields[2]},
+		{dayField, &schedule.days, fields[3]},
+		{monthField, &schedule.months, fields[4]},
+		{weekdayField, &schedule.weekdays, fields[5]},
+	}
+	for _, parser := range parsers {
+		*parser.bits, err = parser.field.parse(parser.values)
+		if err != nil {
+			return Schedule{}, err
+		}
+	}
+	schedule.daysRestricted = fields[3] != "*"
+	schedule.weekdaysRestricted = fields[5] != "*"
+
+	return schedule, nil
+}
+
+// Next is the first time after `after` that the schedule matches, or the zero time if it doesn't match in the next
+// five years
+func (receiver Schedule) Next(after time.Time) time.Time {
+	next := after.UTC().Truncate(time.Second).Add(time.Second)
+	limit := next.Add(maxSearch)
+
+	for next.Before(limit) {
+		if !has(receiver.months, int(next.Month())) {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !receiver.matchesDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !has(receiver.hours, next.Hour()) {
+			next = next.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !has(receiver.minutes, next.Minute()) {
+			next = next.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if !has(receiver.seconds, next.Second()) {
+			next = next.Add(time.Second)
+			continue
+		}
+		return next
+	}
+
+	return time.Time{}
+}
+
+func (receiver Schedule) matchesDay(date time.Time) bool {
+	matchesDay := has(receiver.days, date.Day())
+	matchesWeekday := has(receiver.weekdays, int(date.Weekday()))
+	if receiver.daysRestricted && receiver.weekdaysRestricted {
+		return matchesDay || matchesWeekday
+	}
+	return matchesDay && matchesWeekday
+}
+
+func (receiver field) parse(values string) (uint64, error) {
+	var bits uint64
+	for _, value := range strings.Split(values, ",") {
+		start, end, step, err := receiver.parseRange(value)
+		if err != nil {
+			return 0, err
+		}
+		for number := start; number <= end; number += step {
+			bits |= 1 << number
+		}
+	}
+	return bits, nil
+}
+
+// parseRange parses one item of a list, e.g. `*`, `5`, `1-5`, `*/15`, or `0-30/10`
+func (receiver field) parseRange(value string) (int, int, int, error) {
+	rangePart, stepPart, hasStep := strings.Cut(value, "/")
+
+	step := 1
+	if hasStep {
+		var err error
+		step, err = strconv.Atoi(stepPart)
+		if err != nil || step < 1 {
+			return 0, 0, 0, errors.New("invalid " + receiver.name + " step: " + value)
+		}
+	}
+
+	if rangePart == "*" {
+		return receiver.min, receiver.max, step, nil
+	}
+
+	startPart, endPart, isRange := strings.Cut(rangePart, "-")
+	start, err := receiver.parseNumber(startPart)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	end := start
+	if isRange {
+		end, err = receiver.parseNumber(endPart)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+	} else if hasStep {
+		// `5/15` means from 5 to the end in steps of 15
+		end = receiver.max
+	}
+
+	if end < start {
+		return 0, 0, 0, errors.New("invalid " + receiver.name + " range: " + value)
+	}
+	return start, end, step, nil
+}
+
+func (receiver field) parseNumber(value string) (int, error) {
+	for index, name := range receiver.names {
+		if strings.EqualFold(value, name) {
+			return receiver.min + index, nil
+		}
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < receiver.min || number > receiver.max {
+		return 0, errors.New("invalid " + receiver.name + ": " + value)
+	}
+	return number, nil
+}
+
+func has(bits uint64, number int) bool {
+	return bits&(1<<number) != 0
+}
@@ -0,0 +1,63 @@
+package cron
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, time.June, 14, 10, 7, 30, 0, time.UTC)
+
+func Test_Next_EveryFifteenMinutes_ReturnsNextQuarterHour(t *testing.T) {
+	schedule, err := Parse("0 */15 * * * *")
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.June, 14, 10, 15, 0, 0, time.UTC), schedule.Next(start))
+	assert.Equal(t, time.Date(2024, time.June, 14, 10, 30, 0, 0, time.UTC), schedule.Next(schedule.Next(start)))
+}
+
+func Test_Next_FiveFields_RunsOnTheMinute(t *testing.T) {
+	schedule, err := Parse("30 2 * * *")
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.June, 15, 2, 30, 0, 0, time.UTC), schedule.Next(start))
+}
+
+func Test_Next_WeekdaysByName_SkipsWeekend(t *testing.T) {
+	// June 14th 2024 is a Friday
+	schedule, err := Parse("0 0 9 * * Mon-Fri")
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.June, 17, 9, 0, 0, 0, time.UTC), schedule.Next(start))
+}
+
+func Test_Next_DayAndWeekdayRestricted_MatchesEither(t *testing.T) {
+	schedule, err := Parse("0 0 0 1 * Sun")
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.June, 16, 0, 0, 0, 0, time.UTC), schedule.Next(start))
+	assert.Equal(t, time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC), schedule.Next(time.Date(2024, time.June, 30, 1, 0, 0, 0, time.UTC)))
+}
+
+func Test_Next_ListsAndRanges_ReturnsNextMatch(t *testing.T) {
+	schedule, err := Parse("0 0 6,12-14 * Dec *")
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, time.December, 1, 6, 0, 0, 0, time.UTC), schedule.Next(start))
+	assert.Equal(t, time.Date(2024, time.December, 1, 12, 0, 0, 0, time.UTC), schedule.Next(time.Date(2024, time.December, 1, 6, 0, 0, 0, time.UTC)))
+}
+
+func Test_Next_NeverMatches_ReturnsZeroTime(t *testing.T) {
+	// What our Azure Functions timers used to never run
+	schedule, err := Parse("* * * 30 Feb *")
+
+	assert.NoError(t, err)
+	assert.True(t, schedule.Next(start).IsZero())
+}
+
+func Test_Parse_InvalidExpressions_ReturnError(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * * *", "* * 24 * * *", "* * * 0 * *", "* * * * Foo *", "*/0 * * * * *", "5-1 * * * * *"} {
+		_, err := Parse(expression)
+		assert.Error(t, err, expression)
+	}
+}
@@ -16,7 +16,52 @@ func (receiver *MockBlobHandler) MoveFile(sourceUrl string, destinationUrl strin
 	return args.Error(0)
 }
 
+func (receiver *MockBlobHandler) CopyFile(sourcePath string, destinationPath string) error {
+	args := receiver.Called(sourcePath, destinationPath)
+	return args.Error(0)
+}
+
 func (receiver *MockBlobHandler) UploadFile(fileBytes []byte, blobPath string) error {
 	args := receiver.Called(fileBytes, blobPath)
 	return args.Error(0)
 }
+
+func (receiver *MockBlobHandler) UploadFileWithMetadata(fileBytes []byte, blobPath string, metadata map[string]string) error {
+	args := receiver.Called(fileBytes, blobPath, metadata)
+	return args.Error(0)
+}
+
+func (receiver *MockBlobHandler) StageBlock(blobPath string, blockNumber int, blockBytes []byte) error {
+	args := receiver.Called(blobPath, blockNumber, blockBytes)
+	return args.Error(0)
+}
+
+func (receiver *MockBlobHandler) CommitBlocks(blobPath string, blockCount int) error {
+	args := receiver.Called(blobPath, blockCount)
+	return args.Error(0)
+}
+
+func (receiver *MockBlobHandler) DeleteFile(blobPath string) error {
+	args := receiver.Called(blobPath)
+	return args.Error(0)
+}
+
+func (receiver *MockBlobHandler) ListFiles(prefix string) ([]string, error) {
+	args := receiver.Called(prefix)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (receiver *MockBlobHandler) FileExists(sourceUrl string) (bool, error) {
+	args := receiver.Called(sourceUrl)
+	return args.Bool(0), args.Error(1)
+}
+
+func (receiver *MockBlobHandler) GetMetadata(sourceUrl string) (map[string]string, error) {
+	args := receiver.Called(sourceUrl)
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+
+func (receiver *MockBlobHandler) SetMetadata(sourceUrl string, metadata map[string]string) error {
+	args := receiver.Called(sourceUrl, metadata)
+	return args.Error(0)
+}
@@ -0,0 +1,84 @@
+package mocks
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// InMemoryLeaser is a usecases.Leaser that keeps its leases in memory, so tests can check what happens when a lease
+// is taken without Azure
+type InMemoryLeaser struct {
+	mutex    sync.Mutex
+	leases   map[string]inMemoryLease
+	renewals map[string]int
+}
+
+type inMemoryLease struct {
+	leaseId  string
+	duration time.Duration
+	expires  time.Time
+}
+
+func NewInMemoryLeaser() *InMemoryLeaser {
+	return &InMemoryLeaser{leases: map[string]inMemoryLease{}, renewals: map[string]int{}}
+}
+
+func (receiver *InMemoryLeaser) AcquireLease(name string, duration time.Duration) (string, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if existing, ok := receiver.leases[name]; ok && time.Now().Before(existing.expires) {
+		return "", nil
+	}
+
+	leaseId := uuid.NewString()
+	receiver.leases[name] = inMemoryLease{leaseId: leaseId, duration: duration, expires: time.Now().Add(duration)}
+	return leaseId, nil
+}
+
+func (receiver *InMemoryLeaser) RenewLease(name string, leaseId string) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	existing, ok := receiver.leases[name]
+	if !ok || existing.leaseId != leaseId {
+		return errors.New("lease ID doesn't match the lease on " + name)
+	}
+
+	existing.expires = time.Now().Add(existing.duration)
+	receiver.leases[name] = existing
+	receiver.renewals[name]++
+	return nil
+}
+
+func (receiver *InMemoryLeaser) ReleaseLease(name string, leaseId string) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	existing, ok := receiver.leases[name]
+	if !ok || existing.leaseId != leaseId {
+		return errors.New("lease ID doesn't match the lease on " + name)
+	}
+
+	delete(receiver.leases, name)
+	return nil
+}
+
+// IsLeased returns whether someone holds an unexpired lease on name
+func (receiver *InMemoryLeaser) IsLeased(name string) bool {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	existing, ok := receiver.leases[name]
+	return ok && time.Now().Before(existing.expires)
+}
+
+// Renewals counts the successful RenewLease calls for name
+func (receiver *InMemoryLeaser) Renewals(name string) int {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	return receiver.renewals[name]
+}
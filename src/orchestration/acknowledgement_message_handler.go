@@ -0,0 +1,95 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
+	"github.com/CDCgov/reportstream-sftp-ingestion/sftp"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"os"
+)
+
+const AcknowledgementQueueBaseName = "acknowledgement"
+
+type AcknowledgementPusher interface {
+	PushAcknowledgement(acknowledgement usecases.Acknowledgement) error
+	Close()
+}
+
+// AcknowledgementMessageHandler writes acknowledgements to partners' SFTP servers, using the same credentials we use
+// to copy their files
+type AcknowledgementMessageHandler struct {
+	newAcknowledgementPusher func(partnerId string) (AcknowledgementPusher, error)
+}
+
+func NewAcknowledgementMessageHandler() AcknowledgementMessageHandler {
+	return AcknowledgementMessageHandler{newAcknowledgementPusher: func(partnerId string) (AcknowledgementPusher, error) {
+		credentialGetter, err := secrets.GetCredentialGetter()
+		if err != nil {
+			slog.Error("Unable to initialize credential getter", slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+
+		// Pushing acknowledgements doesn't copy any files, so there's nothing to acknowledge in turn
+		return sftp.NewSftpHandler(credentialGetter, partnerId, nil)
+	}}
+}
+
+func (receiver AcknowledgementMessageHandler) HandleMessageContents(message azqueue.DequeuedMessage) error {
+	var acknowledgement usecases.Acknowledgement
+	err := json.Unmarshal([]byte(*message.MessageText), &acknowledgement)
+	if err != nil {
+		slog.Error("Failed to unmarshal acknowledgement", slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	slog.Info("Handling acknowledgement", slog.String("partnerId", acknowledgement.PartnerId), slog.String(utils.FileNameKey, acknowledgement.FileName), slog.String("status", acknowledgement.Status))
+
+	acknowledgementPusher, err := receiver.newAcknowledgementPusher(acknowledgement.PartnerId)
+	if err != nil {
+		slog.Error("Failed to create sftp handler", slog.Any(utils.ErrorKey, err), slog.String("partnerId", acknowledgement.PartnerId))
+		return err
+	}
+	defer acknowledgementPusher.Close()
+
+	return acknowledgementPusher.PushAcknowledgement(acknowledgement)
+}
+
+// AcknowledgementQueueClient schedules acknowledgements by adding messages to the acknowledgement queue
+type AcknowledgementQueueClient struct {
+	queueClient QueueClient
+}
+
+func NewAcknowledgementQueueClient() (AcknowledgementQueueClient, error) {
+	azureQueueConnectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
+	client, err := azqueue.NewQueueClientFromConnectionString(azureQueueConnectionString, AcknowledgementQueueBaseName+"-queue", nil)
+	if err != nil {
+		slog.Error("Unable to create Azure Queue Client for acknowledgement queue", slog.Any(utils.ErrorKey, err))
+		return AcknowledgementQueueClient{}, err
+	}
+
+	return AcknowledgementQueueClient{queueClient: client}, nil
+}
+
+func (receiver AcknowledgementQueueClient) QueueAcknowledgement(acknowledgement usecases.Acknowledgement) error {
+	messageBytes, err := json.Marshal(acknowledgement)
+	if err != nil {
+		slog.Error("Failed to marshal acknowledgement", slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	// a TimeToLive of -1 means the message will not expire
+	opts := &azqueue.EnqueueMessageOptions{TimeToLive: to.Ptr(int32(-1))}
+	_, err = receiver.queueClient.EnqueueMessage(context.Background(), string(messageBytes), opts)
+	if err != nil {
+		slog.Error("Failed to add the acknowledgement to the queue", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, acknowledgement.FileName))
+		return err
+	}
+
+	slog.Info("Queued acknowledgement", slog.String(utils.FileNameKey, acknowledgement.FileName), slog.String("status", acknowledgement.Status))
+	return nil
+}
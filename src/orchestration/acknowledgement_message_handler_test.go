@@ -0,0 +1,90 @@
+package orchestration
+
+import (
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func Test_AcknowledgementMessageHandler_HandleMessageContents_PushesAcknowledgementAndCloses(t *testing.T) {
+	mockPusher := &MockAcknowledgementPusher{}
+	mockPusher.On("PushAcknowledgement", mock.Anything).Return(nil)
+	mockPusher.On("Close").Return()
+	var handlerPartnerId string
+	handler := AcknowledgementMessageHandler{newAcknowledgementPusher: func(partnerId string) (AcknowledgementPusher, error) {
+		handlerPartnerId = partnerId
+		return mockPusher, nil
+	}}
+
+	err := handler.HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr(`{"partnerId":"ca-phl","fileName":"results.hl7","status":"sent"}`)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ca-phl", handlerPartnerId)
+	mockPusher.AssertCalled(t, "PushAcknowledgement", usecases.Acknowledgement{PartnerId: "ca-phl", FileName: "results.hl7", Status: "sent"})
+	mockPusher.AssertCalled(t, "Close")
+}
+
+func Test_AcknowledgementMessageHandler_HandleMessageContents_MessageIsNotJson_ReturnsError(t *testing.T) {
+	handler := AcknowledgementMessageHandler{newAcknowledgementPusher: func(partnerId string) (AcknowledgementPusher, error) {
+		t.Fail()
+		return nil, nil
+	}}
+
+	err := handler.HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("not json")})
+
+	assert.Error(t, err)
+}
+
+func Test_AcknowledgementMessageHandler_HandleMessageContents_UnableToCreatePusher_ReturnsError(t *testing.T) {
+	handler := AcknowledgementMessageHandler{newAcknowledgementPusher: func(partnerId string) (AcknowledgementPusher, error) {
+		return nil, errors.New("no credentials")
+	}}
+
+	err := handler.HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr(`{"partnerId":"ca-phl"}`)})
+
+	assert.Error(t, err)
+}
+
+func Test_QueueAcknowledgement_EnqueuesMessage(t *testing.T) {
+	mockQueueClient := &MockQueueClient{}
+	mockQueueClient.On("EnqueueMessage", mock.Anything, mock.Anything, mock.Anything).Return(azqueue.EnqueueMessagesResponse{}, nil)
+
+	queueClient := AcknowledgementQueueClient{queueClient: mockQueueClient}
+
+	err := queueClient.QueueAcknowledgement(usecases.Acknowledgement{PartnerId: "ca-phl", FileName: "results.hl7", Status: "sent"})
+
+	assert.NoError(t, err)
+	mockQueueClient.AssertCalled(t, "EnqueueMessage", mock.Anything, mock.MatchedBy(func(message string) bool {
+		return assert.Contains(t, message, `"partnerId":"ca-phl","fileName":"results.hl7"`)
+	}), mock.MatchedBy(func(opts *azqueue.EnqueueMessageOptions) bool {
+		return *opts.TimeToLive == -1 && opts.VisibilityTimeout == nil
+	}))
+}
+
+func Test_QueueAcknowledgement_UnableToEnqueue_ReturnsError(t *testing.T) {
+	mockQueueClient := &MockQueueClient{}
+	mockQueueClient.On("EnqueueMessage", mock.Anything, mock.Anything, mock.Anything).Return(azqueue.EnqueueMessagesResponse{}, errors.New("queue is down"))
+
+	queueClient := AcknowledgementQueueClient{queueClient: mockQueueClient}
+
+	err := queueClient.QueueAcknowledgement(usecases.Acknowledgement{PartnerId: "ca-phl"})
+
+	assert.Error(t, err)
+}
+
+type MockAcknowledgementPusher struct {
+	mock.Mock
+}
+
+func (receiver *MockAcknowledgementPusher) PushAcknowledgement(acknowledgement usecases.Acknowledgement) error {
+	args := receiver.Called(acknowledgement)
+	return args.Error(0)
+}
+
+func (receiver *MockAcknowledgementPusher) Close() {
+	receiver.Called()
+}
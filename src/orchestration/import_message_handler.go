@@ -14,8 +14,8 @@ type ImportMessageHandler struct {
 	usecase usecases.ReadAndSend
 }
 
-func NewImportMessageHandler() (ImportMessageHandler, error) {
-	usecase, err := usecases.NewReadAndSendUsecase()
+func NewImportMessageHandler(statusQueue usecases.SubmissionStatusQueue, acknowledgementQueue usecases.AcknowledgementQueue) (ImportMessageHandler, error) {
+	usecase, err := usecases.NewReadAndSendUsecase(statusQueue, acknowledgementQueue)
 
 	if err != nil {
 		slog.Error("Unable to create Usecase", slog.Any(utils.ErrorKey, err))
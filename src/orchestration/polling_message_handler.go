@@ -1,15 +1,69 @@
 package orchestration
 
 import (
+	"context"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
 	"github.com/CDCgov/reportstream-sftp-ingestion/config"
 	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
 	"github.com/CDCgov/reportstream-sftp-ingestion/sftp"
+	"github.com/CDCgov/reportstream-sftp-ingestion/storage"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
 	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"log/slog"
+	"os"
+	"path"
+	"time"
 )
 
+const PollingQueueBaseName = "polling-trigger"
+
+// pollingLeaseDuration is the longest an Azure lease can last without being renewed. If a replica dies partway
+// through a poll, the partner can be polled again after this long
+const pollingLeaseDuration = 60 * time.Second
+
+// pollingLeaseRenewInterval leaves time to renew the lease again if one renewal fails
+const pollingLeaseRenewInterval = 20 * time.Second
+
+type FileCopier interface {
+	CopyFiles()
+	Close()
+}
+
+// PollingMessageHandler copies files from a partner's SFTP server. It holds a lease on the partner while it copies, so
+// a redelivered or manually triggered message for the same partner doesn't copy the same files at the same time
 type PollingMessageHandler struct {
+	leaser             usecases.Leaser
+	leaseRenewInterval time.Duration
+	newFileCopier      func(partnerId string) (FileCopier, error)
+}
+
+func NewPollingMessageHandler() (PollingMessageHandler, error) {
+	leaser, err := storage.NewAzureBlobLeaser()
+	if err != nil {
+		slog.Error("Failed to init Azure blob leaser", slog.Any(utils.ErrorKey, err))
+		return PollingMessageHandler{}, err
+	}
+
+	acknowledgementQueue, err := NewAcknowledgementQueueClient()
+	if err != nil {
+		slog.Error("Failed to create acknowledgement queue client", slog.Any(utils.ErrorKey, err))
+		return PollingMessageHandler{}, err
+	}
+
+	return PollingMessageHandler{
+		leaser:             leaser,
+		leaseRenewInterval: pollingLeaseRenewInterval,
+		newFileCopier: func(partnerId string) (FileCopier, error) {
+			credentialGetter, err := secrets.GetCredentialGetter()
+			if err != nil {
+				slog.Error("Unable to initialize credential getter", slog.Any(utils.ErrorKey, err))
+				return nil, err
+			}
+
+			return sftp.NewSftpHandler(credentialGetter, partnerId, acknowledgementQueue)
+		},
+	}, nil
 }
 
 func (receiver PollingMessageHandler) HandleMessageContents(message azqueue.DequeuedMessage) error {
@@ -22,30 +76,108 @@ func (receiver PollingMessageHandler) HandleMessageContents(message azqueue.Dequ
 		return nil
 	}
 
-	credentialGetter, err := secrets.GetCredentialGetter()
+	leaseName := pollingLeaseName(partnerId)
+	leaseId, err := receiver.leaser.AcquireLease(leaseName, pollingLeaseDuration)
 	if err != nil {
-		slog.Error("Unable to initialize credential getter", slog.Any(utils.ErrorKey, err))
+		slog.Error("Unable to acquire polling lease", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
 		return err
 	}
+	if leaseId == "" {
+		// The poll that's running copies the same files this one would, so we don't need the message anymore
+		slog.Info("Partner is already being polled, skipping", slog.String("partnerId", partnerId))
+		return nil
+	}
+	stopRenewing := receiver.renewLeaseUntilStopped(leaseName, leaseId)
+	defer func() {
+		stopRenewing()
+		err := receiver.leaser.ReleaseLease(leaseName, leaseId)
+		if err != nil {
+			slog.Warn("Unable to release polling lease, it will expire instead", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+		}
+	}()
 
-	sftpHandler, err := sftp.NewSftpHandler(credentialGetter, partnerId)
+	fileCopier, err := receiver.newFileCopier(partnerId)
 	if err != nil {
 		slog.Error("failed to create sftp handler", slog.Any(utils.ErrorKey, err))
 		return err
 	}
-	defer sftpHandler.Close()
+	defer fileCopier.Close()
 
 	// We don't collect errors from `CopyFiles`, so the queue message will be deleted if we reach this step
 	// regardless of whether it succeeds.
 	// Any files that didn't get copied will be picked up on the next scheduled polling event
 	// Once we see any real errors, we may revisit this
 	slog.Info("about to call CopyFiles")
-	sftpHandler.CopyFiles()
+	fileCopier.CopyFiles()
 	slog.Info("called CopyFiles")
 
 	return nil
 }
 
+// renewLeaseUntilStopped keeps the lease while a poll runs, however long the partner's files take to copy. The
+// returned function stops renewing
+func (receiver PollingMessageHandler) renewLeaseUntilStopped(leaseName string, leaseId string) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(receiver.leaseRenewInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := receiver.leaser.RenewLease(leaseName, leaseId)
+				if err != nil {
+					// We keep trying, since the lease is only lost once it expires
+					slog.Error("Unable to renew polling lease, another poll may copy the same files", slog.Any(utils.ErrorKey, err), slog.String("lease", leaseName))
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+func pollingLeaseName(partnerId string) string {
+	return path.Join("polling", partnerId)
+}
+
+// PollingQueueClient schedules polls by adding messages to the polling queue. The message is the partner ID
+type PollingQueueClient struct {
+	queueClient QueueClient
+}
+
+func NewPollingQueueClient() (PollingQueueClient, error) {
+	azureQueueConnectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
+	client, err := azqueue.NewQueueClientFromConnectionString(azureQueueConnectionString, PollingQueueBaseName+"-queue", nil)
+	if err != nil {
+		slog.Error("Unable to create Azure Queue Client for polling queue", slog.Any(utils.ErrorKey, err))
+		return PollingQueueClient{}, err
+	}
+
+	return PollingQueueClient{queueClient: client}, nil
+}
+
+func (receiver PollingQueueClient) QueuePoll(partnerId string) error {
+	// a TimeToLive of -1 means the message will not expire
+	opts := &azqueue.EnqueueMessageOptions{TimeToLive: to.Ptr(int32(-1))}
+	_, err := receiver.queueClient.EnqueueMessage(context.Background(), partnerId, opts)
+	if err != nil {
+		slog.Error("Failed to add the poll to the queue", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+		return err
+	}
+
+	slog.Info("Queued poll", slog.String("partnerId", partnerId))
+	return nil
+}
+
 func checkIsActive(partnerId string) bool {
 	isActive := false
 	if val, ok := config.Configs[partnerId]; ok {
@@ -0,0 +1,103 @@
+package orchestration
+
+import (
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config/configtest"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func Test_PollingMessageHandler_LeaseIsFree_CopiesFilesAndReleasesLease(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{"ca-phl": {IsActive: true}})
+	leaser := mocks.NewInMemoryLeaser()
+	mockFileCopier := &MockFileCopier{}
+	mockFileCopier.On("CopyFiles").Run(func(mock.Arguments) {
+		assert.True(t, leaser.IsLeased("polling/ca-phl"))
+	})
+	mockFileCopier.On("Close")
+
+	err := newTestPollingMessageHandler(leaser, mockFileCopier).HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("ca-phl")})
+
+	assert.NoError(t, err)
+	mockFileCopier.AssertExpectations(t)
+	assert.False(t, leaser.IsLeased("polling/ca-phl"))
+}
+
+func Test_PollingMessageHandler_PartnerIsAlreadyBeingPolled_SkipsPoll(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{"ca-phl": {IsActive: true}})
+	leaser := mocks.NewInMemoryLeaser()
+	_, err := leaser.AcquireLease("polling/ca-phl", pollingLeaseDuration)
+	assert.NoError(t, err)
+	mockFileCopier := &MockFileCopier{}
+
+	err = newTestPollingMessageHandler(leaser, mockFileCopier).HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("ca-phl")})
+
+	assert.NoError(t, err)
+	mockFileCopier.AssertNotCalled(t, "CopyFiles")
+}
+
+func Test_PollingMessageHandler_CopyTakesAWhile_RenewsLease(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{"ca-phl": {IsActive: true}})
+	leaser := mocks.NewInMemoryLeaser()
+	mockFileCopier := &MockFileCopier{}
+	mockFileCopier.On("CopyFiles").After(50 * time.Millisecond)
+	mockFileCopier.On("Close")
+
+	err := newTestPollingMessageHandler(leaser, mockFileCopier).HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("ca-phl")})
+
+	assert.NoError(t, err)
+	assert.Greater(t, leaser.Renewals("polling/ca-phl"), 0)
+	assert.False(t, leaser.IsLeased("polling/ca-phl"))
+}
+
+func Test_PollingMessageHandler_UnableToAcquireLease_ReturnsError(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{"ca-phl": {IsActive: true}})
+	mockLeaser := &MockLeaser{}
+	mockLeaser.On("AcquireLease", "polling/ca-phl", pollingLeaseDuration).Return("", errors.New("blob storage is down"))
+	mockFileCopier := &MockFileCopier{}
+
+	err := newTestPollingMessageHandler(mockLeaser, mockFileCopier).HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("ca-phl")})
+
+	assert.Error(t, err)
+	mockFileCopier.AssertNotCalled(t, "CopyFiles")
+}
+
+func Test_PollingMessageHandler_PartnerIsNotActive_DoesNotTakeLease(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{"ca-phl": {}})
+	mockLeaser := &MockLeaser{}
+	mockFileCopier := &MockFileCopier{}
+
+	err := newTestPollingMessageHandler(mockLeaser, mockFileCopier).HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("ca-phl")})
+
+	assert.NoError(t, err)
+	mockLeaser.AssertNotCalled(t, "AcquireLease", mock.Anything, mock.Anything)
+}
+
+func newTestPollingMessageHandler(leaser usecases.Leaser, fileCopier FileCopier) PollingMessageHandler {
+	return PollingMessageHandler{
+		leaser:             leaser,
+		leaseRenewInterval: 10 * time.Millisecond,
+		newFileCopier: func(partnerId string) (FileCopier, error) {
+			return fileCopier, nil
+		},
+	}
+}
+
+type MockFileCopier struct {
+	mock.Mock
+}
+
+func (receiver *MockFileCopier) CopyFiles() {
+	receiver.Called()
+}
+
+func (receiver *MockFileCopier) Close() {
+	receiver.Called()
+}
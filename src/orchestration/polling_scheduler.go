@@ -0,0 +1,105 @@
+package orchestration
+
+import (
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/cron"
+	"github.com/CDCgov/reportstream-sftp-ingestion/storage"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"time"
+)
+
+// schedulerLeaseName is the lease that makes one replica the scheduler
+const schedulerLeaseName = "polling-scheduler"
+
+// schedulerLeaseDuration is the longest an Azure lease can last without being renewed. If the scheduling replica dies,
+// another one takes over within this long
+const schedulerLeaseDuration = 60 * time.Second
+
+// schedulerInterval is how often we renew the lease and check for polls that are due, so polls run up to this long
+// after their cron time
+const schedulerInterval = 10 * time.Second
+
+// PollingScheduler queues polls for each active partner on the cron schedule in their PollingSettings. Every replica
+// runs one, but only the replica holding the scheduler lease queues polls, so each poll is only queued once
+type PollingScheduler struct {
+	leaser       usecases.Leaser
+	pollingQueue usecases.PollingQueue
+	leaseId      string
+	nextPolls    map[string]time.Time
+}
+
+func NewPollingScheduler(pollingQueue usecases.PollingQueue) (*PollingScheduler, error) {
+	leaser, err := storage.NewAzureBlobLeaser()
+	if err != nil {
+		slog.Error("Failed to init Azure blob leaser", slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	return &PollingScheduler{leaser: leaser, pollingQueue: pollingQueue}, nil
+}
+
+func (receiver *PollingScheduler) Run() {
+	for {
+		receiver.tick(time.Now())
+		time.Sleep(schedulerInterval)
+	}
+}
+
+// tick takes or renews the scheduler lease, then queues the polls that are due if we hold it
+func (receiver *PollingScheduler) tick(now time.Time) {
+	if !receiver.holdLease() {
+		return
+	}
+
+	for partnerId, partnerConfig := range config.Configs {
+		if partnerConfig == nil || !partnerConfig.PartnerSettings.IsActive || partnerConfig.PartnerSettings.Polling.Cron == "" {
+			continue
+		}
+
+		// The cron was checked when we loaded the config
+		schedule, _ := cron.Parse(partnerConfig.PartnerSettings.Polling.Cron)
+
+		nextPoll, ok := receiver.nextPolls[partnerId]
+		if !ok {
+			receiver.nextPolls[partnerId] = schedule.Next(now)
+			continue
+		}
+		// A cron that never matches, like `* * * 30 Feb *`, has no next poll
+		if nextPoll.IsZero() || now.Before(nextPoll) {
+			continue
+		}
+
+		// If the queue is down, we try again next tick rather than waiting for the next scheduled poll
+		err := receiver.pollingQueue.QueuePoll(partnerId)
+		if err != nil {
+			slog.Error("Failed to queue scheduled poll", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+			continue
+		}
+		receiver.nextPolls[partnerId] = schedule.Next(now)
+	}
+}
+
+// holdLease returns whether we're the scheduler. When we first take the lease, we start each partner's schedule from
+// now, rather than queueing polls the previous scheduler may already have queued
+func (receiver *PollingScheduler) holdLease() bool {
+	if receiver.leaseId != "" {
+		err := receiver.leaser.RenewLease(schedulerLeaseName, receiver.leaseId)
+		if err == nil {
+			return true
+		}
+		slog.Warn("Lost the polling scheduler lease", slog.Any(utils.ErrorKey, err))
+		receiver.leaseId = ""
+	}
+
+	leaseId, err := receiver.leaser.AcquireLease(schedulerLeaseName, schedulerLeaseDuration)
+	if err != nil || leaseId == "" {
+		return false
+	}
+
+	slog.Info("This replica is now the polling scheduler")
+	receiver.leaseId = leaseId
+	receiver.nextPolls = map[string]time.Time{}
+	return true
+}
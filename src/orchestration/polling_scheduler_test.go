@@ -0,0 +1,165 @@
+package orchestration
+
+import (
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config/configtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+var schedulerStart = time.Date(2024, time.June, 14, 10, 7, 30, 0, time.UTC)
+
+func Test_tick_PollIsDue_QueuesPollOnce(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{
+		"ca-phl":  {IsActive: true, Polling: config.PollingSettings{Cron: "0 */15 * * * *"}},
+		"flexion": {IsActive: true},
+	})
+	mockLeaser := &MockLeaser{}
+	mockLeaser.On("AcquireLease", schedulerLeaseName, schedulerLeaseDuration).Return("lease-id", nil)
+	mockLeaser.On("RenewLease", schedulerLeaseName, "lease-id").Return(nil)
+	mockPollingQueue := &MockPollingQueue{}
+	mockPollingQueue.On("QueuePoll", "ca-phl").Return(nil)
+
+	scheduler := PollingScheduler{leaser: mockLeaser, pollingQueue: mockPollingQueue}
+	scheduler.tick(schedulerStart)
+	mockPollingQueue.AssertNotCalled(t, "QueuePoll", mock.Anything)
+
+	scheduler.tick(time.Date(2024, time.June, 14, 10, 15, 5, 0, time.UTC))
+	scheduler.tick(time.Date(2024, time.June, 14, 10, 15, 15, 0, time.UTC))
+
+	mockPollingQueue.AssertNumberOfCalls(t, "QueuePoll", 1)
+}
+
+func Test_tick_AnotherReplicaHoldsLease_DoesNotQueuePolls(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{
+		"ca-phl": {IsActive: true, Polling: config.PollingSettings{Cron: "* * * * * *"}},
+	})
+	mockLeaser := &MockLeaser{}
+	mockLeaser.On("AcquireLease", schedulerLeaseName, schedulerLeaseDuration).Return("", nil)
+	mockPollingQueue := &MockPollingQueue{}
+
+	scheduler := PollingScheduler{leaser: mockLeaser, pollingQueue: mockPollingQueue}
+	scheduler.tick(schedulerStart)
+	scheduler.tick(schedulerStart.Add(time.Minute))
+
+	mockPollingQueue.AssertNotCalled(t, "QueuePoll", mock.Anything)
+}
+
+func Test_tick_LosesLease_StopsQueueingPolls(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{
+		"ca-phl": {IsActive: true, Polling: config.PollingSettings{Cron: "* * * * * *"}},
+	})
+	mockLeaser := &MockLeaser{}
+	mockLeaser.On("AcquireLease", schedulerLeaseName, schedulerLeaseDuration).Return("lease-id", nil).Once()
+	mockLeaser.On("AcquireLease", schedulerLeaseName, schedulerLeaseDuration).Return("", nil)
+	mockLeaser.On("RenewLease", schedulerLeaseName, "lease-id").Return(errors.New("lease expired"))
+	mockPollingQueue := &MockPollingQueue{}
+
+	scheduler := PollingScheduler{leaser: mockLeaser, pollingQueue: mockPollingQueue}
+	scheduler.tick(schedulerStart)
+	scheduler.tick(schedulerStart.Add(time.Minute))
+
+	mockPollingQueue.AssertNotCalled(t, "QueuePoll", mock.Anything)
+	assert.Empty(t, scheduler.leaseId)
+}
+
+func Test_tick_QueueIsDown_TriesAgainNextTick(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{
+		"ca-phl": {IsActive: true, Polling: config.PollingSettings{Cron: "0 0 * * * *"}},
+	})
+	mockLeaser := &MockLeaser{}
+	mockLeaser.On("AcquireLease", schedulerLeaseName, schedulerLeaseDuration).Return("lease-id", nil)
+	mockLeaser.On("RenewLease", schedulerLeaseName, "lease-id").Return(nil)
+	mockPollingQueue := &MockPollingQueue{}
+	mockPollingQueue.On("QueuePoll", "ca-phl").Return(errors.New("queue is down")).Once()
+	mockPollingQueue.On("QueuePoll", "ca-phl").Return(nil)
+
+	scheduler := PollingScheduler{leaser: mockLeaser, pollingQueue: mockPollingQueue}
+	scheduler.tick(schedulerStart)
+	scheduler.tick(time.Date(2024, time.June, 14, 11, 0, 5, 0, time.UTC))
+	scheduler.tick(time.Date(2024, time.June, 14, 11, 0, 15, 0, time.UTC))
+	scheduler.tick(time.Date(2024, time.June, 14, 11, 0, 25, 0, time.UTC))
+
+	mockPollingQueue.AssertNumberOfCalls(t, "QueuePoll", 2)
+}
+
+func Test_tick_CronNeverMatches_DoesNotQueuePolls(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{
+		"ca-phl": {IsActive: true, Polling: config.PollingSettings{Cron: "* * * 30 Feb *"}},
+	})
+	mockLeaser := &MockLeaser{}
+	mockLeaser.On("AcquireLease", schedulerLeaseName, schedulerLeaseDuration).Return("lease-id", nil)
+	mockLeaser.On("RenewLease", schedulerLeaseName, "lease-id").Return(nil)
+	mockPollingQueue := &MockPollingQueue{}
+
+	scheduler := PollingScheduler{leaser: mockLeaser, pollingQueue: mockPollingQueue}
+	scheduler.tick(schedulerStart)
+	scheduler.tick(schedulerStart.Add(time.Hour))
+
+	mockPollingQueue.AssertNotCalled(t, "QueuePoll", mock.Anything)
+}
+
+func Test_tick_PartnerConfigDidNotLoad_QueuesOtherPartnersPolls(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{
+		"ca-phl": {IsActive: true, Polling: config.PollingSettings{Cron: "0 */15 * * * *"}},
+	})
+	config.Configs["flexion"] = nil
+	mockLeaser := &MockLeaser{}
+	mockLeaser.On("AcquireLease", schedulerLeaseName, schedulerLeaseDuration).Return("lease-id", nil)
+	mockLeaser.On("RenewLease", schedulerLeaseName, "lease-id").Return(nil)
+	mockPollingQueue := &MockPollingQueue{}
+	mockPollingQueue.On("QueuePoll", "ca-phl").Return(nil)
+
+	scheduler := PollingScheduler{leaser: mockLeaser, pollingQueue: mockPollingQueue}
+	scheduler.tick(schedulerStart)
+	scheduler.tick(time.Date(2024, time.June, 14, 10, 15, 5, 0, time.UTC))
+
+	mockPollingQueue.AssertCalled(t, "QueuePoll", "ca-phl")
+	mockPollingQueue.AssertNotCalled(t, "QueuePoll", "flexion")
+}
+
+func Test_QueuePoll_EnqueuesPartnerId(t *testing.T) {
+	mockQueueClient := &MockQueueClient{}
+	mockQueueClient.On("EnqueueMessage", mock.Anything, mock.Anything, mock.Anything).Return(azqueue.EnqueueMessagesResponse{}, nil)
+
+	queueClient := PollingQueueClient{queueClient: mockQueueClient}
+
+	err := queueClient.QueuePoll("ca-phl")
+
+	assert.NoError(t, err)
+	mockQueueClient.AssertCalled(t, "EnqueueMessage", mock.Anything, "ca-phl", mock.MatchedBy(func(opts *azqueue.EnqueueMessageOptions) bool {
+		return *opts.TimeToLive == -1
+	}))
+}
+
+type MockLeaser struct {
+	mock.Mock
+}
+
+func (receiver *MockLeaser) AcquireLease(name string, duration time.Duration) (string, error) {
+	args := receiver.Called(name, duration)
+	return args.String(0), args.Error(1)
+}
+
+func (receiver *MockLeaser) RenewLease(name string, leaseId string) error {
+	args := receiver.Called(name, leaseId)
+	return args.Error(0)
+}
+
+func (receiver *MockLeaser) ReleaseLease(name string, leaseId string) error {
+	args := receiver.Called(name, leaseId)
+	return args.Error(0)
+}
+
+type MockPollingQueue struct {
+	mock.Mock
+}
+
+func (receiver *MockPollingQueue) QueuePoll(partnerId string) error {
+	args := receiver.Called(partnerId)
+	return args.Error(0)
+}
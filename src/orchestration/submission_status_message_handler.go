@@ -0,0 +1,83 @@
+package orchestration
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+const SubmissionStatusQueueBaseName = "submission-status"
+
+type SubmissionStatusMessageHandler struct {
+	usecase usecases.CheckSubmissionStatus
+}
+
+func NewSubmissionStatusMessageHandler(statusQueue usecases.SubmissionStatusQueue, acknowledgementQueue usecases.AcknowledgementQueue) (SubmissionStatusMessageHandler, error) {
+	usecase, err := usecases.NewCheckSubmissionStatusUsecase(statusQueue, acknowledgementQueue)
+	if err != nil {
+		slog.Error("Unable to create Usecase", slog.Any(utils.ErrorKey, err))
+		return SubmissionStatusMessageHandler{}, err
+	}
+
+	return SubmissionStatusMessageHandler{usecase: &usecase}, nil
+}
+
+func (receiver SubmissionStatusMessageHandler) HandleMessageContents(message azqueue.DequeuedMessage) error {
+	var check usecases.SubmissionStatusCheck
+	err := json.Unmarshal([]byte(*message.MessageText), &check)
+	if err != nil {
+		slog.Error("Failed to unmarshal submission status check", slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	return receiver.usecase.CheckSubmissionStatus(check)
+}
+
+// SubmissionStatusQueueClient schedules submission status checks by adding messages to the submission status queue
+// that stay invisible until it's time to check again
+type SubmissionStatusQueueClient struct {
+	queueClient  QueueClient
+	delaySeconds int32
+}
+
+func NewSubmissionStatusQueueClient() (SubmissionStatusQueueClient, error) {
+	azureQueueConnectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
+	client, err := azqueue.NewQueueClientFromConnectionString(azureQueueConnectionString, SubmissionStatusQueueBaseName+"-queue", nil)
+	if err != nil {
+		slog.Error("Unable to create Azure Queue Client for submission status queue", slog.Any(utils.ErrorKey, err))
+		return SubmissionStatusQueueClient{}, err
+	}
+
+	delaySeconds, err := strconv.ParseInt(os.Getenv("SUBMISSION_STATUS_CHECK_DELAY_SECONDS"), 10, 32)
+	if err != nil {
+		delaySeconds = 300
+		slog.Info("Failed to parse SUBMISSION_STATUS_CHECK_DELAY_SECONDS, defaulting to 300")
+	}
+
+	return SubmissionStatusQueueClient{queueClient: client, delaySeconds: int32(delaySeconds)}, nil
+}
+
+func (receiver SubmissionStatusQueueClient) QueueStatusCheck(check usecases.SubmissionStatusCheck) error {
+	messageBytes, err := json.Marshal(check)
+	if err != nil {
+		slog.Error("Failed to marshal submission status check", slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	// a TimeToLive of -1 means the message will not expire
+	opts := &azqueue.EnqueueMessageOptions{TimeToLive: to.Ptr(int32(-1)), VisibilityTimeout: to.Ptr(receiver.delaySeconds)}
+	_, err = receiver.queueClient.EnqueueMessage(context.Background(), string(messageBytes), opts)
+	if err != nil {
+		slog.Error("Failed to add the submission status check to the queue", slog.Any(utils.ErrorKey, err), slog.String("reportId", check.ReportId))
+		return err
+	}
+
+	slog.Info("Queued submission status check", slog.String("reportId", check.ReportId), slog.Int("attempt", check.Attempt))
+	return nil
+}
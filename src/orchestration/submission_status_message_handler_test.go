@@ -0,0 +1,72 @@
+package orchestration
+
+import (
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func Test_SubmissionStatusMessageHandler_HandleMessageContents_MessageIsValid_ChecksStatus(t *testing.T) {
+	mockUsecase := &MockCheckSubmissionStatusUsecase{}
+	mockUsecase.On("CheckSubmissionStatus", mock.Anything).Return(nil)
+
+	handler := SubmissionStatusMessageHandler{usecase: mockUsecase}
+
+	message := azqueue.DequeuedMessage{MessageText: to.Ptr(`{"reportId":"report","sourceUrl":"http://localhost/sftp/success/msg.hl7","attempt":2}`)}
+
+	err := handler.HandleMessageContents(message)
+
+	assert.NoError(t, err)
+	mockUsecase.AssertCalled(t, "CheckSubmissionStatus", usecases.SubmissionStatusCheck{ReportId: "report", SourceUrl: "http://localhost/sftp/success/msg.hl7", Attempt: 2})
+}
+
+func Test_SubmissionStatusMessageHandler_HandleMessageContents_MessageIsInvalid_ReturnsError(t *testing.T) {
+	mockUsecase := &MockCheckSubmissionStatusUsecase{}
+
+	handler := SubmissionStatusMessageHandler{usecase: mockUsecase}
+
+	message := azqueue.DequeuedMessage{MessageText: to.Ptr("not json")}
+
+	err := handler.HandleMessageContents(message)
+
+	assert.Error(t, err)
+	mockUsecase.AssertNotCalled(t, "CheckSubmissionStatus", mock.Anything)
+}
+
+func Test_QueueStatusCheck_EnqueuesDelayedMessage(t *testing.T) {
+	mockQueueClient := &MockQueueClient{}
+	mockQueueClient.On("EnqueueMessage", mock.Anything, mock.Anything, mock.Anything).Return(azqueue.EnqueueMessagesResponse{}, nil)
+
+	queueClient := SubmissionStatusQueueClient{queueClient: mockQueueClient, delaySeconds: 60}
+
+	err := queueClient.QueueStatusCheck(usecases.SubmissionStatusCheck{ReportId: "report", SourceUrl: "url"})
+
+	assert.NoError(t, err)
+	mockQueueClient.AssertCalled(t, "EnqueueMessage", mock.Anything, `{"reportId":"report","sourceUrl":"url","attempt":0}`, mock.MatchedBy(func(opts *azqueue.EnqueueMessageOptions) bool {
+		return *opts.VisibilityTimeout == 60 && *opts.TimeToLive == -1
+	}))
+}
+
+func Test_QueueStatusCheck_UnableToEnqueue_ReturnsError(t *testing.T) {
+	mockQueueClient := &MockQueueClient{}
+	mockQueueClient.On("EnqueueMessage", mock.Anything, mock.Anything, mock.Anything).Return(azqueue.EnqueueMessagesResponse{}, errors.New("queue is down"))
+
+	queueClient := SubmissionStatusQueueClient{queueClient: mockQueueClient, delaySeconds: 60}
+
+	err := queueClient.QueueStatusCheck(usecases.SubmissionStatusCheck{ReportId: "report", SourceUrl: "url"})
+
+	assert.Error(t, err)
+}
+
+type MockCheckSubmissionStatusUsecase struct {
+	mock.Mock
+}
+
+func (receiver *MockCheckSubmissionStatusUsecase) CheckSubmissionStatus(check usecases.SubmissionStatusCheck) error {
+	args := receiver.Called(check)
+	return args.Error(0)
+}
@@ -0,0 +1,51 @@
+package orchestration
+
+import (
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/CDCgov/reportstream-sftp-ingestion/zip"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+const ZipReprocessQueueBaseName = "zip-reprocess"
+
+type ZipReprocessor interface {
+	Reprocess(blobPath string) error
+}
+
+// ZipReprocessMessageHandler re-processes the archive whose blob path is in the message, e.g.
+// `ca-phl/unzip/failure/results.zip`
+type ZipReprocessMessageHandler struct {
+	newZipReprocessor func(partnerId string) (ZipReprocessor, error)
+}
+
+func NewZipReprocessMessageHandler() ZipReprocessMessageHandler {
+	return ZipReprocessMessageHandler{newZipReprocessor: func(partnerId string) (ZipReprocessor, error) {
+		// A re-process leaves the archive in `unzip/failure` if it still fails, which the partner already knows about
+		return zip.NewZipHandler(partnerId, nil)
+	}}
+}
+
+func (receiver ZipReprocessMessageHandler) HandleMessageContents(message azqueue.DequeuedMessage) error {
+	blobPath := strings.TrimPrefix(strings.TrimSpace(*message.MessageText), utils.ContainerName+"/")
+	slog.Info("Handling zip re-process message", slog.String("blobPath", blobPath))
+
+	// Partner archives are in `<partner>/unzip`
+	segments := strings.Split(blobPath, "/")
+	if slices.Index(segments, utils.UnzipFolder) != 1 {
+		slog.Error("Zip re-process message isn't an archive in a partner's unzip folder", slog.String("blobPath", blobPath))
+		return errors.New("not an archive in a partner's unzip folder: " + blobPath)
+	}
+	partnerId := segments[0]
+
+	zipReprocessor, err := receiver.newZipReprocessor(partnerId)
+	if err != nil {
+		slog.Error("Failed to create zip handler", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+		return err
+	}
+
+	return zipReprocessor.Reprocess(blobPath)
+}
@@ -0,0 +1,57 @@
+package orchestration
+
+import (
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func Test_ZipReprocessMessageHandler_HandleMessageContents_MessageIsPartnerArchive_Reprocesses(t *testing.T) {
+	mockZipReprocessor := &MockZipReprocessor{}
+	mockZipReprocessor.On("Reprocess", mock.Anything).Return(nil)
+	var handlerPartnerId string
+	handler := ZipReprocessMessageHandler{newZipReprocessor: func(partnerId string) (ZipReprocessor, error) {
+		handlerPartnerId = partnerId
+		return mockZipReprocessor, nil
+	}}
+
+	err := handler.HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("sftp/ca-phl/unzip/failure/results.zip")})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ca-phl", handlerPartnerId)
+	mockZipReprocessor.AssertCalled(t, "Reprocess", "ca-phl/unzip/failure/results.zip")
+}
+
+func Test_ZipReprocessMessageHandler_HandleMessageContents_MessageIsNotInUnzipFolder_ReturnsError(t *testing.T) {
+	mockZipReprocessor := &MockZipReprocessor{}
+	handler := ZipReprocessMessageHandler{newZipReprocessor: func(partnerId string) (ZipReprocessor, error) {
+		return mockZipReprocessor, nil
+	}}
+
+	err := handler.HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("ca-phl/import/results.zip")})
+
+	assert.Error(t, err)
+	mockZipReprocessor.AssertNotCalled(t, "Reprocess", mock.Anything)
+}
+
+func Test_ZipReprocessMessageHandler_HandleMessageContents_UnableToCreateZipHandler_ReturnsError(t *testing.T) {
+	handler := ZipReprocessMessageHandler{newZipReprocessor: func(partnerId string) (ZipReprocessor, error) {
+		return nil, errors.New("no config found for partner " + partnerId)
+	}}
+
+	err := handler.HandleMessageContents(azqueue.DequeuedMessage{MessageText: to.Ptr("ca-phl/unzip/results.zip")})
+
+	assert.Error(t, err)
+}
+
+type MockZipReprocessor struct {
+	mock.Mock
+}
+
+func (receiver *MockZipReprocessor) Reprocess(blobPath string) error {
+	args := receiver.Called(blobPath)
+	return args.Error(0)
+}
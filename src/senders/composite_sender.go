@@ -0,0 +1,133 @@
+package senders
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Destination is a MessageSender with a name we can use in logs
+type Destination struct {
+	Name   string
+	Sender MessageSender
+}
+
+// CompositeSender sends each message to several destinations. The first destination is the primary one, and its
+// report ID is the one we return
+type CompositeSender struct {
+	destinations []Destination
+	mode         string
+}
+
+// NewPartnerSender builds the sender for a partner's configured destinations. A single destination is returned
+// as-is, while multiple destinations are wrapped in a CompositeSender
+func NewPartnerSender(partnerId string, senderSettings config.SenderSettings) (MessageSender, error) {
+	var destinations []Destination
+	for _, destinationSettings := range senderSettings.Destinations {
+		sender, err := newDestinationSender(partnerId, destinationSettings)
+		if err != nil {
+			slog.Error("Failed to construct sender for destination", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId), slog.String("destination", destinationSettings.Name))
+			return nil, err
+		}
+		destinations = append(destinations, Destination{Name: destinationSettings.Name, Sender: sender})
+	}
+
+	if len(destinations) == 0 {
+		return nil, errors.New("no destinations configured for partner " + partnerId)
+	}
+
+	if len(destinations) == 1 {
+		return destinations[0].Sender, nil
+	}
+
+	return CompositeSender{destinations: destinations, mode: senderSettings.Mode}, nil
+}
+
+func newDestinationSender(partnerId string, destinationSettings config.DestinationSettings) (MessageSender, error) {
+	switch destinationSettings.Type {
+	case config.DestinationTypeReportStream:
+		sender, err := NewSender()
+		if err != nil {
+			return nil, err
+		}
+		if destinationSettings.Url != "" {
+			sender = sender.WithBaseUrl(destinationSettings.Url)
+		}
+		return sender, nil
+	case config.DestinationTypeFile:
+		sender, err := NewFileSender()
+		if err != nil {
+			return nil, err
+		}
+		if destinationSettings.Directory != "" {
+			sender.directory = destinationSettings.Directory
+		}
+		return sender, nil
+	case config.DestinationTypeMllp:
+		return NewMllpSender(destinationSettings.Address, destinationSettings.Tls, time.Duration(destinationSettings.TimeoutSeconds)*time.Second)
+	case config.DestinationTypeWebhook:
+		return NewWebhookSender(partnerId, destinationSettings)
+	default:
+		return nil, errors.New("unknown destination type " + destinationSettings.Type)
+	}
+}
+
+// Primary returns the sender whose result decides the outcome in `primaryPlusBestEffort` mode
+func (receiver CompositeSender) Primary() MessageSender {
+	return receiver.destinations[0].Sender
+}
+
+// SendMessage sends the message to every destination and logs each destination's result. In `allMustSucceed` mode,
+// any failure fails the whole send. If every failure is non-transient we return a non-transient error so the message
+// moves to `failure`, unless the primary destination accepted it. Then we return the primary's report ID with a
+// SecondaryDestinationNonTransientFailure error instead. Otherwise we return an error that will retry the message.
+// Retries send to every destination again, so destinations that already succeeded may receive a duplicate
+func (receiver CompositeSender) SendMessage(message []byte) (string, error) {
+	return receiver.SendMessageFromSource(message, MessageSource{})
+}
+
+// SendMessageFromSource works like SendMessage, and passes the source along to destinations that use it
+func (receiver CompositeSender) SendMessageFromSource(message []byte, source MessageSource) (string, error) {
+	var primaryReportId string
+	var primaryErr error
+	var failedDestinations []string
+	allFailuresNonTransient := true
+
+	for index, destination := range receiver.destinations {
+		reportId, err := SendMessageFromSource(destination.Sender, message, source)
+		if err != nil {
+			slog.Warn("Failed to send message to destination", slog.String("destination", destination.Name), slog.Bool("primary", index == 0), slog.Any(utils.ErrorKey, err))
+			failedDestinations = append(failedDestinations, destination.Name)
+			if !strings.Contains(err.Error(), utils.ReportStreamNonTransientFailure) {
+				allFailuresNonTransient = false
+			}
+		} else {
+			slog.Info("Sent message to destination", slog.String("destination", destination.Name), slog.Bool("primary", index == 0), slog.String("reportId", reportId))
+		}
+
+		if index == 0 {
+			primaryReportId = reportId
+			primaryErr = err
+		}
+	}
+
+	if receiver.mode == config.SenderModePrimaryPlusBestEffort {
+		return primaryReportId, primaryErr
+	}
+
+	if len(failedDestinations) > 0 {
+		failureSummary := "failed to send to destinations: " + strings.Join(failedDestinations, ", ")
+		if allFailuresNonTransient && primaryErr == nil {
+			return primaryReportId, errors.New(utils.SecondaryDestinationNonTransientFailure + ": " + failureSummary)
+		}
+		if allFailuresNonTransient {
+			return "", errors.New(utils.ReportStreamNonTransientFailure + ": " + failureSummary)
+		}
+		return "", errors.New(failureSummary)
+	}
+
+	return primaryReportId, nil
+}
@@ -0,0 +1,201 @@
+package senders
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var message = []byte("The DogCow went Moof!")
+
+func Test_CompositeSender_SendMessage_AllDestinationsSucceed_ReturnsPrimaryReportId(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	primarySender := &MockMessageSender{}
+	primarySender.On("SendMessage", message).Return("primary report ID", nil)
+	archiveSender := &MockMessageSender{}
+	archiveSender.On("SendMessage", message).Return("archive report ID", nil)
+
+	compositeSender := CompositeSender{
+		destinations: []Destination{{Name: "reportstream", Sender: primarySender}, {Name: "archive", Sender: archiveSender}},
+		mode:         config.SenderModeAllMustSucceed,
+	}
+
+	reportId, err := compositeSender.SendMessage(message)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "primary report ID", reportId)
+	assert.Contains(t, buffer.String(), "destination=reportstream")
+	assert.Contains(t, buffer.String(), "destination=archive")
+}
+
+func Test_CompositeSender_SendMessage_AllMustSucceedAndSecondaryFailsTransiently_ReturnsTransientError(t *testing.T) {
+	primarySender := &MockMessageSender{}
+	primarySender.On("SendMessage", message).Return("primary report ID", nil)
+	archiveSender := &MockMessageSender{}
+	archiveSender.On("SendMessage", message).Return("", errors.New("503 Service Unavailable"))
+	otherSender := &MockMessageSender{}
+	otherSender.On("SendMessage", message).Return("", errors.New(utils.ReportStreamNonTransientFailure))
+
+	compositeSender := CompositeSender{
+		destinations: []Destination{{Name: "reportstream", Sender: primarySender}, {Name: "archive", Sender: archiveSender}, {Name: "other", Sender: otherSender}},
+		mode:         config.SenderModeAllMustSucceed,
+	}
+
+	reportId, err := compositeSender.SendMessage(message)
+
+	assert.Error(t, err)
+	assert.Equal(t, "", reportId)
+	assert.NotContains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+	assert.Contains(t, err.Error(), "archive, other")
+}
+
+func Test_CompositeSender_SendMessage_AllMustSucceedAndFailuresAreNonTransient_ReturnsNonTransientError(t *testing.T) {
+	primarySender := &MockMessageSender{}
+	primarySender.On("SendMessage", message).Return("", errors.New(utils.ReportStreamNonTransientFailure))
+	archiveSender := &MockMessageSender{}
+	archiveSender.On("SendMessage", message).Return("archive report ID", nil)
+
+	compositeSender := CompositeSender{
+		destinations: []Destination{{Name: "reportstream", Sender: primarySender}, {Name: "archive", Sender: archiveSender}},
+		mode:         config.SenderModeAllMustSucceed,
+	}
+
+	_, err := compositeSender.SendMessage(message)
+
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), utils.ReportStreamNonTransientFailure))
+	archiveSender.AssertCalled(t, "SendMessage", message)
+}
+
+func Test_CompositeSender_SendMessage_AllMustSucceedAndOnlySecondaryFailsNonTransiently_ReturnsPrimaryReportId(t *testing.T) {
+	primarySender := &MockMessageSender{}
+	primarySender.On("SendMessage", message).Return("primary report ID", nil)
+	archiveSender := &MockMessageSender{}
+	archiveSender.On("SendMessage", message).Return("", errors.New(utils.ReportStreamNonTransientFailure+": 400 Bad Request"))
+
+	compositeSender := CompositeSender{
+		destinations: []Destination{{Name: "reportstream", Sender: primarySender}, {Name: "archive", Sender: archiveSender}},
+		mode:         config.SenderModeAllMustSucceed,
+	}
+
+	reportId, err := compositeSender.SendMessage(message)
+
+	assert.Equal(t, "primary report ID", reportId)
+	assert.True(t, strings.HasPrefix(err.Error(), utils.SecondaryDestinationNonTransientFailure))
+	assert.NotContains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+	assert.Contains(t, err.Error(), "archive")
+}
+
+func Test_CompositeSender_SendMessage_BestEffortAndSecondaryFails_ReturnsPrimaryResult(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	primarySender := &MockMessageSender{}
+	primarySender.On("SendMessage", message).Return("primary report ID", nil)
+	archiveSender := &MockMessageSender{}
+	archiveSender.On("SendMessage", message).Return("", errors.New("503 Service Unavailable"))
+
+	compositeSender := CompositeSender{
+		destinations: []Destination{{Name: "reportstream", Sender: primarySender}, {Name: "archive", Sender: archiveSender}},
+		mode:         config.SenderModePrimaryPlusBestEffort,
+	}
+
+	reportId, err := compositeSender.SendMessage(message)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "primary report ID", reportId)
+	assert.Contains(t, buffer.String(), "Failed to send message to destination")
+}
+
+func Test_CompositeSender_SendMessage_BestEffortAndPrimaryFails_ReturnsPrimaryError(t *testing.T) {
+	primarySender := &MockMessageSender{}
+	primarySender.On("SendMessage", message).Return("", errors.New("503 Service Unavailable"))
+	archiveSender := &MockMessageSender{}
+	archiveSender.On("SendMessage", message).Return("archive report ID", nil)
+
+	compositeSender := CompositeSender{
+		destinations: []Destination{{Name: "reportstream", Sender: primarySender}, {Name: "archive", Sender: archiveSender}},
+		mode:         config.SenderModePrimaryPlusBestEffort,
+	}
+
+	_, err := compositeSender.SendMessage(message)
+
+	assert.Error(t, err)
+	assert.Equal(t, "503 Service Unavailable", err.Error())
+}
+
+func Test_NewPartnerSender_SingleDestination_ReturnsSenderWithoutComposite(t *testing.T) {
+	sender, err := NewPartnerSender("flexion", config.SenderSettings{
+		Mode:         config.SenderModeAllMustSucceed,
+		Destinations: []config.DestinationSettings{{Name: "local", Type: config.DestinationTypeFile}},
+	})
+
+	assert.NoError(t, err)
+	assert.IsType(t, FileSender{}, sender)
+}
+
+func Test_NewPartnerSender_MultipleDestinations_ReturnsCompositeSender(t *testing.T) {
+	sender, err := NewPartnerSender("flexion", config.SenderSettings{
+		Mode: config.SenderModePrimaryPlusBestEffort,
+		Destinations: []config.DestinationSettings{
+			{Name: "reportstream", Type: config.DestinationTypeReportStream, Url: "https://staging.prime.cdc.gov"},
+			{Name: "local", Type: config.DestinationTypeFile},
+		},
+	})
+
+	assert.NoError(t, err)
+	compositeSender := sender.(CompositeSender)
+	assert.Equal(t, "https://staging.prime.cdc.gov", compositeSender.Primary().(Sender).baseUrl)
+	assert.True(t, TracksSubmissionHistory(compositeSender))
+	assert.Equal(t, "https://staging.prime.cdc.gov", ReportStreamUrl(compositeSender))
+}
+
+func Test_NewPartnerSender_UnknownDestinationType_ReturnsError(t *testing.T) {
+	_, err := NewPartnerSender("flexion", config.SenderSettings{
+		Destinations: []config.DestinationSettings{{Name: "carrier pigeon", Type: "pigeon"}},
+	})
+
+	assert.Error(t, err)
+}
+
+func Test_TracksSubmissionHistory_PrimaryIsFileSender_ReturnsFalse(t *testing.T) {
+	compositeSender := CompositeSender{destinations: []Destination{{Name: "local", Sender: FileSender{}}, {Name: "reportstream", Sender: Sender{}}}}
+
+	assert.False(t, TracksSubmissionHistory(compositeSender))
+	assert.True(t, TracksSubmissionHistory(Sender{}))
+	assert.Empty(t, ReportStreamUrl(compositeSender))
+}
+
+type MockMessageSender struct {
+	mock.Mock
+}
+
+func (receiver *MockMessageSender) SendMessage(message []byte) (string, error) {
+	args := receiver.Called(message)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func Test_CompositeSender_SendMessageFromSource_PassesSourceToSourceAwareDestinations(t *testing.T) {
+	directory := t.TempDir()
+	otherSender := &MockMessageSender{}
+	otherSender.On("SendMessage", message).Return("other report ID", nil)
+
+	compositeSender := CompositeSender{
+		destinations: []Destination{{Name: "local", Sender: FileSender{directory: directory}}, {Name: "other", Sender: otherSender}},
+		mode:         config.SenderModeAllMustSucceed,
+	}
+
+	_, err := compositeSender.SendMessageFromSource(message, MessageSource{Url: "http://localhost/sftp/flexion/import/order_message.hl7", PartnerId: "flexion"})
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(directory, "flexion", "order_message.hl7"))
+	otherSender.AssertCalled(t, "SendMessage", message)
+}
@@ -18,6 +18,32 @@ type ReportStreamError struct {
 	ErrorUri         string `json:"error_uri"`
 }
 
+// SubmissionHistory is the subset of ReportStream's submission history response that we record
+// once a report reaches a final status. See the sample history response below
+type SubmissionHistory struct {
+	ReportId      string                   `json:"reportId"`
+	OverallStatus string                   `json:"overallStatus"`
+	Timestamp     string                   `json:"timestamp"`
+	ErrorCount    int                      `json:"errorCount"`
+	WarningCount  int                      `json:"warningCount"`
+	Destinations  []SubmissionDestination  `json:"destinations"`
+	Errors        []SubmissionHistoryEntry `json:"errors"`
+	Warnings      []SubmissionHistoryEntry `json:"warnings"`
+}
+
+type SubmissionDestination struct {
+	Organization   string `json:"organization"`
+	OrganizationId string `json:"organization_id"`
+	Service        string `json:"service"`
+	ItemCount      int    `json:"itemCount"`
+}
+
+type SubmissionHistoryEntry struct {
+	Scope     string `json:"scope"`
+	Message   string `json:"message"`
+	ErrorCode string `json:"errorCode"`
+}
+
 /**
 Sample responses from RS waters
 Success:
@@ -79,6 +105,39 @@ JSON Error:
 String error:
 Expected a 'client' query parameter
 
+Submission history (GET /api/waters/report/{reportId}/history):
+{
+  "id" : "78809588-1193-4861-a6a7-52493f7dd254",
+  "submissionId" : 26,
+  "overallStatus" : "Delivered",
+  "timestamp" : "2024-05-20T21:11:36.144Z",
+  "plannedCompletionAt" : "2024-05-20T21:14:00.000Z",
+  "actualCompletionAt" : "2024-05-20T21:13:42.521Z",
+  "sender" : "flexion.simulated-hospital",
+  "reportItemCount" : 1,
+  "errorCount" : 0,
+  "warningCount" : 0,
+  "httpStatus" : 201,
+  "destinations" : [ {
+    "organization" : "Flexion",
+    "organization_id" : "flexion",
+    "service" : "simulated-lab",
+    "itemCount" : 1,
+    "itemCountBeforeQualityFiltering" : 1,
+    "sentReports" : [ ],
+    "downloadedReports" : [ ]
+  } ],
+  "actionName" : "receive",
+  "externalName" : null,
+  "reportId" : "78809588-1193-4861-a6a7-52493f7dd254",
+  "topic" : "etor-ti",
+  "bodyFormat" : "",
+  "errors" : [ ],
+  "warnings" : [ ],
+  "destinationCount" : 1,
+  "fileName" : ""
+}
+
 Successful token response:
 {
     "sub": "flexion.*.report_e6b68103-dd38-420e-8118-2b2f6c9fa3c4",
@@ -1,30 +1,196 @@
 package senders
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"github.com/google/uuid"
+	"log/slog"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 )
 
+const defaultFileSenderDirectory = "localdata"
+
+// FileSender is a local-only stand-in for ReportStream. It writes each message to
+// `<directory>/<partnerId>/<source file's path under import>` next to a `.json` sidecar describing the message. A failure rules
+// file can make it fail some messages so we can exercise retries and the `failure` folder locally
 type FileSender struct {
+	directory    string
+	failureRules []FileSenderFailureRule
+	attempts     *fileSenderAttempts
+}
+
+// FileSenderFailureRule simulates a failure for messages whose source file name matches FileNamePattern
+// (see `path.Match`). Failure is `transient` or `permanent`. When Times is set, only that many attempts fail
+// for each file, so a transient failure succeeds on a later retry
+type FileSenderFailureRule struct {
+	FileNamePattern string `json:"fileNamePattern"`
+	Failure         string `json:"failure"`
+	Times           int    `json:"times"`
+}
+
+// FileSenderSidecar is written next to each message so we can tell which input produced which output
+type FileSenderSidecar struct {
+	ReportId       string    `json:"reportId"`
+	SourceUrl      string    `json:"sourceUrl"`
+	PartnerId      string    `json:"partnerId"`
+	SourceEncoding string    `json:"sourceEncoding"`
+	Encoding       string    `json:"encoding"`
+	Sha256         string    `json:"sha256"`
+	Size           int       `json:"size"`
+	WrittenAt      time.Time `json:"writtenAt"`
+}
+
+type fileSenderAttempts struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// NewFileSender reads FILE_SENDER_DIRECTORY (default `localdata`) and the optional FILE_SENDER_FAILURE_RULES_FILE,
+// a JSON list of FileSenderFailureRule
+func NewFileSender() (FileSender, error) {
+	directory := os.Getenv("FILE_SENDER_DIRECTORY")
+	if directory == "" {
+		directory = defaultFileSenderDirectory
+	}
+
+	var failureRules []FileSenderFailureRule
+	rulesFile := os.Getenv("FILE_SENDER_FAILURE_RULES_FILE")
+	if rulesFile != "" {
+		rulesBytes, err := os.ReadFile(rulesFile)
+		if err != nil {
+			slog.Error("Failed to read file sender failure rules", slog.Any(utils.ErrorKey, err), slog.String("rulesFile", rulesFile))
+			return FileSender{}, err
+		}
+
+		err = json.Unmarshal(rulesBytes, &failureRules)
+		if err != nil {
+			slog.Error("Failed to parse file sender failure rules", slog.Any(utils.ErrorKey, err), slog.String("rulesFile", rulesFile))
+			return FileSender{}, err
+		}
+
+		slog.Info("Loaded file sender failure rules", slog.String("rulesFile", rulesFile), slog.Int("ruleCount", len(failureRules)))
+	}
+
+	return FileSender{
+		directory:    directory,
+		failureRules: failureRules,
+		attempts:     &fileSenderAttempts{counts: make(map[string]int)},
+	}, nil
 }
 
+// SendMessage writes a message without a known source, so it's named with its report ID
 func (receiver FileSender) SendMessage(message []byte) (string, error) {
-	folder := "localdata"
+	return receiver.SendMessageFromSource(message, MessageSource{})
+}
+
+func (receiver FileSender) SendMessageFromSource(message []byte, source MessageSource) (string, error) {
+	reportId := uuid.NewString()
 
-	err := os.MkdirAll(folder, 0755)
+	fileName := fmt.Sprintf("%s.txt", reportId)
+	relativePath := fileName
+	if source.Url != "" {
+		sourceUrl, err := url.Parse(source.Url)
+		if err == nil && path.Base(sourceUrl.Path) != "/" && path.Base(sourceUrl.Path) != "." {
+			fileName = path.Base(sourceUrl.Path)
+			relativePath = importRelativePath(sourceUrl.Path, source.PartnerId)
+		}
+	}
+
+	err := receiver.simulateFailure(fileName, source.Url)
 	if err != nil {
 		return "", err
 	}
 
-	randomUuid := uuid.NewString()
+	directory := receiver.directory
+	if directory == "" {
+		directory = defaultFileSenderDirectory
+	}
+	filePath := filepath.Join(directory, source.PartnerId, filepath.FromSlash(relativePath))
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return "", err
+	}
 
-	filePath := filepath.Join(folder, fmt.Sprintf("%s.txt", randomUuid))
 	err = os.WriteFile(filePath, message, 0644) // permissions = owner read/write, group read, other read
 	if err != nil {
 		return "", err
 	}
 
-	return randomUuid, nil
+	hash := sha256.Sum256(message)
+	sidecarBytes, err := json.MarshalIndent(FileSenderSidecar{
+		ReportId:       reportId,
+		SourceUrl:      source.Url,
+		PartnerId:      source.PartnerId,
+		SourceEncoding: source.Encoding,
+		Encoding:       "UTF-8",
+		Sha256:         hex.EncodeToString(hash[:]),
+		Size:           len(message),
+		WrittenAt:      time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	err = os.WriteFile(filePath+".json", sidecarBytes, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return reportId, nil
+}
+
+// importRelativePath is where a message's file is under the partner's `import` folder, e.g.
+// `results.zip-1a2b3c4d5e6f/order.hl7`, so files with the same name from different archives or uploads don't
+// overwrite each other. It's just the file name when the file isn't in an `import` folder
+func importRelativePath(sourcePath string, partnerId string) string {
+	importFolder := "/" + path.Join(partnerId, utils.MessageStartingFolderPath) + "/"
+	_, relativePath, found := strings.Cut(sourcePath, importFolder)
+	relativePath = path.Clean(relativePath)
+	if !found || relativePath == "." || !filepath.IsLocal(relativePath) {
+		return path.Base(sourcePath)
+	}
+	return relativePath
+}
+
+// simulateFailure returns an error for the first failure rule matching the file name, if any
+func (receiver FileSender) simulateFailure(fileName string, sourceUrl string) error {
+	for index, rule := range receiver.failureRules {
+		matched, err := path.Match(rule.FileNamePattern, fileName)
+		if err != nil || !matched {
+			continue
+		}
+
+		if rule.Times > 0 && receiver.attempts != nil {
+			attemptKey := fmt.Sprintf("%d:%s:%s", index, sourceUrl, fileName)
+
+			receiver.attempts.mutex.Lock()
+			receiver.attempts.counts[attemptKey]++
+			attempt := receiver.attempts.counts[attemptKey]
+			receiver.attempts.mutex.Unlock()
+
+			if attempt > rule.Times {
+				continue
+			}
+		}
+
+		slog.Info("Simulating failure in file sender", slog.String("fileName", fileName), slog.String("failure", rule.Failure))
+		if rule.Failure == config.StatusRulePermanent {
+			return errors.New(utils.ReportStreamNonTransientFailure + ": simulated permanent failure for " + fileName)
+		}
+		return errors.New("simulated transient failure for " + fileName)
+	}
+
+	return nil
 }
@@ -0,0 +1,157 @@
+package senders
+
+import (
+	"encoding/json"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const fileSenderSourceUrl = "http://localhost/sftp/flexion/import/order_message.hl7"
+
+func Test_NewFileSender_VariablesAreSet_ReturnsSenderWithRules(t *testing.T) {
+	directory := t.TempDir()
+	rulesFile := filepath.Join(directory, "rules.json")
+	err := os.WriteFile(rulesFile, []byte(`[{"fileNamePattern": "*_bad.hl7", "failure": "permanent"}]`), 0600)
+	assert.NoError(t, err)
+
+	t.Setenv("FILE_SENDER_DIRECTORY", directory)
+	t.Setenv("FILE_SENDER_FAILURE_RULES_FILE", rulesFile)
+
+	sender, err := NewFileSender()
+
+	assert.NoError(t, err)
+	assert.Equal(t, directory, sender.directory)
+	assert.Equal(t, []FileSenderFailureRule{{FileNamePattern: "*_bad.hl7", Failure: config.StatusRulePermanent}}, sender.failureRules)
+}
+
+func Test_NewFileSender_VariablesAreNotSet_ReturnsDefaultSender(t *testing.T) {
+	sender, err := NewFileSender()
+
+	assert.NoError(t, err)
+	assert.Equal(t, defaultFileSenderDirectory, sender.directory)
+	assert.Empty(t, sender.failureRules)
+}
+
+func Test_NewFileSender_RulesFileIsInvalid_ReturnsError(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(rulesFile, []byte(`not json`), 0600)
+	assert.NoError(t, err)
+
+	t.Setenv("FILE_SENDER_FAILURE_RULES_FILE", rulesFile)
+
+	_, err = NewFileSender()
+
+	assert.Error(t, err)
+}
+
+func Test_FileSender_SendMessageFromSource_WritesMessageAndSidecar(t *testing.T) {
+	directory := t.TempDir()
+	sender := FileSender{directory: directory}
+
+	reportId, err := sender.SendMessageFromSource(message, MessageSource{Url: fileSenderSourceUrl, PartnerId: "flexion", Encoding: "ISO-8859-1"})
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, reportId)
+
+	written, err := os.ReadFile(filepath.Join(directory, "flexion", "order_message.hl7"))
+	assert.NoError(t, err)
+	assert.Equal(t, message, written)
+
+	sidecarBytes, err := os.ReadFile(filepath.Join(directory, "flexion", "order_message.hl7.json"))
+	assert.NoError(t, err)
+	var sidecar FileSenderSidecar
+	err = json.Unmarshal(sidecarBytes, &sidecar)
+	assert.NoError(t, err)
+	assert.Equal(t, reportId, sidecar.ReportId)
+	assert.Equal(t, fileSenderSourceUrl, sidecar.SourceUrl)
+	assert.Equal(t, "ISO-8859-1", sidecar.SourceEncoding)
+	assert.Equal(t, "UTF-8", sidecar.Encoding)
+	assert.Equal(t, "7e0f9a18809beeafc6d10b68558a7956c6bfd3a820c45fbcca9611cfb29e348a", sidecar.Sha256)
+	assert.Equal(t, len(message), sidecar.Size)
+	assert.False(t, sidecar.WrittenAt.IsZero())
+}
+
+func Test_FileSender_SendMessageFromSource_SameNameInDifferentZips_KeepsBoth(t *testing.T) {
+	directory := t.TempDir()
+	sender := FileSender{directory: directory}
+
+	_, err := sender.SendMessageFromSource([]byte("MSH|first"), MessageSource{Url: "http://localhost/sftp/flexion/import/first.zip-1a2b3c4d5e6f/order.hl7", PartnerId: "flexion"})
+	assert.NoError(t, err)
+	_, err = sender.SendMessageFromSource([]byte("MSH|second"), MessageSource{Url: "http://localhost/sftp/flexion/import/second.zip-6f5e4d3c2b1a/order.hl7", PartnerId: "flexion"})
+	assert.NoError(t, err)
+
+	first, err := os.ReadFile(filepath.Join(directory, "flexion", "first.zip-1a2b3c4d5e6f", "order.hl7"))
+	assert.NoError(t, err)
+	assert.Equal(t, "MSH|first", string(first))
+	second, err := os.ReadFile(filepath.Join(directory, "flexion", "second.zip-6f5e4d3c2b1a", "order.hl7"))
+	assert.NoError(t, err)
+	assert.Equal(t, "MSH|second", string(second))
+	assert.FileExists(t, filepath.Join(directory, "flexion", "second.zip-6f5e4d3c2b1a", "order.hl7.json"))
+}
+
+func Test_importRelativePath(t *testing.T) {
+	assert.Equal(t, "2024/06/order.hl7", importRelativePath("/sftp/flexion/import/2024/06/order.hl7", "flexion"))
+	assert.Equal(t, "order.hl7", importRelativePath("/sftp/flexion/failure/order.hl7", "flexion"))
+	assert.Equal(t, "order.hl7", importRelativePath("/sftp/flexion/import/../../order.hl7", "flexion"))
+}
+
+func Test_FileSender_SendMessage_NoSource_NamesFileWithReportId(t *testing.T) {
+	directory := t.TempDir()
+	sender := FileSender{directory: directory}
+
+	reportId, err := sender.SendMessage(message)
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(directory, reportId+".txt"))
+	assert.FileExists(t, filepath.Join(directory, reportId+".txt.json"))
+}
+
+func Test_FileSender_SendMessageFromSource_PermanentFailureRuleMatches_ReturnsNonTransientError(t *testing.T) {
+	directory := t.TempDir()
+	sender := FileSender{
+		directory:    directory,
+		failureRules: []FileSenderFailureRule{{FileNamePattern: "order_*.hl7", Failure: config.StatusRulePermanent}},
+	}
+
+	_, err := sender.SendMessageFromSource(message, MessageSource{Url: fileSenderSourceUrl, PartnerId: "flexion"})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+	assert.NoFileExists(t, filepath.Join(directory, "flexion", "order_message.hl7"))
+}
+
+func Test_FileSender_SendMessageFromSource_TransientFailureRuleWithTimes_SucceedsAfterRetries(t *testing.T) {
+	directory := t.TempDir()
+	sender := FileSender{
+		directory:    directory,
+		failureRules: []FileSenderFailureRule{{FileNamePattern: "order_*.hl7", Failure: config.StatusRuleTransient, Times: 2}},
+		attempts:     &fileSenderAttempts{counts: make(map[string]int)},
+	}
+	source := MessageSource{Url: fileSenderSourceUrl, PartnerId: "flexion"}
+
+	_, err := sender.SendMessageFromSource(message, source)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+
+	_, err = sender.SendMessageFromSource(message, source)
+	assert.Error(t, err)
+
+	_, err = sender.SendMessageFromSource(message, source)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(directory, "flexion", "order_message.hl7"))
+}
+
+func Test_FileSender_SendMessageFromSource_NoRuleMatches_Succeeds(t *testing.T) {
+	sender := FileSender{
+		directory:    t.TempDir(),
+		failureRules: []FileSenderFailureRule{{FileNamePattern: "*_bad.hl7", Failure: config.StatusRulePermanent}},
+	}
+
+	_, err := sender.SendMessageFromSource(message, MessageSource{Url: fileSenderSourceUrl, PartnerId: "flexion"})
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,195 @@
+package senders
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MLLP (Minimal Lower Layer Protocol) wraps each HL7 message in a start block and an end block
+const mllpStartBlock = 0x0b
+const mllpEndBlock = 0x1c
+const mllpCarriageReturn = 0x0d
+
+const defaultMllpTimeout = 30 * time.Second
+
+// maxMllpAckBytes is far more than an HL7 ACK needs, so a destination that never ends its ACK can't make us buffer
+// bytes until the timeout
+const maxMllpAckBytes = 64 * 1024
+
+// HL7 ACK codes in MSA-1. `AA` and `CA` mean the message was accepted
+var mllpAcceptCodes = []string{"AA", "CA"}
+
+// MllpSender delivers HL7 messages over a TCP connection using MLLP framing, optionally over TLS
+type MllpSender struct {
+	address   string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+func NewMllpSender(address string, useTls bool, timeout time.Duration) (MllpSender, error) {
+	if address == "" {
+		return MllpSender{}, errors.New("MLLP address is required")
+	}
+
+	if timeout <= 0 {
+		timeout = defaultMllpTimeout
+	}
+
+	sender := MllpSender{address: address, timeout: timeout}
+	if useTls {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return MllpSender{}, err
+		}
+		sender.tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+
+	return sender, nil
+}
+
+// SendMessage opens a connection, sends a single framed message, and waits for the HL7 ACK. We return the message
+// control ID from the ACK as the report ID. AE/AR (and the commit-level CE/CR) ACKs mean the receiver looked at the
+// message and won't take it, so they're non-transient failures, as are ACKs bigger than maxMllpAckBytes. Connection
+// and timeout errors can be retried
+func (receiver MllpSender) SendMessage(message []byte) (string, error) {
+	conn, err := receiver.dial()
+	if err != nil {
+		slog.Error("Failed to connect to MLLP destination", slog.String("address", receiver.address), slog.Any(utils.ErrorKey, err))
+		return "", err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(receiver.timeout))
+	if err != nil {
+		return "", err
+	}
+
+	_, err = conn.Write(frameMllpMessage(message))
+	if err != nil {
+		slog.Error("Failed to write to MLLP destination", slog.String("address", receiver.address), slog.Any(utils.ErrorKey, err))
+		return "", err
+	}
+
+	limitedConn := &io.LimitedReader{R: conn, N: maxMllpAckBytes}
+	ack, err := readMllpMessage(bufio.NewReader(limitedConn))
+	if err != nil && limitedConn.N == 0 {
+		slog.Error("ACK from MLLP destination is too big", slog.String("address", receiver.address), slog.Int("maxBytes", maxMllpAckBytes))
+		return "", errors.New(utils.ReportStreamNonTransientFailure + ": MLLP ACK is bigger than " + strconv.Itoa(maxMllpAckBytes) + " bytes")
+	}
+	if err != nil {
+		slog.Error("Failed to read ACK from MLLP destination", slog.String("address", receiver.address), slog.Any(utils.ErrorKey, err))
+		return "", err
+	}
+
+	ackCode, controlId, ackText, err := parseAck(ack, messageControlId(message))
+	if err != nil {
+		slog.Error("Failed to parse ACK from MLLP destination", slog.String("address", receiver.address), slog.Any(utils.ErrorKey, err))
+		return "", err
+	}
+
+	if slices.Contains(mllpAcceptCodes, ackCode) {
+		return controlId, nil
+	}
+
+	slog.Info("MLLP destination rejected message", slog.String("ackCode", ackCode), slog.String("controlId", controlId), slog.String("text", ackText))
+	return "", errors.New(utils.ReportStreamNonTransientFailure + ": MLLP ACK " + ackCode + " " + ackText)
+}
+
+func (receiver MllpSender) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: receiver.timeout}
+	if receiver.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", receiver.address, receiver.tlsConfig)
+	}
+	return dialer.Dial("tcp", receiver.address)
+}
+
+func frameMllpMessage(message []byte) []byte {
+	framed := make([]byte, 0, len(message)+3)
+	framed = append(framed, mllpStartBlock)
+	framed = append(framed, message...)
+	return append(framed, mllpEndBlock, mllpCarriageReturn)
+}
+
+// readMllpMessage reads one framed message and returns its contents without the framing bytes
+func readMllpMessage(reader *bufio.Reader) ([]byte, error) {
+	startBlock, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if startBlock != mllpStartBlock {
+		return nil, errors.New("MLLP message did not begin with a start block")
+	}
+
+	content, err := reader.ReadBytes(mllpEndBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	carriageReturn, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if carriageReturn != mllpCarriageReturn {
+		return nil, errors.New("MLLP message did not end with a carriage return")
+	}
+
+	return content[:len(content)-1], nil
+}
+
+// messageControlId returns MSH-10 from an HL7 message, or an empty string when it doesn't have one
+func messageControlId(message []byte) string {
+	if !bytes.HasPrefix(message, []byte("MSH")) || len(message) < 4 {
+		return ""
+	}
+
+	mshSegment, _, _ := strings.Cut(strings.ReplaceAll(string(message), "\n", "\r"), "\r")
+	mshFields := strings.Split(mshSegment, string(message[3]))
+	// MSH-1 is the field separator itself, so MSH-10 is the tenth field after splitting on it
+	if len(mshFields) > 9 {
+		return mshFields[9]
+	}
+	return ""
+}
+
+// parseAck returns MSA-1 (the ACK code), MSA-2 (the control ID), and MSA-3 (the text message) from an HL7 ACK. An ACK
+// for another message, e.g. a stale one left on the connection, is an error when we know the sent message's control ID
+func parseAck(ack []byte, sentControlId string) (string, string, string, error) {
+	if !bytes.HasPrefix(ack, []byte("MSH")) || len(ack) < 4 {
+		return "", "", "", errors.New("ACK did not begin with an MSH segment")
+	}
+	fieldSeparator := string(ack[3])
+
+	segments := strings.FieldsFunc(string(ack), func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+	for _, segment := range segments {
+		fields := strings.Split(segment, fieldSeparator)
+		if fields[0] != "MSA" || len(fields) < 2 {
+			continue
+		}
+
+		ackCode, controlId, ackText := fields[1], "", ""
+		if len(fields) > 2 {
+			controlId = fields[2]
+		}
+		if len(fields) > 3 {
+			ackText = fields[3]
+		}
+		if sentControlId != "" && controlId != sentControlId {
+			return "", "", "", errors.New("ACK is for control ID " + controlId + " but we sent " + sentControlId)
+		}
+		return ackCode, controlId, ackText, nil
+	}
+
+	return "", "", "", errors.New("ACK did not include an MSA segment")
+}
@@ -0,0 +1,193 @@
+package senders
+
+import (
+	"crypto/tls"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const hl7Message = "MSH|^~\\&|LAB|FACILITY|||20240711160217||ORU^R01|CONTROL123|P|2.5.1\rPID|1||12345\r"
+
+func Test_MllpSender_SendMessage_AckIsAA_ReturnsControlId(t *testing.T) {
+	server, err := NewMllpTestServer("AA", false)
+	assert.NoError(t, err)
+	defer server.Close()
+
+	sender, err := NewMllpSender(server.Address(), false, time.Second)
+	assert.NoError(t, err)
+
+	controlId, err := sender.SendMessage([]byte(hl7Message))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "CONTROL123", controlId)
+	assert.Equal(t, [][]byte{[]byte(hl7Message)}, server.Received())
+}
+
+func Test_MllpSender_SendMessage_AckIsAE_ReturnsNonTransientError(t *testing.T) {
+	server, err := NewMllpTestServer("AE", false)
+	assert.NoError(t, err)
+	defer server.Close()
+
+	sender, err := NewMllpSender(server.Address(), false, time.Second)
+	assert.NoError(t, err)
+
+	_, err = sender.SendMessage([]byte(hl7Message))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+	assert.Contains(t, err.Error(), "AE")
+}
+
+func Test_MllpSender_SendMessage_AckIsAR_ReturnsNonTransientError(t *testing.T) {
+	server, err := NewMllpTestServer("AR", false)
+	assert.NoError(t, err)
+	defer server.Close()
+
+	sender, err := NewMllpSender(server.Address(), false, time.Second)
+	assert.NoError(t, err)
+
+	_, err = sender.SendMessage([]byte(hl7Message))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+}
+
+func Test_MllpSender_SendMessage_ServerUsesTls_ReturnsControlId(t *testing.T) {
+	server, err := NewMllpTestServer("AA", true)
+	assert.NoError(t, err)
+	defer server.Close()
+
+	sender, err := NewMllpSender(server.Address(), true, time.Second)
+	assert.NoError(t, err)
+	sender.tlsConfig = &tls.Config{RootCAs: server.CertPool, ServerName: "127.0.0.1"}
+
+	controlId, err := sender.SendMessage([]byte(hl7Message))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "CONTROL123", controlId)
+}
+
+func Test_MllpSender_SendMessage_AckIsForAnotherMessage_ReturnsTransientError(t *testing.T) {
+	server, err := NewMllpTestServer("AA", false)
+	assert.NoError(t, err)
+	defer server.Close()
+	server.AckControlId = "CONTROL456"
+
+	sender, err := NewMllpSender(server.Address(), false, time.Second)
+	assert.NoError(t, err)
+
+	_, err = sender.SendMessage([]byte(hl7Message))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ACK is for control ID CONTROL456 but we sent CONTROL123")
+	assert.NotContains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+}
+
+func Test_MllpSender_SendMessage_UnableToConnect_ReturnsTransientError(t *testing.T) {
+	server, err := NewMllpTestServer("AA", false)
+	assert.NoError(t, err)
+	address := server.Address()
+	server.Close()
+
+	sender, err := NewMllpSender(address, false, time.Second)
+	assert.NoError(t, err)
+
+	_, err = sender.SendMessage([]byte(hl7Message))
+
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+}
+
+func Test_MllpSender_SendMessage_NoAckBeforeTimeout_ReturnsTransientError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		// Accept the connection and never answer
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	sender, err := NewMllpSender(listener.Addr().String(), false, 100*time.Millisecond)
+	assert.NoError(t, err)
+
+	_, err = sender.SendMessage([]byte(hl7Message))
+
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+}
+
+func Test_MllpSender_SendMessage_AckNeverEnds_ReturnsNonTransientError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		// Start an ACK and keep sending without an end block
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = conn.Write([]byte{mllpStartBlock})
+			_, _ = conn.Write([]byte(strings.Repeat("MSA|AA|", 2*maxMllpAckBytes)))
+		}
+	}()
+
+	sender, err := NewMllpSender(listener.Addr().String(), false, 5*time.Second)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = sender.SendMessage([]byte(hl7Message))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+	assert.Contains(t, err.Error(), "MLLP ACK is bigger than")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func Test_NewMllpSender_AddressIsMissing_ReturnsError(t *testing.T) {
+	_, err := NewMllpSender("", false, time.Second)
+
+	assert.Error(t, err)
+}
+
+func Test_parseAck_AckIsMissingMsaSegment_ReturnsError(t *testing.T) {
+	_, _, _, err := parseAck([]byte("MSH|^~\\&|RECEIVER||||20240711160217||ACK|CONTROL123|P|2.5.1\r"), "CONTROL123")
+	assert.Error(t, err)
+
+	_, _, _, err = parseAck([]byte("not an ack"), "CONTROL123")
+	assert.Error(t, err)
+}
+
+func Test_parseAck_SegmentsSeparatedByNewlines_ReturnsFields(t *testing.T) {
+	ack := strings.Join([]string{"MSH|^~\\&|RECEIVER||||20240711160217||ACK|CONTROL123|P|2.5.1", "MSA|AE|CONTROL123|Missing PID-3"}, "\n")
+
+	ackCode, controlId, ackText, err := parseAck([]byte(ack), "CONTROL123")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AE", ackCode)
+	assert.Equal(t, "CONTROL123", controlId)
+	assert.Equal(t, "Missing PID-3", ackText)
+}
+
+func Test_parseAck_SentMessageHasNoControlId_ReturnsFields(t *testing.T) {
+	ack := "MSH|^~\\&|RECEIVER||||20240711160217||ACK|CONTROL123|P|2.5.1\rMSA|AA|CONTROL123\r"
+
+	ackCode, controlId, _, err := parseAck([]byte(ack), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AA", ackCode)
+	assert.Equal(t, "CONTROL123", controlId)
+}
+
+func Test_messageControlId(t *testing.T) {
+	assert.Equal(t, "CONTROL123", messageControlId([]byte(hl7Message)))
+	assert.Equal(t, "CONTROL123", messageControlId([]byte("MSH#^~\\&#LAB#FACILITY###20240711160217##ORU^R01#CONTROL123#P#2.5.1\n")))
+	assert.Equal(t, "", messageControlId([]byte("MSH|^~\\&|LAB\r")))
+	assert.Equal(t, "", messageControlId([]byte("PID|1||12345\r")))
+}
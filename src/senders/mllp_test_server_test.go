@@ -0,0 +1,153 @@
+package senders
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// MllpTestServer is a local MLLP listener for testing MllpSender without a real HL7 interface engine. It answers
+// every message with an ACK using AckCode and keeps the messages it received
+type MllpTestServer struct {
+	AckCode string
+	// AckControlId, when set, goes in the ACK's MSA-2 instead of the message's control ID
+	AckControlId string
+	// CertPool trusts the server's self-signed certificate when the server uses TLS
+	CertPool *x509.CertPool
+
+	listener net.Listener
+	mutex    sync.Mutex
+	received [][]byte
+}
+
+// NewMllpTestServer starts listening on a random local port. Call Close when done
+func NewMllpTestServer(ackCode string, useTls bool) (*MllpTestServer, error) {
+	server := &MllpTestServer{AckCode: ackCode}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	if useTls {
+		certificate, certPool, err := selfSignedCertificate()
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		server.CertPool = certPool
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{certificate}})
+	}
+
+	server.listener = listener
+	go server.serve()
+
+	return server, nil
+}
+
+func (server *MllpTestServer) Address() string {
+	return server.listener.Addr().String()
+}
+
+func (server *MllpTestServer) Received() [][]byte {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.received
+}
+
+func (server *MllpTestServer) Close() error {
+	return server.listener.Close()
+}
+
+func (server *MllpTestServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("MLLP test server failed to accept connection", slog.Any(utils.ErrorKey, err))
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+func (server *MllpTestServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for {
+		message, err := readMllpMessage(reader)
+		if err != nil {
+			return
+		}
+
+		server.mutex.Lock()
+		server.received = append(server.received, message)
+		server.mutex.Unlock()
+
+		controlId := messageControlId(message)
+		if server.AckControlId != "" {
+			controlId = server.AckControlId
+		}
+
+		_, err = conn.Write(frameMllpMessage(buildAck(controlId, server.AckCode)))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// buildAck answers a message using its MSH-10 control ID, the way an HL7 receiver would
+func buildAck(controlId string, ackCode string) []byte {
+	timestamp := time.Now().UTC().Format("20060102150405")
+	return []byte("MSH|^~\\&|MLLP_TEST_SERVER||||" + timestamp + "||ACK|" + controlId + "|P|2.5.1\r" +
+		"MSA|" + ackCode + "|" + controlId + "|Test server " + ackCode + "\r")
+}
+
+func selfSignedCertificate() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	parsedCertificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(parsedCertificate)
+
+	return tls.Certificate{Certificate: [][]byte{certificateBytes}, PrivateKey: key}, certPool, nil
+}
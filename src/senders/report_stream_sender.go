@@ -2,6 +2,7 @@ package senders
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
@@ -41,6 +42,13 @@ func NewSender() (Sender, error) {
 	}, nil
 }
 
+// WithBaseUrl returns a copy of the sender that uses the ReportStream at baseUrl, e.g. to check on a report sent to a
+// partner's own ReportStream destination
+func (sender Sender) WithBaseUrl(baseUrl string) Sender {
+	sender.baseUrl = baseUrl
+	return sender
+}
+
 func (sender Sender) generateJwt() (string, error) {
 
 	key, err := sender.credentialGetter.GetPrivateKey(sender.privateKeyName)
@@ -48,7 +56,6 @@ func (sender Sender) generateJwt() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	id, _ := uuid.NewUUID()
 
 	env := os.Getenv("ENV")
 	audiencePrefix := ""
@@ -56,16 +63,24 @@ func (sender Sender) generateJwt() (string, error) {
 		audiencePrefix = env + "."
 	}
 
+	return generateClientAssertion(key, sender.clientName, audiencePrefix+"prime.cdc.gov")
+}
+
+// generateClientAssertion creates a short-lived JWT signed with our private key. OAuth servers like ReportStream's
+// accept it in place of a client secret when we request an access token
+func generateClientAssertion(key *rsa.PrivateKey, clientId string, audience string) (string, error) {
+	id, _ := uuid.NewUUID()
+
 	claims := &jwt.RegisteredClaims{
 		ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
 		ID:        id.String(),
-		Issuer:    sender.clientName,
-		Subject:   sender.clientName,
-		Audience:  jwt.ClaimStrings{audiencePrefix + "prime.cdc.gov"},
+		Issuer:    clientId,
+		Subject:   clientId,
+		Audience:  jwt.ClaimStrings{audience},
 	}
 
 	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	t.Header["kid"] = sender.clientName
+	t.Header["kid"] = clientId
 
 	return t.SignedString(key)
 }
@@ -83,7 +98,12 @@ func (sender Sender) getToken() (string, error) {
 		"client_assertion":      {senderJwt},
 	}
 
-	req, err := http.NewRequest("POST", sender.baseUrl+"/api/token", strings.NewReader(data.Encode()))
+	return requestAccessToken(http.DefaultClient, sender.baseUrl+"/api/token", data)
+}
+
+// requestAccessToken posts an OAuth client credentials request to the token URL and returns the access token
+func requestAccessToken(httpClient *http.Client, tokenUrl string, data url.Values) (string, error) {
+	req, err := http.NewRequest("POST", tokenUrl, strings.NewReader(data.Encode()))
 	if err != nil {
 		return "", err
 	}
@@ -92,7 +112,7 @@ func (sender Sender) getToken() (string, error) {
 		"Content-Type": {"application/x-www-form-urlencoded"},
 	}
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := httpClient.Do(req)
 
 	if err != nil {
 		slog.Error("error calling token endpoint", slog.Any(utils.ErrorKey, err))
@@ -169,3 +189,47 @@ func (sender Sender) SendMessage(message []byte) (string, error) {
 	slog.Info("report", slog.Any("report", report))
 	return report.ReportId, nil
 }
+
+// GetSubmissionHistory retrieves ReportStream's submission history for a report we previously sent, which includes
+// the report's overall status, its destinations, and any errors or warnings found after it was received
+func (sender Sender) GetSubmissionHistory(reportId string) (SubmissionHistory, error) {
+	token, err := sender.getToken()
+	if err != nil {
+		return SubmissionHistory{}, err
+	}
+
+	req, err := http.NewRequest("GET", sender.baseUrl+"/api/waters/report/"+url.PathEscape(reportId)+"/history", nil)
+	if err != nil {
+		return SubmissionHistory{}, err
+	}
+
+	req.Header = http.Header{
+		"Authorization": {"Bearer " + token},
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return SubmissionHistory{}, err
+	}
+
+	defer res.Body.Close()
+
+	responseBodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return SubmissionHistory{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		slog.Info("status", slog.Any("code", res.StatusCode), slog.String("status", res.Status))
+		slog.Info("response body", slog.String("responseBodyBytes", string(responseBodyBytes)))
+		return SubmissionHistory{}, errors.New(res.Status)
+	}
+
+	var history SubmissionHistory
+	err = json.Unmarshal(responseBodyBytes, &history)
+	if err != nil {
+		return SubmissionHistory{}, err
+	}
+
+	return history, nil
+}
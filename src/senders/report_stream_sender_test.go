@@ -562,6 +562,88 @@ func (suite *SenderTestSuite) Test_SendMessage_UnableToParseResponseBody_Returns
 	assert.Equal(suite.T(), "", reportId)
 }
 
+func (suite *SenderTestSuite) Test_GetSubmissionHistory_ReportIsDelivered_ReturnsHistory() {
+	sender, err := NewSender()
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	sender.credentialGetter = mockCredentialGetter
+
+	testKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	mockCredentialGetter.On("GetPrivateKey", reportStreamPrivateKeyName).Return(testKey, nil)
+
+	var historyPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.URL.Path == "/api/token" {
+			w.Write([]byte(`{"access_token": "token", "token_type": "bearer"}`))
+		} else {
+			historyPath = r.URL.Path
+			w.Write([]byte(`
+			{
+			  "overallStatus" : "Delivered",
+			  "reportId" : "78809588-1193-4861-a6a7-52493f7dd254",
+			  "errorCount" : 0,
+			  "warningCount" : 1,
+			  "destinations" : [ { "organization" : "Flexion", "organization_id" : "flexion", "service" : "simulated-lab", "itemCount" : 1 } ],
+			  "errors" : [ ],
+			  "warnings" : [ { "scope" : "item", "message" : "Missing patient race", "errorCode" : "UNKNOWN" } ]
+			}
+			`))
+		}
+	}))
+	defer server.Close()
+
+	sender.baseUrl = server.URL
+
+	history, err := sender.GetSubmissionHistory("78809588-1193-4861-a6a7-52493f7dd254")
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), "/api/waters/report/78809588-1193-4861-a6a7-52493f7dd254/history", historyPath)
+	assert.Equal(suite.T(), "Delivered", history.OverallStatus)
+	assert.Equal(suite.T(), "simulated-lab", history.Destinations[0].Service)
+	assert.Equal(suite.T(), "Missing patient race", history.Warnings[0].Message)
+}
+
+func (suite *SenderTestSuite) Test_GetSubmissionHistory_UnableToGetToken_ReturnsError() {
+	sender, err := NewSender()
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	sender.credentialGetter = mockCredentialGetter
+
+	mockCredentialGetter.On("GetPrivateKey", reportStreamPrivateKeyName).Return(&rsa.PrivateKey{}, errors.New("failed to retrieve private key"))
+
+	_, err = sender.GetSubmissionHistory("78809588-1193-4861-a6a7-52493f7dd254")
+
+	assert.Error(suite.T(), err)
+}
+
+func (suite *SenderTestSuite) Test_GetSubmissionHistory_StatusIsNotOk_ReturnsError() {
+	sender, err := NewSender()
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	sender.credentialGetter = mockCredentialGetter
+
+	testKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	mockCredentialGetter.On("GetPrivateKey", reportStreamPrivateKeyName).Return(testKey, nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/token" {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"access_token": "token", "token_type": "bearer"}`))
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sender.baseUrl = server.URL
+
+	_, err = sender.GetSubmissionHistory("78809588-1193-4861-a6a7-52493f7dd254")
+
+	assert.Error(suite.T(), err)
+	assert.Equal(suite.T(), "404 Not Found", err.Error())
+}
+
 func Test_SenderTestSuite(t *testing.T) {
 	suite.Run(t, new(SenderTestSuite))
 }
@@ -6,3 +6,57 @@ package senders
 type MessageSender interface {
 	SendMessage(message []byte) (string, error)
 }
+
+// The SubmissionHistoryGetter interface is about checking on a message after it's been accepted.
+// A successful send only means ReportStream received the message, not that it was delivered
+type SubmissionHistoryGetter interface {
+	GetSubmissionHistory(reportId string) (SubmissionHistory, error)
+}
+
+// TracksSubmissionHistory reports whether the report IDs returned by a sender can be looked up in ReportStream's
+// submission history. For a CompositeSender, that depends on its primary destination
+func TracksSubmissionHistory(sender MessageSender) bool {
+	if compositeSender, ok := sender.(CompositeSender); ok {
+		return TracksSubmissionHistory(compositeSender.Primary())
+	}
+
+	_, ok := sender.(SubmissionHistoryGetter)
+	return ok
+}
+
+// ReportStreamUrl returns the base URL of the ReportStream whose submission history has the sender's report IDs, which
+// a partner's destination can set instead of REPORT_STREAM_URL_PREFIX. It's empty when the sender doesn't track
+// submission history
+func ReportStreamUrl(sender MessageSender) string {
+	if compositeSender, ok := sender.(CompositeSender); ok {
+		return ReportStreamUrl(compositeSender.Primary())
+	}
+
+	if reportStreamSender, ok := sender.(Sender); ok {
+		return reportStreamSender.baseUrl
+	}
+	return ""
+}
+
+// MessageSource describes where a message came from, for senders that keep track of it
+type MessageSource struct {
+	Url       string
+	PartnerId string
+	// Encoding is the source file's encoding. We convert messages to UTF-8 before sending them
+	Encoding string
+}
+
+// The SourceAwareSender interface is for senders that use a message's source, like the FileSender
+// keeping the source file's name
+type SourceAwareSender interface {
+	SendMessageFromSource(message []byte, source MessageSource) (string, error)
+}
+
+// SendMessageFromSource passes the message source along to senders that use it, and otherwise just sends the message
+func SendMessageFromSource(sender MessageSender, message []byte, source MessageSource) (string, error) {
+	if sourceAwareSender, ok := sender.(SourceAwareSender); ok {
+		return sourceAwareSender.SendMessageFromSource(message, source)
+	}
+
+	return sender.SendMessage(message)
+}
@@ -0,0 +1,217 @@
+package senders
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultWebhookTimeout = 30 * time.Second
+const defaultWebhookContentType = "text/plain"
+
+// If the partner doesn't configure a rule for a status code, these decide whether it's a success or whether we retry
+var defaultStatusRules = []config.StatusRule{
+	{MinStatus: 200, MaxStatus: 299, Result: config.StatusRuleSuccess},
+	{MinStatus: http.StatusRequestTimeout, MaxStatus: http.StatusRequestTimeout, Result: config.StatusRuleTransient},
+	{MinStatus: http.StatusTooManyRequests, MaxStatus: http.StatusTooManyRequests, Result: config.StatusRuleTransient},
+	{MinStatus: 500, MaxStatus: 599, Result: config.StatusRuleTransient},
+}
+
+// WebhookSender posts each message to an HTTPS endpoint. Secrets are named
+// `<partnerId>-<destination name>-<purpose>-<env>`, see SECRETS.md
+type WebhookSender struct {
+	url              string
+	headers          map[string]string
+	contentType      string
+	statusRules      []config.StatusRule
+	auth             config.WebhookAuthSettings
+	secretPrefix     string
+	credentialGetter secrets.CredentialGetter
+	httpClient       *http.Client
+}
+
+func NewWebhookSender(partnerId string, destinationSettings config.DestinationSettings) (WebhookSender, error) {
+	if destinationSettings.Url == "" {
+		return WebhookSender{}, errors.New("webhook url is required")
+	}
+
+	credentialGetter, err := secrets.GetCredentialGetter()
+	if err != nil {
+		return WebhookSender{}, err
+	}
+
+	timeout := time.Duration(destinationSettings.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	contentType := destinationSettings.ContentType
+	if contentType == "" {
+		contentType = defaultWebhookContentType
+	}
+
+	sender := WebhookSender{
+		url:              destinationSettings.Url,
+		headers:          destinationSettings.Headers,
+		contentType:      contentType,
+		statusRules:      destinationSettings.StatusRules,
+		auth:             destinationSettings.Auth,
+		secretPrefix:     partnerId + "-" + destinationSettings.Name,
+		credentialGetter: credentialGetter,
+		httpClient:       &http.Client{Timeout: timeout},
+	}
+
+	if destinationSettings.Auth.Type == config.WebhookAuthMutualTls {
+		certificate, err := sender.clientCertificate()
+		if err != nil {
+			slog.Error("Failed to load client certificate for webhook", slog.Any(utils.ErrorKey, err), slog.String("destination", destinationSettings.Name))
+			return WebhookSender{}, err
+		}
+		sender.httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12},
+		}
+	}
+
+	return sender, nil
+}
+
+// SendMessage posts the message and classifies the response with the status rules. A `permanent` result is a
+// non-transient failure, so the message moves to `failure`. We return the response's `X-Request-Id` header, if any,
+// as the report ID
+func (receiver WebhookSender) SendMessage(message []byte) (string, error) {
+	req, err := http.NewRequest("POST", receiver.url, bytes.NewBuffer(message))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", receiver.contentType)
+	for name, value := range receiver.headers {
+		req.Header.Set(name, value)
+	}
+
+	authorization, err := receiver.authorizationHeader()
+	if err != nil {
+		slog.Error("Failed to authorize webhook request", slog.Any(utils.ErrorKey, err), slog.String("authType", receiver.auth.Type))
+		return "", err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	res, err := receiver.httpClient.Do(req)
+	if err != nil {
+		slog.Error("error calling webhook", slog.Any(utils.ErrorKey, err))
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	responseBodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	switch receiver.classifyStatus(res.StatusCode) {
+	case config.StatusRuleSuccess:
+		return res.Header.Get("X-Request-Id"), nil
+	case config.StatusRulePermanent:
+		slog.Info("webhook rejected message", slog.String("status", res.Status), slog.String("responseBody", string(responseBodyBytes)))
+		return "", errors.New(utils.ReportStreamNonTransientFailure + ": webhook responded " + res.Status)
+	default:
+		slog.Info("webhook failed to accept message", slog.String("status", res.Status), slog.String("responseBody", string(responseBodyBytes)))
+		return "", errors.New(res.Status)
+	}
+}
+
+// classifyStatus checks the partner's rules first, then the default rules. Anything left over is permanent
+func (receiver WebhookSender) classifyStatus(statusCode int) string {
+	for _, rule := range slices.Concat(receiver.statusRules, defaultStatusRules) {
+		if statusCode >= rule.MinStatus && statusCode <= rule.MaxStatus {
+			return rule.Result
+		}
+	}
+	return config.StatusRulePermanent
+}
+
+func (receiver WebhookSender) authorizationHeader() (string, error) {
+	switch receiver.auth.Type {
+	case config.WebhookAuthBearerToken:
+		token, err := receiver.credentialGetter.GetSecret(receiver.secretName("bearer-token"))
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + strings.TrimSpace(token), nil
+	case config.WebhookAuthOauthClientCredentials:
+		token, err := receiver.getOauthToken()
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", nil
+	}
+}
+
+// getOauthToken requests an access token with the client credentials grant. We authenticate with either a client
+// secret or a client assertion JWT signed with our private key, the same way we get a ReportStream token
+func (receiver WebhookSender) getOauthToken() (string, error) {
+	data := url.Values{
+		"grant_type": {"client_credentials"},
+	}
+	if receiver.auth.Scope != "" {
+		data.Set("scope", receiver.auth.Scope)
+	}
+
+	if receiver.auth.UsePrivateKeyJwt {
+		key, err := receiver.credentialGetter.GetPrivateKey(receiver.secretName("private-key"))
+		if err != nil {
+			return "", err
+		}
+
+		clientAssertion, err := generateClientAssertion(key, receiver.auth.ClientId, receiver.auth.TokenUrl)
+		if err != nil {
+			return "", err
+		}
+
+		data.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		data.Set("client_assertion", clientAssertion)
+	} else {
+		clientSecret, err := receiver.credentialGetter.GetSecret(receiver.secretName("client-secret"))
+		if err != nil {
+			return "", err
+		}
+
+		data.Set("client_id", receiver.auth.ClientId)
+		data.Set("client_secret", strings.TrimSpace(clientSecret))
+	}
+
+	return requestAccessToken(receiver.httpClient, receiver.auth.TokenUrl, data)
+}
+
+func (receiver WebhookSender) clientCertificate() (tls.Certificate, error) {
+	certificatePem, err := receiver.credentialGetter.GetSecret(receiver.secretName("client-certificate"))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyPem, err := receiver.credentialGetter.GetSecret(receiver.secretName("client-private-key"))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.X509KeyPair([]byte(certificatePem), []byte(keyPem))
+}
+
+func (receiver WebhookSender) secretName(purpose string) string {
+	return receiver.secretPrefix + "-" + purpose + "-" + utils.EnvironmentName() // pragma: allowlist secret
+}
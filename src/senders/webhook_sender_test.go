@@ -0,0 +1,240 @@
+package senders
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_WebhookSender_SendMessage_EndpointAccepts_ReturnsRequestId(t *testing.T) {
+	var receivedHeaders http.Header
+	var receivedBody []byte
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedHeaders = r.Header
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("X-Request-Id", "request ID")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sender := WebhookSender{
+		url:         server.URL,
+		headers:     map[string]string{"X-Partner": "flexion"},
+		contentType: "application/hl7-v2",
+		httpClient:  server.Client(),
+	}
+
+	reportId, err := sender.SendMessage(message)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "request ID", reportId)
+	assert.Equal(t, message, receivedBody)
+	assert.Equal(t, "application/hl7-v2", receivedHeaders.Get("Content-Type"))
+	assert.Equal(t, "flexion", receivedHeaders.Get("X-Partner"))
+	assert.Empty(t, receivedHeaders.Get("Authorization"))
+}
+
+func Test_WebhookSender_SendMessage_EndpointIsUnavailable_ReturnsTransientError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	sender := WebhookSender{url: server.URL, httpClient: server.Client()}
+
+	_, err := sender.SendMessage(message)
+
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+}
+
+func Test_WebhookSender_SendMessage_EndpointRejectsMessage_ReturnsNonTransientError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sender := WebhookSender{url: server.URL, httpClient: server.Client()}
+
+	_, err := sender.SendMessage(message)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+}
+
+func Test_WebhookSender_SendMessage_StatusRuleMatches_UsesRuleBeforeDefaults(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+
+	sender := WebhookSender{
+		url:         server.URL,
+		statusRules: []config.StatusRule{{MinStatus: http.StatusConflict, MaxStatus: http.StatusConflict, Result: config.StatusRuleSuccess}},
+		httpClient:  server.Client(),
+	}
+
+	_, err := sender.SendMessage(message)
+
+	assert.NoError(t, err)
+}
+
+func Test_WebhookSender_SendMessage_BearerTokenAuth_SendsTokenFromKeyVault(t *testing.T) {
+	var authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", "flexion-lab-bearer-token-local").Return("static token\n", nil)
+
+	sender := WebhookSender{
+		url:              server.URL,
+		auth:             config.WebhookAuthSettings{Type: config.WebhookAuthBearerToken},
+		secretPrefix:     "flexion-lab",
+		credentialGetter: mockCredentialGetter,
+		httpClient:       server.Client(),
+	}
+
+	_, err := sender.SendMessage(message)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer static token", authorization)
+}
+
+func Test_WebhookSender_SendMessage_UnableToGetBearerToken_ReturnsErrorWithoutSending(t *testing.T) {
+	called := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", "flexion-lab-bearer-token-local").Return("", errors.New("secret not found"))
+
+	sender := WebhookSender{
+		url:              server.URL,
+		auth:             config.WebhookAuthSettings{Type: config.WebhookAuthBearerToken},
+		secretPrefix:     "flexion-lab",
+		credentialGetter: mockCredentialGetter,
+		httpClient:       server.Client(),
+	}
+
+	_, err := sender.SendMessage(message)
+
+	assert.Error(t, err)
+	assert.False(t, called)
+}
+
+func Test_WebhookSender_SendMessage_OauthClientSecret_SendsAccessToken(t *testing.T) {
+	var tokenForm map[string][]string
+	var authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token" {
+			r.ParseForm()
+			tokenForm = r.PostForm
+			w.Write([]byte(`{"access_token": "access token", "token_type": "bearer"}`))
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", "flexion-lab-client-secret-local").Return("client secret", nil)
+
+	sender := WebhookSender{
+		url:              server.URL + "/messages",
+		auth:             config.WebhookAuthSettings{Type: config.WebhookAuthOauthClientCredentials, TokenUrl: server.URL + "/oauth/token", ClientId: "client", Scope: "messages.write"},
+		secretPrefix:     "flexion-lab",
+		credentialGetter: mockCredentialGetter,
+		httpClient:       server.Client(),
+	}
+
+	_, err := sender.SendMessage(message)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer access token", authorization)
+	assert.Equal(t, []string{"client_credentials"}, tokenForm["grant_type"])
+	assert.Equal(t, []string{"client"}, tokenForm["client_id"])
+	assert.Equal(t, []string{"client secret"}, tokenForm["client_secret"])
+	assert.Equal(t, []string{"messages.write"}, tokenForm["scope"])
+}
+
+func Test_WebhookSender_SendMessage_OauthPrivateKeyJwt_SendsClientAssertion(t *testing.T) {
+	var tokenForm map[string][]string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/oauth/token" {
+			r.ParseForm()
+			tokenForm = r.PostForm
+			w.Write([]byte(`{"access_token": "access token", "token_type": "bearer"}`))
+		}
+	}))
+	defer server.Close()
+
+	testKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetPrivateKey", "flexion-lab-private-key-local").Return(testKey, nil)
+
+	sender := WebhookSender{
+		url:              server.URL + "/messages",
+		auth:             config.WebhookAuthSettings{Type: config.WebhookAuthOauthClientCredentials, TokenUrl: server.URL + "/oauth/token", ClientId: "client", UsePrivateKeyJwt: true},
+		secretPrefix:     "flexion-lab",
+		credentialGetter: mockCredentialGetter,
+		httpClient:       server.Client(),
+	}
+
+	_, err = sender.SendMessage(message)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokenForm["client_assertion"])
+	assert.Empty(t, tokenForm["client_secret"])
+}
+
+func Test_WebhookSender_SendMessage_TokenRequestFails_ReturnsError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", "flexion-lab-client-secret-local").Return("client secret", nil)
+
+	sender := WebhookSender{
+		url:              server.URL + "/messages",
+		auth:             config.WebhookAuthSettings{Type: config.WebhookAuthOauthClientCredentials, TokenUrl: server.URL + "/oauth/token", ClientId: "client"},
+		secretPrefix:     "flexion-lab",
+		credentialGetter: mockCredentialGetter,
+		httpClient:       server.Client(),
+	}
+
+	_, err := sender.SendMessage(message)
+
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), utils.ReportStreamNonTransientFailure)
+}
+
+func Test_NewWebhookSender_UrlIsMissing_ReturnsError(t *testing.T) {
+	_, err := NewWebhookSender("flexion", config.DestinationSettings{Name: "lab", Type: config.DestinationTypeWebhook})
+
+	assert.Error(t, err)
+}
+
+func Test_NewWebhookSender_DefaultsAreApplied_ReturnsSender(t *testing.T) {
+	sender, err := NewWebhookSender("flexion", config.DestinationSettings{Name: "lab", Type: config.DestinationTypeWebhook, Url: "https://lab.example.com/messages"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, defaultWebhookContentType, sender.contentType)
+	assert.Equal(t, defaultWebhookTimeout, sender.httpClient.Timeout)
+	assert.Equal(t, "flexion-lab-bearer-token-local", sender.secretName("bearer-token"))
+}
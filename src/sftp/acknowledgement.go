@@ -0,0 +1,170 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+const hl7Timestamp = "20060102150405"
+
+// acknowledgementFile is what we tell the partner in CSV and JSON acknowledgements. It leaves out our blob URL
+type acknowledgementFile struct {
+	FileName         string    `json:"fileName"`
+	ArchiveEntryPath string    `json:"archiveEntryPath,omitempty"`
+	Status           string    `json:"status"`
+	ReportId         string    `json:"reportId,omitempty"`
+	Reasons          []string  `json:"reasons,omitempty"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+// PushAcknowledgement writes an acknowledgement for one of the partner's files to their SFTP server, in the format and
+// directory from the partner's AcknowledgementSettings. The file is named after theirs, e.g. `results.hl7.ack.csv`, so
+// a later acknowledgement for the same file replaces the earlier one. A message from an archive is named after the
+// archive and where it was in the archive, e.g. `results.zip_2024_order.hl7.ack.csv`
+func (receiver *SftpHandler) PushAcknowledgement(acknowledgement usecases.Acknowledgement) error {
+	acknowledgements := receiver.partnerSettings.Acknowledgements
+	if acknowledgements.Format == "" {
+		slog.Info("Partner doesn't want acknowledgements, skipping", slog.String("partnerId", receiver.partnerId))
+		return nil
+	}
+
+	contents, err := formatAcknowledgement(acknowledgement, acknowledgements.Format)
+	if err != nil {
+		slog.Error("Failed to format acknowledgement", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, acknowledgement.FileName))
+		return err
+	}
+
+	acknowledgementName := acknowledgement.FileName
+	if acknowledgement.ArchiveEntryPath != "" {
+		acknowledgementName += "_" + strings.ReplaceAll(acknowledgement.ArchiveEntryPath, "/", "_")
+	}
+	remotePath := path.Join(acknowledgements.Directory, acknowledgementName+".ack."+acknowledgements.Format)
+	err = receiver.writeRemoteFile(remotePath, contents)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Wrote acknowledgement to SFTP server", slog.String(utils.FileNameKey, remotePath), slog.String("status", acknowledgement.Status))
+	return nil
+}
+
+// writeRemoteFile writes to a temporary name first, so the partner never picks up a partial file
+func (receiver *SftpHandler) writeRemoteFile(remotePath string, contents []byte) error {
+	temporaryPath := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".tmp")
+
+	writer, err := receiver.sftpClient.Create(temporaryPath)
+	if err != nil {
+		slog.Error("Failed to create file on SFTP server", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, temporaryPath))
+		return err
+	}
+
+	_, err = writer.Write(contents)
+	if err != nil {
+		slog.Error("Failed to write file on SFTP server", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, temporaryPath))
+		_ = writer.Close()
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		slog.Error("Failed to close file on SFTP server", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, temporaryPath))
+		return err
+	}
+
+	// SFTP servers don't all let a rename replace an existing file, and there isn't one the first time
+	_ = receiver.sftpClient.Remove(remotePath)
+
+	err = receiver.sftpClient.Rename(temporaryPath, remotePath)
+	if err != nil {
+		slog.Error("Failed to rename file on SFTP server", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, temporaryPath))
+		return err
+	}
+
+	return nil
+}
+
+// isAcknowledgementFile keeps us from copying our own acknowledgements, or their temporary files, when the partner's
+// acknowledgement directory is one we copy files from
+func (receiver *SftpHandler) isAcknowledgementFile(name string) bool {
+	format := receiver.partnerSettings.Acknowledgements.Format
+	if format == "" {
+		return false
+	}
+	suffix := ".ack." + format
+	return strings.HasSuffix(name, suffix) || strings.HasSuffix(name, suffix+".tmp")
+}
+
+func formatAcknowledgement(acknowledgement usecases.Acknowledgement, format string) ([]byte, error) {
+	file := acknowledgementFile{
+		FileName:         acknowledgement.FileName,
+		ArchiveEntryPath: acknowledgement.ArchiveEntryPath,
+		Status:           acknowledgement.Status,
+		ReportId:         acknowledgement.ReportId,
+		Reasons:          acknowledgement.Reasons,
+		Timestamp:        acknowledgement.Timestamp,
+	}
+
+	switch format {
+	case config.AcknowledgementFormatCsv:
+		var buffer bytes.Buffer
+		writer := csv.NewWriter(&buffer)
+		_ = writer.Write([]string{"file_name", "archive_entry_path", "status", "report_id", "reasons", "timestamp"})
+		_ = writer.Write([]string{file.FileName, file.ArchiveEntryPath, file.Status, file.ReportId, strings.Join(file.Reasons, "; "), file.Timestamp.Format(time.RFC3339)})
+		writer.Flush()
+		return buffer.Bytes(), writer.Error()
+	case config.AcknowledgementFormatJson:
+		return json.MarshalIndent(file, "", "  ")
+	case config.AcknowledgementFormatHl7:
+		return formatHl7Acknowledgement(acknowledgement), nil
+	}
+
+	return nil, errors.New("unknown acknowledgement format: " + format)
+}
+
+// formatHl7Acknowledgement writes an HL7 ACK for each message in the partner's file. The MSA segment has `AA` for a
+// file we sent and `AR` for one we rejected, along with the report ID or the reasons
+func formatHl7Acknowledgement(acknowledgement usecases.Acknowledgement) []byte {
+	acknowledgementCode := "AA"
+	text := acknowledgement.ReportId
+	if acknowledgement.Status == usecases.AcknowledgementStatusRejected {
+		acknowledgementCode = "AR"
+		text = strings.Join(acknowledgement.Reasons, "; ")
+	}
+
+	controlIds := acknowledgement.MessageControlIds
+	if len(controlIds) == 0 {
+		// Without the partner's control IDs, one ACK still tells them what happened to the file
+		controlIds = []string{""}
+	}
+
+	timestamp := acknowledgement.Timestamp.UTC().Format(hl7Timestamp)
+	var builder strings.Builder
+	for index, controlId := range controlIds {
+		builder.WriteString(fmt.Sprintf("MSH|^~\\&|RS-SFTP-INGESTION|CDC|%s||%s||ACK|%s-%d|P|2.5.1\r", escapeHl7(acknowledgement.PartnerId), timestamp, timestamp, index+1))
+		builder.WriteString(fmt.Sprintf("MSA|%s|%s|%s\r", acknowledgementCode, escapeHl7(controlId), escapeHl7(text)))
+	}
+	return []byte(builder.String())
+}
+
+// escapeHl7 escapes HL7's delimiters, assuming the standard `|^~\&` encoding characters
+func escapeHl7(value string) string {
+	return strings.NewReplacer(
+		"\\", "\\E\\",
+		"|", "\\F\\",
+		"^", "\\S\\",
+		"&", "\\T\\",
+		"~", "\\R\\",
+		"\r", " ",
+		"\n", " ",
+	).Replace(value)
+}
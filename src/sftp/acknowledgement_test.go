@@ -0,0 +1,140 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"os"
+	"testing"
+	"time"
+)
+
+var acknowledgedAt = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func Test_PushAcknowledgement_WritesTemporaryFileThenRenames(t *testing.T) {
+	writer := &testWriteCloser{}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Create", "acks/.results.hl7.ack.json.tmp").Return(writer, nil)
+	mockSftpClient.On("Remove", "acks/results.hl7.ack.json").Return(os.ErrNotExist)
+	mockSftpClient.On("Rename", "acks/.results.hl7.ack.json.tmp", "acks/results.hl7.ack.json").Return(nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{Acknowledgements: config.AcknowledgementSettings{Format: config.AcknowledgementFormatJson, Directory: "acks"}}}
+
+	err := sftpHandler.PushAcknowledgement(usecases.Acknowledgement{FileName: "results.hl7", Status: usecases.AcknowledgementStatusSent, Timestamp: acknowledgedAt})
+
+	assert.NoError(t, err)
+	assert.True(t, writer.closed)
+	assert.Contains(t, writer.String(), `"fileName": "results.hl7"`)
+	mockSftpClient.AssertCalled(t, "Rename", "acks/.results.hl7.ack.json.tmp", "acks/results.hl7.ack.json")
+}
+
+func Test_PushAcknowledgement_MessageCameFromArchive_NamesFileAfterArchiveAndEntry(t *testing.T) {
+	writer := &testWriteCloser{}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Create", "acks/.results.zip_2024_order.hl7.ack.csv.tmp").Return(writer, nil)
+	mockSftpClient.On("Remove", "acks/results.zip_2024_order.hl7.ack.csv").Return(os.ErrNotExist)
+	mockSftpClient.On("Rename", "acks/.results.zip_2024_order.hl7.ack.csv.tmp", "acks/results.zip_2024_order.hl7.ack.csv").Return(nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{Acknowledgements: config.AcknowledgementSettings{Format: config.AcknowledgementFormatCsv, Directory: "acks"}}}
+
+	err := sftpHandler.PushAcknowledgement(usecases.Acknowledgement{FileName: "results.zip", ArchiveEntryPath: "2024/order.hl7", Status: usecases.AcknowledgementStatusSent, Timestamp: acknowledgedAt})
+
+	assert.NoError(t, err)
+	assert.Contains(t, writer.String(), "results.zip,2024/order.hl7,sent,")
+	mockSftpClient.AssertCalled(t, "Rename", "acks/.results.zip_2024_order.hl7.ack.csv.tmp", "acks/results.zip_2024_order.hl7.ack.csv")
+}
+
+func Test_PushAcknowledgement_UnableToCreateFile_ReturnsError(t *testing.T) {
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Create", mock.Anything).Return(&testWriteCloser{}, errors.New("permission denied"))
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{Acknowledgements: config.AcknowledgementSettings{Format: config.AcknowledgementFormatCsv, Directory: "acks"}}}
+
+	err := sftpHandler.PushAcknowledgement(usecases.Acknowledgement{FileName: "results.hl7", Status: usecases.AcknowledgementStatusSent})
+
+	assert.Error(t, err)
+	mockSftpClient.AssertNotCalled(t, "Rename", mock.Anything, mock.Anything)
+}
+
+func Test_PushAcknowledgement_PartnerDoesNotWantAcknowledgements_DoesNothing(t *testing.T) {
+	mockSftpClient := new(MockSftpWrapper)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerId: partnerId}
+
+	err := sftpHandler.PushAcknowledgement(usecases.Acknowledgement{FileName: "results.hl7"})
+
+	assert.NoError(t, err)
+	mockSftpClient.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func Test_formatAcknowledgement_Csv(t *testing.T) {
+	contents, err := formatAcknowledgement(usecases.Acknowledgement{
+		FileName:  "results.hl7",
+		Status:    usecases.AcknowledgementStatusRejected,
+		Reasons:   []string{"bad, very bad", "worse"},
+		Timestamp: acknowledgedAt,
+	}, config.AcknowledgementFormatCsv)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "file_name,archive_entry_path,status,report_id,reasons,timestamp\nresults.hl7,,rejected,,\"bad, very bad; worse\",2024-06-01T12:00:00Z\n", string(contents))
+}
+
+func Test_formatAcknowledgement_Json_LeavesOutSourceUrl(t *testing.T) {
+	contents, err := formatAcknowledgement(usecases.Acknowledgement{
+		FileName:  "results.hl7",
+		SourceUrl: "http://localhost/sftp/flexion/success/results.hl7",
+		Status:    usecases.AcknowledgementStatusSent,
+		ReportId:  "report",
+		Timestamp: acknowledgedAt,
+	}, config.AcknowledgementFormatJson)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"fileName":"results.hl7","status":"sent","reportId":"report","timestamp":"2024-06-01T12:00:00Z"}`, string(contents))
+}
+
+func Test_formatAcknowledgement_Hl7_WritesAckForEachMessage(t *testing.T) {
+	contents, err := formatAcknowledgement(usecases.Acknowledgement{
+		PartnerId:         partnerId,
+		Status:            usecases.AcknowledgementStatusRejected,
+		Reasons:           []string{"Invalid|observation"},
+		MessageControlIds: []string{"control-1", "control-2"},
+		Timestamp:         acknowledgedAt,
+	}, config.AcknowledgementFormatHl7)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "MSH|^~\\&|RS-SFTP-INGESTION|CDC|flexion||20240601120000||ACK|20240601120000-1|P|2.5.1\r"+
+		"MSA|AR|control-1|Invalid\\F\\observation\r"+
+		"MSH|^~\\&|RS-SFTP-INGESTION|CDC|flexion||20240601120000||ACK|20240601120000-2|P|2.5.1\r"+
+		"MSA|AR|control-2|Invalid\\F\\observation\r", string(contents))
+}
+
+func Test_formatAcknowledgement_UnknownFormat_ReturnsError(t *testing.T) {
+	_, err := formatAcknowledgement(usecases.Acknowledgement{}, "xml")
+
+	assert.Error(t, err)
+}
+
+func Test_listRemoteFiles_SkipsAcknowledgements(t *testing.T) {
+	order := testFileInfo{name: "order.hl7"}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{order, testFileInfo{name: "old.hl7.ack.csv"}, testFileInfo{name: ".order.hl7.ack.csv.tmp"}}, nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient,
+		partnerSettings: config.PartnerSettings{Acknowledgements: config.AcknowledgementSettings{Format: config.AcknowledgementFormatCsv, Directory: "dogcow"}}}
+
+	files, err := sftpHandler.listRemoteFiles("dogcow", "", 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []remoteFile{{fileInfo: order, directory: "dogcow"}}, files)
+}
+
+type testWriteCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (receiver *testWriteCloser) Close() error {
+	receiver.closed = true
+	return nil
+}
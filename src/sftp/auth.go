@@ -0,0 +1,106 @@
+package sftp
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"golang.org/x/crypto/ssh"
+	"log/slog"
+)
+
+// getSshAuthMethods builds the ways we log in to the partner's SFTP server from their SftpAuthSettings. The ssh
+// package tries them in order, so for `publicKeyAndPassword` the server gets the key first and then the password
+func getSshAuthMethods(credentialGetter secrets.CredentialGetter, partnerId string, sftpAuth config.SftpAuthSettings) ([]ssh.AuthMethod, error) {
+	var authMethods []ssh.AuthMethod
+
+	if sftpAuth.Mode == "" || sftpAuth.Mode == config.SftpAuthPublicKey || sftpAuth.Mode == config.SftpAuthPublicKeyAndPassword {
+		userCredentialPrivateKey, err := getUserCredentialPrivateKey(credentialGetter, partnerId)
+		if err != nil {
+			slog.Error("Unable to get user credential private key", slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(userCredentialPrivateKey))
+	}
+
+	if sftpAuth.Mode == config.SftpAuthPassword || sftpAuth.Mode == config.SftpAuthPublicKeyAndPassword || sftpAuth.Mode == config.SftpAuthKeyboardInteractive {
+		password, err := getSftpPassword(credentialGetter, partnerId)
+		if err != nil {
+			slog.Error("Unable to get SFTP password", slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+
+		if sftpAuth.Mode == config.SftpAuthKeyboardInteractive {
+			authMethods = append(authMethods, ssh.KeyboardInteractive(passwordChallenge(password)))
+		} else {
+			authMethods = append(authMethods, ssh.Password(password))
+		}
+	}
+
+	if len(authMethods) == 0 {
+		return nil, errors.New("unknown SFTP auth mode: " + sftpAuth.Mode)
+	}
+
+	return authMethods, nil
+}
+
+func getUserCredentialPrivateKey(credentialGetter secrets.CredentialGetter, partnerId string) (ssh.Signer, error) {
+
+	userAuthenticationKeyName := partnerId + "-sftp-user-credential-private-key-" + utils.EnvironmentName() // pragma: allowlist secret
+
+	key, err := credentialGetter.GetSecret(userAuthenticationKeyName)
+	if err != nil {
+		slog.Error("Unable to retrieve user authentication key secret", slog.String("KeyName", userAuthenticationKeyName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	pem, err := ssh.ParsePrivateKey([]byte(key))
+
+	// We only read the passphrase secret for keys that need one
+	var passphraseMissingError *ssh.PassphraseMissingError
+	if errors.As(err, &passphraseMissingError) {
+		passphraseName := partnerId + "-sftp-user-credential-private-key-passphrase-" + utils.EnvironmentName() // pragma: allowlist secret
+		passphrase, err := credentialGetter.GetSecret(passphraseName)
+		if err != nil {
+			slog.Error("Unable to retrieve private key passphrase secret", slog.String("KeyName", passphraseName), slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+		pem, err = ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+		if err != nil {
+			slog.Error("Unable to parse private key with passphrase", slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+		return pem, nil
+	}
+
+	if err != nil {
+		slog.Error("Unable to parse private key", slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+	return pem, err
+}
+
+func getSftpPassword(credentialGetter secrets.CredentialGetter, partnerId string) (string, error) {
+	passwordName := partnerId + "-sftp-password-" + utils.EnvironmentName() // pragma: allowlist secret
+
+	password, err := credentialGetter.GetSecret(passwordName)
+	if err != nil {
+		slog.Error("Unable to retrieve SFTP password secret", slog.String("KeyName", passwordName), slog.Any(utils.ErrorKey, err))
+		return "", err
+	}
+	return password, nil
+}
+
+// passwordChallenge answers keyboard-interactive logins. Servers usually ask one `Password:` question, so we answer
+// every question with the password, and questions that echo (like a banner asking to continue) with nothing
+func passwordChallenge(password string) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for index := range questions {
+			if !echos[index] {
+				answers[index] = password
+			}
+		}
+		return answers, nil
+	}
+}
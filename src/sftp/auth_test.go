@@ -0,0 +1,108 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+const privateKeyName = "flexion-sftp-user-credential-private-key-local" // pragma: allowlist secret
+const passwordName = "flexion-sftp-password-local"                      // pragma: allowlist secret
+
+func Test_getSshAuthMethods_ModeIsEmpty_UsesPublicKey(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", privateKeyName).Return(secretValue, nil)
+
+	authMethods, err := getSshAuthMethods(mockCredentialGetter, partnerId, config.SftpAuthSettings{})
+
+	assert.NoError(t, err)
+	assert.Len(t, authMethods, 1)
+	mockCredentialGetter.AssertNotCalled(t, "GetSecret", passwordName)
+}
+
+func Test_getSshAuthMethods_ModeIsPassword_OnlyReadsPassword(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", passwordName).Return("hunter2", nil)
+
+	authMethods, err := getSshAuthMethods(mockCredentialGetter, partnerId, config.SftpAuthSettings{Mode: config.SftpAuthPassword})
+
+	assert.NoError(t, err)
+	assert.Len(t, authMethods, 1)
+	mockCredentialGetter.AssertNotCalled(t, "GetSecret", privateKeyName)
+}
+
+func Test_getSshAuthMethods_ModeIsPublicKeyAndPassword_UsesBoth(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", privateKeyName).Return(secretValue, nil)
+	mockCredentialGetter.On("GetSecret", passwordName).Return("hunter2", nil)
+
+	authMethods, err := getSshAuthMethods(mockCredentialGetter, partnerId, config.SftpAuthSettings{Mode: config.SftpAuthPublicKeyAndPassword})
+
+	assert.NoError(t, err)
+	assert.Len(t, authMethods, 2)
+}
+
+func Test_getSshAuthMethods_UnableToGetPassword_ReturnsError(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", passwordName).Return("", errors.New("not found"))
+
+	authMethods, err := getSshAuthMethods(mockCredentialGetter, partnerId, config.SftpAuthSettings{Mode: config.SftpAuthKeyboardInteractive})
+
+	assert.Nil(t, authMethods)
+	assert.Error(t, err)
+}
+
+func Test_getSshAuthMethods_UnknownMode_ReturnsError(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+
+	authMethods, err := getSshAuthMethods(mockCredentialGetter, partnerId, config.SftpAuthSettings{Mode: "telepathy"})
+
+	assert.Nil(t, authMethods)
+	assert.Error(t, err)
+}
+
+func Test_getUserCredentialPrivateKey_KeyHasPassphrase_ReadsPassphraseSecret(t *testing.T) {
+	encryptedKey := encryptedPrivateKey(t, "correct horse")
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", privateKeyName).Return(encryptedKey, nil)
+	mockCredentialGetter.On("GetSecret", "flexion-sftp-user-credential-private-key-passphrase-local").Return("correct horse", nil)
+
+	signer, err := getUserCredentialPrivateKey(mockCredentialGetter, partnerId)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, signer)
+}
+
+func Test_getUserCredentialPrivateKey_PassphraseIsWrong_ReturnsError(t *testing.T) {
+	encryptedKey := encryptedPrivateKey(t, "correct horse")
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", privateKeyName).Return(encryptedKey, nil)
+	mockCredentialGetter.On("GetSecret", mock.Anything).Return("battery staple", nil)
+
+	signer, err := getUserCredentialPrivateKey(mockCredentialGetter, partnerId)
+
+	assert.Nil(t, signer)
+	assert.Error(t, err)
+}
+
+func Test_passwordChallenge_AnswersHiddenQuestionsWithPassword(t *testing.T) {
+	answers, err := passwordChallenge("hunter2")("", "", []string{"Password: ", "Press enter to continue"}, []bool{false, true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hunter2", ""}, answers)
+}
+
+func encryptedPrivateKey(t *testing.T, passphrase string) string {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte(passphrase))
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(block))
+}
@@ -0,0 +1,172 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// defaultDialTimeout and defaultOperationTimeout are for partners whose SftpConnectionSettings don't set them. They're
+// well under the queue message's visibility timeout, so a server that stops responding can't hold a poll that long
+const defaultDialTimeout = 30 * time.Second
+const defaultOperationTimeout = 2 * time.Minute
+
+var errOperationTimedOut = errors.New("SFTP operation timed out")
+
+// newSshClientConfig builds the SSH client config from the partner's SftpConnectionSettings
+func newSshClientConfig(user string, authMethods []ssh.AuthMethod, hostKeyCallback ssh.HostKeyCallback, hostKeyAlgorithms []string, connection config.SftpConnectionSettings) *ssh.ClientConfig {
+	sshClientConfig := &ssh.ClientConfig{
+		User:              user,
+		Auth:              authMethods,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           secondsOrDefault(connection.DialTimeoutSeconds, defaultDialTimeout),
+	}
+	sshClientConfig.Ciphers = connection.Ciphers
+	sshClientConfig.KeyExchanges = connection.KeyExchanges
+	sshClientConfig.MACs = connection.MACs
+	return sshClientConfig
+}
+
+// connectSsh connects to the partner's SFTP server, through their jump host if they use one. The jump host's client
+// is returned so it can be closed along with the SFTP server's
+func connectSsh(credentialGetter secrets.CredentialGetter, partnerId string, address string, sshClientConfig *ssh.ClientConfig, connection config.SftpConnectionSettings) (*ssh.Client, *ssh.Client, error) {
+	if !connection.UseJumpHost {
+		dialer := &net.Dialer{Timeout: sshClientConfig.Timeout}
+		sshClient, err := dialSsh(dialer.Dial, address, sshClientConfig)
+		return sshClient, nil, err
+	}
+
+	jumpHostClient, err := connectJumpHost(credentialGetter, partnerId, sshClientConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dialThroughJumpHost := func(network string, address string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), sshClientConfig.Timeout)
+		defer cancel()
+		return jumpHostClient.DialContext(ctx, network, address)
+	}
+	sshClient, err := dialSsh(dialThroughJumpHost, address, sshClientConfig)
+	if err != nil {
+		_ = jumpHostClient.Close()
+		return nil, nil, err
+	}
+	return sshClient, jumpHostClient, nil
+}
+
+// connectJumpHost logs in to the partner's jump host with the same credentials as their SFTP server
+func connectJumpHost(credentialGetter secrets.CredentialGetter, partnerId string, sshClientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	jumpHostSecrets := map[string]string{}
+	for _, secretName := range []string{"address", "user", "public-key"} {
+		keyName := partnerId + "-sftp-jump-host-" + secretName + "-" + utils.EnvironmentName() // pragma: allowlist secret
+		value, err := credentialGetter.GetSecret(keyName)
+		if err != nil {
+			slog.Error("Unable to get jump host secret", slog.String("KeyName", keyName), slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+		jumpHostSecrets[secretName] = value
+	}
+
+	hostKeyCallback, hostKeyAlgorithms, err := getSshClientHostKeyCallback(jumpHostSecrets["public-key"])
+	if err != nil {
+		slog.Error("Unable construct the jump host key callback", slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	jumpHostConfig := *sshClientConfig
+	jumpHostConfig.User = jumpHostSecrets["user"]
+	jumpHostConfig.HostKeyCallback = hostKeyCallback
+	jumpHostConfig.HostKeyAlgorithms = hostKeyAlgorithms
+
+	dialer := &net.Dialer{Timeout: sshClientConfig.Timeout}
+	jumpHostClient, err := dialSsh(dialer.Dial, jumpHostSecrets["address"], &jumpHostConfig)
+	if err != nil {
+		slog.Error("Failed to connect to jump host", slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+	return jumpHostClient, nil
+}
+
+// dialSsh connects and does the SSH handshake. ssh.Dial's timeout only covers connecting, so we also close the
+// connection if the handshake takes longer than the timeout, in case the server accepts the connection and then stops
+// responding
+func dialSsh(dial func(network string, address string) (net.Conn, error), address string, sshClientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.AfterFunc(sshClientConfig.Timeout, func() {
+		_ = conn.Close()
+	})
+	clientConn, channels, requests, err := ssh.NewClientConn(conn, address, sshClientConfig)
+	if !timer.Stop() {
+		if clientConn != nil {
+			_ = clientConn.Close()
+		}
+		return nil, errors.New("SSH handshake timed out")
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(clientConn, channels, requests), nil
+}
+
+// startKeepalive sends an SSH keepalive every interval until the connection closes. A keepalive that fails closes
+// the connection, so whatever is waiting on it fails instead of hanging
+func startKeepalive(sshClient *ssh.Client, interval time.Duration) {
+	closed := make(chan struct{})
+	go func() {
+		_ = sshClient.Wait()
+		close(closed)
+	}()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+				_, _, err := sshClient.SendRequest("keepalive@openssh.com", true, nil)
+				if err != nil {
+					slog.Warn("SSH keepalive failed, closing the connection", slog.Any(utils.ErrorKey, err))
+					_ = sshClient.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+func sftpClientOptions(connection config.SftpConnectionSettings) []sftp.ClientOption {
+	var options []sftp.ClientOption
+	if connection.MaxPacketBytes > 0 {
+		options = append(options, sftp.MaxPacket(connection.MaxPacketBytes))
+	}
+	if connection.MaxConcurrentRequestsPerFile > 0 {
+		options = append(options, sftp.MaxConcurrentRequestsPerFile(connection.MaxConcurrentRequestsPerFile))
+	}
+	if connection.DisableConcurrentReads {
+		options = append(options, sftp.UseConcurrentReads(false))
+	}
+	return options
+}
+
+func secondsOrDefault(seconds int, defaultDuration time.Duration) time.Duration {
+	if seconds == 0 {
+		return defaultDuration
+	}
+	return time.Duration(seconds) * time.Second
+}
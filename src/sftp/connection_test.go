@@ -0,0 +1,137 @@
+package sftp
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_newSshClientConfig_UsesPartnerAlgorithmsAndTimeout(t *testing.T) {
+	connection := config.SftpConnectionSettings{
+		DialTimeoutSeconds: 10,
+		Ciphers:            []string{"aes128-cbc"},
+		KeyExchanges:       []string{"diffie-hellman-group1-sha1"},
+		MACs:               []string{"hmac-sha1"},
+	}
+
+	sshClientConfig := newSshClientConfig(user, nil, ssh.InsecureIgnoreHostKey(), []string{ssh.KeyAlgoED25519}, connection)
+
+	assert.Equal(t, user, sshClientConfig.User)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, sshClientConfig.HostKeyAlgorithms)
+	assert.Equal(t, 10*time.Second, sshClientConfig.Timeout)
+	assert.Equal(t, []string{"aes128-cbc"}, sshClientConfig.Ciphers)
+	assert.Equal(t, []string{"diffie-hellman-group1-sha1"}, sshClientConfig.KeyExchanges)
+	assert.Equal(t, []string{"hmac-sha1"}, sshClientConfig.MACs)
+}
+
+func Test_newSshClientConfig_NoTimeout_UsesDefault(t *testing.T) {
+	sshClientConfig := newSshClientConfig(user, nil, ssh.InsecureIgnoreHostKey(), nil, config.SftpConnectionSettings{})
+
+	assert.Equal(t, defaultDialTimeout, sshClientConfig.Timeout)
+	assert.Nil(t, sshClientConfig.Ciphers)
+}
+
+func Test_dialSsh_ServerNeverResponds_TimesOut(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	dial := func(network string, address string) (net.Conn, error) {
+		return clientConn, nil
+	}
+	sshClientConfig := &ssh.ClientConfig{User: user, HostKeyCallback: ssh.InsecureIgnoreHostKey(), Timeout: 20 * time.Millisecond}
+
+	// Read what the client sends so it's only waiting on the server's reply
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			if _, err := serverConn.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	sshClient, err := dialSsh(dial, "sftp.example.com:22", sshClientConfig)
+
+	assert.Nil(t, sshClient)
+	assert.EqualError(t, err, "SSH handshake timed out")
+}
+
+func Test_dialSsh_UnableToConnect_ReturnsError(t *testing.T) {
+	dial := func(network string, address string) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+
+	sshClient, err := dialSsh(dial, "sftp.example.com:22", &ssh.ClientConfig{Timeout: time.Second})
+
+	assert.Nil(t, sshClient)
+	assert.EqualError(t, err, "connection refused")
+}
+
+func Test_connectSsh_UnableToGetJumpHostSecret_ReturnsError(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", "flexion-sftp-jump-host-address-local").Return("", errors.New("not found"))
+
+	sshClient, jumpHostClient, err := connectSsh(mockCredentialGetter, partnerId, "sftp.example.com:22", &ssh.ClientConfig{Timeout: time.Second}, config.SftpConnectionSettings{UseJumpHost: true})
+
+	assert.Nil(t, sshClient)
+	assert.Nil(t, jumpHostClient)
+	assert.Error(t, err)
+	mockCredentialGetter.AssertNumberOfCalls(t, "GetSecret", 1)
+}
+
+func Test_sftpClientOptions(t *testing.T) {
+	assert.Empty(t, sftpClientOptions(config.SftpConnectionSettings{}))
+	assert.Len(t, sftpClientOptions(config.SftpConnectionSettings{MaxPacketBytes: 32768, MaxConcurrentRequestsPerFile: 4, DisableConcurrentReads: true}), 3)
+}
+
+func Test_runWithTimeout_OperationFinishes_ReturnsResult(t *testing.T) {
+	value, err := runWithTimeout(time.Second, nil, func() (string, error) {
+		return "moof", nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "moof", value)
+}
+
+func Test_runWithTimeout_OperationHangs_CancelsAndWaitsForOperationToReturn(t *testing.T) {
+	cancelled := make(chan struct{})
+	operationReturned := false
+
+	_, err := runWithTimeout(10*time.Millisecond, []func() error{func() error {
+		close(cancelled)
+		return nil
+	}}, func() (int, error) {
+		<-cancelled
+		operationReturned = true
+		return 0, errors.New("channel closed")
+	})
+
+	assert.ErrorIs(t, err, errOperationTimedOut)
+	assert.True(t, operationReturned)
+}
+
+func Test_runWithTimeout_FirstCancelDoesNotWork_UsesNextOne(t *testing.T) {
+	connectionClosed := make(chan struct{})
+	channelClosed := false
+
+	_, err := runWithTimeout(10*time.Millisecond, []func() error{
+		func() error {
+			channelClosed = true
+			return nil
+		},
+		func() error {
+			close(connectionClosed)
+			return nil
+		},
+	}, func() (int, error) {
+		<-connectionClosed
+		return 0, errors.New("connection lost")
+	})
+
+	assert.ErrorIs(t, err, errOperationTimedOut)
+	assert.True(t, channelClosed)
+}
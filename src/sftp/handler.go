@@ -1,6 +1,9 @@
 package sftp
 
 import (
+	"bytes"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
 	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
 	"github.com/CDCgov/reportstream-sftp-ingestion/storage"
 	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
@@ -9,27 +12,34 @@ import (
 	"golang.org/x/crypto/ssh"
 	"io"
 	"log/slog"
-	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 type SftpHandler struct {
 	sshClient        *ssh.Client // sshAdapter
+	jumpHostClient   *ssh.Client // only set when the partner's SFTP server is behind a jump host
 	sftpClient       SftpWrapper
 	blobHandler      usecases.BlobHandler
 	credentialGetter secrets.CredentialGetter
 	zipHandler       zip.ZipHandlerInterface
 	partnerId        string
+	partnerSettings  config.PartnerSettings
 }
 
-func NewSftpHandler(credentialGetter secrets.CredentialGetter, partnerId string) (*SftpHandler, error) {
-	// In the future, we'll pass in info about what customer we're using (and thus what URL/key/password to use)
+// NewSftpHandler connects to partnerId's SFTP server. acknowledgementQueue tells the partner about archives we couldn't
+// unzip, and can be nil when the handler won't copy files
+func NewSftpHandler(credentialGetter secrets.CredentialGetter, partnerId string, acknowledgementQueue usecases.AcknowledgementQueue) (*SftpHandler, error) {
+	// A partner whose config didn't load gets the default settings here, and NewZipHandler turns them away below
+	var partnerSettings config.PartnerSettings
+	if partnerConfig := config.Configs[partnerId]; partnerConfig != nil {
+		partnerSettings = partnerConfig.PartnerSettings
+	}
 
-	userCredentialPrivateKey, err := getUserCredentialPrivateKey(credentialGetter, partnerId)
+	authMethods, err := getSshAuthMethods(credentialGetter, partnerId, partnerSettings.SftpAuth)
 	if err != nil {
-		slog.Error("Unable to get user credential private key", slog.Any(utils.ErrorKey, err))
 		return nil, err
 	}
 
@@ -40,7 +50,7 @@ func NewSftpHandler(credentialGetter secrets.CredentialGetter, partnerId string)
 		return nil, err
 	}
 
-	hostKeyCallback, err := getSshClientHostKeyCallback(hostPublicKey)
+	hostKeyCallback, hostKeyAlgorithms, err := getSshClientHostKeyCallback(hostPublicKey)
 	if err != nil {
 		slog.Error("Unable construct the host key callback", slog.Any("KeyName", hostPublicKeyName), slog.Any(utils.ErrorKey, err))
 		return nil, err
@@ -53,13 +63,7 @@ func NewSftpHandler(credentialGetter secrets.CredentialGetter, partnerId string)
 		return nil, err
 	}
 
-	config := &ssh.ClientConfig{
-		User: sftpUser,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(userCredentialPrivateKey),
-		},
-		HostKeyCallback: hostKeyCallback,
-	}
+	sshClientConfig := newSshClientConfig(sftpUser, authMethods, hostKeyCallback, hostKeyAlgorithms, partnerSettings.SftpConnection)
 
 	sftpServerAddressName := partnerId + "-sftp-server-address-" + utils.EnvironmentName() // pragma: allowlist secret
 	sftpServerAddress, err := credentialGetter.GetSecret(sftpServerAddressName)
@@ -68,13 +72,17 @@ func NewSftpHandler(credentialGetter secrets.CredentialGetter, partnerId string)
 		return nil, err
 	}
 
-	sshClient, err := ssh.Dial("tcp", sftpServerAddress, config)
+	sshClient, jumpHostClient, err := connectSsh(credentialGetter, partnerId, sftpServerAddress, sshClientConfig, partnerSettings.SftpConnection)
 	if err != nil {
 		slog.Error("Failed to make SSH client", slog.Any(utils.ErrorKey, err))
 		return nil, err
 	}
 
-	sftpClient, err := NewPkgSftpImplementation(sshClient)
+	if partnerSettings.SftpConnection.KeepaliveSeconds > 0 {
+		startKeepalive(sshClient, time.Duration(partnerSettings.SftpConnection.KeepaliveSeconds)*time.Second)
+	}
+
+	sftpClient, err := NewPkgSftpImplementation(sshClient, partnerSettings.SftpConnection)
 	if err != nil {
 		slog.Error("Failed to make SFTP client", slog.Any(utils.ErrorKey, err))
 		return nil, err
@@ -86,7 +94,7 @@ func NewSftpHandler(credentialGetter secrets.CredentialGetter, partnerId string)
 		return nil, err
 	}
 
-	zipHandler, err := zip.NewZipHandler()
+	zipHandler, err := zip.NewZipHandler(partnerId, acknowledgementQueue)
 
 	if err != nil {
 		slog.Error("Failed to init zip handler", slog.Any(utils.ErrorKey, err))
@@ -95,43 +103,16 @@ func NewSftpHandler(credentialGetter secrets.CredentialGetter, partnerId string)
 
 	return &SftpHandler{
 		sshClient:        sshClient,
+		jumpHostClient:   jumpHostClient,
 		sftpClient:       sftpClient,
 		blobHandler:      blobHandler,
 		credentialGetter: credentialGetter,
 		zipHandler:       zipHandler,
 		partnerId:        partnerId,
+		partnerSettings:  partnerSettings,
 	}, nil
 }
 
-func getSshClientHostKeyCallback(serverKey string) (ssh.HostKeyCallback, error) {
-	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(serverKey))
-
-	if err != nil {
-		slog.Error("Failed to parse authorized key", slog.Any(utils.ErrorKey, err))
-		return nil, err
-	}
-
-	return ssh.FixedHostKey(pk), nil
-}
-
-func getUserCredentialPrivateKey(credentialGetter secrets.CredentialGetter, partnerId string) (ssh.Signer, error) {
-
-	userAuthenticationKeyName := partnerId + "-sftp-user-credential-private-key-" + utils.EnvironmentName() // pragma: allowlist secret
-
-	key, err := credentialGetter.GetSecret(userAuthenticationKeyName)
-	if err != nil {
-		slog.Error("Unable to retrieve user authentication key secret", slog.String("KeyName", userAuthenticationKeyName), slog.Any(utils.ErrorKey, err))
-		return nil, err
-	}
-
-	pem, err := ssh.ParsePrivateKey([]byte(key))
-	if err != nil {
-		slog.Error("Unable to parse private key", slog.Any(utils.ErrorKey, err))
-		return nil, err
-	}
-	return pem, err
-}
-
 func (receiver *SftpHandler) Close() {
 	slog.Info("About to close SFTP handler")
 	if receiver.sftpClient != nil {
@@ -146,6 +127,12 @@ func (receiver *SftpHandler) Close() {
 			slog.Error("Failed to close SSH client", slog.Any(utils.ErrorKey, err))
 		}
 	}
+	if receiver.jumpHostClient != nil {
+		err := receiver.jumpHostClient.Close()
+		if err != nil {
+			slog.Error("Failed to close jump host SSH client", slog.Any(utils.ErrorKey, err))
+		}
+	}
 	slog.Info("SFTP handler closed")
 }
 
@@ -159,21 +146,20 @@ func (receiver *SftpHandler) CopyFiles() {
 
 	slog.Info("starting directory", slog.String("start dir", sftpStartingDirectory))
 
-	fileInfos, err := receiver.sftpClient.ReadDir(sftpStartingDirectory)
+	files, err := receiver.readyFiles(sftpStartingDirectory)
 	if err != nil {
-		slog.Error("Failed to read directory", slog.Any(utils.ErrorKey, err))
 		return
 	}
 
 	var wg sync.WaitGroup
-	//loop through files
-	for index, fileInfo := range fileInfos {
+	//loop through files the partner has finished uploading
+	for index, file := range files {
 		// Increment the wait group counter
 		wg.Add(1)
 		go func() {
 			// Decrement the counter when the go routine completes
 			defer wg.Done()
-			receiver.copySingleFile(fileInfo, index, sftpStartingDirectory)
+			receiver.copySingleFile(file, index)
 		}()
 	}
 	// Wait for all the wg elements to complete. Otherwise this function will return
@@ -187,90 +173,223 @@ func (receiver *SftpHandler) CopyFiles() {
 		- replace `files` hard-coded above with a per-customer value for e.g. `sftp_starting_folder` or similar (where
 			we go on their external SFTP server to retrieve files)
 		- pass customer info to SFTP client, so we know whose files these are/what creds to use
-		- since we have customer info, can use that to build destination path for upload
 		- have a type or enum or something for allowed destination subfolders? E.g. import, unzip, failure, success, etc.
 	*/
 
 }
 
-// copySingleFile moves a single file from an external SFTP server to our blob storage. Zip files go to an `unzip`
-// folder and then we call the zipHandler.Unzip. Other files go to `import` to begin processing
-func (receiver *SftpHandler) copySingleFile(fileInfo os.FileInfo, index int, directory string) {
+// copySingleFile moves a single file from an external SFTP server to our blob storage, see routeFile. Files are
+// checked against their manifest from next to them on the SFTP server, if any. The file's done marker, if any, is
+// removed along with it
+func (receiver *SftpHandler) copySingleFile(file remoteFile, index int) {
+	fileInfo := file.fileInfo
 	slog.Info("Considering file", slog.String(utils.FileNameKey, fileInfo.Name()), slog.Int("number", index))
 	if fileInfo.IsDir() {
 		slog.Info("Skipping directory", slog.String(utils.FileNameKey, fileInfo.Name()))
 		return
 	}
 
-	fullFilePath := directory + "/" + fileInfo.Name()
+	fileBytes, err := receiver.transferFile(file)
+	if err != nil {
+		return
+	}
 
-	fileReadCloser, err := receiver.sftpClient.Open(fullFilePath)
+	var readSidecarManifest func() (*zip.ManifestFile, error)
+	if file.manifestName != "" {
+		readSidecarManifest = func() (*zip.ManifestFile, error) {
+			manifestBytes, err := receiver.readFile(file.directory + "/" + file.manifestName)
+			if err != nil {
+				return nil, err
+			}
+			return &zip.ManifestFile{Name: file.manifestName, Contents: manifestBytes}, nil
+		}
+	}
 
+	err = receiver.routeFile(fileInfo.Name(), file.relativeDirectory, fileBytes, readSidecarManifest)
 	if err != nil {
-		slog.Error("Failed to open file", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
+		// Leave the file (and its manifest) on the SFTP server to try again next time
 		return
 	}
 
-	slog.Info("file opened", slog.String(utils.FileNameKey, fullFilePath))
+	receiver.finishCopiedFile(file)
+}
 
-	fileBytes, err := io.ReadAll(fileReadCloser)
+// routeFile puts a partner's file in our blob storage. Archives (zip, gzip, or tar) go to an `unzip` folder and then
+// we call the zipHandler.Unzip, along with the archive's manifest from readSidecarManifest when it isn't nil. Other
+// files go to `import` to begin processing, once they match their manifest, see manifestRejection. PGP-encrypted files are decrypted first, for partners who turn that on. It
+// returns nil once we're done with the file, including when it went to a `failure` folder, and an error when the
+// partner's copy should be kept so we can try again
+func (receiver *SftpHandler) routeFile(fileName string, relativeDirectory string, fileBytes []byte, readSidecarManifest func() (*zip.ManifestFile, error)) error {
+	if !receiver.partnerSettings.RemoteFiles.KeepDirectoryStructure {
+		relativeDirectory = ""
+	}
+	return receiver.importFile(fileName, relativeDirectory, fileBytes, readSidecarManifest)
+}
+
+// ImportUpload routes a file the partner uploaded over HTTPS, see routeFile. The file goes in a folder named after the
+// upload's tracking ID in each of the partner's folders, so uploads with the same name can't overwrite each other and
+// we can find the file again when the partner asks how it's doing
+func (receiver *SftpHandler) ImportUpload(trackingId string, fileName string, fileBytes []byte) error {
+	return receiver.importFile(fileName, trackingId, fileBytes, nil)
+}
+
+// importFile does the work for routeFile, with blobDirectory under each of the partner's folders
+func (receiver *SftpHandler) importFile(fileName string, blobDirectory string, fileBytes []byte, readSidecarManifest func() (*zip.ManifestFile, error)) error {
+	// Encrypted files are decrypted before we decide where they go, so e.g. `results.zip.pgp` is unzipped
+	if receiver.isPgpEncrypted(fileName, fileBytes) {
+		decryptedBytes, decryptedName, err := receiver.decryptFile(fileName, fileBytes)
+		var rejection pgpRejection
+		if errors.As(err, &rejection) {
+			return receiver.rejectFile(fileName, blobDirectory, fileBytes, rejection.reason)
+		}
+		if err != nil {
+			return err
+		}
+		fileBytes, fileName = decryptedBytes, decryptedName
+	}
+
+	// We look at the file's contents rather than its name, so e.g. `results.zip.bak` isn't treated as a zip
+	isArchive := zip.DetectArchiveFormat(fileBytes) != ""
+
+	var sidecarManifest *zip.ManifestFile
+	if readSidecarManifest != nil {
+		var err error
+		sidecarManifest, err = readSidecarManifest()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Unzip checks archives against their manifests, since it needs their files
+	if !isArchive {
+		reason := receiver.manifestRejection(fileName, fileBytes, sidecarManifest)
+		if reason != "" {
+			slog.Error("File doesn't match its manifest, so not importing it", slog.String(utils.FileNameKey, fileName), slog.String("reason", reason))
+			return receiver.rejectUnverifiedFile(fileName, blobDirectory, fileBytes, sidecarManifest, reason)
+		}
+	}
+
+	// Upload the retrieved file to either the `unzip` or `import` folder
+	// Files go in the partner's folder so later steps can apply the partner's settings
+	blobFolder := utils.MessageStartingFolderPath
+	if isArchive {
+		blobFolder = utils.UnzipFolder
+	}
+	blobPath := filepath.Join(receiver.partnerId, blobFolder, blobDirectory, fileName)
+	// Keep the partner's copy unless we're sure ours is identical
+	err := receiver.uploadVerified(fileBytes, blobPath)
 	if err != nil {
-		slog.Error("Failed to read file", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
-		return
+		return err
 	}
 
-	err = fileReadCloser.Close()
+	slog.Info("About to consider whether this is an archive", slog.String(utils.FileNameKey, fileName), slog.Bool("isArchive", isArchive))
+
+	if !isArchive {
+		return nil
+	}
+
+	// Unzip reads the bytes we already have in memory, so the archive is never written to the container's disk
+	err = receiver.zipHandler.Unzip(bytes.NewReader(fileBytes), int64(len(fileBytes)), blobPath, sidecarManifest)
 	if err != nil {
-		slog.Error("Failed to close file after reading", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
-		return
+		slog.Error("Failed to unzip file", slog.Any(utils.ErrorKey, err))
+		return err
 	}
 
-	var blobPath string
-	// Upload the retrieved file to either the `unzip` or `import` folder
-	if strings.Contains(fileInfo.Name(), ".zip") {
-		blobPath = filepath.Join(utils.UnzipFolder, fileInfo.Name())
-	} else {
-		blobPath = filepath.Join(utils.MessageStartingFolderPath, fileInfo.Name())
+	return nil
+}
+
+// rejectFile uploads a file we won't import, e.g. because we couldn't decrypt it, to the `failure` folder, with a `.txt`
+// file giving the reason next to it
+func (receiver *SftpHandler) rejectFile(fileName string, relativeDirectory string, fileBytes []byte, reason string) error {
+	blobPath := filepath.Join(receiver.partnerId, utils.FailureFolder, relativeDirectory, fileName)
+
+	err := receiver.blobHandler.UploadFile(fileBytes, blobPath)
+	if err != nil {
+		slog.Error("Failed to upload rejected file", slog.Any(utils.ErrorKey, err), slog.String("blobPath", blobPath))
+		return err
 	}
-	err = receiver.blobHandler.UploadFile(fileBytes, blobPath)
+
+	err = receiver.blobHandler.UploadFile([]byte(fileName+": "+reason+"\n"), blobPath+".txt")
 	if err != nil {
-		slog.Error("Failed to upload file", slog.Any(utils.ErrorKey, err))
-		return
+		slog.Error("Failed to upload failure file", slog.Any(utils.ErrorKey, err), slog.String("blobPath", blobPath+".txt"))
+		return err
 	}
 
-	slog.Info("About to consider whether this is a zip", slog.String(utils.FileNameKey, fileInfo.Name()))
+	return nil
+}
 
-	deleteZip := false
-	isZip := strings.Contains(fileInfo.Name(), ".zip")
-	if isZip {
-		// write file to local filesystem
-		zipFileName := fileInfo.Name()
-		err = os.WriteFile(zipFileName, fileBytes, 0644) // permissions = owner read/write, group read, other read
-		if err != nil {
-			slog.Error("Failed to write file", slog.Any(utils.ErrorKey, err), slog.String("name", fileInfo.Name()))
-			return
+// manifestRejection says why a file that isn't an archive doesn't match its manifest from next to it on the SFTP
+// server, or is empty when it does. A file without a manifest is only rejected when the partner requires one
+func (receiver *SftpHandler) manifestRejection(fileName string, fileBytes []byte, sidecarManifest *zip.ManifestFile) string {
+	if sidecarManifest == nil {
+		if receiver.partnerSettings.RequireManifest {
+			return "partner " + receiver.partnerId + " requires a manifest, but there isn't one next to the file"
 		}
+		return ""
+	}
 
-		err = receiver.zipHandler.Unzip(zipFileName, blobPath)
-		if err != nil {
-			slog.Error("Failed to unzip file", slog.Any(utils.ErrorKey, err))
-		} else {
-			deleteZip = true
-		}
+	var reasons []string
+	for _, fileError := range zip.VerifyFile(fileName, fileBytes, *sidecarManifest) {
+		reasons = append(reasons, fileError.Filename+": "+fileError.ErrorMessage)
+	}
+	return strings.Join(reasons, "; ")
+}
 
-		//delete file from local filesystem
-		err = os.Remove(zipFileName)
-		if err != nil {
-			slog.Error("Failed to remove file from local server", slog.Any(utils.ErrorKey, err), slog.String("name", fileInfo.Name()))
-		}
+// rejectUnverifiedFile is rejectFile for a file that doesn't match its manifest, which goes in the `failure` folder
+// with it so we can see what the partner sent
+func (receiver *SftpHandler) rejectUnverifiedFile(fileName string, relativeDirectory string, fileBytes []byte, sidecarManifest *zip.ManifestFile, reason string) error {
+	err := receiver.rejectFile(fileName, relativeDirectory, fileBytes, reason)
+	if err != nil || sidecarManifest == nil {
+		return err
 	}
 
-	if !isZip || deleteZip {
-		err = receiver.sftpClient.Remove(fullFilePath)
-		if err != nil {
-			slog.Error("Failed to remove file from SFTP server", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
-			return
+	manifestPath := filepath.Join(receiver.partnerId, utils.FailureFolder, relativeDirectory, sidecarManifest.Name)
+	err = receiver.blobHandler.UploadFile(sidecarManifest.Contents, manifestPath)
+	if err != nil {
+		slog.Error("Failed to upload manifest", slog.Any(utils.ErrorKey, err), slog.String("manifestPath", manifestPath))
+		return err
+	}
+
+	return nil
+}
+
+// finishCopiedFile applies the partner's post-copy action to a file we're done with. It also applies to the file's
+// manifest and the file's done marker, so they aren't left behind without it
+func (receiver *SftpHandler) finishCopiedFile(file remoteFile) {
+	copiedAt := time.Now()
+	err := receiver.finishRemoteFile(file, file.fileInfo.Name(), copiedAt)
+	if err != nil {
+		return
+	}
+
+	for _, companionName := range []string{file.manifestName, file.doneMarkerName} {
+		if companionName != "" {
+			_ = receiver.finishRemoteFile(file, companionName, copiedAt)
 		}
-		slog.Info("Successfully copied file and removed from SFTP server", slog.Any(utils.FileNameKey, fullFilePath))
 	}
 }
+
+func (receiver *SftpHandler) readFile(fullFilePath string) ([]byte, error) {
+	fileReadCloser, err := receiver.sftpClient.Open(fullFilePath)
+	if err != nil {
+		slog.Error("Failed to open file", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
+		return nil, err
+	}
+
+	slog.Info("file opened", slog.String(utils.FileNameKey, fullFilePath))
+
+	fileBytes, err := io.ReadAll(fileReadCloser)
+	if err != nil {
+		slog.Error("Failed to read file", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
+		_ = fileReadCloser.Close()
+		return nil, err
+	}
+
+	err = fileReadCloser.Close()
+	if err != nil {
+		slog.Error("Failed to close file after reading", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
+		return nil, err
+	}
+
+	return fileBytes, nil
+}
@@ -2,7 +2,10 @@ package sftp
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
 	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
 	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"github.com/CDCgov/reportstream-sftp-ingestion/zip"
@@ -14,6 +17,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -31,7 +35,7 @@ func Test_NewSFTPHandler_UnableToGetUserCredentialPrivateKey_ReturnsError(t *tes
 	mockCredentialGetter := new(mocks.MockCredentialGetter)
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return("", errors.New("error"))
 
-	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId)
+	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId, nil)
 
 	assert.Nil(t, sftpHandler)
 	assert.Error(t, err)
@@ -46,7 +50,7 @@ func Test_NewSFTPHandler_UnableToGetSFTPServerPublicKeyNameSecret_ReturnsError(t
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return(secretValue, nil).Once()
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return("", errors.New("error"))
 
-	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId)
+	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId, nil)
 
 	assert.Nil(t, sftpHandler)
 	assert.Error(t, err)
@@ -61,7 +65,7 @@ func Test_NewSFTPHandler_UnableToGetSSHClientHostKeyCallback_ReturnsError(t *tes
 
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return(secretValue, nil)
 
-	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId)
+	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId, nil)
 
 	assert.Nil(t, sftpHandler)
 	assert.Error(t, err)
@@ -79,7 +83,7 @@ func Test_NewSFTPHandler_UnableToGetSFTPUserNameSecret_ReturnsError(t *testing.T
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return(serverKey, nil).Once()
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return("", errors.New("error"))
 
-	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId)
+	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId, nil)
 
 	assert.Nil(t, sftpHandler)
 	assert.Error(t, err)
@@ -97,7 +101,7 @@ func Test_NewSFTPHandler_UnableToGetSFTPServerAddressName_ReturnsError(t *testin
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return(user, nil).Once()
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return("", errors.New("error"))
 
-	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId)
+	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId, nil)
 
 	assert.Nil(t, sftpHandler)
 	assert.Error(t, err)
@@ -115,7 +119,7 @@ func Test_NewSFTPHandler_UnableToDialIntoTCP_ReturnsError(t *testing.T) {
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return(user, nil).Once()
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return("wrong-value", nil).Once()
 
-	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId)
+	sftpHandler, err := NewSftpHandler(mockCredentialGetter, partnerId, nil)
 
 	assert.Nil(t, sftpHandler)
 	assert.Error(t, err)
@@ -124,14 +128,14 @@ func Test_NewSFTPHandler_UnableToDialIntoTCP_ReturnsError(t *testing.T) {
 
 func Test_getSshClientHostKeyCallback_ReturnsFixedHostKeyCallback(t *testing.T) {
 	serverKey := serverKey
-	actualParsedKeyCallback, err := getSshClientHostKeyCallback(serverKey)
+	actualParsedKeyCallback, _, err := getSshClientHostKeyCallback(serverKey)
 
 	assert.NotNil(t, actualParsedKeyCallback)
 	assert.NoError(t, err)
 }
 
 func Test_getSshClientHostKeyCallback_UnableToParseServerKey_ReturnsError(t *testing.T) {
-	actualParsedKeyCallback, err := getSshClientHostKeyCallback(invalidServerKey)
+	actualParsedKeyCallback, _, err := getSshClientHostKeyCallback(invalidServerKey)
 
 	assert.Nil(t, actualParsedKeyCallback)
 	assert.Error(t, err)
@@ -183,16 +187,20 @@ func Test_CopyFiles_SuccessfullyCopiesFiles(t *testing.T) {
 	mockSftpClient := new(MockSftpWrapper)
 	mockSftpClient.On("ReadDir", mock.Anything).Return(files, nil)
 	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
 	mockSftpClient.On("Remove", mock.Anything).Return(nil)
 
 	mockBlobHandler := &mocks.MockBlobHandler{}
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
 
 	mockCredentialGetter := new(mocks.MockCredentialGetter)
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return("dogcow", nil)
 
 	mockZipHandler := &MockZipHandler{}
-	mockZipHandler.On("Unzip", mock.Anything, mock.Anything).Return(nil)
+	mockZipHandler.On("Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, credentialGetter: mockCredentialGetter, zipHandler: mockZipHandler}
 
@@ -202,6 +210,135 @@ func Test_CopyFiles_SuccessfullyCopiesFiles(t *testing.T) {
 	assert.NotContains(t, buffer.String(), "Failed to read directory ")
 }
 
+func Test_CopyFiles_ArchiveHasSidecarManifest_PassesManifestToUnzipAndRemovesBoth(t *testing.T) {
+	fileDirectory := t.TempDir()
+	zipBytes, _ := os.ReadFile(filepath.Join("..", "..", "mock_data", "copy_file_test.txt.zip"))
+	manifestBytes := []byte("0123456789abcdef0123456789abcdef  results.zip\n")
+	assert.NoError(t, os.WriteFile(filepath.Join(fileDirectory, "results.zip"), zipBytes, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(fileDirectory, "results.zip.md5"), manifestBytes, 0600))
+	zipInfo, _ := os.Stat(filepath.Join(fileDirectory, "results.zip"))
+	manifestInfo, _ := os.Stat(filepath.Join(fileDirectory, "results.zip.md5"))
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", mock.Anything).Return([]os.FileInfo{manifestInfo, zipInfo}, nil)
+	mockSftpClient.On("Open", "dogcow/results.zip").Return(io.NopCloser(bytes.NewReader(zipBytes)), nil)
+	mockSftpClient.On("Open", "dogcow/results.zip.md5").Return(io.NopCloser(bytes.NewReader(manifestBytes)), nil)
+	mockSftpClient.On("Stat", "dogcow/results.zip").Return(zipInfo, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(zipBytes, nil)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", mock.Anything).Return("dogcow", nil)
+
+	mockZipHandler := &MockZipHandler{}
+	mockZipHandler.On("Unzip", mock.Anything, mock.Anything, mock.Anything, &zip.ManifestFile{Name: "results.zip.md5", Contents: manifestBytes}).Return(nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, credentialGetter: mockCredentialGetter, zipHandler: mockZipHandler}
+
+	sftpHandler.CopyFiles()
+
+	mockZipHandler.AssertNumberOfCalls(t, "Unzip", 1)
+	mockZipHandler.AssertExpectations(t)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockSftpClient.AssertCalled(t, "Remove", "dogcow/results.zip")
+	mockSftpClient.AssertCalled(t, "Remove", "dogcow/results.zip.md5")
+}
+
+func Test_CopyFiles_ManifestHasNoArchive_LeavesManifest(t *testing.T) {
+	fileDirectory := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(fileDirectory, "results.zip.sha256"), []byte("hash"), 0600))
+	manifestInfo, _ := os.Stat(filepath.Join(fileDirectory, "results.zip.sha256"))
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", mock.Anything).Return([]os.FileInfo{manifestInfo}, nil)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", mock.Anything).Return("dogcow", nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: &mocks.MockBlobHandler{}, credentialGetter: mockCredentialGetter, zipHandler: &MockZipHandler{}}
+
+	sftpHandler.CopyFiles()
+
+	mockSftpClient.AssertNotCalled(t, "Open", mock.Anything)
+	mockSftpClient.AssertNotCalled(t, "Remove", mock.Anything)
+}
+
+func Test_copySingleFile_FileMatchesSidecarManifest_ImportsFile(t *testing.T) {
+	fileBytes := []byte("MSH|order")
+	sum := sha256.Sum256(fileBytes)
+	manifestBytes := []byte(hex.EncodeToString(sum[:]) + "  order.hl7\n")
+	mockSftpClient, mockBlobHandler, file := mocksForSidecarManifestTest(t, fileBytes, "order.hl7.sha256", manifestBytes)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: &MockZipHandler{}, partnerId: partnerId}
+	sftpHandler.copySingleFile(file, 1)
+
+	assertImported(t, mockBlobHandler, fileBytes, filepath.Join(partnerId, utils.MessageStartingFolderPath, "order.hl7"))
+	mockSftpClient.AssertCalled(t, "Remove", file.fullPath())
+	mockSftpClient.AssertCalled(t, "Remove", file.directory+"/order.hl7.sha256")
+}
+
+func Test_copySingleFile_FileDoesNotMatchSidecarManifest_MovesFileAndManifestToFailure(t *testing.T) {
+	fileBytes := []byte("MSH|order")
+	manifestBytes := []byte("0123456789abcdef0123456789abcdef  order.hl7\n")
+	mockSftpClient, mockBlobHandler, file := mocksForSidecarManifestTest(t, fileBytes, "order.hl7.md5", manifestBytes)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: &MockZipHandler{}, partnerId: partnerId}
+	sftpHandler.copySingleFile(file, 1)
+
+	failurePath := filepath.Join(partnerId, utils.FailureFolder, "order.hl7")
+	mockBlobHandler.AssertCalled(t, "UploadFile", fileBytes, failurePath)
+	mockBlobHandler.AssertCalled(t, "UploadFile", manifestBytes, filepath.Join(partnerId, utils.FailureFolder, "order.hl7.md5"))
+	mockBlobHandler.AssertCalled(t, "UploadFile", mock.MatchedBy(func(reason []byte) bool {
+		return strings.Contains(string(reason), "but order.hl7.md5 says 0123456789abcdef0123456789abcdef")
+	}), failurePath+".txt")
+	mockBlobHandler.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+	mockSftpClient.AssertCalled(t, "Remove", file.fullPath())
+}
+
+func Test_copySingleFile_PartnerRequiresManifestAndThereIsNone_MovesFileToFailure(t *testing.T) {
+	fileBytes := []byte("MSH|order")
+	mockSftpClient, mockBlobHandler, file := mocksForSidecarManifestTest(t, fileBytes, "", nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: &MockZipHandler{}, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{RequireManifest: true}}
+	sftpHandler.copySingleFile(file, 1)
+
+	failurePath := filepath.Join(partnerId, utils.FailureFolder, "order.hl7")
+	mockBlobHandler.AssertCalled(t, "UploadFile", fileBytes, failurePath)
+	mockBlobHandler.AssertCalled(t, "UploadFile", []byte("order.hl7: partner "+partnerId+" requires a manifest, but there isn't one next to the file\n"), failurePath+".txt")
+	mockBlobHandler.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+}
+
+// mocksForSidecarManifestTest sets up `order.hl7` with fileBytes on the SFTP server, and a manifest next to it unless
+// manifestName is empty
+func mocksForSidecarManifestTest(t *testing.T, fileBytes []byte, manifestName string, manifestBytes []byte) (*MockSftpWrapper, *mocks.MockBlobHandler, remoteFile) {
+	fileDirectory := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(fileDirectory, "order.hl7"), fileBytes, 0600))
+	fileInfo, _ := os.Stat(filepath.Join(fileDirectory, "order.hl7"))
+	file := remoteFile{fileInfo: fileInfo, directory: fileDirectory, manifestName: manifestName}
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", file.fullPath()).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	if manifestName != "" {
+		mockSftpClient.On("Open", fileDirectory+"/"+manifestName).Return(io.NopCloser(bytes.NewReader(manifestBytes)), nil)
+	}
+	mockSftpClient.On("Stat", file.fullPath()).Return(fileInfo, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
+
+	return mockSftpClient, mockBlobHandler, file
+}
+
 func Test_Close_FailsToCloseSFTPClient(t *testing.T) {
 	mockBlobHandler := &mocks.MockBlobHandler{}
 	mockSftpClient := new(MockSftpWrapper)
@@ -268,16 +405,20 @@ func Test_copySingleFile_CopiesFile(t *testing.T) {
 
 	mockSftpClient := new(MockSftpWrapper)
 	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
 	mockSftpClient.On("Remove", mock.Anything).Return(nil)
 
 	mockBlobHandler := &mocks.MockBlobHandler{}
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
 
 	mockZipHandler := &MockZipHandler{}
-	mockZipHandler.On("Unzip", mock.Anything, mock.Anything).Return(nil)
+	mockZipHandler.On("Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: mockZipHandler}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockBlobHandler.AssertCalled(t, "UploadFile", mock.Anything, mock.Anything)
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
@@ -289,6 +430,29 @@ func Test_copySingleFile_CopiesFile(t *testing.T) {
 	assert.Contains(t, buffer.String(), "Successfully copied file and removed from SFTP server")
 }
 
+func Test_copySingleFile_UploadsToPartnerFolder(t *testing.T) {
+	fileDirectory := filepath.Join("..", "..", "mock_data")
+	filePath := filepath.Join(fileDirectory, "copy_file_test.txt")
+	fileInfo, _ := os.Stat(filePath)
+	fileBytes, _ := os.ReadFile(filePath)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId}
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
+
+	assertImported(t, mockBlobHandler, fileBytes, filepath.Join(partnerId, utils.MessageStartingFolderPath, "copy_file_test.txt"))
+}
+
 func Test_copySingleFile_SkipsDirectory_LogsError(t *testing.T) {
 	buffer, defaultLogger := utils.SetupLogger()
 	defer slog.SetDefault(defaultLogger)
@@ -300,7 +464,7 @@ func Test_copySingleFile_SkipsDirectory_LogsError(t *testing.T) {
 	fileInfo, _ := os.Stat(fileDirectory)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	assert.Contains(t, buffer.String(), "Skipping directory")
 }
@@ -317,7 +481,7 @@ func Test_copySingleFile_FailsToOpenFile_LogsError(t *testing.T) {
 	fileInfo, _ := os.Stat(filePath)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
 	assert.Contains(t, buffer.String(), "Failed to open file")
@@ -335,7 +499,7 @@ func Test_copySingleFile_FailsToReadFile_LogsError(t *testing.T) {
 	fileInfo, _ := os.Stat(filePath)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
 	assert.Contains(t, buffer.String(), "Failed to read file")
@@ -353,7 +517,7 @@ func Test_copySingleFile_FailsToCloseFile_LogsError(t *testing.T) {
 	fileInfo, _ := os.Stat(filePath)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
 	assert.Contains(t, buffer.String(), "Failed to close file after reading")
@@ -370,26 +534,58 @@ func Test_copySingleFile_FileIsNotZipFile_DoesNotUnzipFile(t *testing.T) {
 
 	mockSftpClient := new(MockSftpWrapper)
 	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
 	mockSftpClient.On("Remove", mock.Anything).Return(nil)
 
 	mockBlobHandler := &mocks.MockBlobHandler{}
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
 
 	mockZipHandler := &MockZipHandler{}
-	mockZipHandler.On("Unzip", mock.Anything).Return(nil)
+	mockZipHandler.On("Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: mockZipHandler}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockBlobHandler.AssertCalled(t, "UploadFile", mock.Anything, mock.Anything)
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
-	mockZipHandler.AssertNotCalled(t, "Unzip", mock.Anything)
+	mockZipHandler.AssertNotCalled(t, "Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 	mockSftpClient.AssertCalled(t, "Remove", mock.Anything)
 	assert.Contains(t, buffer.String(), "Considering file")
 	assert.NotContains(t, buffer.String(), "Skipping directory")
 	assert.Contains(t, buffer.String(), "Successfully copied file and removed from SFTP server")
 }
 
+func Test_copySingleFile_NameContainsZipButFileIsNotArchive_UploadsToImport(t *testing.T) {
+	fileDirectory := t.TempDir()
+	filePath := filepath.Join(fileDirectory, "order_message.zip.bak")
+	err := os.WriteFile(filePath, []byte("MSH|^~\\&|order"), 0600)
+	assert.NoError(t, err)
+	fileInfo, _ := os.Stat(filePath)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader([]byte("MSH|^~\\&|order"))), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|^~\\&|order"), nil)
+
+	mockZipHandler := &MockZipHandler{}
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: mockZipHandler, partnerId: partnerId}
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
+
+	assertImported(t, mockBlobHandler, mock.Anything, filepath.Join(partnerId, utils.MessageStartingFolderPath, "order_message.zip.bak"))
+	mockZipHandler.AssertNotCalled(t, "Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockSftpClient.AssertCalled(t, "Remove", mock.Anything)
+}
+
 func Test_copySingleFile_FailsToUploadFile_LogsError(t *testing.T) {
 	buffer, defaultLogger := utils.SetupLogger()
 	defer slog.SetDefault(defaultLogger)
@@ -401,15 +597,18 @@ func Test_copySingleFile_FailsToUploadFile_LogsError(t *testing.T) {
 
 	mockSftpClient := new(MockSftpWrapper)
 	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
 
 	mockBlobHandler := &mocks.MockBlobHandler{}
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(errors.New(utils.ErrorKey))
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
 
 	mockZipHandler := &MockZipHandler{}
-	mockZipHandler.On("Unzip", mock.Anything).Return(nil)
+	mockZipHandler.On("Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: mockZipHandler}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockBlobHandler.AssertCalled(t, "UploadFile", mock.Anything, mock.Anything)
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
@@ -427,16 +626,20 @@ func Test_copySingleFile_FailsToUnzipFile_LogsError(t *testing.T) {
 
 	mockSftpClient := new(MockSftpWrapper)
 	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
 	mockSftpClient.On("Remove", mock.Anything).Return(nil)
 
 	mockZipHandler := &MockZipHandler{}
-	mockZipHandler.On("Unzip", mock.Anything, mock.Anything).Return(errors.New("fails to unzip file"))
+	mockZipHandler.On("Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("fails to unzip file"))
 
 	mockBlobHandler := &mocks.MockBlobHandler{}
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: mockZipHandler}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockBlobHandler.AssertCalled(t, "UploadFile", mock.Anything, mock.Anything)
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
@@ -456,16 +659,20 @@ func Test_copySingleFile_FailsToDeleteFileFromSFTPServer_LogsErrorAndReturn(t *t
 
 	mockSftpClient := new(MockSftpWrapper)
 	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
 	mockSftpClient.On("Remove", mock.Anything).Return(errors.New("failed to remove file from sftp server"))
 
 	mockZipHandler := &MockZipHandler{}
-	mockZipHandler.On("Unzip", mock.Anything, mock.Anything).Return(nil)
+	mockZipHandler.On("Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	mockBlobHandler := &mocks.MockBlobHandler{}
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: mockZipHandler}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockBlobHandler.AssertCalled(t, "UploadFile", mock.Anything, mock.Anything)
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
@@ -485,16 +692,20 @@ func Test_copySingleFile_DeletesFileFromSFTPServer(t *testing.T) {
 
 	mockSftpClient := new(MockSftpWrapper)
 	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
 	mockSftpClient.On("Remove", mock.Anything).Return(nil)
 
 	mockZipHandler := &MockZipHandler{}
-	mockZipHandler.On("Unzip", mock.Anything, mock.Anything).Return(nil)
+	mockZipHandler.On("Unzip", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	mockBlobHandler := &mocks.MockBlobHandler{}
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
 
 	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: mockZipHandler}
-	sftpHandler.copySingleFile(fileInfo, 1, fileDirectory)
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
 
 	mockBlobHandler.AssertCalled(t, "UploadFile", mock.Anything, mock.Anything)
 	mockSftpClient.AssertCalled(t, "Open", mock.Anything)
@@ -503,6 +714,63 @@ func Test_copySingleFile_DeletesFileFromSFTPServer(t *testing.T) {
 	assert.Contains(t, buffer.String(), "Successfully copied file and removed from SFTP server")
 }
 
+func Test_copySingleFile_FileIsArchive_UnzipsFromMemoryWithoutWritingToDisk(t *testing.T) {
+	fileDirectory := filepath.Join("..", "..", "mock_data")
+	filePath := filepath.Join(fileDirectory, "copy_file_test.txt.zip")
+	fileInfo, _ := os.Stat(filePath)
+	fileBytes, _ := os.ReadFile(filePath)
+
+	workingDirectory, _ := os.Getwd()
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(workingDirectory)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", mock.Anything).Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", mock.Anything).Return(fileInfo, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockZipHandler := &MockZipHandler{}
+	mockZipHandler.On("Unzip", mock.Anything, int64(len(fileBytes)), filepath.Join(partnerId, utils.UnzipFolder, fileInfo.Name()), (*zip.ManifestFile)(nil)).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, zipHandler: mockZipHandler, partnerId: partnerId}
+	sftpHandler.copySingleFile(remoteFile{fileInfo: fileInfo, directory: fileDirectory}, 1)
+
+	mockZipHandler.AssertExpectations(t)
+	entries, err := os.ReadDir(".")
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_ImportUpload_UploadsToTrackingIdFolder(t *testing.T) {
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|order"), nil)
+
+	sftpHandler := SftpHandler{blobHandler: mockBlobHandler, partnerId: partnerId}
+	err := sftpHandler.ImportUpload("a-tracking-id", "order.hl7", []byte("MSH|order"))
+
+	assert.NoError(t, err)
+	assertImported(t, mockBlobHandler, []byte("MSH|order"), "flexion/import/a-tracking-id/order.hl7")
+}
+
+// assertImported checks fileBytes went through staging to blobPath, see uploadVerified
+func assertImported(t *testing.T, mockBlobHandler *mocks.MockBlobHandler, fileBytes any, blobPath string) {
+	mockBlobHandler.AssertCalled(t, "UploadFile", fileBytes, mock.MatchedBy(isStagingPath))
+	mockBlobHandler.AssertCalled(t, "CopyFile", mock.MatchedBy(isStagingPath), blobPath)
+}
+
+func isStagingPath(blobPath string) bool {
+	return strings.Contains(blobPath, utils.StagingFolder+"/")
+}
+
 // Mocks for test
 
 type MockSftpWrapper struct {
@@ -529,16 +797,36 @@ func (receiver *MockSftpWrapper) Remove(path string) error {
 	return args.Error(0)
 }
 
+func (receiver *MockSftpWrapper) Rename(oldPath string, newPath string) error {
+	args := receiver.Called(oldPath, newPath)
+	return args.Error(0)
+}
+
+func (receiver *MockSftpWrapper) Create(path string) (io.WriteCloser, error) {
+	args := receiver.Called(path)
+	return args.Get(0).(io.WriteCloser), args.Error(1)
+}
+
+func (receiver *MockSftpWrapper) Stat(path string) (os.FileInfo, error) {
+	args := receiver.Called(path)
+	return args.Get(0).(os.FileInfo), args.Error(1)
+}
+
+func (receiver *MockSftpWrapper) OpenAt(path string, offset int64) (io.ReadCloser, error) {
+	args := receiver.Called(path, offset)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
 type MockZipHandler struct {
 	mock.Mock
 }
 
-func (receiver *MockZipHandler) Unzip(zipFilePath string, blobPath string) error {
-	args := receiver.Called(zipFilePath, blobPath)
+func (receiver *MockZipHandler) Unzip(archive io.ReaderAt, size int64, blobPath string, sidecarManifest *zip.ManifestFile) error {
+	args := receiver.Called(archive, size, blobPath, sidecarManifest)
 	return args.Error(0)
 }
 
-func (receiver *MockZipHandler) ExtractAndUploadSingleFile(f *yekazip.File, zipPassword string, zipFile string, errorList []zip.FileError) []zip.FileError {
+func (receiver *MockZipHandler) ExtractAndUploadSingleFile(f *yekazip.File, zipPassword string, zipFile string, budget *zip.ExtractionBudget, errorList []zip.FileError) []zip.FileError {
 	args := receiver.Called(f, zipPassword, errorList)
 	return args.Get(0).([]zip.FileError)
 }
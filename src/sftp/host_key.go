@@ -0,0 +1,163 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"golang.org/x/crypto/ssh"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+)
+
+const knownHostsCertAuthorityMarker = "cert-authority"
+const knownHostsRevokedMarker = "revoked"
+
+// hostKeys are the keys from a partner's host key secret
+type hostKeys struct {
+	keys        []ssh.PublicKey
+	authorities []ssh.PublicKey
+	revoked     []ssh.PublicKey
+}
+
+// getSshClientHostKeyCallback accepts any of the keys in serverKeys, which has one key per line. Lines are either
+// authorized keys (`ssh-ed25519 AAAA...`) or known_hosts entries, including `@cert-authority` for servers that
+// present a certificate and `@revoked`. Host names in known_hosts entries are ignored because each partner has their
+// own secret. Listing the old and new keys lets a partner rotate their host key without breaking polling. The host key
+// algorithms for those keys are returned too, so the server can't negotiate an algorithm we have no key for
+func getSshClientHostKeyCallback(serverKeys string) (ssh.HostKeyCallback, []string, error) {
+	parsedKeys, err := parseHostKeys(serverKeys)
+	if err != nil {
+		slog.Error("Failed to parse host keys", slog.Any(utils.ErrorKey, err))
+		return nil, nil, err
+	}
+
+	certChecker := &ssh.CertChecker{
+		IsHostAuthority: func(authority ssh.PublicKey, address string) bool {
+			return containsKey(parsedKeys.authorities, authority)
+		},
+		IsRevoked: func(certificate *ssh.Certificate) bool {
+			return containsKey(parsedKeys.revoked, certificate.SignatureKey) || containsKey(parsedKeys.revoked, certificate.Key)
+		},
+		HostKeyFallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if containsKey(parsedKeys.revoked, key) {
+				return errors.New("ssh: host key is revoked")
+			}
+			if !containsKey(parsedKeys.keys, key) {
+				return errors.New("ssh: host key mismatch")
+			}
+			return nil
+		},
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := certChecker.CheckHostKey(hostname, remote, key)
+		if err != nil {
+			attributes := []any{
+				slog.Any(utils.ErrorKey, err),
+				slog.String("hostname", hostname),
+				slog.String("offeredFingerprint", ssh.FingerprintSHA256(key)),
+				slog.Any("expectedFingerprints", fingerprints(parsedKeys.keys)),
+				slog.Any("expectedAuthorityFingerprints", fingerprints(parsedKeys.authorities)),
+			}
+			if certificate, isCertificate := key.(*ssh.Certificate); isCertificate {
+				attributes = append(attributes, slog.String("offeredAuthorityFingerprint", ssh.FingerprintSHA256(certificate.SignatureKey)))
+			}
+			slog.Error("SFTP server's host key isn't one we expect", attributes...)
+		}
+		return err
+	}, parsedKeys.algorithms(), nil
+}
+
+// algorithms are the host key algorithms that the server can use with one of our keys. An RSA key can sign with any
+// of the RSA algorithms. A certificate's key doesn't have to be the same type as its authority's, so trusting an
+// authority allows every certificate algorithm
+func (receiver hostKeys) algorithms() []string {
+	var keyAlgorithms []string
+	for _, key := range receiver.keys {
+		keyAlgorithms = appendMissing(keyAlgorithms, algorithmsForKeyType(key.Type())...)
+	}
+	if len(receiver.authorities) > 0 {
+		keyAlgorithms = appendMissing(keyAlgorithms,
+			ssh.CertAlgoED25519v01,
+			ssh.CertAlgoECDSA256v01,
+			ssh.CertAlgoECDSA384v01,
+			ssh.CertAlgoECDSA521v01,
+			ssh.CertAlgoRSASHA512v01,
+			ssh.CertAlgoRSASHA256v01,
+			ssh.CertAlgoRSAv01,
+		)
+	}
+	return keyAlgorithms
+}
+
+func algorithmsForKeyType(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+func appendMissing(values []string, additions ...string) []string {
+	for _, addition := range additions {
+		if !slices.Contains(values, addition) {
+			values = append(values, addition)
+		}
+	}
+	return values
+}
+
+func parseHostKeys(serverKeys string) (hostKeys, error) {
+	var parsedKeys hostKeys
+	for _, line := range strings.Split(serverKeys, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Markers only appear in known_hosts entries, and ParseAuthorizedKey would take them for key options
+		if !strings.HasPrefix(line, "@") {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err == nil {
+				parsedKeys.keys = append(parsedKeys.keys, key)
+				continue
+			}
+		}
+
+		marker, _, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err != nil {
+			return hostKeys{}, err
+		}
+		switch marker {
+		case knownHostsCertAuthorityMarker:
+			parsedKeys.authorities = append(parsedKeys.authorities, key)
+		case knownHostsRevokedMarker:
+			parsedKeys.revoked = append(parsedKeys.revoked, key)
+		default:
+			parsedKeys.keys = append(parsedKeys.keys, key)
+		}
+	}
+
+	if len(parsedKeys.keys) == 0 && len(parsedKeys.authorities) == 0 {
+		return hostKeys{}, errors.New("no host keys found")
+	}
+	return parsedKeys, nil
+}
+
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, candidate := range keys {
+		if bytes.Equal(candidate.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+func fingerprints(keys []ssh.PublicKey) []string {
+	var keyFingerprints []string
+	for _, key := range keys {
+		keyFingerprints = append(keyFingerprints, ssh.FingerprintSHA256(key))
+	}
+	return keyFingerprints
+}
@@ -0,0 +1,132 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"log/slog"
+	"net"
+	"testing"
+)
+
+const sftpHostname = "sftp.example.com:22"
+
+var remoteAddress = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+
+func Test_getSshClientHostKeyCallback_SecretHasOldAndNewKeys_AcceptsBoth(t *testing.T) {
+	oldKey := newHostSigner(t).PublicKey()
+	newKey := newHostSigner(t).PublicKey()
+
+	callback, _, err := getSshClientHostKeyCallback(string(ssh.MarshalAuthorizedKey(oldKey)) + "# rotated 2024-06-01\n" + knownhosts.Line([]string{"sftp.example.com"}, newKey) + "\n")
+
+	assert.NoError(t, err)
+	assert.NoError(t, callback(sftpHostname, remoteAddress, oldKey))
+	assert.NoError(t, callback(sftpHostname, remoteAddress, newKey))
+}
+
+func Test_getSshClientHostKeyCallback_KeyDoesNotMatch_LogsFingerprints(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	expectedKey := newHostSigner(t).PublicKey()
+	offeredKey := newHostSigner(t).PublicKey()
+
+	callback, _, err := getSshClientHostKeyCallback(string(ssh.MarshalAuthorizedKey(expectedKey)))
+	assert.NoError(t, err)
+
+	err = callback(sftpHostname, remoteAddress, offeredKey)
+
+	assert.Error(t, err)
+	assert.Contains(t, buffer.String(), "SFTP server's host key isn't one we expect")
+	assert.Contains(t, buffer.String(), ssh.FingerprintSHA256(offeredKey))
+	assert.Contains(t, buffer.String(), ssh.FingerprintSHA256(expectedKey))
+}
+
+func Test_getSshClientHostKeyCallback_CertificateFromAuthority_AcceptsCertificate(t *testing.T) {
+	authority := newHostSigner(t)
+	certificate := newHostCertificate(t, authority, "sftp.example.com")
+
+	callback, _, err := getSshClientHostKeyCallback("@cert-authority *.example.com " + string(ssh.MarshalAuthorizedKey(authority.PublicKey())))
+
+	assert.NoError(t, err)
+	assert.NoError(t, callback(sftpHostname, remoteAddress, certificate))
+}
+
+func Test_getSshClientHostKeyCallback_CertificateFromOtherAuthority_ReturnsError(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	authority := newHostSigner(t)
+	otherAuthority := newHostSigner(t)
+	certificate := newHostCertificate(t, otherAuthority, "sftp.example.com")
+
+	callback, _, err := getSshClientHostKeyCallback("@cert-authority * " + string(ssh.MarshalAuthorizedKey(authority.PublicKey())))
+	assert.NoError(t, err)
+
+	assert.Error(t, callback(sftpHostname, remoteAddress, certificate))
+	assert.Contains(t, buffer.String(), ssh.FingerprintSHA256(otherAuthority.PublicKey()))
+}
+
+func Test_getSshClientHostKeyCallback_KeyIsRevoked_ReturnsError(t *testing.T) {
+	revokedKey := newHostSigner(t).PublicKey()
+	authorizedKey := string(ssh.MarshalAuthorizedKey(revokedKey))
+
+	callback, _, err := getSshClientHostKeyCallback(authorizedKey + "@revoked * " + authorizedKey)
+
+	assert.NoError(t, err)
+	assert.Error(t, callback(sftpHostname, remoteAddress, revokedKey))
+}
+
+func Test_getSshClientHostKeyCallback_OnlyRevokedKeys_ReturnsError(t *testing.T) {
+	callback, _, err := getSshClientHostKeyCallback("@revoked * " + string(ssh.MarshalAuthorizedKey(newHostSigner(t).PublicKey())))
+
+	assert.Nil(t, callback)
+	assert.Error(t, err)
+}
+
+func Test_getSshClientHostKeyCallback_RotatingFromRsaToEd25519_ReturnsAlgorithmsForBothKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	oldKey, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+	newKey := newHostSigner(t).PublicKey()
+
+	_, algorithms, err := getSshClientHostKeyCallback(string(ssh.MarshalAuthorizedKey(oldKey)) + string(ssh.MarshalAuthorizedKey(newKey)))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA, ssh.KeyAlgoED25519}, algorithms)
+}
+
+func Test_getSshClientHostKeyCallback_CertificateAuthority_ReturnsCertificateAlgorithms(t *testing.T) {
+	authority := newHostSigner(t)
+
+	_, algorithms, err := getSshClientHostKeyCallback("@cert-authority * " + string(ssh.MarshalAuthorizedKey(authority.PublicKey())))
+
+	assert.NoError(t, err)
+	assert.Contains(t, algorithms, ssh.CertAlgoED25519v01)
+	assert.Contains(t, algorithms, ssh.CertAlgoRSASHA512v01)
+	assert.NotContains(t, algorithms, ssh.KeyAlgoED25519)
+}
+
+func newHostSigner(t *testing.T) ssh.Signer {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	assert.NoError(t, err)
+	return signer
+}
+
+func newHostCertificate(t *testing.T, authority ssh.Signer, hostname string) *ssh.Certificate {
+	certificate := &ssh.Certificate{
+		Key:             newHostSigner(t).PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{hostname},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	assert.NoError(t, certificate.SignCert(rand.Reader, authority))
+	return certificate
+}
@@ -0,0 +1,224 @@
+package sftp
+
+import (
+	"bytes"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// pgpExtensions mark a file as PGP-encrypted, and are dropped from its name once it's decrypted, e.g.
+// `results.zip.pgp` becomes `results.zip`
+var pgpExtensions = []string{".pgp", ".gpg", ".asc"}
+
+const pgpArmorHeader = "-----BEGIN PGP MESSAGE-----"
+
+// OpenPGP packet tags that start an encrypted message
+const pgpPublicKeyEncryptedSessionKeyTag = 1
+const pgpSymmetricKeyEncryptedSessionKeyTag = 3
+
+// pgpRejection is for a file we can't decrypt, or whose signature doesn't check out. Trying again won't help, so the
+// file goes to the `failure` folder
+type pgpRejection struct {
+	reason string
+}
+
+func (receiver pgpRejection) Error() string {
+	return receiver.reason
+}
+
+// isPgpEncrypted checks the file's name and its first bytes, since partners don't always name encrypted files the
+// same way
+func (receiver *SftpHandler) isPgpEncrypted(fileName string, fileBytes []byte) bool {
+	if !receiver.partnerSettings.Pgp.Decrypt {
+		return false
+	}
+
+	if pgpExtension(fileName) != "" || bytes.HasPrefix(bytes.TrimSpace(fileBytes), []byte(pgpArmorHeader)) {
+		return true
+	}
+
+	if len(fileBytes) == 0 || fileBytes[0]&0x80 == 0 {
+		return false
+	}
+	// New format packet headers have the tag in the low six bits, and old format ones in bits 2 to 5
+	tag := (fileBytes[0] & 0x3c) >> 2
+	if fileBytes[0]&0x40 != 0 {
+		tag = fileBytes[0] & 0x3f
+	}
+	return tag == pgpPublicKeyEncryptedSessionKeyTag || tag == pgpSymmetricKeyEncryptedSessionKeyTag
+}
+
+// decryptFile decrypts a file with our private key for the partner, and checks the partner's signature when their
+// PgpSettings require one. It returns the decrypted file and its name without the PGP extension. Files we can't
+// decrypt return a pgpRejection, and other errors, like a missing secret, are worth trying again
+func (receiver *SftpHandler) decryptFile(fileName string, fileBytes []byte) ([]byte, string, error) {
+	keyring, err := receiver.pgpKeyring()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var partnerKeyring openpgp.EntityList
+	if receiver.partnerSettings.Pgp.RequireSignature {
+		partnerKeyring, err = receiver.partnerPgpKeyring()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	decryptedBytes, err := decryptPgp(fileBytes, keyring, partnerKeyring)
+	if err != nil {
+		slog.Error("Failed to decrypt file", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fileName))
+		return nil, "", err
+	}
+
+	slog.Info("Decrypted file", slog.String(utils.FileNameKey, fileName))
+	return decryptedBytes, strings.TrimSuffix(fileName, pgpExtension(fileName)), nil
+}
+
+// pgpKeyring has our private key for the partner, decrypted with its passphrase when it has one
+func (receiver *SftpHandler) pgpKeyring() (openpgp.EntityList, error) {
+	privateKeyName := receiver.partnerId + "-pgp-private-key-" + utils.EnvironmentName() // pragma: allowlist secret
+	privateKey, err := receiver.credentialGetter.GetSecret(privateKeyName)
+	if err != nil {
+		slog.Error("Unable to get PGP private key", slog.String("KeyName", privateKeyName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(privateKey))
+	if err != nil {
+		slog.Error("Unable to parse PGP private key", slog.String("KeyName", privateKeyName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	if hasEncryptedPrivateKey(keyring) {
+		passphraseName := receiver.partnerId + "-pgp-private-key-passphrase-" + utils.EnvironmentName() // pragma: allowlist secret
+		passphrase, err := receiver.credentialGetter.GetSecret(passphraseName)
+		if err != nil {
+			slog.Error("Unable to get PGP private key passphrase", slog.String("KeyName", passphraseName), slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+
+		err = decryptPrivateKeys(keyring, []byte(passphrase))
+		if err != nil {
+			slog.Error("Unable to decrypt PGP private key", slog.String("KeyName", privateKeyName), slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+	}
+
+	return keyring, nil
+}
+
+// partnerPgpKeyring has the partner's public key, for checking their signatures
+func (receiver *SftpHandler) partnerPgpKeyring() (openpgp.EntityList, error) {
+	partnerPublicKeyName := receiver.partnerId + "-pgp-partner-public-key-" + utils.EnvironmentName() // pragma: allowlist secret
+	partnerPublicKey, err := receiver.credentialGetter.GetSecret(partnerPublicKeyName)
+	if err != nil {
+		slog.Error("Unable to get partner's PGP public key", slog.String("KeyName", partnerPublicKeyName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	partnerKeyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(partnerPublicKey))
+	if err != nil {
+		slog.Error("Unable to parse partner's PGP public key", slog.String("KeyName", partnerPublicKeyName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	return partnerKeyring, nil
+}
+
+// decryptPgp decrypts a binary or ASCII-armored PGP message. When partnerKeyring isn't nil, the message has to be
+// signed with one of its keys. Problems with the message itself are a pgpRejection
+func decryptPgp(fileBytes []byte, keyring openpgp.EntityList, partnerKeyring openpgp.EntityList) ([]byte, error) {
+	var encrypted io.Reader = bytes.NewReader(fileBytes)
+	if bytes.HasPrefix(bytes.TrimSpace(fileBytes), []byte(pgpArmorHeader)) {
+		block, err := armor.Decode(bytes.NewReader(bytes.TrimSpace(fileBytes)))
+		if err != nil {
+			return nil, pgpRejection{reason: "file isn't valid ASCII-armored PGP: " + err.Error()}
+		}
+		encrypted = block.Body
+	}
+
+	// The partner's keys are only in the keyring so we can check their signature, not to decrypt anything
+	messageDetails, err := openpgp.ReadMessage(encrypted, append(slices.Clip(keyring), partnerKeyring...), nil, nil)
+	if err != nil {
+		return nil, pgpRejection{reason: "unable to decrypt file: " + err.Error()}
+	}
+
+	// The signature is only checked once we've read to the end of the message
+	decryptedBytes, err := io.ReadAll(messageDetails.UnverifiedBody)
+	if err != nil {
+		return nil, pgpRejection{reason: "unable to decrypt file: " + err.Error()}
+	}
+
+	if partnerKeyring != nil {
+		if !messageDetails.IsSigned {
+			return nil, pgpRejection{reason: "file isn't signed"}
+		}
+		// Our own key is in the keyring too, so a known signer isn't necessarily the partner
+		if messageDetails.SignedBy == nil || !isInKeyring(messageDetails.SignedBy.Entity, partnerKeyring) {
+			return nil, pgpRejection{reason: "file isn't signed with the partner's key"}
+		}
+	}
+	if messageDetails.SignatureError != nil {
+		return nil, pgpRejection{reason: "file's signature is invalid: " + messageDetails.SignatureError.Error()}
+	}
+
+	return decryptedBytes, nil
+}
+
+func pgpExtension(fileName string) string {
+	for _, extension := range pgpExtensions {
+		if strings.HasSuffix(strings.ToLower(fileName), extension) {
+			return fileName[len(fileName)-len(extension):]
+		}
+	}
+	return ""
+}
+
+func isInKeyring(entity *openpgp.Entity, keyring openpgp.EntityList) bool {
+	for _, keyringEntity := range keyring {
+		if bytes.Equal(keyringEntity.PrimaryKey.Fingerprint, entity.PrimaryKey.Fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasEncryptedPrivateKey(keyring openpgp.EntityList) bool {
+	for _, entity := range keyring {
+		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+			return true
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decryptPrivateKeys(keyring openpgp.EntityList, passphrase []byte) error {
+	for _, entity := range keyring {
+		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+			err := entity.PrivateKey.Decrypt(passphrase)
+			if err != nil {
+				return err
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				err := subkey.PrivateKey.Decrypt(passphrase)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
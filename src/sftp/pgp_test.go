@@ -0,0 +1,226 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+const pgpPrivateKeyName = "flexion-pgp-private-key-local"              // pragma: allowlist secret
+const pgpPartnerPublicKeyName = "flexion-pgp-partner-public-key-local" // pragma: allowlist secret
+
+var pgpSettings = config.PartnerSettings{Pgp: config.PgpSettings{Decrypt: true}}
+
+func Test_copySingleFile_FileIsPgpEncrypted_UploadsDecryptedFile(t *testing.T) {
+	ourKey := newPgpEntity(t)
+	encrypted := encryptPgp(t, []byte("MSH|order"), ourKey, nil, true)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/order.hl7.asc").Return(io.NopCloser(bytes.NewReader(encrypted)), nil)
+	mockSftpClient.On("Stat", "dogcow/order.hl7.asc").Return(testFileInfo{name: "order.hl7.asc", size: int64(len(encrypted))}, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|order"), nil)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", pgpPrivateKeyName).Return(armoredPrivateKey(t, ourKey), nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, credentialGetter: mockCredentialGetter, partnerId: partnerId, partnerSettings: pgpSettings}
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7.asc"}, directory: "dogcow"}, 1)
+
+	assertImported(t, mockBlobHandler, []byte("MSH|order"), "flexion/import/order.hl7")
+	mockSftpClient.AssertCalled(t, "Remove", "dogcow/order.hl7.asc")
+}
+
+func Test_copySingleFile_FileIsEncryptedForAnotherKey_MovesToFailureWithReason(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	encrypted := encryptPgp(t, []byte("MSH|order"), newPgpEntity(t), nil, false)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/order.hl7.pgp").Return(io.NopCloser(bytes.NewReader(encrypted)), nil)
+	mockSftpClient.On("Stat", "dogcow/order.hl7.pgp").Return(testFileInfo{name: "order.hl7.pgp", size: int64(len(encrypted))}, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", pgpPrivateKeyName).Return(armoredPrivateKey(t, newPgpEntity(t)), nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, credentialGetter: mockCredentialGetter, partnerId: partnerId, partnerSettings: pgpSettings}
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7.pgp"}, directory: "dogcow"}, 1)
+
+	mockBlobHandler.AssertCalled(t, "UploadFile", encrypted, "flexion/failure/order.hl7.pgp")
+	mockBlobHandler.AssertCalled(t, "UploadFile", mock.MatchedBy(func(reason []byte) bool {
+		return bytes.HasPrefix(reason, []byte("order.hl7.pgp: unable to decrypt file"))
+	}), "flexion/failure/order.hl7.pgp.txt")
+	mockBlobHandler.AssertNotCalled(t, "UploadFile", mock.Anything, "flexion/import/order.hl7")
+	mockSftpClient.AssertCalled(t, "Remove", "dogcow/order.hl7.pgp")
+	assert.Contains(t, buffer.String(), "Failed to decrypt file")
+}
+
+func Test_copySingleFile_CantGetPgpPrivateKey_LeavesFileOnSftpServer(t *testing.T) {
+	encrypted := encryptPgp(t, []byte("MSH|order"), newPgpEntity(t), nil, true)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/order.hl7").Return(io.NopCloser(bytes.NewReader(encrypted)), nil)
+	mockSftpClient.On("Stat", "dogcow/order.hl7").Return(testFileInfo{name: "order.hl7", size: int64(len(encrypted))}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", pgpPrivateKeyName).Return("", errors.New("key vault is down"))
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, credentialGetter: mockCredentialGetter, partnerId: partnerId, partnerSettings: pgpSettings}
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7"}, directory: "dogcow"}, 1)
+
+	mockBlobHandler.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything)
+	mockSftpClient.AssertNotCalled(t, "Remove", mock.Anything)
+}
+
+func Test_decryptPgp_SignatureRequiredAndSignedByPartner_ReturnsDecryptedFile(t *testing.T) {
+	ourKey := newPgpEntity(t)
+	partnerKey := newPgpEntity(t)
+	encrypted := encryptPgp(t, []byte("MSH|order"), ourKey, partnerKey, false)
+
+	decrypted, err := decryptPgp(encrypted, openpgp.EntityList{ourKey}, openpgp.EntityList{partnerKey})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("MSH|order"), decrypted)
+}
+
+func Test_decryptPgp_SignatureRequiredButNotSigned_ReturnsRejection(t *testing.T) {
+	ourKey := newPgpEntity(t)
+	encrypted := encryptPgp(t, []byte("MSH|order"), ourKey, nil, false)
+
+	_, err := decryptPgp(encrypted, openpgp.EntityList{ourKey}, openpgp.EntityList{newPgpEntity(t)})
+
+	assert.Equal(t, pgpRejection{reason: "file isn't signed"}, err)
+}
+
+func Test_decryptPgp_SignedBySomeoneElse_ReturnsRejection(t *testing.T) {
+	ourKey := newPgpEntity(t)
+	encrypted := encryptPgp(t, []byte("MSH|order"), ourKey, newPgpEntity(t), false)
+
+	_, err := decryptPgp(encrypted, openpgp.EntityList{ourKey}, openpgp.EntityList{newPgpEntity(t)})
+
+	assert.Equal(t, pgpRejection{reason: "file isn't signed with the partner's key"}, err)
+}
+
+func Test_decryptPgp_SignedWithOurOwnKey_ReturnsRejection(t *testing.T) {
+	ourKey := newPgpEntity(t)
+	encrypted := encryptPgp(t, []byte("MSH|order"), ourKey, ourKey, false)
+
+	_, err := decryptPgp(encrypted, openpgp.EntityList{ourKey}, openpgp.EntityList{newPgpEntity(t)})
+
+	assert.Equal(t, pgpRejection{reason: "file isn't signed with the partner's key"}, err)
+}
+
+func Test_decryptPgp_SignatureNotRequired_ReturnsDecryptedFile(t *testing.T) {
+	ourKey := newPgpEntity(t)
+	encrypted := encryptPgp(t, []byte("MSH|order"), ourKey, newPgpEntity(t), false)
+
+	decrypted, err := decryptPgp(encrypted, openpgp.EntityList{ourKey}, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("MSH|order"), decrypted)
+}
+
+func Test_partnerPgpKeyring_ReturnsPartnerPublicKey(t *testing.T) {
+	partnerKey := newPgpEntity(t)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", pgpPartnerPublicKeyName).Return(armoredPublicKey(t, partnerKey), nil)
+
+	sftpHandler := SftpHandler{credentialGetter: mockCredentialGetter, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{Pgp: config.PgpSettings{Decrypt: true, RequireSignature: true}}}
+	partnerKeyring, err := sftpHandler.partnerPgpKeyring()
+
+	assert.NoError(t, err)
+	assert.Len(t, partnerKeyring, 1)
+	assert.Equal(t, partnerKey.PrimaryKey.KeyId, partnerKeyring[0].PrimaryKey.KeyId)
+}
+
+func Test_isPgpEncrypted(t *testing.T) {
+	binary := encryptPgp(t, []byte("MSH|order"), newPgpEntity(t), nil, false)
+	armored := encryptPgp(t, []byte("MSH|order"), newPgpEntity(t), nil, true)
+	sftpHandler := SftpHandler{partnerSettings: pgpSettings}
+
+	assert.True(t, sftpHandler.isPgpEncrypted("results.zip.GPG", []byte("anything")))
+	assert.True(t, sftpHandler.isPgpEncrypted("order.hl7", binary))
+	assert.True(t, sftpHandler.isPgpEncrypted("order.hl7", armored))
+	assert.False(t, sftpHandler.isPgpEncrypted("order.hl7", []byte("MSH|order")))
+	assert.False(t, sftpHandler.isPgpEncrypted("results.zip", []byte("PK\x03\x04")))
+
+	notDecrypting := SftpHandler{}
+	assert.False(t, notDecrypting.isPgpEncrypted("order.hl7.pgp", binary))
+}
+
+func newPgpEntity(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", &packet.Config{RSABits: 1024, DefaultHash: crypto.SHA256})
+	assert.NoError(t, err)
+	return entity
+}
+
+func armoredPrivateKey(t *testing.T, entity *openpgp.Entity) string {
+	var buffer bytes.Buffer
+	writer, err := armor.Encode(&buffer, openpgp.PrivateKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.SerializePrivate(writer, nil))
+	assert.NoError(t, writer.Close())
+	return buffer.String()
+}
+
+func armoredPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	var buffer bytes.Buffer
+	writer, err := armor.Encode(&buffer, openpgp.PublicKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.Serialize(writer))
+	assert.NoError(t, writer.Close())
+	return buffer.String()
+}
+
+func encryptPgp(t *testing.T, plaintext []byte, recipient *openpgp.Entity, signer *openpgp.Entity, armored bool) []byte {
+	var buffer bytes.Buffer
+	var output io.WriteCloser = nopWriteCloser{&buffer}
+	if armored {
+		var err error
+		output, err = armor.Encode(&buffer, "PGP MESSAGE", nil)
+		assert.NoError(t, err)
+	}
+
+	writer, err := openpgp.Encrypt(output, []*openpgp.Entity{recipient}, signer, nil, nil)
+	assert.NoError(t, err)
+	_, err = writer.Write(plaintext)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, output.Close())
+	return buffer.Bytes()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
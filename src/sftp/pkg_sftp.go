@@ -1,42 +1,276 @@
 package sftp
 
 import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"os"
+	"time"
 )
 
+// maxSftpSessions is how many SFTP sessions we open on a partner's SSH connection at once. OpenSSH only allows 10
+// by default
+const maxSftpSessions = 8
+
+// PkgSftpImplementation runs each operation, and each open file, on its own SFTP session, see sftpSession
 type PkgSftpImplementation struct {
-	client *sftp.Client
+	sshClient        *ssh.Client
+	clientOptions    []sftp.ClientOption
+	operationTimeout time.Duration
+	// sessions holds a slot for each SFTP session we have open, up to maxSftpSessions
+	sessions chan struct{}
 }
 
-func NewPkgSftpImplementation(sshClient *ssh.Client) (PkgSftpImplementation, error) {
-	sftpClient, err := sftp.NewClient(sshClient)
+func NewPkgSftpImplementation(sshClient *ssh.Client, connection config.SftpConnectionSettings) (PkgSftpImplementation, error) {
+	implementation := PkgSftpImplementation{
+		sshClient:        sshClient,
+		clientOptions:    sftpClientOptions(connection),
+		operationTimeout: secondsOrDefault(connection.OperationTimeoutSeconds, defaultOperationTimeout),
+		sessions:         make(chan struct{}, maxSftpSessions),
+	}
+
+	// Check we can use SFTP before we start on the partner's files
+	session, err := implementation.openSession()
 	if err != nil {
 		return PkgSftpImplementation{}, err
 	}
+	_ = session.close()
 
-	return PkgSftpImplementation{client: sftpClient}, nil
+	return implementation, nil
 }
 
 func (p PkgSftpImplementation) ReadDir(path string) ([]os.FileInfo, error) {
-	return p.client.ReadDir(path)
+	return runInSession(p, func(client *sftp.Client) ([]os.FileInfo, error) {
+		return client.ReadDir(path)
+	})
 }
 
 func (p PkgSftpImplementation) Open(path string) (io.ReadCloser, error) {
-	file, err := p.client.Open(path)
+	return p.openFile(func(client *sftp.Client) (*sftp.File, error) {
+		return client.Open(path)
+	})
+}
+
+func (p PkgSftpImplementation) OpenAt(path string, offset int64) (io.ReadCloser, error) {
+	return p.openFile(func(client *sftp.Client) (*sftp.File, error) {
+		file, err := client.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		_, err = file.Seek(offset, io.SeekStart)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return file, nil
+	})
+}
+
+func (p PkgSftpImplementation) Stat(path string) (os.FileInfo, error) {
+	return runInSession(p, func(client *sftp.Client) (os.FileInfo, error) {
+		return client.Stat(path)
+	})
+}
+
+// Close doesn't have anything to close, since each operation closes its own session. The SSH connection belongs to
+// the SftpHandler
+func (p PkgSftpImplementation) Close() error {
+	return nil
+}
+
+func (p PkgSftpImplementation) Remove(path string) error {
+	_, err := runInSession(p, func(client *sftp.Client) (struct{}, error) {
+		return struct{}{}, client.Remove(path)
+	})
+	return err
+}
+
+func (p PkgSftpImplementation) Rename(oldPath string, newPath string) error {
+	_, err := runInSession(p, func(client *sftp.Client) (struct{}, error) {
+		return struct{}{}, client.Rename(oldPath, newPath)
+	})
+	return err
+}
+
+func (p PkgSftpImplementation) Create(path string) (io.WriteCloser, error) {
+	return p.openFile(func(client *sftp.Client) (*sftp.File, error) {
+		return client.Create(path)
+	})
+}
+
+// openFile opens a file on a new session, which stays open until the file is closed
+func (p PkgSftpImplementation) openFile(open func(client *sftp.Client) (*sftp.File, error)) (io.ReadWriteCloser, error) {
+	session, err := p.openSession()
 	if err != nil {
 		return nil, err
 	}
 
-	return file, nil
+	file, err := runWithTimeout(p.operationTimeout, session.cancellers, func() (*sftp.File, error) {
+		return open(session.client)
+	})
+	if err != nil {
+		_ = session.close()
+		return nil, err
+	}
+
+	return timeoutFile{file: file, session: session, operationTimeout: p.operationTimeout}, nil
 }
 
-func (p PkgSftpImplementation) Close() error {
-	return p.client.Close()
+// sftpSession is an SFTP client on its own SSH channel. When an operation times out we close its channel, which fails
+// whatever is waiting on that session without affecting the partner's other files on the same connection
+type sftpSession struct {
+	client  *sftp.Client
+	channel *ssh.Session
+	release func()
+	// cancellers are what runWithTimeout uses to stop an operation on the session, see cancellers
+	cancellers []func() error
 }
 
-func (p PkgSftpImplementation) Remove(path string) error {
-	return p.client.Remove(path)
+// openSession waits for a free session slot, then starts SFTP on a new channel. This is what sftp.NewClient does, but
+// keeping the channel means we can close it when the server stops responding
+func (p PkgSftpImplementation) openSession() (*sftpSession, error) {
+	p.sessions <- struct{}{}
+	release := func() {
+		<-p.sessions
+	}
+
+	channel, err := p.sshClient.NewSession()
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	client, err := runWithTimeout(p.operationTimeout, p.cancellers(channel), func() (*sftp.Client, error) {
+		err := channel.RequestSubsystem("sftp")
+		if err != nil {
+			return nil, err
+		}
+		writer, err := channel.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		reader, err := channel.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		return sftp.NewClientPipe(reader, writer, p.clientOptions...)
+	})
+	if err != nil {
+		_ = channel.Close()
+		release()
+		return nil, err
+	}
+
+	return &sftpSession{client: client, channel: channel, release: release, cancellers: p.cancellers(channel)}, nil
+}
+
+// cancellers stop an operation on channel that's timed out. Closing the channel fails whatever is waiting on it, as
+// long as the server's SSH connection still responds. If it doesn't, nothing else on the connection is getting
+// anywhere either, so we close the whole connection
+func (p PkgSftpImplementation) cancellers(channel *ssh.Session) []func() error {
+	return []func() error{channel.Close, p.sshClient.Close}
+}
+
+// close closes the channel before the client, because the client waits for the server to close its end first, which
+// never happens if the server has stopped responding
+func (receiver *sftpSession) close() error {
+	defer receiver.release()
+
+	err := receiver.channel.Close()
+	_ = receiver.client.Close()
+	if errors.Is(err, io.EOF) {
+		// The channel was already closed, e.g. because an operation timed out
+		return nil
+	}
+	return err
+}
+
+// runInSession runs an operation on a new session, and closes the session afterwards
+func runInSession[T any](p PkgSftpImplementation, operation func(client *sftp.Client) (T, error)) (T, error) {
+	session, err := p.openSession()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	defer session.close()
+
+	return runWithTimeout(p.operationTimeout, session.cancellers, func() (T, error) {
+		return operation(session.client)
+	})
+}
+
+// runWithTimeout gives up on an operation that takes longer than timeout. It calls each of cancellers in turn, giving
+// the operation another timeout to fail after each one, and waits for the operation to return, so it isn't still
+// using anything of the caller's, like a read's buffer, once we return. The last canceller has to make it fail
+func runWithTimeout[T any](timeout time.Duration, cancellers []func() error, operation func() (T, error)) (T, error) {
+	if timeout <= 0 {
+		return operation()
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := operation()
+		done <- result{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case operationResult := <-done:
+		return operationResult.value, operationResult.err
+	case <-timer.C:
+	}
+
+	var zero T
+	for _, cancel := range cancellers[:len(cancellers)-1] {
+		_ = cancel()
+		timer.Reset(timeout)
+		select {
+		case <-done:
+			return zero, errOperationTimedOut
+		case <-timer.C:
+		}
+	}
+
+	_ = cancellers[len(cancellers)-1]()
+	<-done
+	return zero, errOperationTimedOut
+}
+
+// timeoutFile applies the operation timeout to each read or write, so a large file can take as long as it needs
+// while a stalled read still fails. Closing the file closes its session
+type timeoutFile struct {
+	file             *sftp.File
+	session          *sftpSession
+	operationTimeout time.Duration
+}
+
+func (receiver timeoutFile) Read(buffer []byte) (int, error) {
+	return runWithTimeout(receiver.operationTimeout, receiver.session.cancellers, func() (int, error) {
+		return receiver.file.Read(buffer)
+	})
+}
+
+func (receiver timeoutFile) Write(data []byte) (int, error) {
+	return runWithTimeout(receiver.operationTimeout, receiver.session.cancellers, func() (int, error) {
+		return receiver.file.Write(data)
+	})
+}
+
+func (receiver timeoutFile) Close() error {
+	_, err := runWithTimeout(receiver.operationTimeout, receiver.session.cancellers, func() (struct{}, error) {
+		return struct{}{}, receiver.file.Close()
+	})
+	sessionErr := receiver.session.close()
+	if err != nil {
+		return err
+	}
+	return sessionErr
 }
@@ -0,0 +1,130 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PkgSftpImplementation_ReadStalls_TimesOutWithoutAffectingOtherFiles(t *testing.T) {
+	implementation := connectToStallingServer(t)
+
+	stalledFile, err := implementation.Open("/stall.hl7")
+	assert.NoError(t, err)
+	otherFile, err := implementation.Open("/order.hl7")
+	assert.NoError(t, err)
+
+	_, err = stalledFile.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, errOperationTimedOut)
+	_ = stalledFile.Close()
+
+	contents, err := io.ReadAll(otherFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "MSH|order", string(contents))
+	assert.NoError(t, otherFile.Close())
+
+	_, err = implementation.Stat("/order.hl7")
+	assert.NoError(t, err)
+}
+
+// connectToStallingServer connects to an SFTP server where reading `/stall.hl7` never returns, until the test ends
+func connectToStallingServer(t *testing.T) PkgSftpImplementation {
+	stop := make(chan struct{})
+	handlers := sftp.InMemHandler()
+	handlers.FileGet = stallingFiles{stop: stop}
+	handlers.FileList = stallingFiles{stop: stop}
+
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivateKey)
+	assert.NoError(t, err)
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		close(stop)
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftpConnection(connection, serverConfig, handlers)
+		}
+	}()
+
+	sshClient, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = sshClient.Close()
+	})
+
+	implementation, err := NewPkgSftpImplementation(sshClient, config.SftpConnectionSettings{})
+	assert.NoError(t, err)
+	implementation.operationTimeout = 50 * time.Millisecond
+	return implementation
+}
+
+func serveSftpConnection(connection net.Conn, serverConfig *ssh.ServerConfig, handlers sftp.Handlers) {
+	_, channels, requests, err := ssh.NewServerConn(connection, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for request := range channelRequests {
+				_ = request.Reply(request.Type == "subsystem", nil)
+				if request.Type == "subsystem" {
+					go func() {
+						_ = sftp.NewRequestServer(channel, handlers).Serve()
+						_ = channel.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+type stallingFiles struct {
+	stop chan struct{}
+}
+
+func (receiver stallingFiles) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	if request.Filepath == "/stall.hl7" {
+		return stallingReader(receiver), nil
+	}
+	return strings.NewReader("MSH|order"), nil
+}
+
+func (receiver stallingFiles) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	return fileInfoLister{testFileInfo{name: "order.hl7", size: int64(len("MSH|order"))}}, nil
+}
+
+type stallingReader struct {
+	stop chan struct{}
+}
+
+func (receiver stallingReader) ReadAt([]byte, int64) (int, error) {
+	<-receiver.stop
+	return 0, os.ErrClosed
+}
@@ -0,0 +1,147 @@
+package sftp
+
+import (
+	"encoding/json"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultRenameSuffix is what the `rename` post-copy action adds to a file's name when the partner doesn't set a suffix
+const defaultRenameSuffix = ".processed"
+
+// pulledFilesFolder holds a record for each file we've copied from a partner using the `leave` post-copy action, e.g.
+// `sftp/ca-phl/pulled/2024/results.hl7.json`
+const pulledFilesFolder = "pulled"
+
+const copiedAtFormat = "20060102T150405Z"
+
+// pulledFileRecord is how we recognise a file we've already copied. A file with the same name but a different size
+// or modified time is a new file
+type pulledFileRecord struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// finishRemoteFile does the partner's post-copy action to name, which is the file we copied or its manifest or done
+// marker in the same directory
+func (receiver *SftpHandler) finishRemoteFile(file remoteFile, name string, copiedAt time.Time) error {
+	postCopy := receiver.partnerSettings.PostCopy
+	fullFilePath := file.directory + "/" + name
+
+	switch postCopy.Action {
+	case config.PostCopyLeave:
+		if name != file.fileInfo.Name() {
+			return nil
+		}
+		return receiver.recordPulledFile(file)
+	case config.PostCopyRename:
+		return receiver.renameRemoteFile(fullFilePath, file.directory+"/"+renamedFileName(name, postCopy, copiedAt))
+	case config.PostCopyMove:
+		return receiver.renameRemoteFile(fullFilePath, path.Join(receiver.moveDirectory(file), renamedFileName(name, postCopy, copiedAt)))
+	default:
+		err := receiver.sftpClient.Remove(fullFilePath)
+		if err != nil {
+			slog.Error("Failed to remove file from SFTP server", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
+			return err
+		}
+		slog.Info("Successfully copied file and removed from SFTP server", slog.Any(utils.FileNameKey, fullFilePath))
+		return nil
+	}
+}
+
+func (receiver *SftpHandler) renameRemoteFile(oldPath string, newPath string) error {
+	err := receiver.sftpClient.Rename(oldPath, newPath)
+	if err != nil {
+		slog.Error("Failed to rename file on SFTP server", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, oldPath), slog.String("newPath", newPath))
+		return err
+	}
+	slog.Info("Successfully copied file and renamed it on SFTP server", slog.String(utils.FileNameKey, oldPath), slog.String("newPath", newPath))
+	return nil
+}
+
+// moveDirectory is where the `move` post-copy action puts files. A relative directory is from the starting directory
+func (receiver *SftpHandler) moveDirectory(file remoteFile) string {
+	directory := receiver.partnerSettings.PostCopy.Directory
+	if path.IsAbs(directory) {
+		return directory
+	}
+	return path.Join(file.startingDirectory(), directory)
+}
+
+// isPostCopyLeftover is true for files and directories our post-copy action left on the SFTP server, so we don't copy
+// them again
+func (receiver *SftpHandler) isPostCopyLeftover(relativePath string, fileInfo os.FileInfo) bool {
+	postCopy := receiver.partnerSettings.PostCopy
+	switch postCopy.Action {
+	case config.PostCopyRename:
+		return !fileInfo.IsDir() && strings.HasSuffix(fileInfo.Name(), renameSuffix(postCopy))
+	case config.PostCopyMove:
+		return fileInfo.IsDir() && !path.IsAbs(postCopy.Directory) && relativePath == path.Clean(postCopy.Directory)
+	case config.PostCopyLeave:
+		return !fileInfo.IsDir() && receiver.wasPulled(relativePath, fileInfo)
+	}
+	return false
+}
+
+func (receiver *SftpHandler) recordPulledFile(file remoteFile) error {
+	recordBytes, err := json.Marshal(pulledFileRecord{Size: file.fileInfo.Size(), ModTime: file.fileInfo.ModTime().UTC()})
+	if err != nil {
+		slog.Error("Failed to make pulled file record", slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	recordPath := receiver.pulledFileRecordPath(path.Join(file.relativeDirectory, file.fileInfo.Name()))
+	err = receiver.blobHandler.UploadFile(recordBytes, recordPath)
+	if err != nil {
+		slog.Error("Failed to upload pulled file record", slog.Any(utils.ErrorKey, err), slog.String("recordPath", recordPath))
+		return err
+	}
+	slog.Info("Successfully copied file and left it on SFTP server", slog.String(utils.FileNameKey, file.fullPath()))
+	return nil
+}
+
+// wasPulled checks for a record that we've already copied the file. When we can't read the record, we copy the file
+// again rather than risk missing it
+func (receiver *SftpHandler) wasPulled(relativePath string, fileInfo os.FileInfo) bool {
+	recordBytes, err := receiver.blobHandler.FetchFileByUrl(path.Join(utils.ContainerName, receiver.pulledFileRecordPath(relativePath)))
+	if err != nil {
+		return false
+	}
+
+	var record pulledFileRecord
+	err = json.Unmarshal(recordBytes, &record)
+	if err != nil {
+		slog.Warn("Unable to read pulled file record", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, relativePath))
+		return false
+	}
+
+	return record.Size == fileInfo.Size() && record.ModTime.Equal(fileInfo.ModTime())
+}
+
+func (receiver *SftpHandler) pulledFileRecordPath(relativePath string) string {
+	return path.Join(receiver.partnerId, pulledFilesFolder, relativePath+".json")
+}
+
+// renamedFileName is a file's new name for the `rename` and `move` post-copy actions
+func renamedFileName(name string, postCopy config.PostCopySettings, copiedAt time.Time) string {
+	if postCopy.AddTimestamp {
+		extension := path.Ext(name)
+		name = strings.TrimSuffix(name, extension) + "_" + copiedAt.UTC().Format(copiedAtFormat) + extension
+	}
+	if postCopy.Action == config.PostCopyRename {
+		name += renameSuffix(postCopy)
+	}
+	return name
+}
+
+func renameSuffix(postCopy config.PostCopySettings) string {
+	if postCopy.Suffix == "" {
+		return defaultRenameSuffix
+	}
+	return postCopy.Suffix
+}
@@ -0,0 +1,146 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+var copiedAt = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func Test_copySingleFile_PostCopyIsRename_RenamesFileAndDoneMarker(t *testing.T) {
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/order.hl7").Return(io.NopCloser(bytes.NewReader([]byte("MSH|order"))), nil)
+	mockSftpClient.On("Stat", "dogcow/order.hl7").Return(testFileInfo{name: "order.hl7", size: 9}, nil)
+	mockSftpClient.On("Rename", mock.Anything, mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|order"), nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{PostCopy: config.PostCopySettings{Action: config.PostCopyRename}}}
+
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7"}, directory: "dogcow", doneMarkerName: "order.hl7.done"}, 1)
+
+	mockSftpClient.AssertCalled(t, "Rename", "dogcow/order.hl7", "dogcow/order.hl7.processed")
+	mockSftpClient.AssertCalled(t, "Rename", "dogcow/order.hl7.done", "dogcow/order.hl7.done.processed")
+	mockSftpClient.AssertNotCalled(t, "Remove", mock.Anything)
+}
+
+func Test_copySingleFile_RenameFails_LeavesDoneMarker(t *testing.T) {
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/order.hl7").Return(io.NopCloser(bytes.NewReader([]byte("MSH|order"))), nil)
+	mockSftpClient.On("Stat", "dogcow/order.hl7").Return(testFileInfo{name: "order.hl7", size: 9}, nil)
+	mockSftpClient.On("Rename", mock.Anything, mock.Anything).Return(errors.New("permission denied"))
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|order"), nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{PostCopy: config.PostCopySettings{Action: config.PostCopyRename}}}
+
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7"}, directory: "dogcow", doneMarkerName: "order.hl7.done"}, 1)
+
+	mockSftpClient.AssertNumberOfCalls(t, "Rename", 1)
+}
+
+func Test_finishRemoteFile_PostCopyIsMove_MovesToDirectoryWithTimestamp(t *testing.T) {
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Rename", mock.Anything, mock.Anything).Return(nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient,
+		partnerSettings: config.PartnerSettings{PostCopy: config.PostCopySettings{Action: config.PostCopyMove, Directory: "processed", AddTimestamp: true}}}
+	file := remoteFile{fileInfo: testFileInfo{name: "results.zip"}, directory: "dogcow/2024", relativeDirectory: "2024"}
+
+	err := sftpHandler.finishRemoteFile(file, "results.zip", copiedAt)
+
+	assert.NoError(t, err)
+	mockSftpClient.AssertCalled(t, "Rename", "dogcow/2024/results.zip", "dogcow/processed/results_20240601T120000Z.zip")
+}
+
+func Test_finishRemoteFile_MoveDirectoryIsAbsolute_MovesToDirectory(t *testing.T) {
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Rename", mock.Anything, mock.Anything).Return(nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient,
+		partnerSettings: config.PartnerSettings{PostCopy: config.PostCopySettings{Action: config.PostCopyMove, Directory: "/archive"}}}
+
+	err := sftpHandler.finishRemoteFile(remoteFile{fileInfo: testFileInfo{name: "results.zip"}, directory: "dogcow"}, "results.zip", copiedAt)
+
+	assert.NoError(t, err)
+	mockSftpClient.AssertCalled(t, "Rename", "dogcow/results.zip", "/archive/results.zip")
+}
+
+func Test_finishRemoteFile_PostCopyIsLeave_RecordsPulledFile(t *testing.T) {
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockSftpClient := new(MockSftpWrapper)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{PostCopy: config.PostCopySettings{Action: config.PostCopyLeave}}}
+	file := remoteFile{fileInfo: testFileInfo{name: "order.hl7", size: 9, modTime: copiedAt}, directory: "dogcow/2024", relativeDirectory: "2024", doneMarkerName: "order.hl7.done"}
+
+	assert.NoError(t, sftpHandler.finishRemoteFile(file, "order.hl7", copiedAt))
+	assert.NoError(t, sftpHandler.finishRemoteFile(file, "order.hl7.done", copiedAt))
+
+	mockBlobHandler.AssertCalled(t, "UploadFile", []byte(`{"size":9,"modTime":"2024-06-01T12:00:00Z"}`), "flexion/pulled/2024/order.hl7.json")
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockSftpClient.AssertNotCalled(t, "Remove", mock.Anything)
+	mockSftpClient.AssertNotCalled(t, "Rename", mock.Anything, mock.Anything)
+}
+
+func Test_listRemoteFiles_PostCopyIsLeave_SkipsFilesAlreadyPulled(t *testing.T) {
+	changed := testFileInfo{name: "changed.hl7", size: 20, modTime: copiedAt}
+	newFile := testFileInfo{name: "new.hl7", size: 9, modTime: copiedAt}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{testFileInfo{name: "pulled.hl7", size: 9, modTime: copiedAt}, changed, newFile}, nil)
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", "sftp/flexion/pulled/pulled.hl7.json").Return([]byte(`{"size":9,"modTime":"2024-06-01T12:00:00Z"}`), nil)
+	mockBlobHandler.On("FetchFileByUrl", "sftp/flexion/pulled/changed.hl7.json").Return([]byte(`{"size":9,"modTime":"2024-06-01T12:00:00Z"}`), nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte{}, errors.New("not found"))
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{PostCopy: config.PostCopySettings{Action: config.PostCopyLeave}}}
+
+	files, err := sftpHandler.listRemoteFiles("dogcow", "", 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []remoteFile{{fileInfo: changed, directory: "dogcow"}, {fileInfo: newFile, directory: "dogcow"}}, files)
+}
+
+func Test_listRemoteFiles_PostCopyIsRenameOrMove_SkipsFilesAlreadyCopied(t *testing.T) {
+	order := testFileInfo{name: "order.hl7"}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{order, testFileInfo{name: "old.hl7.processed"}, testFileInfo{name: "processed", isDir: true}}, nil)
+
+	renameHandler := SftpHandler{sftpClient: mockSftpClient, partnerSettings: config.PartnerSettings{PostCopy: config.PostCopySettings{Action: config.PostCopyRename}}}
+	files, err := renameHandler.listRemoteFiles("dogcow", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []remoteFile{{fileInfo: order, directory: "dogcow"}}, files)
+
+	moveHandler := SftpHandler{sftpClient: mockSftpClient, partnerSettings: config.PartnerSettings{
+		RemoteFiles: config.RemoteFileSettings{Recursive: true},
+		PostCopy:    config.PostCopySettings{Action: config.PostCopyMove, Directory: "processed/"},
+	}}
+	files, err = moveHandler.listRemoteFiles("dogcow", "", 0)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	mockSftpClient.AssertNotCalled(t, "ReadDir", "dogcow/processed")
+}
+
+func Test_renamedFileName(t *testing.T) {
+	assert.Equal(t, "results.zip", renamedFileName("results.zip", config.PostCopySettings{Action: config.PostCopyMove}, copiedAt))
+	assert.Equal(t, "results_20240601T120000Z.zip", renamedFileName("results.zip", config.PostCopySettings{Action: config.PostCopyMove, AddTimestamp: true}, copiedAt))
+	assert.Equal(t, "results_20240601T120000Z.zip.copied", renamedFileName("results.zip", config.PostCopySettings{Action: config.PostCopyRename, Suffix: ".copied", AddTimestamp: true}, copiedAt))
+}
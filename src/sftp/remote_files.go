@@ -0,0 +1,162 @@
+package sftp
+
+import (
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/CDCgov/reportstream-sftp-ingestion/zip"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// defaultMaxDepth is how many levels of subdirectories we go down when a partner's RemoteFileSettings are recursive
+// but don't set MaxDepth
+const defaultMaxDepth = 5
+
+// remoteFile is a file on the partner's SFTP server that we're going to copy
+type remoteFile struct {
+	fileInfo os.FileInfo
+	// directory is the file's directory on the SFTP server
+	directory string
+	// relativeDirectory is directory relative to the starting directory, or empty for files in the starting directory
+	relativeDirectory string
+	// manifestName is the file's manifest next to it on the SFTP server, if any
+	manifestName string
+	// doneMarkerName is the marker the partner wrote next to the file once it finished uploading, if any
+	doneMarkerName string
+}
+
+func (receiver remoteFile) fullPath() string {
+	return receiver.directory + "/" + receiver.fileInfo.Name()
+}
+
+func (receiver remoteFile) startingDirectory() string {
+	if receiver.relativeDirectory == "" {
+		return receiver.directory
+	}
+	return strings.TrimSuffix(receiver.directory, "/"+receiver.relativeDirectory)
+}
+
+// listRemoteFiles finds the files to copy in directory and, when the partner's settings are recursive, its
+// subdirectories. Files are checked against the partner's patterns before their StableFileSettings, so we don't wait
+// on files we aren't going to copy. depth is 0 for the starting directory
+func (receiver *SftpHandler) listRemoteFiles(directory string, relativeDirectory string, depth int) ([]remoteFile, error) {
+	fileInfos, err := receiver.sftpClient.ReadDir(directory)
+	if err != nil {
+		slog.Error("Failed to read directory", slog.Any(utils.ErrorKey, err), slog.String("directory", directory))
+		return nil, err
+	}
+
+	remoteFiles := receiver.partnerSettings.RemoteFiles
+	maxDepth := remoteFiles.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = defaultMaxDepth
+	}
+
+	fileNames := fileNameSet(fileInfos)
+	doneMarkers := receiver.doneMarkerNames(fileInfos)
+
+	var files []remoteFile
+	for _, fileInfo := range fileInfos {
+		relativePath := path.Join(relativeDirectory, fileInfo.Name())
+
+		if fileInfo.IsDir() {
+			if !remoteFiles.Recursive || depth >= maxDepth || receiver.isPostCopyLeftover(relativePath, fileInfo) {
+				slog.Info("Skipping directory", slog.String(utils.FileNameKey, relativePath))
+				continue
+			}
+
+			// A subdirectory we can't read is left for the next poll, and doesn't stop us copying everything else
+			subdirectoryFiles, err := receiver.listRemoteFiles(directory+"/"+fileInfo.Name(), relativePath, depth+1)
+			if err == nil {
+				files = append(files, subdirectoryFiles...)
+			}
+			continue
+		}
+
+		// Done markers are removed along with their file
+		if _, isDoneMarker := doneMarkers[strings.ToLower(fileInfo.Name())]; isDoneMarker {
+			continue
+		}
+
+		// A manifest next to a file is read along with the file. If the file hasn't arrived yet, the manifest waits
+		// for it
+		if zip.IsSidecarManifestName(fileInfo.Name()) {
+			slog.Info("Skipping manifest, which is read with its file", slog.String(utils.FileNameKey, relativePath))
+			continue
+		}
+
+		if receiver.isAcknowledgementFile(fileInfo.Name()) {
+			slog.Info("Skipping acknowledgement we wrote", slog.String(utils.FileNameKey, relativePath))
+			continue
+		}
+
+		if !isWantedFile(relativePath, remoteFiles) {
+			slog.Info("Skipping file that doesn't match the partner's file patterns", slog.String(utils.FileNameKey, relativePath))
+			continue
+		}
+
+		if receiver.isPostCopyLeftover(relativePath, fileInfo) {
+			slog.Info("Skipping file we've already copied", slog.String(utils.FileNameKey, relativePath))
+			continue
+		}
+
+		if !receiver.hasFinishedUploading(fileInfo, doneMarkers) {
+			continue
+		}
+
+		file := remoteFile{
+			fileInfo:          fileInfo,
+			directory:         directory,
+			relativeDirectory: relativeDirectory,
+			doneMarkerName:    receiver.doneMarkerName(fileInfo.Name(), doneMarkers),
+		}
+		for _, extension := range zip.SidecarManifestExtensions {
+			if fileNames[fileInfo.Name()+extension] {
+				file.manifestName = fileInfo.Name() + extension
+				break
+			}
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// isWantedFile checks a file's path relative to the starting directory against the partner's include and exclude
+// patterns
+func isWantedFile(relativePath string, remoteFiles config.RemoteFileSettings) bool {
+	for _, pattern := range remoteFiles.Exclude {
+		if matchesFilePattern(pattern, relativePath) {
+			return false
+		}
+	}
+
+	if len(remoteFiles.Include) == 0 {
+		return true
+	}
+	for _, pattern := range remoteFiles.Include {
+		if matchesFilePattern(pattern, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFilePattern matches a `regex:` pattern against the file's relative path. Globs with a `/` are matched
+// against the relative path, and other globs against the file name, so `.DS_Store` matches in every directory
+func matchesFilePattern(pattern string, relativePath string) bool {
+	if expression, isRegex := strings.CutPrefix(pattern, config.RegexPatternPrefix); isRegex {
+		matched, err := regexp.MatchString(expression, relativePath)
+		return err == nil && matched
+	}
+
+	name := path.Base(relativePath)
+	if strings.Contains(pattern, "/") {
+		name = relativePath
+	}
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
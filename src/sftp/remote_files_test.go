@@ -0,0 +1,129 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"os"
+	"testing"
+)
+
+func Test_isWantedFile_NoPatterns_ReturnsTrue(t *testing.T) {
+	assert.True(t, isWantedFile("orders/order.hl7", config.RemoteFileSettings{}))
+}
+
+func Test_isWantedFile_MatchesIncludeAndExcludePatterns(t *testing.T) {
+	remoteFiles := config.RemoteFileSettings{
+		Include: []string{"*.hl7", `regex:^results_\d+\.zip$`},
+		Exclude: []string{".DS_Store", "archive/*", "*_partial.hl7"},
+	}
+
+	assert.True(t, isWantedFile("order.hl7", remoteFiles))
+	assert.True(t, isWantedFile("2024/order.hl7", remoteFiles))
+	assert.True(t, isWantedFile("results_20240601.zip", remoteFiles))
+	assert.False(t, isWantedFile("2024/results_20240601.zip", remoteFiles))
+	assert.False(t, isWantedFile("order_partial.hl7", remoteFiles))
+	assert.False(t, isWantedFile("archive/order.hl7", remoteFiles))
+	assert.False(t, isWantedFile("2024/.DS_Store", remoteFiles))
+	assert.False(t, isWantedFile("notes.txt", remoteFiles))
+}
+
+func Test_listRemoteFiles_NotRecursive_SkipsDirectories(t *testing.T) {
+	order := testFileInfo{name: "order.hl7"}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{order, testFileInfo{name: "2024", isDir: true}}, nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient}
+
+	files, err := sftpHandler.listRemoteFiles("dogcow", "", 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []remoteFile{{fileInfo: order, directory: "dogcow"}}, files)
+	mockSftpClient.AssertNumberOfCalls(t, "ReadDir", 1)
+}
+
+func Test_listRemoteFiles_Recursive_StopsAtMaxDepth(t *testing.T) {
+	order := testFileInfo{name: "order.hl7"}
+	june := testFileInfo{name: "june.hl7"}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{order, testFileInfo{name: "2024", isDir: true}}, nil)
+	mockSftpClient.On("ReadDir", "dogcow/2024").Return([]os.FileInfo{june, testFileInfo{name: "06", isDir: true}}, nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerSettings: config.PartnerSettings{RemoteFiles: config.RemoteFileSettings{Recursive: true, MaxDepth: 1}}}
+
+	files, err := sftpHandler.listRemoteFiles("dogcow", "", 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []remoteFile{
+		{fileInfo: order, directory: "dogcow"},
+		{fileInfo: june, directory: "dogcow/2024", relativeDirectory: "2024"},
+	}, files)
+	mockSftpClient.AssertNotCalled(t, "ReadDir", "dogcow/2024/06")
+}
+
+func Test_listRemoteFiles_SubdirectoryCantBeRead_ReturnsOtherFiles(t *testing.T) {
+	order := testFileInfo{name: "order.hl7"}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{testFileInfo{name: "locked", isDir: true}, order}, nil)
+	mockSftpClient.On("ReadDir", "dogcow/locked").Return([]os.FileInfo{}, errors.New("permission denied"))
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerSettings: config.PartnerSettings{RemoteFiles: config.RemoteFileSettings{Recursive: true}}}
+
+	files, err := sftpHandler.listRemoteFiles("dogcow", "", 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []remoteFile{{fileInfo: order, directory: "dogcow"}}, files)
+}
+
+func Test_listRemoteFiles_FileDoesNotMatchPatterns_SkipsFile(t *testing.T) {
+	order := testFileInfo{name: "order.hl7"}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{order, testFileInfo{name: ".DS_Store"}}, nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerSettings: config.PartnerSettings{RemoteFiles: config.RemoteFileSettings{Exclude: []string{".DS_Store"}}}}
+
+	files, err := sftpHandler.listRemoteFiles("dogcow", "", 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []remoteFile{{fileInfo: order, directory: "dogcow"}}, files)
+}
+
+func Test_copySingleFile_KeepDirectoryStructure_UploadsToSubfolder(t *testing.T) {
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/2024/order.hl7").Return(io.NopCloser(bytes.NewReader([]byte("MSH|order"))), nil)
+	mockSftpClient.On("Stat", "dogcow/2024/order.hl7").Return(testFileInfo{name: "order.hl7", size: 9}, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|order"), nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId,
+		partnerSettings: config.PartnerSettings{RemoteFiles: config.RemoteFileSettings{KeepDirectoryStructure: true}}}
+
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7"}, directory: "dogcow/2024", relativeDirectory: "2024"}, 1)
+
+	assertImported(t, mockBlobHandler, []byte("MSH|order"), "flexion/import/2024/order.hl7")
+	mockSftpClient.AssertCalled(t, "Remove", "dogcow/2024/order.hl7")
+}
+
+func Test_copySingleFile_DoNotKeepDirectoryStructure_UploadsToPartnerFolder(t *testing.T) {
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/2024/order.hl7").Return(io.NopCloser(bytes.NewReader([]byte("MSH|order"))), nil)
+	mockSftpClient.On("Stat", "dogcow/2024/order.hl7").Return(testFileInfo{name: "order.hl7", size: 9}, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|order"), nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId}
+
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7"}, directory: "dogcow/2024", relativeDirectory: "2024"}, 1)
+
+	assertImported(t, mockBlobHandler, []byte("MSH|order"), "flexion/import/order.hl7")
+}
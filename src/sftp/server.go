@@ -0,0 +1,224 @@
+package sftp
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
+	"github.com/CDCgov/reportstream-sftp-ingestion/storage"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/CDCgov/reportstream-sftp-ingestion/zip"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"log/slog"
+	"net"
+)
+
+// partnerIdExtension is where the SSH permissions carry the partner ID from logging in to the SFTP session
+const partnerIdExtension = "partnerId"
+
+// fileRouter is what the SFTP server needs to send an uploaded file through the same routing as the files we copy from
+// partners' SFTP servers
+type fileRouter interface {
+	routeFile(fileName string, relativeDirectory string, fileBytes []byte, readSidecarManifest func() (*zip.ManifestFile, error)) error
+}
+
+// SftpServer is our own SFTP server, for partners who push files to us instead of us polling theirs. Each partner logs
+// in as their partner ID with one of their public keys from Key Vault, and only sees their own write-only directory
+type SftpServer struct {
+	credentialGetter secrets.CredentialGetter
+	sshConfig        *ssh.ServerConfig
+	newFileRouter    func(partnerId string) (fileRouter, error)
+	newBlobHandler   func() (usecases.BlobHandler, error)
+	uploadLimiter    *uploadLimiter
+	routingBudget    *routingBudget
+}
+
+func NewSftpServer(credentialGetter secrets.CredentialGetter, acknowledgementQueue usecases.AcknowledgementQueue) (*SftpServer, error) {
+	hostPrivateKeyName := "sftp-server-host-private-key-" + utils.EnvironmentName() // pragma: allowlist secret
+	hostPrivateKey, err := credentialGetter.GetSecret(hostPrivateKeyName)
+	if err != nil {
+		slog.Error("Unable to get SFTP server host private key", slog.String("KeyName", hostPrivateKeyName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	hostSigner, err := ssh.ParsePrivateKey([]byte(hostPrivateKey))
+	if err != nil {
+		slog.Error("Unable to parse SFTP server host private key", slog.String("KeyName", hostPrivateKeyName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	server := &SftpServer{
+		credentialGetter: credentialGetter,
+		newFileRouter: func(partnerId string) (fileRouter, error) {
+			return NewPushedFileHandler(credentialGetter, partnerId, acknowledgementQueue)
+		},
+		newBlobHandler: func() (usecases.BlobHandler, error) {
+			return storage.NewAzureBlobHandler()
+		},
+		uploadLimiter: newUploadLimiter(maxConcurrentUploads),
+		routingBudget: newRoutingBudget(maxRoutingBytes),
+	}
+	server.sshConfig = &ssh.ServerConfig{PublicKeyCallback: server.authenticate}
+	server.sshConfig.AddHostKey(hostSigner)
+
+	return server, nil
+}
+
+// NewPushedFileHandler is an SftpHandler without a connection to a partner's SFTP server, for routing the files the
+// partner pushes to us over SFTP or HTTPS
+func NewPushedFileHandler(credentialGetter secrets.CredentialGetter, partnerId string, acknowledgementQueue usecases.AcknowledgementQueue) (*SftpHandler, error) {
+	blobHandler, err := storage.NewAzureBlobHandler()
+	if err != nil {
+		slog.Error("Failed to init Azure blob client", slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	zipHandler, err := zip.NewZipHandler(partnerId, acknowledgementQueue)
+	if err != nil {
+		slog.Error("Failed to init zip handler", slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	return &SftpHandler{
+		blobHandler:      blobHandler,
+		credentialGetter: credentialGetter,
+		zipHandler:       zipHandler,
+		partnerId:        partnerId,
+		partnerSettings:  config.Configs[partnerId].PartnerSettings,
+	}, nil
+}
+
+// ListenAndServe accepts SFTP connections on address, e.g. `:2222`, until the listener fails
+func (receiver *SftpServer) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		slog.Error("Failed to listen for SFTP connections", slog.Any(utils.ErrorKey, err), slog.String("address", address))
+		return err
+	}
+
+	slog.Info("SFTP server listening", slog.String("address", address))
+	return receiver.Serve(listener)
+}
+
+// Serve handles each connection on listener in its own goroutine. It returns nil once the listener is closed
+func (receiver *SftpServer) Serve(listener net.Listener) error {
+	for {
+		connection, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			slog.Error("Failed to accept SFTP connection", slog.Any(utils.ErrorKey, err))
+			return err
+		}
+
+		go receiver.handleConnection(connection)
+	}
+}
+
+// authenticate lets in partners whose config enables our SFTP server, using their partner ID as the username and one
+// of the public keys in their authorized keys secret. We read the secret on every login so rotated keys take effect
+// straight away
+func (receiver *SftpServer) authenticate(metadata ssh.ConnMetadata, publicKey ssh.PublicKey) (*ssh.Permissions, error) {
+	partnerId := metadata.User()
+	partnerConfig := config.Configs[partnerId]
+	if partnerConfig == nil || !partnerConfig.PartnerSettings.IsActive || !partnerConfig.PartnerSettings.SftpServer.Enabled {
+		slog.Warn("Login to SFTP server for a partner who can't use it", slog.String("partnerId", partnerId), slog.String("remoteAddress", metadata.RemoteAddr().String()))
+		return nil, errors.New("unknown user " + partnerId)
+	}
+
+	authorizedKeysName := partnerId + "-sftp-server-authorized-keys-" + utils.EnvironmentName() // pragma: allowlist secret
+	authorizedKeys, err := receiver.credentialGetter.GetSecret(authorizedKeysName)
+	if err != nil {
+		slog.Error("Unable to get partner's authorized keys for SFTP server", slog.String("KeyName", authorizedKeysName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	parsedKeys, err := parseHostKeys(authorizedKeys)
+	if err != nil {
+		slog.Error("Unable to parse partner's authorized keys for SFTP server", slog.String("KeyName", authorizedKeysName), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	if !containsKey(parsedKeys.keys, publicKey) {
+		slog.Warn("Partner's public key isn't one we expect", slog.String("partnerId", partnerId), slog.String("offered", ssh.FingerprintSHA256(publicKey)))
+		return nil, errors.New("public key not authorized for " + partnerId)
+	}
+
+	return &ssh.Permissions{Extensions: map[string]string{partnerIdExtension: partnerId}}, nil
+}
+
+func (receiver *SftpServer) handleConnection(connection net.Conn) {
+	defer connection.Close()
+
+	serverConnection, channels, requests, err := ssh.NewServerConn(connection, receiver.sshConfig)
+	if err != nil {
+		slog.Warn("Failed SSH handshake with SFTP client", slog.Any(utils.ErrorKey, err), slog.String("remoteAddress", connection.RemoteAddr().String()))
+		return
+	}
+	defer serverConnection.Close()
+	go ssh.DiscardRequests(requests)
+
+	partnerId := serverConnection.Permissions.Extensions[partnerIdExtension]
+	slog.Info("Partner connected to SFTP server", slog.String("partnerId", partnerId), slog.String("remoteAddress", connection.RemoteAddr().String()))
+
+	router, err := receiver.newFileRouter(partnerId)
+	if err != nil {
+		slog.Error("Failed to create file router for SFTP server", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+		return
+	}
+
+	blobHandler, err := receiver.newBlobHandler()
+	if err != nil {
+		slog.Error("Failed to init Azure blob client for SFTP server", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+		return
+	}
+	fileSystem := partnerFileSystem{
+		partnerId:     partnerId,
+		router:        router,
+		blobHandler:   blobHandler,
+		limiter:       receiver.uploadLimiter,
+		routingBudget: receiver.routingBudget,
+	}
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			slog.Error("Failed to accept SSH channel", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+			continue
+		}
+
+		go handleSession(channel, channelRequests, fileSystem)
+	}
+
+	slog.Info("Partner disconnected from SFTP server", slog.String("partnerId", partnerId))
+}
+
+// handleSession only starts the SFTP subsystem. Partners don't get a shell or anything else SSH offers
+func handleSession(channel ssh.Channel, requests <-chan *ssh.Request, fileSystem partnerFileSystem) {
+	defer channel.Close()
+
+	for request := range requests {
+		// The subsystem request's payload is the subsystem name as an SSH string, i.e. a four byte length first
+		isSftp := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+		_ = request.Reply(isSftp, nil)
+		if !isSftp {
+			continue
+		}
+
+		requestServer := sftp.NewRequestServer(channel, fileSystem.handlers())
+		err := requestServer.Serve()
+		if err != nil && !errors.Is(err, io.EOF) {
+			slog.Error("SFTP session ended with an error", slog.Any(utils.ErrorKey, err), slog.String("partnerId", fileSystem.partnerId))
+		}
+		_ = requestServer.Close()
+		return
+	}
+}
@@ -0,0 +1,389 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/CDCgov/reportstream-sftp-ingestion/zip"
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUploadBytes is the biggest file a partner can upload, since we route the whole file in memory once it's uploaded
+const maxUploadBytes = 1024 * 1024 * 1024
+
+// maxConcurrentUploads is how many files a partner can upload at once, across all their connections
+const maxConcurrentUploads = 4
+
+// uploadBlockBytes is how much of an upload we keep in memory before staging it as a block
+const uploadBlockBytes = 4 * 1024 * 1024
+
+// maxWriteAheadBytes is how much an upload can write past the bytes before it arrive. SFTP clients have a few
+// requests in flight at once, so writes can arrive a little out of order
+const maxWriteAheadBytes = 4 * 1024 * 1024
+
+// maxRoutingBytes is how much of all partners' uploads we route at once, see routingBudget
+const maxRoutingBytes = 2 * maxUploadBytes
+
+var errTooManyUploads = errors.New("too many uploads in progress, please wait for one to finish")
+
+var errUploadTooBig = errors.New("file is bigger than the 1 GiB we accept")
+
+var errNegativeOffset = errors.New("can't write before the start of a file")
+
+var errRewrite = errors.New("can't write over part of a file that's already uploaded")
+
+var errWriteAhead = errors.New("writes are too far out of order")
+
+var errMissingBytes = errors.New("file has bytes that were never written")
+
+// errImportFailed is what the partner's SFTP client sees when we couldn't import their file. The details are in our
+// logs rather than sent to the partner
+var errImportFailed = errors.New("failed to import file, please upload it again")
+
+// partnerFileSystem is what a partner sees on our SFTP server. Their root directory is their folder in blob storage,
+// and it's write-only: every directory looks empty and files can't be downloaded. Paths are cleaned against `/`, so
+// a partner can't reach outside their folder
+type partnerFileSystem struct {
+	partnerId     string
+	router        fileRouter
+	blobHandler   usecases.BlobHandler
+	limiter       *uploadLimiter
+	routingBudget *routingBudget
+}
+
+func (receiver partnerFileSystem) handlers() sftp.Handlers {
+	return sftp.Handlers{FileGet: receiver, FilePut: receiver, FileCmd: receiver, FileList: receiver}
+}
+
+func (receiver partnerFileSystem) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	slog.Warn("Partner tried to download from SFTP server", slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, request.Filepath))
+	return nil, os.ErrPermission
+}
+
+// Filewrite streams the upload into a staging blob until the partner closes it, see uploadedFile
+func (receiver partnerFileSystem) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	filePath := path.Clean("/" + request.Filepath)
+	fileName := path.Base(filePath)
+
+	// We only read manifests from next to files on partners' SFTP servers. A pushed archive has to include its own
+	if zip.IsSidecarManifestName(fileName) {
+		slog.Warn("Partner uploaded a manifest on its own to SFTP server", slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, filePath))
+		return nil, os.ErrPermission
+	}
+
+	if !receiver.limiter.acquire(receiver.partnerId) {
+		slog.Warn("Partner has too many uploads in progress on SFTP server", slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, filePath))
+		return nil, errTooManyUploads
+	}
+
+	slog.Info("Partner is uploading file to SFTP server", slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, filePath))
+	return &uploadedFile{
+		router:            receiver.router,
+		blobHandler:       receiver.blobHandler,
+		routingBudget:     receiver.routingBudget,
+		partnerId:         receiver.partnerId,
+		fileName:          fileName,
+		relativeDirectory: strings.TrimPrefix(path.Dir(filePath), "/"),
+		stagingPath:       path.Join(receiver.partnerId, utils.StagingFolder, uuid.NewString()),
+		release: func() {
+			receiver.limiter.release(receiver.partnerId)
+		},
+	}, nil
+}
+
+// Filecmd accepts the commands clients send around an upload without changing anything, since every directory
+// exists and uploaded files are already imported. Other commands, like renaming, aren't supported
+func (receiver partnerFileSystem) Filecmd(request *sftp.Request) error {
+	switch request.Method {
+	case "Setstat", "Mkdir":
+		return nil
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (receiver partnerFileSystem) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	filePath := path.Clean("/" + request.Filepath)
+	switch request.Method {
+	case "List":
+		return fileInfoLister{}, nil
+	case "Stat":
+		// Files disappear into blob storage as soon as they're uploaded, so only the root directory is there
+		if filePath == "/" {
+			return fileInfoLister{directoryInfo{name: "/"}}, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// uploadedFile streams an upload into a staging blob a block at a time, so nothing with PHI is written to the
+// container's disk and only the latest block is in memory. Once the partner closes it, we route the staged file like a
+// file we copied from the partner's SFTP server. SFTP clients can write a little out of order, so writes past the end
+// of what we have wait in aheadWrites for the bytes before them
+type uploadedFile struct {
+	router            fileRouter
+	blobHandler       usecases.BlobHandler
+	routingBudget     *routingBudget
+	partnerId         string
+	fileName          string
+	relativeDirectory string
+	stagingPath       string
+	mutex             sync.Mutex
+	// block is the bytes after stagedBytes, which haven't been staged yet
+	block       []byte
+	stagedBytes int64
+	blockCount  int
+	aheadWrites map[int64][]byte
+	aheadBytes  int
+	transferErr error
+	// release frees the partner's upload slot once Close is done with the file
+	release func()
+}
+
+func (receiver *uploadedFile) WriteAt(data []byte, offset int64) (int, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	// Comparing the offset first means adding the length can't overflow
+	if offset > maxUploadBytes || int64(len(data)) > maxUploadBytes-offset {
+		return 0, errUploadTooBig
+	}
+	if offset < receiver.stagedBytes {
+		return 0, errRewrite
+	}
+
+	if offset > receiver.writtenBytes() {
+		if receiver.aheadBytes+len(data) > maxWriteAheadBytes {
+			return 0, errWriteAhead
+		}
+		if receiver.aheadWrites == nil {
+			receiver.aheadWrites = map[int64][]byte{}
+		}
+		// The SFTP server reuses data for the next request
+		receiver.aheadBytes += len(data) - len(receiver.aheadWrites[offset])
+		receiver.aheadWrites[offset] = bytes.Clone(data)
+		return len(data), nil
+	}
+
+	receiver.writeToBlock(data, offset)
+	receiver.writeAheadWrites()
+
+	if len(receiver.block) >= uploadBlockBytes {
+		err := receiver.stageBlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// writtenBytes is how much of the file we have without gaps, staged or not
+func (receiver *uploadedFile) writtenBytes() int64 {
+	return receiver.stagedBytes + int64(len(receiver.block))
+}
+
+// writeToBlock writes data at an offset between stagedBytes and writtenBytes
+func (receiver *uploadedFile) writeToBlock(data []byte, offset int64) {
+	blockOffset := offset - receiver.stagedBytes
+	if end := blockOffset + int64(len(data)); end > int64(len(receiver.block)) {
+		receiver.block = append(receiver.block, make([]byte, end-int64(len(receiver.block)))...)
+	}
+	copy(receiver.block[blockOffset:], data)
+}
+
+// writeAheadWrites moves the writes that were waiting on the bytes we now have into the block
+func (receiver *uploadedFile) writeAheadWrites() {
+	for wroteAny := true; wroteAny; {
+		wroteAny = false
+		for offset, data := range receiver.aheadWrites {
+			if offset <= receiver.writtenBytes() {
+				receiver.writeToBlock(data, offset)
+				delete(receiver.aheadWrites, offset)
+				receiver.aheadBytes -= len(data)
+				wroteAny = true
+			}
+		}
+	}
+}
+
+func (receiver *uploadedFile) stageBlock() error {
+	err := receiver.blobHandler.StageBlock(receiver.stagingPath, receiver.blockCount, receiver.block)
+	if err != nil {
+		slog.Error("Failed to stage upload to SFTP server", slog.Any(utils.ErrorKey, err), slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, receiver.fileName))
+		return err
+	}
+
+	receiver.blockCount++
+	receiver.stagedBytes += int64(len(receiver.block))
+	receiver.block = nil
+	return nil
+}
+
+// TransferError is called instead of us getting the rest of the file when the upload fails part way, like when the
+// partner's connection drops. Close then leaves the blocks uncommitted, for Azure to throw away, rather than importing
+// half a file
+func (receiver *uploadedFile) TransferError(err error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	receiver.transferErr = err
+}
+
+// Close commits the staged blob and routes the file. When that fails, the partner's client gets an error so they know
+// to upload it again
+func (receiver *uploadedFile) Close() error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	if receiver.release != nil {
+		defer receiver.release()
+	}
+
+	if receiver.transferErr != nil {
+		slog.Warn("Upload to SFTP server was aborted, so not importing it", slog.Any(utils.ErrorKey, receiver.transferErr), slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, receiver.fileName))
+		return receiver.transferErr
+	}
+	if len(receiver.aheadWrites) > 0 {
+		slog.Warn("Upload to SFTP server has gaps, so not importing it", slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, receiver.fileName), slog.Int64("writtenBytes", receiver.writtenBytes()))
+		return errMissingBytes
+	}
+
+	if len(receiver.block) > 0 {
+		err := receiver.stageBlock()
+		if err != nil {
+			return errImportFailed
+		}
+	}
+	err := receiver.blobHandler.CommitBlocks(receiver.stagingPath, receiver.blockCount)
+	if err != nil {
+		slog.Error("Failed to commit upload to SFTP server", slog.Any(utils.ErrorKey, err), slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, receiver.fileName))
+		return errImportFailed
+	}
+	defer func() {
+		err := receiver.blobHandler.DeleteFile(receiver.stagingPath)
+		if err != nil {
+			slog.Warn("Failed to remove staged upload", slog.Any(utils.ErrorKey, err), slog.String("stagingPath", receiver.stagingPath))
+		}
+	}()
+
+	receiver.routingBudget.acquire(receiver.stagedBytes)
+	defer receiver.routingBudget.release(receiver.stagedBytes)
+
+	fileBytes, err := receiver.blobHandler.FetchFileByUrl(path.Join(utils.ContainerName, receiver.stagingPath))
+	if err != nil {
+		slog.Error("Failed to read staged upload to SFTP server", slog.Any(utils.ErrorKey, err), slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, receiver.fileName))
+		return errImportFailed
+	}
+
+	err = receiver.router.routeFile(receiver.fileName, receiver.relativeDirectory, fileBytes, nil)
+	if err != nil {
+		slog.Error("Failed to import file uploaded to SFTP server", slog.Any(utils.ErrorKey, err), slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, receiver.fileName))
+		return errImportFailed
+	}
+
+	slog.Info("Imported file uploaded to SFTP server", slog.String("partnerId", receiver.partnerId), slog.String(utils.FileNameKey, receiver.fileName), slog.Int("size", len(fileBytes)))
+	return nil
+}
+
+// routingBudget holds the bytes of all partners' uploads we route at once to maxRoutingBytes, since routing needs the
+// whole file in memory. Uploads wait for their turn rather than failing
+type routingBudget struct {
+	mutex     sync.Mutex
+	available *sync.Cond
+	maximum   int64
+	inUse     int64
+}
+
+func newRoutingBudget(maximum int64) *routingBudget {
+	budget := &routingBudget{maximum: maximum}
+	budget.available = sync.NewCond(&budget.mutex)
+	return budget
+}
+
+// acquire waits until there's room for size more bytes. A file bigger than the whole budget only waits for the others
+// to finish
+func (receiver *routingBudget) acquire(size int64) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	for receiver.inUse > 0 && receiver.inUse+size > receiver.maximum {
+		receiver.available.Wait()
+	}
+	receiver.inUse += size
+}
+
+func (receiver *routingBudget) release(size int64) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	receiver.inUse -= size
+	receiver.available.Broadcast()
+}
+
+// uploadLimiter counts each partner's uploads in progress, so we can hold them to maxConcurrentUploads
+type uploadLimiter struct {
+	mutex      sync.Mutex
+	maximum    int
+	inProgress map[string]int
+}
+
+func newUploadLimiter(maximum int) *uploadLimiter {
+	return &uploadLimiter{maximum: maximum, inProgress: map[string]int{}}
+}
+
+// acquire takes one of the partner's upload slots, or returns false if they're all in use
+func (receiver *uploadLimiter) acquire(partnerId string) bool {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	if receiver.inProgress[partnerId] >= receiver.maximum {
+		return false
+	}
+	receiver.inProgress[partnerId]++
+	return true
+}
+
+func (receiver *uploadLimiter) release(partnerId string) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	receiver.inProgress[partnerId]--
+	if receiver.inProgress[partnerId] <= 0 {
+		delete(receiver.inProgress, partnerId)
+	}
+}
+
+type fileInfoLister []os.FileInfo
+
+func (receiver fileInfoLister) ListAt(fileInfos []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(receiver)) {
+		return 0, io.EOF
+	}
+	count := copy(fileInfos, receiver[offset:])
+	if count < len(fileInfos) {
+		return count, io.EOF
+	}
+	return count, nil
+}
+
+type directoryInfo struct {
+	name string
+}
+
+func (receiver directoryInfo) Name() string       { return receiver.name }
+func (receiver directoryInfo) Size() int64        { return 0 }
+func (receiver directoryInfo) Mode() os.FileMode  { return os.ModeDir | 0700 }
+func (receiver directoryInfo) ModTime() time.Time { return time.Time{} }
+func (receiver directoryInfo) IsDir() bool        { return true }
+func (receiver directoryInfo) Sys() any           { return nil }
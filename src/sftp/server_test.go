@@ -0,0 +1,406 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config/configtest"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/CDCgov/reportstream-sftp-ingestion/zip"
+	"github.com/google/uuid"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/ssh"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const sftpServerHostKeyName = "sftp-server-host-private-key-local"               // pragma: allowlist secret
+const sftpServerAuthorizedKeysName = "flexion-sftp-server-authorized-keys-local" // pragma: allowlist secret
+
+func Test_SftpServer_PartnerUploadsFile_RoutesFileWhenClosed(t *testing.T) {
+	mockFileRouter := &MockFileRouter{}
+	mockFileRouter.On("routeFile", "order.hl7", "2024/06", []byte("MSH|order")).Return(nil)
+	sftpClient := connectToTestServer(t, mockFileRouter)
+
+	file, err := sftpClient.Create("/2024/06/order.hl7")
+	assert.NoError(t, err)
+	_, err = file.Write([]byte("MSH|order"))
+	assert.NoError(t, err)
+	mockFileRouter.AssertNotCalled(t, "routeFile", mock.Anything, mock.Anything, mock.Anything)
+
+	assert.NoError(t, file.Close())
+	mockFileRouter.AssertExpectations(t)
+}
+
+func Test_SftpServer_RoutingFails_ClientGetsError(t *testing.T) {
+	mockFileRouter := &MockFileRouter{}
+	mockFileRouter.On("routeFile", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("blob storage is down"))
+	sftpClient := connectToTestServer(t, mockFileRouter)
+
+	file, err := sftpClient.Create("order.hl7")
+	assert.NoError(t, err)
+	_, err = file.Write([]byte("MSH|order"))
+	assert.NoError(t, err)
+
+	err = file.Close()
+	assert.ErrorContains(t, err, "failed to import file")
+}
+
+func Test_SftpServer_PartnerHasTooManyUploadsOpen_RejectsAnother(t *testing.T) {
+	mockFileRouter := &MockFileRouter{}
+	mockFileRouter.On("routeFile", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	sftpClient := connectToTestServer(t, mockFileRouter)
+
+	var files []*sftp.File
+	for range maxConcurrentUploads {
+		file, err := sftpClient.Create("order.hl7")
+		assert.NoError(t, err)
+		files = append(files, file)
+	}
+
+	_, err := sftpClient.Create("order.hl7")
+	assert.Error(t, err)
+
+	assert.NoError(t, files[0].Close())
+	file, err := sftpClient.Create("order.hl7")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+}
+
+func Test_SftpServer_PathLeavesRootDirectory_StaysInPartnerFolder(t *testing.T) {
+	mockFileRouter := &MockFileRouter{}
+	mockFileRouter.On("routeFile", "order.hl7", "ca-phl", mock.Anything).Return(nil)
+	sftpClient := connectToTestServer(t, mockFileRouter)
+
+	file, err := sftpClient.Create("../../ca-phl/order.hl7")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	mockFileRouter.AssertExpectations(t)
+}
+
+func Test_SftpServer_PartnerTriesToDownload_PermissionDenied(t *testing.T) {
+	sftpClient := connectToTestServer(t, &MockFileRouter{})
+
+	_, err := sftpClient.Open("order.hl7")
+
+	assert.Error(t, err)
+	entries, err := sftpClient.ReadDir("/")
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func Test_SftpServer_PublicKeyIsNotAuthorized_RejectsLogin(t *testing.T) {
+	setUpSftpServerConfig(t, true)
+	address := startTestServer(t, newHostSigner(t), &MockFileRouter{})
+
+	_, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            partnerId,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(newHostSigner(t))},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+
+	assert.Error(t, err)
+}
+
+func Test_SftpServer_PartnerHasNotEnabledSftpServer_RejectsLogin(t *testing.T) {
+	setUpSftpServerConfig(t, false)
+	clientSigner := newHostSigner(t)
+	address := startTestServer(t, clientSigner, &MockFileRouter{})
+
+	_, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            partnerId,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+
+	assert.Error(t, err)
+}
+
+func Test_NewSftpServer_UnableToGetHostKey_ReturnsError(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", sftpServerHostKeyName).Return("", errors.New("not found"))
+
+	server, err := NewSftpServer(mockCredentialGetter, nil)
+
+	assert.Error(t, err)
+	assert.Nil(t, server)
+}
+
+func Test_uploadedFile_WritesOutOfOrder_RoutesBytesInOrder(t *testing.T) {
+	mockFileRouter := &MockFileRouter{}
+	mockFileRouter.On("routeFile", "order.hl7", "", []byte("MSH|order")).Return(nil)
+	file := newTestUploadedFile(mockFileRouter, newStagedBlobHandler())
+
+	_, err := file.WriteAt([]byte("der"), 6)
+	assert.NoError(t, err)
+	_, err = file.WriteAt([]byte("or"), 4)
+	assert.NoError(t, err)
+	_, err = file.WriteAt([]byte("MSH|"), 0)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []byte("MSH|order"), file.block)
+	assert.Empty(t, file.aheadWrites)
+	assert.NoError(t, file.Close())
+	mockFileRouter.AssertExpectations(t)
+}
+
+func Test_uploadedFile_BlockIsFull_StagesBlock(t *testing.T) {
+	blobHandler := newStagedBlobHandler()
+	file := newTestUploadedFile(&MockFileRouter{}, blobHandler)
+
+	_, err := file.WriteAt(bytes.Repeat([]byte("M"), uploadBlockBytes), 0)
+
+	assert.NoError(t, err)
+	assert.Empty(t, file.block)
+	assert.Len(t, blobHandler.blocks[file.stagingPath], 1)
+
+	_, err = file.WriteAt([]byte("MSH|"), 0)
+	assert.ErrorIs(t, err, errRewrite)
+}
+
+func Test_uploadedFile_OffsetIsOutOfRange_ReturnsErrorWithoutPanicking(t *testing.T) {
+	file := &uploadedFile{}
+
+	_, err := file.WriteAt([]byte("MSH|"), -1)
+	assert.ErrorIs(t, err, errNegativeOffset)
+
+	_, err = file.WriteAt([]byte("MSH|"), math.MaxInt64-1)
+	assert.ErrorIs(t, err, errUploadTooBig)
+
+	_, err = file.WriteAt([]byte("x"), maxUploadBytes)
+	assert.ErrorIs(t, err, errUploadTooBig)
+	assert.Empty(t, file.block)
+}
+
+func Test_uploadedFile_WritesTooFarAhead_ReturnsError(t *testing.T) {
+	file := &uploadedFile{}
+
+	_, err := file.WriteAt(make([]byte, maxWriteAheadBytes), 1)
+	assert.NoError(t, err)
+	_, err = file.WriteAt([]byte("x"), maxWriteAheadBytes+1)
+
+	assert.ErrorIs(t, err, errWriteAhead)
+}
+
+func Test_uploadedFile_FileHasGap_DoesNotRouteFile(t *testing.T) {
+	mockFileRouter := &MockFileRouter{}
+	blobHandler := newStagedBlobHandler()
+	file := newTestUploadedFile(mockFileRouter, blobHandler)
+	_, err := file.WriteAt([]byte("order"), 4)
+	assert.NoError(t, err)
+
+	err = file.Close()
+
+	assert.ErrorIs(t, err, errMissingBytes)
+	assert.Empty(t, blobHandler.blobs)
+	mockFileRouter.AssertNotCalled(t, "routeFile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_uploadedFile_TransferFailed_DoesNotRouteFile(t *testing.T) {
+	mockFileRouter := &MockFileRouter{}
+	blobHandler := newStagedBlobHandler()
+	file := newTestUploadedFile(mockFileRouter, blobHandler)
+	_, err := file.WriteAt([]byte("MSH|"), 0)
+	assert.NoError(t, err)
+
+	file.TransferError(errors.New("connection lost"))
+	err = file.Close()
+
+	assert.Error(t, err)
+	assert.Empty(t, blobHandler.blobs)
+	mockFileRouter.AssertNotCalled(t, "routeFile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_uploadedFile_Routed_RemovesStagedBlob(t *testing.T) {
+	mockFileRouter := &MockFileRouter{}
+	mockFileRouter.On("routeFile", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	blobHandler := newStagedBlobHandler()
+	file := newTestUploadedFile(mockFileRouter, blobHandler)
+	_, err := file.WriteAt([]byte("MSH|order"), 0)
+	assert.NoError(t, err)
+
+	assert.NoError(t, file.Close())
+
+	assert.True(t, strings.HasPrefix(file.stagingPath, partnerId+"/staging/"))
+	assert.Empty(t, blobHandler.blobs)
+}
+
+func Test_routingBudget_BudgetIsUsed_WaitsForRelease(t *testing.T) {
+	budget := newRoutingBudget(10)
+	budget.acquire(8)
+
+	acquired := make(chan bool)
+	go func() {
+		budget.acquire(5)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		assert.Fail(t, "acquired more than the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	budget.release(8)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		assert.Fail(t, "didn't acquire after release")
+	}
+}
+
+func Test_routingBudget_FileIsBiggerThanBudget_DoesNotWaitForever(t *testing.T) {
+	budget := newRoutingBudget(10)
+
+	budget.acquire(20)
+	budget.release(20)
+
+	assert.Zero(t, budget.inUse)
+}
+
+func setUpSftpServerConfig(t *testing.T, enabled bool) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{partnerId: {
+		IsActive:   true,
+		SftpServer: config.SftpServerSettings{Enabled: enabled},
+	}})
+}
+
+// startTestServer runs our SFTP server on a random local port, with clientSigner's public key as the partner's
+// authorized key
+func startTestServer(t *testing.T, clientSigner ssh.Signer, router fileRouter) string {
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	hostKeyBlock, err := ssh.MarshalPrivateKey(hostPrivateKey, "")
+	assert.NoError(t, err)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", sftpServerHostKeyName).Return(string(pem.EncodeToMemory(hostKeyBlock)), nil)
+	mockCredentialGetter.On("GetSecret", sftpServerAuthorizedKeysName).Return("# partner's keys\n"+string(ssh.MarshalAuthorizedKey(clientSigner.PublicKey())), nil)
+
+	server, err := NewSftpServer(mockCredentialGetter, nil)
+	assert.NoError(t, err)
+	server.newFileRouter = func(partnerId string) (fileRouter, error) {
+		return router, nil
+	}
+	server.newBlobHandler = func() (usecases.BlobHandler, error) {
+		return newStagedBlobHandler(), nil
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	return listener.Addr().String()
+}
+
+func connectToTestServer(t *testing.T, router fileRouter) *sftp.Client {
+	setUpSftpServerConfig(t, true)
+	clientSigner := newHostSigner(t)
+	address := startTestServer(t, clientSigner, router)
+
+	sshClient, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            partnerId,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.NoError(t, err)
+
+	sftpClient, err := sftp.NewClient(sshClient)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = sftpClient.Close()
+		_ = sshClient.Close()
+	})
+
+	return sftpClient
+}
+
+type MockFileRouter struct {
+	mock.Mock
+}
+
+func (receiver *MockFileRouter) routeFile(fileName string, relativeDirectory string, fileBytes []byte, readSidecarManifest func() (*zip.ManifestFile, error)) error {
+	args := receiver.Called(fileName, relativeDirectory, fileBytes)
+	return args.Error(0)
+}
+
+func newTestUploadedFile(router fileRouter, blobHandler usecases.BlobHandler) *uploadedFile {
+	return &uploadedFile{
+		router:        router,
+		blobHandler:   blobHandler,
+		routingBudget: newRoutingBudget(maxRoutingBytes),
+		partnerId:     partnerId,
+		fileName:      "order.hl7",
+		stagingPath:   partnerId + "/staging/" + uuid.NewString(),
+	}
+}
+
+// stagedBlobHandler keeps the blocks our SFTP server stages in memory, and serves them back once they're committed
+type stagedBlobHandler struct {
+	mocks.MockBlobHandler
+	mutex  sync.Mutex
+	blocks map[string][][]byte
+	blobs  map[string][]byte
+}
+
+func newStagedBlobHandler() *stagedBlobHandler {
+	return &stagedBlobHandler{blocks: map[string][][]byte{}, blobs: map[string][]byte{}}
+}
+
+func (receiver *stagedBlobHandler) StageBlock(blobPath string, blockNumber int, blockBytes []byte) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	blocks := receiver.blocks[blobPath]
+	for len(blocks) <= blockNumber {
+		blocks = append(blocks, nil)
+	}
+	blocks[blockNumber] = bytes.Clone(blockBytes)
+	receiver.blocks[blobPath] = blocks
+	return nil
+}
+
+func (receiver *stagedBlobHandler) CommitBlocks(blobPath string, blockCount int) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	receiver.blobs[blobPath] = bytes.Join(receiver.blocks[blobPath][:blockCount], nil)
+	delete(receiver.blocks, blobPath)
+	return nil
+}
+
+func (receiver *stagedBlobHandler) FetchFileByUrl(sourceUrl string) ([]byte, error) {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	blob, ok := receiver.blobs[strings.TrimPrefix(sourceUrl, utils.ContainerName+"/")]
+	if !ok {
+		return nil, errors.New("blob not found")
+	}
+	return blob, nil
+}
+
+func (receiver *stagedBlobHandler) DeleteFile(blobPath string) error {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	delete(receiver.blobs, blobPath)
+	return nil
+}
@@ -0,0 +1,130 @@
+package sftp
+
+import (
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// waitForListing is how we wait between directory listings when checking file sizes, so tests don't have to
+var waitForListing = time.Sleep
+
+// readyFiles lists the files to copy from startingDirectory, see listRemoteFiles, and leaves out the ones whose size
+// changes while we wait, according to the partner's StableFileSettings. We wait once per poll, however many
+// directories the files are in
+func (receiver *SftpHandler) readyFiles(startingDirectory string) ([]remoteFile, error) {
+	files, err := receiver.listRemoteFiles(startingDirectory, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	unchangedSizeSeconds := receiver.partnerSettings.StableFile.UnchangedSizeSeconds
+	if unchangedSizeSeconds > 0 && len(files) > 0 {
+		files = receiver.unchangedFiles(files, time.Duration(unchangedSizeSeconds)*time.Second)
+	}
+	return files, nil
+}
+
+// hasFinishedUploading checks a file against the partner's StableFileSettings that don't need a second listing.
+// doneMarkers is from doneMarkerNames for the file's directory
+func (receiver *SftpHandler) hasFinishedUploading(fileInfo os.FileInfo, doneMarkers map[string]string) bool {
+	stableFile := receiver.partnerSettings.StableFile
+	name := fileInfo.Name()
+
+	if isTempName(name, stableFile.TempNamePatterns) {
+		slog.Info("Skipping file with a temporary name", slog.String(utils.FileNameKey, name))
+		return false
+	}
+	if len(stableFile.DoneMarkerExtensions) > 0 && receiver.doneMarkerName(name, doneMarkers) == "" {
+		slog.Info("Skipping file without a done marker", slog.String(utils.FileNameKey, name))
+		return false
+	}
+	if age := time.Since(fileInfo.ModTime()); age < time.Duration(stableFile.MinAgeSeconds)*time.Second {
+		slog.Info("Skipping file that was modified too recently", slog.String(utils.FileNameKey, name), slog.Duration("age", age))
+		return false
+	}
+	return true
+}
+
+// unchangedFiles lists each of the files' directories again after a wait, and only keeps the files whose size and
+// modified time haven't changed
+func (receiver *SftpHandler) unchangedFiles(files []remoteFile, wait time.Duration) []remoteFile {
+	waitForListing(wait)
+
+	laterFiles := map[string]os.FileInfo{}
+	listedDirectories := map[string]bool{}
+	var unchanged []remoteFile
+	for _, file := range files {
+		if !listedDirectories[file.directory] {
+			listedDirectories[file.directory] = true
+			laterFileInfos, err := receiver.sftpClient.ReadDir(file.directory)
+			if err != nil {
+				slog.Error("Failed to read directory to check file sizes", slog.Any(utils.ErrorKey, err), slog.String("directory", file.directory))
+			}
+			for _, laterFileInfo := range laterFileInfos {
+				laterFiles[file.directory+"/"+laterFileInfo.Name()] = laterFileInfo
+			}
+		}
+
+		laterFileInfo, ok := laterFiles[file.fullPath()]
+		if !ok || laterFileInfo.Size() != file.fileInfo.Size() || !laterFileInfo.ModTime().Equal(file.fileInfo.ModTime()) {
+			slog.Info("Skipping file that's still changing", slog.String(utils.FileNameKey, file.fullPath()))
+			continue
+		}
+		unchanged = append(unchanged, file)
+	}
+
+	return unchanged
+}
+
+// doneMarkerNames maps the lower case names of the done markers in a directory to their names. Markers are matched
+// without case, so `results.hl7.DONE` marks `results.hl7` when the partner's extension is `.done`
+func (receiver *SftpHandler) doneMarkerNames(fileInfos []os.FileInfo) map[string]string {
+	doneMarkers := map[string]string{}
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() && receiver.isDoneMarker(fileInfo.Name()) {
+			doneMarkers[strings.ToLower(fileInfo.Name())] = fileInfo.Name()
+		}
+	}
+	return doneMarkers
+}
+
+func (receiver *SftpHandler) isDoneMarker(name string) bool {
+	for _, extension := range receiver.partnerSettings.StableFile.DoneMarkerExtensions {
+		if strings.HasSuffix(strings.ToLower(name), strings.ToLower(extension)) {
+			return true
+		}
+	}
+	return false
+}
+
+// doneMarkerName is the name of the done marker next to a file, or empty when there isn't one
+func (receiver *SftpHandler) doneMarkerName(name string, doneMarkers map[string]string) string {
+	for _, extension := range receiver.partnerSettings.StableFile.DoneMarkerExtensions {
+		if doneMarker, ok := doneMarkers[strings.ToLower(name+extension)]; ok {
+			return doneMarker
+		}
+	}
+	return ""
+}
+
+func isTempName(name string, tempNamePatterns []string) bool {
+	for _, pattern := range tempNamePatterns {
+		matched, _ := path.Match(pattern, name)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func fileNameSet(fileInfos []os.FileInfo) map[string]bool {
+	fileNames := make(map[string]bool, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		fileNames[fileInfo.Name()] = true
+	}
+	return fileNames
+}
@@ -0,0 +1,170 @@
+package sftp
+
+import (
+	"bytes"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_readyFiles_NoSettings_ReturnsEveryFile(t *testing.T) {
+	order := testFileInfo{name: "order.hl7", modTime: time.Now()}
+	results := testFileInfo{name: "results.tmp", modTime: time.Now()}
+	sftpHandler := SftpHandler{sftpClient: listingSftpClient(order, results)}
+
+	assert.Equal(t, []os.FileInfo{order, results}, readyFileInfos(t, sftpHandler))
+}
+
+func Test_readyFiles_FileHasTempName_SkipsFile(t *testing.T) {
+	order := testFileInfo{name: "order.hl7"}
+	sftpHandler := SftpHandler{sftpClient: listingSftpClient(order, testFileInfo{name: "results.zip.tmp"}, testFileInfo{name: ".results.zip"}),
+		partnerSettings: config.PartnerSettings{StableFile: config.StableFileSettings{TempNamePatterns: []string{"*.tmp", ".*"}}}}
+
+	assert.Equal(t, []os.FileInfo{order}, readyFileInfos(t, sftpHandler))
+}
+
+func Test_readyFiles_DoneMarkersAreRequired_ReturnsOnlyMarkedFiles(t *testing.T) {
+	order := testFileInfo{name: "order.hl7"}
+	sftpHandler := SftpHandler{sftpClient: listingSftpClient(order, testFileInfo{name: "order.hl7.done"}, testFileInfo{name: "results.zip"}, testFileInfo{name: "orphan.hl7.ok"}),
+		partnerSettings: config.PartnerSettings{StableFile: config.StableFileSettings{DoneMarkerExtensions: []string{".done", ".ok"}}}}
+
+	assert.Equal(t, []os.FileInfo{order}, readyFileInfos(t, sftpHandler))
+}
+
+func Test_readyFiles_DoneMarkerIsUpperCase_ReturnsMarkedFile(t *testing.T) {
+	results := testFileInfo{name: "results.hl7"}
+	sftpHandler := SftpHandler{sftpClient: listingSftpClient(results, testFileInfo{name: "results.hl7.DONE"}),
+		partnerSettings: config.PartnerSettings{StableFile: config.StableFileSettings{DoneMarkerExtensions: []string{".done"}}}}
+
+	files, err := sftpHandler.readyFiles("dogcow")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []remoteFile{{fileInfo: results, directory: "dogcow", doneMarkerName: "results.hl7.DONE"}}, files)
+}
+
+func Test_readyFiles_FileIsTooNew_SkipsFile(t *testing.T) {
+	oldFile := testFileInfo{name: "old.hl7", modTime: time.Now().Add(-10 * time.Minute)}
+	sftpHandler := SftpHandler{sftpClient: listingSftpClient(oldFile, testFileInfo{name: "new.hl7", modTime: time.Now()}),
+		partnerSettings: config.PartnerSettings{StableFile: config.StableFileSettings{MinAgeSeconds: 300}}}
+
+	assert.Equal(t, []os.FileInfo{oldFile}, readyFileInfos(t, sftpHandler))
+}
+
+func Test_readyFiles_FileSizeChangesBetweenListings_SkipsFile(t *testing.T) {
+	defer stubWaitForListing()()
+	modTime := time.Now().Add(-time.Hour)
+	done := testFileInfo{name: "done.hl7", size: 10, modTime: modTime}
+	growing := testFileInfo{name: "growing.hl7", size: 10, modTime: modTime}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{done, growing}, nil).Once()
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{done, testFileInfo{name: "growing.hl7", size: 20, modTime: modTime}}, nil).Once()
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerSettings: config.PartnerSettings{StableFile: config.StableFileSettings{UnchangedSizeSeconds: 5}}}
+
+	assert.Equal(t, []os.FileInfo{done}, readyFileInfos(t, sftpHandler))
+}
+
+func Test_readyFiles_SecondListingFails_ReturnsNoFiles(t *testing.T) {
+	defer stubWaitForListing()()
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{testFileInfo{name: "order.hl7"}}, nil).Once()
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{}, errors.New("connection lost")).Once()
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerSettings: config.PartnerSettings{StableFile: config.StableFileSettings{UnchangedSizeSeconds: 5}}}
+
+	assert.Empty(t, readyFileInfos(t, sftpHandler))
+}
+
+func Test_readyFiles_RecursiveWithUnchangedSize_WaitsOnceAndSkipsUnwantedFiles(t *testing.T) {
+	waits := 0
+	originalWaitForListing := waitForListing
+	waitForListing = func(time.Duration) { waits++ }
+	t.Cleanup(func() { waitForListing = originalWaitForListing })
+
+	order := testFileInfo{name: "order.hl7"}
+	june := testFileInfo{name: "june.hl7"}
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return([]os.FileInfo{order, testFileInfo{name: "notes.txt"}, testFileInfo{name: "2024", isDir: true}, testFileInfo{name: "empty", isDir: true}}, nil)
+	mockSftpClient.On("ReadDir", "dogcow/2024").Return([]os.FileInfo{june}, nil)
+	mockSftpClient.On("ReadDir", "dogcow/empty").Return([]os.FileInfo{testFileInfo{name: "notes.txt"}}, nil)
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, partnerSettings: config.PartnerSettings{
+		RemoteFiles: config.RemoteFileSettings{Recursive: true, Include: []string{"*.hl7"}},
+		StableFile:  config.StableFileSettings{UnchangedSizeSeconds: 5},
+	}}
+
+	assert.Equal(t, []os.FileInfo{order, june}, readyFileInfos(t, sftpHandler))
+	assert.Equal(t, 1, waits)
+	mockSftpClient.AssertNumberOfCalls(t, "ReadDir", 5)
+}
+
+func Test_CopyFiles_FileHasDoneMarker_CopiesFileAndRemovesMarker(t *testing.T) {
+	fileBytes := []byte("MSH|order")
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", mock.Anything).Return([]os.FileInfo{testFileInfo{name: "order.hl7.done"}, testFileInfo{name: "order.hl7"}, testFileInfo{name: "result.hl7"}}, nil)
+	mockSftpClient.On("Open", "dogcow/order.hl7").Return(io.NopCloser(bytes.NewReader(fileBytes)), nil)
+	mockSftpClient.On("Stat", "dogcow/order.hl7").Return(testFileInfo{name: "order.hl7", size: int64(len(fileBytes))}, nil)
+	mockSftpClient.On("Remove", mock.Anything).Return(nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return(fileBytes, nil)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", mock.Anything).Return("dogcow", nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, credentialGetter: mockCredentialGetter, zipHandler: &MockZipHandler{},
+		partnerSettings: config.PartnerSettings{StableFile: config.StableFileSettings{DoneMarkerExtensions: []string{".done"}}}}
+
+	sftpHandler.CopyFiles()
+
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockSftpClient.AssertCalled(t, "Remove", "dogcow/order.hl7")
+	mockSftpClient.AssertCalled(t, "Remove", "dogcow/order.hl7.done")
+	mockSftpClient.AssertNotCalled(t, "Open", "dogcow/result.hl7")
+}
+
+// listingSftpClient lists fileInfos in the `dogcow` directory
+func listingSftpClient(fileInfos ...os.FileInfo) *MockSftpWrapper {
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("ReadDir", "dogcow").Return(fileInfos, nil)
+	return mockSftpClient
+}
+
+// readyFileInfos is the file infos of readyFiles for the `dogcow` directory
+func readyFileInfos(t *testing.T, sftpHandler SftpHandler) []os.FileInfo {
+	files, err := sftpHandler.readyFiles("dogcow")
+	assert.NoError(t, err)
+
+	var fileInfos []os.FileInfo
+	for _, file := range files {
+		fileInfos = append(fileInfos, file.fileInfo)
+	}
+	return fileInfos
+}
+
+// stubWaitForListing stops readyFiles from waiting between listings, and returns a function to restore it
+func stubWaitForListing() func() {
+	originalWaitForListing := waitForListing
+	waitForListing = func(time.Duration) {}
+	return func() { waitForListing = originalWaitForListing }
+}
+
+type testFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (receiver testFileInfo) Name() string       { return receiver.name }
+func (receiver testFileInfo) Size() int64        { return receiver.size }
+func (receiver testFileInfo) Mode() os.FileMode  { return 0600 }
+func (receiver testFileInfo) ModTime() time.Time { return receiver.modTime }
+func (receiver testFileInfo) IsDir() bool        { return receiver.isDir }
+func (receiver testFileInfo) Sys() any           { return nil }
@@ -0,0 +1,232 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"path"
+	"time"
+)
+
+// transferChunkBytes is how much we read from the SFTP server at a time. Files bigger than one chunk can resume
+// from where a dropped transfer stopped
+const transferChunkBytes = 8 * 1024 * 1024
+
+const partialTransfersFolder = "partial"
+
+// partialTransferRecord describes the bytes we saved from a transfer that didn't finish. They're only used to resume
+// the same file, i.e. one with the same size and modified time
+type partialTransferRecord struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Offset  int64     `json:"offset"`
+	Sha256  string    `json:"sha256"`
+}
+
+// transferFile reads a file from the SFTP server in chunks. When the transfer of a file bigger than one chunk fails
+// part way, we save what we have so the next poll resumes from there. Once we have the whole file, we check it against
+// the file's current size and modified time on the SFTP server, in case it changed while we were reading it
+func (receiver *SftpHandler) transferFile(file remoteFile) ([]byte, error) {
+	fullFilePath := file.fullPath()
+	resumable := file.fileInfo.Size() > transferChunkBytes
+
+	var fileBytes []byte
+	if resumable {
+		fileBytes = receiver.fetchPartialTransfer(file)
+	}
+	startingOffset := int64(len(fileBytes))
+
+	var fileReadCloser io.ReadCloser
+	var err error
+	if startingOffset > 0 {
+		slog.Info("Resuming transfer", slog.String(utils.FileNameKey, fullFilePath), slog.Int64("offset", startingOffset))
+		fileReadCloser, err = receiver.sftpClient.OpenAt(fullFilePath, startingOffset)
+	} else {
+		fileReadCloser, err = receiver.sftpClient.Open(fullFilePath)
+	}
+	if err != nil {
+		slog.Error("Failed to open file", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
+		return nil, err
+	}
+
+	slog.Info("file opened", slog.String(utils.FileNameKey, fullFilePath))
+
+	chunk := make([]byte, min(transferChunkBytes, max(file.fileInfo.Size(), bytes.MinRead)))
+	for {
+		var bytesRead int
+		bytesRead, err = io.ReadFull(fileReadCloser, chunk)
+		fileBytes = append(fileBytes, chunk[:bytesRead]...)
+		if err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		slog.Error("Failed to read file", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath), slog.Int("bytesRead", len(fileBytes)))
+		_ = fileReadCloser.Close()
+		if resumable && int64(len(fileBytes)) > startingOffset {
+			receiver.savePartialTransfer(file, fileBytes)
+		}
+		return nil, err
+	}
+
+	err = fileReadCloser.Close()
+	if err != nil {
+		slog.Error("Failed to close file after reading", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
+		return nil, err
+	}
+
+	remoteFileInfo, err := receiver.sftpClient.Stat(fullFilePath)
+	if err != nil {
+		slog.Error("Failed to check file after reading", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, fullFilePath))
+		return nil, err
+	}
+	if int64(len(fileBytes)) != remoteFileInfo.Size() || !remoteFileInfo.ModTime().Equal(file.fileInfo.ModTime()) {
+		slog.Error("File changed or was cut short while we read it, leaving it for the next poll", slog.String(utils.FileNameKey, fullFilePath),
+			slog.Int("bytesRead", len(fileBytes)), slog.Int64("remoteSize", remoteFileInfo.Size()))
+		return nil, errors.New("transferred size doesn't match the file on the SFTP server")
+	}
+
+	if startingOffset > 0 {
+		receiver.removePartialTransfer(file)
+	}
+
+	return fileBytes, nil
+}
+
+// uploadVerified uploads the file to a staging blob and reads it back, and only copies it to blobPath once it matches,
+// so we never remove the partner's file unless we have an identical copy. Files in `import` start processing as soon
+// as they're created, so checking them there could send a file we then upload again. The copy happens within blob
+// storage, so it's the same bytes we checked
+func (receiver *SftpHandler) uploadVerified(fileBytes []byte, blobPath string) error {
+	stagingPath := path.Join(receiver.partnerId, utils.StagingFolder, uuid.NewString())
+	err := receiver.blobHandler.UploadFile(fileBytes, stagingPath)
+	if err != nil {
+		slog.Error("Failed to upload file", slog.Any(utils.ErrorKey, err), slog.String("blobPath", blobPath))
+		return err
+	}
+	defer func() {
+		err := receiver.blobHandler.DeleteFile(stagingPath)
+		if err != nil {
+			slog.Warn("Failed to remove staged file", slog.Any(utils.ErrorKey, err), slog.String("stagingPath", stagingPath))
+		}
+	}()
+
+	err = receiver.verifyUpload(stagingPath, fileBytes)
+	if err != nil {
+		return err
+	}
+
+	err = receiver.blobHandler.CopyFile(stagingPath, blobPath)
+	if err != nil {
+		slog.Error("Failed to copy staged file", slog.Any(utils.ErrorKey, err), slog.String("stagingPath", stagingPath), slog.String("blobPath", blobPath))
+		return err
+	}
+
+	return nil
+}
+
+// verifyUpload reads back what we uploaded and compares checksums
+func (receiver *SftpHandler) verifyUpload(blobPath string, fileBytes []byte) error {
+	expectedChecksum := checksum(fileBytes)
+
+	uploadedBytes, err := receiver.blobHandler.FetchFileByUrl(path.Join(utils.ContainerName, blobPath))
+	if err != nil {
+		slog.Error("Failed to read back uploaded file", slog.Any(utils.ErrorKey, err), slog.String("blobPath", blobPath))
+		return err
+	}
+
+	uploadedChecksum := checksum(uploadedBytes)
+	if uploadedChecksum != expectedChecksum {
+		slog.Error("Uploaded file doesn't match the file on the SFTP server", slog.String("blobPath", blobPath),
+			slog.String("expectedSha256", expectedChecksum), slog.String("uploadedSha256", uploadedChecksum))
+		return errors.New("uploaded file checksum mismatch")
+	}
+
+	slog.Info("Verified uploaded file", slog.String("blobPath", blobPath), slog.String("sha256", expectedChecksum))
+	return nil
+}
+
+// fetchPartialTransfer returns the bytes saved from an earlier transfer of this file, or nil to start from the
+// beginning
+func (receiver *SftpHandler) fetchPartialTransfer(file remoteFile) []byte {
+	dataPath := receiver.partialTransferPath(file)
+	recordBytes, err := receiver.blobHandler.FetchFileByUrl(path.Join(utils.ContainerName, dataPath+".json"))
+	if err != nil {
+		return nil
+	}
+
+	var record partialTransferRecord
+	err = json.Unmarshal(recordBytes, &record)
+	if err != nil {
+		slog.Warn("Unable to read partial transfer record", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, file.fullPath()))
+		return nil
+	}
+	if record.Size != file.fileInfo.Size() || !record.ModTime.Equal(file.fileInfo.ModTime()) {
+		slog.Info("File changed since the partial transfer, starting over", slog.String(utils.FileNameKey, file.fullPath()))
+		return nil
+	}
+
+	partialBytes, err := receiver.blobHandler.FetchFileByUrl(path.Join(utils.ContainerName, dataPath))
+	if err != nil || int64(len(partialBytes)) != record.Offset || checksum(partialBytes) != record.Sha256 {
+		slog.Warn("Partial transfer doesn't match its record, starting over", slog.Any(utils.ErrorKey, err), slog.String(utils.FileNameKey, file.fullPath()))
+		return nil
+	}
+
+	return partialBytes
+}
+
+// savePartialTransfer saves the bytes before the record, so a record always describes bytes that exist
+func (receiver *SftpHandler) savePartialTransfer(file remoteFile, partialBytes []byte) {
+	dataPath := receiver.partialTransferPath(file)
+	record := partialTransferRecord{
+		Size:    file.fileInfo.Size(),
+		ModTime: file.fileInfo.ModTime().UTC(),
+		Offset:  int64(len(partialBytes)),
+		Sha256:  checksum(partialBytes),
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Failed to make partial transfer record", slog.Any(utils.ErrorKey, err))
+		return
+	}
+
+	err = receiver.blobHandler.UploadFile(partialBytes, dataPath)
+	if err != nil {
+		slog.Error("Failed to save partial transfer", slog.Any(utils.ErrorKey, err), slog.String("blobPath", dataPath))
+		return
+	}
+	err = receiver.blobHandler.UploadFile(recordBytes, dataPath+".json")
+	if err != nil {
+		slog.Error("Failed to save partial transfer record", slog.Any(utils.ErrorKey, err), slog.String("blobPath", dataPath))
+		return
+	}
+
+	slog.Info("Saved partial transfer to resume next time", slog.String(utils.FileNameKey, file.fullPath()), slog.Int64("offset", record.Offset))
+}
+
+// removePartialTransfer removes the record first, so what's left after a failure is never used
+func (receiver *SftpHandler) removePartialTransfer(file remoteFile) {
+	dataPath := receiver.partialTransferPath(file)
+	for _, blobPath := range []string{dataPath + ".json", dataPath} {
+		err := receiver.blobHandler.DeleteFile(blobPath)
+		if err != nil {
+			slog.Warn("Failed to remove partial transfer", slog.Any(utils.ErrorKey, err), slog.String("blobPath", blobPath))
+			return
+		}
+	}
+}
+
+func (receiver *SftpHandler) partialTransferPath(file remoteFile) string {
+	return path.Join(receiver.partnerId, partialTransfersFolder, file.relativeDirectory, file.fileInfo.Name())
+}
+
+func checksum(fileBytes []byte) string {
+	sum := sha256.Sum256(fileBytes)
+	return hex.EncodeToString(sum[:])
+}
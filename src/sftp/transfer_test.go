@@ -0,0 +1,162 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+var modifiedAt = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func Test_copySingleFile_UploadDoesNotMatch_LeavesFileOnSftpServer(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/order.hl7").Return(io.NopCloser(bytes.NewReader([]byte("MSH|order"))), nil)
+	mockSftpClient.On("Stat", "dogcow/order.hl7").Return(testFileInfo{name: "order.hl7", size: 9}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|ord"), nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId}
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7"}, directory: "dogcow"}, 1)
+
+	mockSftpClient.AssertNotCalled(t, "Remove", mock.Anything)
+	assert.Contains(t, buffer.String(), "Uploaded file doesn't match the file on the SFTP server")
+	mockBlobHandler.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "DeleteFile", mock.MatchedBy(isStagingPath))
+}
+
+func Test_uploadVerified_UploadMatches_CopiesStagedFileAndRemovesIt(t *testing.T) {
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", []byte("MSH|order"), mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|order"), nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, "flexion/import/order.hl7").Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+
+	sftpHandler := SftpHandler{blobHandler: mockBlobHandler, partnerId: partnerId}
+	err := sftpHandler.uploadVerified([]byte("MSH|order"), "flexion/import/order.hl7")
+
+	assert.NoError(t, err)
+	stagingPath := mockBlobHandler.Calls[0].Arguments.String(1)
+	assert.True(t, strings.HasPrefix(stagingPath, "flexion/staging/"))
+	mockBlobHandler.AssertCalled(t, "FetchFileByUrl", "sftp/"+stagingPath)
+	mockBlobHandler.AssertCalled(t, "CopyFile", stagingPath, "flexion/import/order.hl7")
+	mockBlobHandler.AssertCalled(t, "DeleteFile", stagingPath)
+}
+
+func Test_uploadVerified_UnableToCopy_ReturnsError(t *testing.T) {
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte("MSH|order"), nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(errors.New("blob storage is down"))
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+
+	sftpHandler := SftpHandler{blobHandler: mockBlobHandler, partnerId: partnerId}
+	err := sftpHandler.uploadVerified([]byte("MSH|order"), "flexion/import/order.hl7")
+
+	assert.Error(t, err)
+	mockBlobHandler.AssertCalled(t, "DeleteFile", mock.MatchedBy(isStagingPath))
+}
+
+func Test_copySingleFile_FileChangedWhileReading_DoesNotUpload(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/order.hl7").Return(io.NopCloser(bytes.NewReader([]byte("MSH|order"))), nil)
+	mockSftpClient.On("Stat", "dogcow/order.hl7").Return(testFileInfo{name: "order.hl7", size: 20}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId}
+	sftpHandler.copySingleFile(remoteFile{fileInfo: testFileInfo{name: "order.hl7"}, directory: "dogcow"}, 1)
+
+	mockBlobHandler.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything)
+	mockSftpClient.AssertNotCalled(t, "Remove", mock.Anything)
+	assert.Contains(t, buffer.String(), "File changed or was cut short while we read it")
+}
+
+func Test_transferFile_LargeFileTransferFails_SavesPartialTransfer(t *testing.T) {
+	largeFile := testFileInfo{name: "results.zip", size: transferChunkBytes + 1000, modTime: modifiedAt}
+	readBeforeFailure := bytes.Repeat([]byte("a"), transferChunkBytes+100)
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/results.zip").Return(io.NopCloser(io.MultiReader(bytes.NewReader(readBeforeFailure), ReadCloserThatErrors{ReadError: errors.New("connection lost")})), nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", "sftp/flexion/partial/results.zip.json").Return([]byte{}, errors.New("not found"))
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId}
+	fileBytes, err := sftpHandler.transferFile(remoteFile{fileInfo: largeFile, directory: "dogcow"})
+
+	assert.Error(t, err)
+	assert.Nil(t, fileBytes)
+	mockBlobHandler.AssertCalled(t, "UploadFile", readBeforeFailure, "flexion/partial/results.zip")
+	recordBytes := mockBlobHandler.Calls[2].Arguments.Get(0).([]byte)
+	var record partialTransferRecord
+	assert.NoError(t, json.Unmarshal(recordBytes, &record))
+	assert.Equal(t, partialTransferRecord{Size: largeFile.size, ModTime: modifiedAt, Offset: int64(len(readBeforeFailure)), Sha256: checksum(readBeforeFailure)}, record)
+}
+
+func Test_transferFile_PartialTransferMatches_ResumesFromOffset(t *testing.T) {
+	largeFile := testFileInfo{name: "results.zip", size: transferChunkBytes + 1000, modTime: modifiedAt}
+	partialBytes := bytes.Repeat([]byte("a"), transferChunkBytes+100)
+	remainingBytes := bytes.Repeat([]byte("b"), 900)
+	recordBytes, _ := json.Marshal(partialTransferRecord{Size: largeFile.size, ModTime: modifiedAt, Offset: int64(len(partialBytes)), Sha256: checksum(partialBytes)})
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("OpenAt", "dogcow/results.zip", int64(len(partialBytes))).Return(io.NopCloser(bytes.NewReader(remainingBytes)), nil)
+	mockSftpClient.On("Stat", "dogcow/results.zip").Return(largeFile, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", "sftp/flexion/partial/results.zip.json").Return(recordBytes, nil)
+	mockBlobHandler.On("FetchFileByUrl", "sftp/flexion/partial/results.zip").Return(partialBytes, nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId}
+	fileBytes, err := sftpHandler.transferFile(remoteFile{fileInfo: largeFile, directory: "dogcow"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, append(partialBytes, remainingBytes...), fileBytes)
+	mockSftpClient.AssertNotCalled(t, "Open", mock.Anything)
+	mockBlobHandler.AssertCalled(t, "DeleteFile", "flexion/partial/results.zip.json")
+	mockBlobHandler.AssertCalled(t, "DeleteFile", "flexion/partial/results.zip")
+}
+
+func Test_transferFile_FileChangedSincePartialTransfer_StartsOver(t *testing.T) {
+	largeFile := testFileInfo{name: "results.zip", size: transferChunkBytes + 1000, modTime: modifiedAt}
+	fileContents := bytes.Repeat([]byte("c"), int(largeFile.size))
+	recordBytes, _ := json.Marshal(partialTransferRecord{Size: largeFile.size, ModTime: modifiedAt.Add(-time.Hour), Offset: 100, Sha256: "stale"})
+
+	mockSftpClient := new(MockSftpWrapper)
+	mockSftpClient.On("Open", "dogcow/results.zip").Return(io.NopCloser(bytes.NewReader(fileContents)), nil)
+	mockSftpClient.On("Stat", "dogcow/results.zip").Return(largeFile, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", "sftp/flexion/partial/results.zip.json").Return(recordBytes, nil)
+
+	sftpHandler := SftpHandler{sftpClient: mockSftpClient, blobHandler: mockBlobHandler, partnerId: partnerId}
+	fileBytes, err := sftpHandler.transferFile(remoteFile{fileInfo: largeFile, directory: "dogcow"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, fileContents, fileBytes)
+	mockSftpClient.AssertNotCalled(t, "OpenAt", mock.Anything, mock.Anything)
+	mockBlobHandler.AssertNotCalled(t, "DeleteFile", mock.Anything)
+}
@@ -10,4 +10,9 @@ type SftpWrapper interface {
 	Open(path string) (io.ReadCloser, error)
 	Close() error
 	Remove(path string) error
+	Rename(oldPath string, newPath string) error
+	Create(path string) (io.WriteCloser, error)
+	Stat(path string) (os.FileInfo, error)
+	// OpenAt opens a file for reading from offset, to resume a transfer
+	OpenAt(path string, offset int64) (io.ReadCloser, error)
 }
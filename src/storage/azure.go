@@ -1,14 +1,26 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
 	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"io"
 	"log/slog"
 	"os"
+	"strings"
+	"time"
 )
 
+// copyStatusInterval is how often CopyFile checks on a copy that's still going
+const copyStatusInterval = time.Second
+
 type AzureBlobHandler struct {
 	blobClient *azblob.Client
 }
@@ -33,19 +45,40 @@ func (receiver AzureBlobHandler) FetchFileByUrl(sourceUrl string) ([]byte, error
 }
 
 func (receiver AzureBlobHandler) FetchFile(containerName string, blobName string) ([]byte, error) {
+	fileBytes, _, err := receiver.fetchFileAndMetadata(containerName, blobName)
+	return fileBytes, err
+}
+
+func (receiver AzureBlobHandler) fetchFileAndMetadata(containerName string, blobName string) ([]byte, map[string]*string, error) {
 	streamResponse, err := receiver.blobClient.DownloadStream(context.Background(), containerName, blobName, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	retryReader := streamResponse.NewRetryReader(context.Background(), nil)
 	defer retryReader.Close()
 
-	return io.ReadAll(retryReader)
+	fileBytes, err := io.ReadAll(retryReader)
+	return fileBytes, streamResponse.Metadata, err
 }
 
 func (receiver AzureBlobHandler) UploadFile(fileBytes []byte, blobPath string) error {
-	uploadResponse, err := receiver.blobClient.UploadBuffer(context.Background(), utils.ContainerName, blobPath, fileBytes, nil)
+	return receiver.uploadFile(fileBytes, blobPath, nil)
+}
+
+// UploadFileWithMetadata uploads a file with its metadata in one request, so the metadata is there by the time
+// anything reacts to the blob being created. Azure requires metadata keys to be valid C# identifiers
+func (receiver AzureBlobHandler) UploadFileWithMetadata(fileBytes []byte, blobPath string, metadata map[string]string) error {
+	blobMetadata := make(map[string]*string, len(metadata))
+	for key, value := range metadata {
+		blobMetadata[key] = &value
+	}
+
+	return receiver.uploadFile(fileBytes, blobPath, blobMetadata)
+}
+
+func (receiver AzureBlobHandler) uploadFile(fileBytes []byte, blobPath string, metadata map[string]*string) error {
+	uploadResponse, err := receiver.blobClient.UploadBuffer(context.Background(), utils.ContainerName, blobPath, fileBytes, &azblob.UploadBufferOptions{Metadata: metadata})
 	if err != nil {
 		slog.Error("Unable to upload file", slog.String("destinationUrl", blobPath), slog.Any(utils.ErrorKey, err))
 		return err
@@ -56,6 +89,42 @@ func (receiver AzureBlobHandler) UploadFile(fileBytes []byte, blobPath string) e
 	return nil
 }
 
+// StageBlock uploads one block of a block blob. It isn't part of the blob until CommitBlocks, and Azure throws away
+// blocks that are never committed
+func (receiver AzureBlobHandler) StageBlock(blobPath string, blockNumber int, blockBytes []byte) error {
+	blockBlobClient := receiver.blobClient.ServiceClient().NewContainerClient(utils.ContainerName).NewBlockBlobClient(blobPath)
+	_, err := blockBlobClient.StageBlock(context.Background(), blockId(blockNumber), streaming.NopCloser(bytes.NewReader(blockBytes)), nil)
+	if err != nil {
+		slog.Error("Unable to stage block", slog.String("blobPath", blobPath), slog.Int("blockNumber", blockNumber), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	return nil
+}
+
+// CommitBlocks makes the blob from the blocks StageBlock uploaded, in order
+func (receiver AzureBlobHandler) CommitBlocks(blobPath string, blockCount int) error {
+	blockIds := make([]string, blockCount)
+	for blockNumber := range blockCount {
+		blockIds[blockNumber] = blockId(blockNumber)
+	}
+
+	blockBlobClient := receiver.blobClient.ServiceClient().NewContainerClient(utils.ContainerName).NewBlockBlobClient(blobPath)
+	_, err := blockBlobClient.CommitBlockList(context.Background(), blockIds, nil)
+	if err != nil {
+		slog.Error("Unable to commit blocks", slog.String("blobPath", blobPath), slog.Int("blockCount", blockCount), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	slog.Info("Successfully committed blocks", slog.String("blobPath", blobPath), slog.Int("blockCount", blockCount))
+	return nil
+}
+
+// blockId is a block's ID from its place in the blob. Azure needs the IDs in a blob to be base64 and the same length
+func blockId(blockNumber int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", blockNumber)))
+}
+
 func (receiver AzureBlobHandler) MoveFile(sourceUrl string, destinationUrl string) error {
 	sourceUrlParts, err := azblob.ParseURL(sourceUrl)
 	if err != nil {
@@ -69,13 +138,14 @@ func (receiver AzureBlobHandler) MoveFile(sourceUrl string, destinationUrl strin
 		return err
 	}
 
-	fileBytes, err := receiver.FetchFileByUrl(sourceUrl)
+	// The metadata moves with the file, e.g. so a failed message can still be traced back to its zip
+	fileBytes, metadata, err := receiver.fetchFileAndMetadata(sourceUrlParts.ContainerName, sourceUrlParts.BlobName)
 	if err != nil {
 		slog.Error("Unable to fetch file", slog.String("sourceUrl", sourceUrl), slog.Any(utils.ErrorKey, err))
 		return err
 	}
 
-	err = receiver.UploadFile(fileBytes, destinationUrlParts.BlobName)
+	err = receiver.uploadFile(fileBytes, destinationUrlParts.BlobName, metadata)
 	if err != nil {
 		return err
 	}
@@ -88,3 +158,137 @@ func (receiver AzureBlobHandler) MoveFile(sourceUrl string, destinationUrl strin
 
 	return nil
 }
+
+// CopyFile copies a blob to another path in our container without it leaving blob storage, and waits for the copy to
+// finish. The copy keeps the source's metadata
+func (receiver AzureBlobHandler) CopyFile(sourcePath string, destinationPath string) error {
+	containerClient := receiver.blobClient.ServiceClient().NewContainerClient(utils.ContainerName)
+	sourceUrl := containerClient.NewBlobClient(sourcePath).URL()
+	destinationClient := containerClient.NewBlobClient(destinationPath)
+
+	copyResponse, err := destinationClient.StartCopyFromURL(context.Background(), sourceUrl, nil)
+	if err != nil {
+		slog.Error("Unable to copy file", slog.String("sourcePath", sourcePath), slog.String("destinationPath", destinationPath), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	// Copies within a storage account are usually done by the time StartCopyFromURL returns
+	copyStatus := copyResponse.CopyStatus
+	for copyStatus != nil && *copyStatus == blob.CopyStatusTypePending {
+		time.Sleep(copyStatusInterval)
+		properties, err := destinationClient.GetProperties(context.Background(), nil)
+		if err != nil {
+			slog.Error("Unable to check copy status", slog.String("destinationPath", destinationPath), slog.Any(utils.ErrorKey, err))
+			return err
+		}
+		copyStatus = properties.CopyStatus
+	}
+
+	if copyStatus != nil && *copyStatus != blob.CopyStatusTypeSuccess {
+		slog.Error("File copy didn't succeed", slog.String("sourcePath", sourcePath), slog.String("destinationPath", destinationPath), slog.String("copyStatus", string(*copyStatus)))
+		return errors.New("file copy finished with status " + string(*copyStatus))
+	}
+
+	slog.Info("Successfully copied file", slog.String("sourcePath", sourcePath), slog.String("destinationPath", destinationPath))
+	return nil
+}
+
+func (receiver AzureBlobHandler) DeleteFile(blobPath string) error {
+	_, err := receiver.blobClient.DeleteBlob(context.Background(), utils.ContainerName, blobPath, nil)
+	if err != nil {
+		slog.Error("Unable to delete file", slog.String("blobPath", blobPath), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	return nil
+}
+
+// ListFiles lists the paths of the blobs in our container that start with prefix
+func (receiver AzureBlobHandler) ListFiles(prefix string) ([]string, error) {
+	var blobPaths []string
+	pager := receiver.blobClient.NewListBlobsFlatPager(utils.ContainerName, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			slog.Error("Unable to list files", slog.String("prefix", prefix), slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+
+		for _, blobItem := range page.Segment.BlobItems {
+			blobPaths = append(blobPaths, *blobItem.Name)
+		}
+	}
+
+	return blobPaths, nil
+}
+
+// FileExists checks for a blob without downloading it
+func (receiver AzureBlobHandler) FileExists(sourceUrl string) (bool, error) {
+	sourceUrlParts, err := azblob.ParseURL(sourceUrl)
+	if err != nil {
+		slog.Error("Unable to parse source URL", slog.String("sourceUrl", sourceUrl), slog.Any(utils.ErrorKey, err))
+		return false, err
+	}
+
+	blobClient := receiver.blobClient.ServiceClient().NewContainerClient(sourceUrlParts.ContainerName).NewBlobClient(sourceUrlParts.BlobName)
+	_, err = blobClient.GetProperties(context.Background(), nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		slog.Error("Unable to check for blob", slog.String("sourceUrl", sourceUrl), slog.Any(utils.ErrorKey, err))
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetMetadata reads the metadata on an existing blob without downloading it
+func (receiver AzureBlobHandler) GetMetadata(sourceUrl string) (map[string]string, error) {
+	sourceUrlParts, err := azblob.ParseURL(sourceUrl)
+	if err != nil {
+		slog.Error("Unable to parse source URL", slog.String("sourceUrl", sourceUrl), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	blobClient := receiver.blobClient.ServiceClient().NewContainerClient(sourceUrlParts.ContainerName).NewBlobClient(sourceUrlParts.BlobName)
+	properties, err := blobClient.GetProperties(context.Background(), nil)
+	if err != nil {
+		slog.Error("Unable to get blob metadata", slog.String("sourceUrl", sourceUrl), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+
+	// Metadata comes back in HTTP headers, so the SDK gives us keys like `Source_archive`. Azure doesn't care about
+	// the case of metadata keys, so we use the lowercase ones we set
+	metadata := make(map[string]string, len(properties.Metadata))
+	for key, value := range properties.Metadata {
+		if value != nil {
+			metadata[strings.ToLower(key)] = *value
+		}
+	}
+
+	return metadata, nil
+}
+
+// SetMetadata replaces the metadata on an existing blob. Azure requires metadata keys to be valid C# identifiers
+func (receiver AzureBlobHandler) SetMetadata(sourceUrl string, metadata map[string]string) error {
+	sourceUrlParts, err := azblob.ParseURL(sourceUrl)
+	if err != nil {
+		slog.Error("Unable to parse source URL", slog.String("sourceUrl", sourceUrl), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	blobMetadata := make(map[string]*string, len(metadata))
+	for key, value := range metadata {
+		blobMetadata[key] = &value
+	}
+
+	blobClient := receiver.blobClient.ServiceClient().NewContainerClient(sourceUrlParts.ContainerName).NewBlobClient(sourceUrlParts.BlobName)
+	_, err = blobClient.SetMetadata(context.Background(), blobMetadata, nil)
+	if err != nil {
+		slog.Error("Unable to set blob metadata", slog.String("sourceUrl", sourceUrl), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+)
+
+// AzureBlobLeaser takes leases on empty blobs in the `leases` folder of our container. Azure leases last between 15
+// and 60 seconds unless they're renewed
+type AzureBlobLeaser struct {
+	blobClient *azblob.Client
+}
+
+func NewAzureBlobLeaser() (AzureBlobLeaser, error) {
+	connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
+	blobClient, err := azblob.NewClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		return AzureBlobLeaser{}, err
+	}
+
+	return AzureBlobLeaser{blobClient: blobClient}, nil
+}
+
+func (receiver AzureBlobLeaser) AcquireLease(name string, duration time.Duration) (string, error) {
+	leaseClient, err := receiver.leaseClient(name, nil)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := leaseClient.AcquireLease(context.Background(), int32(duration.Seconds()), nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		// The first time we take a lease, we have to create its blob. If another replica beats us to it, acquiring the
+		// lease below tells us whether they hold it
+		_, uploadErr := receiver.blobClient.UploadBuffer(context.Background(), utils.ContainerName, leaseBlobPath(name), []byte{}, nil)
+		if uploadErr != nil {
+			slog.Warn("Unable to create lease blob", slog.String("lease", name), slog.Any(utils.ErrorKey, uploadErr))
+		}
+		response, err = leaseClient.AcquireLease(context.Background(), int32(duration.Seconds()), nil)
+	}
+	if bloberror.HasCode(err, bloberror.LeaseAlreadyPresent) {
+		return "", nil
+	}
+	if err != nil {
+		slog.Error("Unable to acquire lease", slog.String("lease", name), slog.Any(utils.ErrorKey, err))
+		return "", err
+	}
+
+	return *response.LeaseID, nil
+}
+
+func (receiver AzureBlobLeaser) RenewLease(name string, leaseId string) error {
+	leaseClient, err := receiver.leaseClient(name, &leaseId)
+	if err != nil {
+		return err
+	}
+
+	_, err = leaseClient.RenewLease(context.Background(), nil)
+	if err != nil {
+		slog.Error("Unable to renew lease", slog.String("lease", name), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	return nil
+}
+
+func (receiver AzureBlobLeaser) ReleaseLease(name string, leaseId string) error {
+	leaseClient, err := receiver.leaseClient(name, &leaseId)
+	if err != nil {
+		return err
+	}
+
+	_, err = leaseClient.ReleaseLease(context.Background(), nil)
+	if err != nil {
+		slog.Error("Unable to release lease", slog.String("lease", name), slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	return nil
+}
+
+// leaseClient works with the lease on name's blob. A nil leaseId makes a new random one for acquiring the lease
+func (receiver AzureBlobLeaser) leaseClient(name string, leaseId *string) (*lease.BlobClient, error) {
+	blobClient := receiver.blobClient.ServiceClient().NewContainerClient(utils.ContainerName).NewBlockBlobClient(leaseBlobPath(name))
+	leaseClient, err := lease.NewBlobClient(blobClient, &lease.BlobClientOptions{LeaseID: leaseId})
+	if err != nil {
+		slog.Error("Unable to create lease client", slog.String("lease", name), slog.Any(utils.ErrorKey, err))
+		return nil, err
+	}
+	return leaseClient, nil
+}
+
+func leaseBlobPath(name string) string {
+	return path.Join(utils.LeaseFolder, name)
+}
@@ -0,0 +1,127 @@
+package usecases
+
+import (
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Acknowledgement statuses: `sent` means ReportStream accepted the file and gave us a report ID, `received` means
+// the partner's other destinations accepted it, and `rejected` means it went to a `failure` folder
+const AcknowledgementStatusSent = "sent"
+const AcknowledgementStatusReceived = "received"
+const AcknowledgementStatusRejected = "rejected"
+
+// Acknowledgement is the content of a queue message asking us to tell a partner what happened to one of their files
+type Acknowledgement struct {
+	PartnerId string `json:"partnerId"`
+	// FileName is the name of the partner's file, which the acknowledgement file is named after. For a message from
+	// an archive, it's the archive's name, and ArchiveEntryPath is where the message was in the archive
+	FileName         string `json:"fileName"`
+	ArchiveEntryPath string `json:"archiveEntryPath,omitempty"`
+	// SourceUrl is the file's blob URL in the `success` or `failure` folder
+	SourceUrl string   `json:"sourceUrl"`
+	Status    string   `json:"status"`
+	ReportId  string   `json:"reportId,omitempty"`
+	Reasons   []string `json:"reasons,omitempty"`
+	// MessageControlIds are from the MSH segments in the file, for HL7 ACKs
+	MessageControlIds []string  `json:"messageControlIds,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// The AcknowledgementQueue interface is about scheduling an Acknowledgement to be written to the partner's SFTP server
+type AcknowledgementQueue interface {
+	QueueAcknowledgement(acknowledgement Acknowledgement) error
+}
+
+// QueueAcknowledgement queues an acknowledgement when the partner wants them. We only log failures because the file
+// has already been handled
+func QueueAcknowledgement(acknowledgementQueue AcknowledgementQueue, acknowledgement Acknowledgement, content []byte) {
+	if !wantsAcknowledgement(acknowledgementQueue, acknowledgement) {
+		return
+	}
+
+	if acknowledgement.FileName == "" {
+		acknowledgement.FileName = fileNameFromUrl(acknowledgement.SourceUrl)
+	}
+	acknowledgement.MessageControlIds = messageControlIds(content)
+	acknowledgement.Timestamp = time.Now().UTC()
+
+	err := acknowledgementQueue.QueueAcknowledgement(acknowledgement)
+	if err != nil {
+		slog.Error("Failed to queue acknowledgement", slog.Any(utils.ErrorKey, err), slog.String("sourceUrl", acknowledgement.SourceUrl))
+	}
+}
+
+// queueMessageAcknowledgement is QueueAcknowledgement for a message in blob storage, named after the file the partner
+// sent, see fromSourceArchive. The message's metadata is only needed for the name, so we still acknowledge the message
+// when we can't read it
+func queueMessageAcknowledgement(acknowledgementQueue AcknowledgementQueue, blobHandler BlobHandler, acknowledgement Acknowledgement, content []byte) {
+	if !wantsAcknowledgement(acknowledgementQueue, acknowledgement) {
+		return
+	}
+
+	metadata, err := blobHandler.GetMetadata(acknowledgement.SourceUrl)
+	if err != nil {
+		slog.Warn("Unable to read message metadata for its acknowledgement", slog.Any(utils.ErrorKey, err), slog.String("sourceUrl", acknowledgement.SourceUrl))
+	}
+
+	QueueAcknowledgement(acknowledgementQueue, acknowledgement.fromSourceArchive(metadata), content)
+}
+
+func wantsAcknowledgement(acknowledgementQueue AcknowledgementQueue, acknowledgement Acknowledgement) bool {
+	if acknowledgementQueue == nil || acknowledgement.SourceUrl == "" {
+		return false
+	}
+
+	partnerConfig := config.Configs[acknowledgement.PartnerId]
+	return partnerConfig != nil && partnerConfig.PartnerSettings.Acknowledgements.Format != ""
+}
+
+// fromSourceArchive names an acknowledgement after the archive a message came from, using the metadata Unzip puts on
+// each file it extracts, so the partner can match it to the file they sent. Messages that weren't in an archive keep
+// their own name
+func (acknowledgement Acknowledgement) fromSourceArchive(metadata map[string]string) Acknowledgement {
+	sourceArchive := metadata[utils.SourceArchiveMetadataKey]
+	if sourceArchive == "" {
+		return acknowledgement
+	}
+
+	entryPath := metadata[utils.SourceArchiveEntryPathMetadataKey]
+	unescapedPath, err := url.PathUnescape(entryPath)
+	if err == nil {
+		entryPath = unescapedPath
+	}
+
+	acknowledgement.FileName = path.Base(sourceArchive)
+	acknowledgement.ArchiveEntryPath = entryPath
+	return acknowledgement
+}
+
+// messageControlIds reads MSH-10 from each message in an HL7 file
+func messageControlIds(content []byte) []string {
+	var controlIds []string
+	for _, segment := range strings.FieldsFunc(string(content), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		if !strings.HasPrefix(segment, "MSH") || len(segment) < 4 {
+			continue
+		}
+		// MSH-1 is the field separator itself, so MSH-10 is the tenth field after splitting on it
+		fields := strings.Split(segment, segment[3:4])
+		if len(fields) > 9 && fields[9] != "" {
+			controlIds = append(controlIds, fields[9])
+		}
+	}
+	return controlIds
+}
+
+func fileNameFromUrl(sourceUrl string) string {
+	parsedUrl, err := url.Parse(sourceUrl)
+	if err != nil {
+		return path.Base(sourceUrl)
+	}
+	return path.Base(parsedUrl.Path)
+}
@@ -0,0 +1,202 @@
+package usecases
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config/configtest"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"log/slog"
+	"testing"
+)
+
+const ackMessage = "MSH|^~\\&|LAB|FAC|||20240601||ORU^R01|control-1|P|2.5.1\rPID|1\rMSH|^~\\&|LAB|FAC|||20240601||ORU^R01|control-2|P|2.5.1\r"
+
+func setUpAcknowledgementConfig(t *testing.T, partnerId string) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{partnerId: {
+		Acknowledgements: config.AcknowledgementSettings{Format: config.AcknowledgementFormatCsv, Directory: "acks"},
+	}})
+}
+
+func Test_ReadAndSend_PartnerWantsAcknowledgements_QueuesSentAcknowledgement(t *testing.T) {
+	setUpAcknowledgementConfig(t, "customer")
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte(ackMessage), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string{}, nil)
+
+	mockMessageSender := &MockTrackedMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("epic report ID", nil)
+
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	mockAcknowledgementQueue.On("QueueAcknowledgement", mock.Anything).Return(nil)
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, acknowledgementQueue: mockAcknowledgementQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	acknowledgement := mockAcknowledgementQueue.Calls[0].Arguments.Get(0).(Acknowledgement)
+	assert.Equal(t, "customer", acknowledgement.PartnerId)
+	assert.Equal(t, "order_message.hl7", acknowledgement.FileName)
+	assert.Equal(t, utils.SuccessSourceUrl, acknowledgement.SourceUrl)
+	assert.Equal(t, AcknowledgementStatusSent, acknowledgement.Status)
+	assert.Equal(t, "epic report ID", acknowledgement.ReportId)
+	assert.Equal(t, []string{"control-1", "control-2"}, acknowledgement.MessageControlIds)
+	assert.False(t, acknowledgement.Timestamp.IsZero())
+}
+
+func Test_ReadAndSend_NonTransientFailure_QueuesRejectedAcknowledgement(t *testing.T) {
+	setUpAcknowledgementConfig(t, "customer")
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte(ackMessage), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.FailureSourceUrl).Return(nil)
+	mockBlobHandler.On("GetMetadata", utils.FailureSourceUrl).Return(map[string]string{}, nil)
+
+	mockMessageSender := &MockMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("", errors.New(utils.ReportStreamNonTransientFailure))
+
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	mockAcknowledgementQueue.On("QueueAcknowledgement", mock.Anything).Return(nil)
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, acknowledgementQueue: mockAcknowledgementQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	acknowledgement := mockAcknowledgementQueue.Calls[0].Arguments.Get(0).(Acknowledgement)
+	assert.Equal(t, AcknowledgementStatusRejected, acknowledgement.Status)
+	assert.Equal(t, utils.FailureSourceUrl, acknowledgement.SourceUrl)
+	assert.Equal(t, []string{utils.ReportStreamNonTransientFailure}, acknowledgement.Reasons)
+}
+
+func Test_ReadAndSend_MessageCameFromArchive_NamesAcknowledgementAfterArchive(t *testing.T) {
+	setUpAcknowledgementConfig(t, "customer")
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte(ackMessage), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string{
+		utils.SourceArchiveMetadataKey:          "customer/unzip/orders.zip",
+		utils.SourceArchiveEntryPathMetadataKey: "2024/lab%20r%C3%A9sult.hl7",
+	}, nil)
+
+	mockMessageSender := &MockMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("epic report ID", nil)
+
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	mockAcknowledgementQueue.On("QueueAcknowledgement", mock.Anything).Return(nil)
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, acknowledgementQueue: mockAcknowledgementQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	acknowledgement := mockAcknowledgementQueue.Calls[0].Arguments.Get(0).(Acknowledgement)
+	assert.Equal(t, "orders.zip", acknowledgement.FileName)
+	assert.Equal(t, "2024/lab résult.hl7", acknowledgement.ArchiveEntryPath)
+}
+
+func Test_ReadAndSend_UnableToGetMetadata_StillQueuesAcknowledgement(t *testing.T) {
+	setUpAcknowledgementConfig(t, "customer")
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte(ackMessage), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string(nil), errors.New("blob storage is down"))
+
+	mockMessageSender := &MockMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("epic report ID", nil)
+
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	mockAcknowledgementQueue.On("QueueAcknowledgement", mock.Anything).Return(nil)
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, acknowledgementQueue: mockAcknowledgementQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	acknowledgement := mockAcknowledgementQueue.Calls[0].Arguments.Get(0).(Acknowledgement)
+	assert.Equal(t, "order_message.hl7", acknowledgement.FileName)
+	assert.Empty(t, acknowledgement.ArchiveEntryPath)
+}
+
+func Test_ReadAndSend_PartnerDoesNotWantAcknowledgements_DoesNotQueueAcknowledgement(t *testing.T) {
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte(ackMessage), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+
+	mockMessageSender := &MockMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("epic report ID", nil)
+
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, acknowledgementQueue: mockAcknowledgementQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	mockAcknowledgementQueue.AssertNotCalled(t, "QueueAcknowledgement", mock.Anything)
+}
+
+func Test_queueAcknowledgement_UnableToQueue_LogsError(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+	setUpAcknowledgementConfig(t, "customer")
+
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	mockAcknowledgementQueue.On("QueueAcknowledgement", mock.Anything).Return(errors.New("queue is down"))
+
+	QueueAcknowledgement(mockAcknowledgementQueue, Acknowledgement{PartnerId: "customer", SourceUrl: utils.SuccessSourceUrl}, nil)
+
+	assert.Contains(t, buffer.String(), "Failed to queue acknowledgement")
+}
+
+func Test_messageControlIds(t *testing.T) {
+	assert.Equal(t, []string{"control-1", "control-2"}, messageControlIds([]byte(ackMessage)))
+	assert.Equal(t, []string{"abc"}, messageControlIds([]byte("MSH|^~\\&|LAB|FAC|||20240601||ORU^R01|abc|P|2.5.1\nPID|1\n")))
+	assert.Nil(t, messageControlIds([]byte("The DogCow went Moof!")))
+	assert.Nil(t, messageControlIds([]byte("MSH|^~\\&|LAB")))
+}
+
+func Test_ReadAndSend_PrimaryAcceptsAndSecondaryRejects_MovesToSuccessAndQueuesSentAcknowledgement(t *testing.T) {
+	setUpAcknowledgementConfig(t, "customer")
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte(ackMessage), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string{}, nil)
+
+	mockMessageSender := &MockTrackedMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("epic report ID", errors.New(utils.SecondaryDestinationNonTransientFailure+": failed to send to destinations: archive"))
+
+	mockStatusQueue := &MockSubmissionStatusQueue{}
+	mockStatusQueue.On("QueueStatusCheck", mock.Anything).Return(nil)
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	mockAcknowledgementQueue.On("QueueAcknowledgement", mock.Anything).Return(nil)
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, statusQueue: mockStatusQueue, acknowledgementQueue: mockAcknowledgementQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertCalled(t, "MoveFile", utils.SourceUrl, utils.SuccessSourceUrl)
+	mockBlobHandler.AssertNotCalled(t, "MoveFile", utils.SourceUrl, utils.FailureSourceUrl)
+	mockStatusQueue.AssertCalled(t, "QueueStatusCheck", SubmissionStatusCheck{ReportId: "epic report ID", SourceUrl: utils.SuccessSourceUrl})
+	acknowledgement := mockAcknowledgementQueue.Calls[0].Arguments.Get(0).(Acknowledgement)
+	assert.Equal(t, AcknowledgementStatusSent, acknowledgement.Status)
+	assert.Equal(t, "epic report ID", acknowledgement.ReportId)
+}
+
+type MockAcknowledgementQueue struct {
+	mock.Mock
+}
+
+func (receiver *MockAcknowledgementQueue) QueueAcknowledgement(acknowledgement Acknowledgement) error {
+	args := receiver.Called(acknowledgement)
+	return args.Error(0)
+}
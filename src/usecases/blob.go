@@ -5,5 +5,16 @@ package usecases
 type BlobHandler interface {
 	FetchFileByUrl(sourceUrl string) ([]byte, error)
 	MoveFile(sourceUrl string, destinationUrl string) error
+	CopyFile(sourcePath string, destinationPath string) error
 	UploadFile(fileBytes []byte, blobPath string) error
+	UploadFileWithMetadata(fileBytes []byte, blobPath string, metadata map[string]string) error
+	// StageBlock and CommitBlocks upload a file a part at a time, so it doesn't have to be in memory all at once. The
+	// blob is made from blocks 0 to blockCount-1 when they're committed
+	StageBlock(blobPath string, blockNumber int, blockBytes []byte) error
+	CommitBlocks(blobPath string, blockCount int) error
+	FileExists(sourceUrl string) (bool, error)
+	GetMetadata(sourceUrl string) (map[string]string, error)
+	SetMetadata(sourceUrl string, metadata map[string]string) error
+	DeleteFile(blobPath string) error
+	ListFiles(prefix string) ([]string, error)
 }
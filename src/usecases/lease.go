@@ -0,0 +1,12 @@
+package usecases
+
+import "time"
+
+// The Leaser interface is about making sure only one replica does something at a time, e.g. with Azure blob leases.
+// A lease expires unless it's renewed, so a replica that dies doesn't hold it forever
+type Leaser interface {
+	// AcquireLease returns the new lease's ID, or an empty ID when someone else holds the lease
+	AcquireLease(name string, duration time.Duration) (string, error)
+	RenewLease(name string, leaseId string) error
+	ReleaseLease(name string, leaseId string) error
+}
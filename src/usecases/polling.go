@@ -0,0 +1,6 @@
+package usecases
+
+// The PollingQueue interface is about asking a replica to poll a partner's SFTP server
+type PollingQueue interface {
+	QueuePoll(partnerId string) error
+}
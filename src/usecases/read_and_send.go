@@ -1,12 +1,15 @@
 package usecases
 
 import (
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
 	"github.com/CDCgov/reportstream-sftp-ingestion/senders"
 	"github.com/CDCgov/reportstream-sftp-ingestion/storage"
 	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"golang.org/x/text/encoding/charmap"
 	"log/slog"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
 )
 
@@ -17,9 +20,13 @@ type ReadAndSend interface {
 type ReadAndSendUsecase struct {
 	blobHandler   BlobHandler
 	messageSender senders.MessageSender
+	// partnerSenders holds the senders for partners with their own configured destinations
+	partnerSenders       map[string]senders.MessageSender
+	statusQueue          SubmissionStatusQueue
+	acknowledgementQueue AcknowledgementQueue
 }
 
-func NewReadAndSendUsecase() (ReadAndSendUsecase, error) {
+func NewReadAndSendUsecase(statusQueue SubmissionStatusQueue, acknowledgementQueue AcknowledgementQueue) (ReadAndSendUsecase, error) {
 	blobHandler, err := storage.NewAzureBlobHandler()
 	if err != nil {
 		slog.Error("Failed to init Azure blob client", slog.Any(utils.ErrorKey, err))
@@ -31,7 +38,13 @@ func NewReadAndSendUsecase() (ReadAndSendUsecase, error) {
 
 	if reportStreamBaseUrl == "" {
 		slog.Info("REPORT_STREAM_URL_PREFIX not set, using file senders instead")
-		messageSender = senders.FileSender{}
+		messageSender, err = senders.NewFileSender()
+		if err != nil {
+			slog.Warn("Failed to construct the file senders", slog.Any(utils.ErrorKey, err))
+			return ReadAndSendUsecase{}, err
+		}
+		// There's no submission history to check when we aren't sending to ReportStream
+		statusQueue = nil
 	} else {
 		slog.Info("Found REPORT_STREAM_URL_PREFIX, will send to ReportStream")
 		messageSender, err = senders.NewSender()
@@ -41,16 +54,34 @@ func NewReadAndSendUsecase() (ReadAndSendUsecase, error) {
 		}
 	}
 
+	partnerSenders := make(map[string]senders.MessageSender)
+	for partnerId, partnerConfig := range config.Configs {
+		if partnerConfig == nil || len(partnerConfig.PartnerSettings.Senders.Destinations) == 0 {
+			continue
+		}
+
+		partnerSender, err := senders.NewPartnerSender(partnerId, partnerConfig.PartnerSettings.Senders)
+		if err != nil {
+			slog.Warn("Failed to construct the partner senders", slog.Any(utils.ErrorKey, err), slog.String("partnerId", partnerId))
+			return ReadAndSendUsecase{}, err
+		}
+		partnerSenders[partnerId] = partnerSender
+	}
+
 	return ReadAndSendUsecase{
-		blobHandler:   blobHandler,
-		messageSender: messageSender,
+		blobHandler:          blobHandler,
+		messageSender:        messageSender,
+		partnerSenders:       partnerSenders,
+		statusQueue:          statusQueue,
+		acknowledgementQueue: acknowledgementQueue,
 	}, nil
 }
 
 // ReadAndSend retrieves the specified blob from Azure and sends it to ReportStream. On a success response from ReportStream,
-// we move the file to a `success` folder. On a non-transient error, we move the file to a `failure` folder and return
+// we move the file to a `success` folder, even if a partner's secondary destinations rejected it. On a non-transient error, we move the file to a `failure` folder and return
 // `nil` so that we'll delete the queue message and not retry. On a transient error or an unknown error, we return
-// an error, which will cause the queue message to retry later
+// an error, which will cause the queue message to retry later. Once a partner's file is in `success` or `failure`, we
+// queue an acknowledgement for the partner if they want one
 func (receiver *ReadAndSendUsecase) ReadAndSend(sourceUrl string) error {
 	content, err := receiver.blobHandler.FetchFileByUrl(sourceUrl)
 	if err != nil {
@@ -64,7 +95,14 @@ func (receiver *ReadAndSendUsecase) ReadAndSend(sourceUrl string) error {
 		return err
 	}
 
-	reportId, err := receiver.messageSender.SendMessage(encodedContent)
+	messageSender := receiver.senderForUrl(sourceUrl)
+
+	source := senders.MessageSource{Url: sourceUrl, PartnerId: partnerIdFromUrl(sourceUrl), Encoding: sourceEncoding}
+	reportId, err := senders.SendMessageFromSource(messageSender, encodedContent, source)
+	if err != nil && strings.Contains(err.Error(), utils.SecondaryDestinationNonTransientFailure) {
+		slog.Error("The primary destination accepted the file, but other destinations rejected it", slog.Any(utils.ErrorKey, err), slog.String("sourceUrl", sourceUrl), slog.String("reportId", reportId))
+		err = nil
+	}
 	if err != nil {
 		slog.Error("Failed to send the file to ReportStream", slog.Any(utils.ErrorKey, err), slog.String("sourceUrl", sourceUrl))
 
@@ -72,7 +110,13 @@ func (receiver *ReadAndSendUsecase) ReadAndSend(sourceUrl string) error {
 		// Returning `nil` will let queue.go delete the queue message so that it will stop retrying
 		// We're treating all other errors as unexpected (and possibly transient) for now
 		if strings.Contains(err.Error(), utils.ReportStreamNonTransientFailure) {
-			receiver.moveFile(sourceUrl, utils.FailureFolder)
+			failureUrl := receiver.moveFile(sourceUrl, utils.FailureFolder)
+			queueMessageAcknowledgement(receiver.acknowledgementQueue, receiver.blobHandler, Acknowledgement{
+				PartnerId: source.PartnerId,
+				SourceUrl: failureUrl,
+				Status:    AcknowledgementStatusRejected,
+				Reasons:   []string{err.Error()},
+			}, content)
 			return nil
 		}
 
@@ -82,11 +126,65 @@ func (receiver *ReadAndSendUsecase) ReadAndSend(sourceUrl string) error {
 
 	slog.Info("File sent to ReportStream", slog.String("reportId", reportId))
 
-	receiver.moveFile(sourceUrl, utils.SuccessFolder)
+	successUrl := receiver.moveFile(sourceUrl, utils.SuccessFolder)
+
+	// A success response only means ReportStream received the file, so queue a check on its delivery.
+	// We only log failures here because returning an error would send the file again
+	if receiver.statusQueue != nil && successUrl != "" && senders.TracksSubmissionHistory(messageSender) {
+		err = receiver.statusQueue.QueueStatusCheck(SubmissionStatusCheck{ReportId: reportId, SourceUrl: successUrl, ReportStreamUrl: senders.ReportStreamUrl(messageSender)})
+		if err != nil {
+			slog.Error("Failed to queue submission status check", slog.Any(utils.ErrorKey, err), slog.String("reportId", reportId))
+		}
+	}
+
+	acknowledgement := Acknowledgement{PartnerId: source.PartnerId, SourceUrl: successUrl, Status: AcknowledgementStatusReceived}
+	if senders.TracksSubmissionHistory(messageSender) {
+		acknowledgement.Status = AcknowledgementStatusSent
+		acknowledgement.ReportId = reportId
+	}
+	queueMessageAcknowledgement(receiver.acknowledgementQueue, receiver.blobHandler, acknowledgement, content)
 
 	return nil
 }
 
+// senderForUrl picks the partner's configured sender when the file is in a partner folder,
+// e.g. `sftp/ca-phl/import/message.hl7`, and otherwise falls back to the default sender
+func (receiver *ReadAndSendUsecase) senderForUrl(sourceUrl string) senders.MessageSender {
+	partnerId := partnerIdFromUrl(sourceUrl)
+	if partnerSender, ok := receiver.partnerSenders[partnerId]; ok {
+		slog.Info("Using partner senders", slog.String("partnerId", partnerId))
+		return partnerSender
+	}
+
+	return receiver.messageSender
+}
+
+// partnerIdFromUrl returns the folder just above `import` in a blob URL, or an empty string when the
+// file isn't in a partner folder
+func partnerIdFromUrl(sourceUrl string) string {
+	return partnerIdAboveFolder(sourceUrl, utils.MessageStartingFolderPath)
+}
+
+// partnerIdAboveFolder returns the folder just above folderName in a blob URL, e.g. `ca-phl` in
+// `sftp/ca-phl/success/message.hl7`, or an empty string when the file isn't in a partner folder
+func partnerIdAboveFolder(sourceUrl string, folderName string) string {
+	parsedUrl, err := url.Parse(sourceUrl)
+	if err != nil {
+		return ""
+	}
+
+	pathSegments := strings.Split(parsedUrl.Path, "/")
+	folderIndex := slices.Index(pathSegments, folderName)
+	if folderIndex < 1 || pathSegments[folderIndex-1] == utils.ContainerName {
+		return ""
+	}
+
+	return pathSegments[folderIndex-1]
+}
+
+// sourceEncoding is the encoding ConvertToUtf8 assumes source files use
+const sourceEncoding = "ISO-8859-1"
+
 // ConvertToUtf8 converts an HL7 file to UTF-8 encoding, which ReportStream expects
 // CADPH files are ISO-8859-1, so for now we'll assume all files are this format
 // TODO - make this conversion dynamic, possibly by file detection or partner config
@@ -98,17 +196,21 @@ func (receiver *ReadAndSendUsecase) ConvertToUtf8(content []byte) ([]byte, error
 	return encodedContent, nil
 }
 
-func (receiver *ReadAndSendUsecase) moveFile(sourceUrl string, newFolderName string) {
+// moveFile returns the file's new URL, or an empty string if it wasn't moved
+func (receiver *ReadAndSendUsecase) moveFile(sourceUrl string, newFolderName string) string {
 	destinationUrl := strings.Replace(sourceUrl, utils.MessageStartingFolderPath, newFolderName, 1)
 
 	if destinationUrl == sourceUrl {
 		slog.Error("Unexpected source URL, did not move", slog.String("sourceUrl", sourceUrl))
-		return
+		return ""
 	}
 
 	// After successful message handling, move source file
 	err := receiver.blobHandler.MoveFile(sourceUrl, destinationUrl)
 	if err != nil {
 		slog.Error("Failed to move file after processing", slog.Any(utils.ErrorKey, err))
+		return ""
 	}
+
+	return destinationUrl
 }
@@ -3,6 +3,7 @@ package usecases
 import (
 	"errors"
 	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/senders"
 	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -71,6 +72,97 @@ func Test_ReadAndSend_successfulReadAndSend(t *testing.T) {
 	mockBlobHandler.AssertCalled(t, "MoveFile", utils.SourceUrl, utils.SuccessSourceUrl)
 }
 
+func Test_ReadAndSend_successfulReadAndSend_QueuesSubmissionStatusCheck(t *testing.T) {
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte("The DogCow went Moof!"), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+
+	mockMessageSender := &MockTrackedMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("epic report ID", nil)
+
+	mockStatusQueue := &MockSubmissionStatusQueue{}
+	mockStatusQueue.On("QueueStatusCheck", mock.Anything).Return(nil)
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, statusQueue: mockStatusQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	mockStatusQueue.AssertCalled(t, "QueueStatusCheck", SubmissionStatusCheck{ReportId: "epic report ID", SourceUrl: utils.SuccessSourceUrl})
+}
+
+func Test_ReadAndSend_UnableToQueueSubmissionStatusCheck_LogsErrorAndReturnsNil(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte("The DogCow went Moof!"), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+
+	mockMessageSender := &MockTrackedMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("epic report ID", nil)
+
+	mockStatusQueue := &MockSubmissionStatusQueue{}
+	mockStatusQueue.On("QueueStatusCheck", mock.Anything).Return(errors.New("queue is down"))
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, statusQueue: mockStatusQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), "Failed to queue submission status check")
+}
+
+func Test_ReadAndSend_SenderDoesNotTrackSubmissionHistory_DoesNotQueueSubmissionStatusCheck(t *testing.T) {
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte("The DogCow went Moof!"), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+
+	mockMessageSender := &MockMessageSender{}
+	mockMessageSender.On("SendMessage", mock.Anything).Return("epic report ID", nil)
+
+	mockStatusQueue := &MockSubmissionStatusQueue{}
+
+	usecase := ReadAndSendUsecase{blobHandler: mockBlobHandler, messageSender: mockMessageSender, statusQueue: mockStatusQueue}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	mockStatusQueue.AssertNotCalled(t, "QueueStatusCheck", mock.Anything)
+}
+
+func Test_ReadAndSend_FileIsInPartnerFolder_UsesPartnerSender(t *testing.T) {
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("FetchFileByUrl", utils.SourceUrl).Return([]byte("The DogCow went Moof!"), nil)
+	mockBlobHandler.On("MoveFile", utils.SourceUrl, utils.SuccessSourceUrl).Return(nil)
+
+	defaultSender := &MockMessageSender{}
+	partnerSender := &MockMessageSender{}
+	partnerSender.On("SendMessage", mock.Anything).Return("partner report ID", nil)
+
+	usecase := ReadAndSendUsecase{
+		blobHandler:    mockBlobHandler,
+		messageSender:  defaultSender,
+		partnerSenders: map[string]senders.MessageSender{"customer": partnerSender},
+	}
+
+	err := usecase.ReadAndSend(utils.SourceUrl)
+
+	assert.NoError(t, err)
+	partnerSender.AssertCalled(t, "SendMessage", mock.Anything)
+	defaultSender.AssertNotCalled(t, "SendMessage", mock.Anything)
+}
+
+func Test_partnerIdFromUrl_ReturnsFolderAboveImport(t *testing.T) {
+	assert.Equal(t, "customer", partnerIdFromUrl(utils.SourceUrl))
+	assert.Equal(t, "ca-phl", partnerIdFromUrl("http://127.0.0.1:12000/devstoreaccount1/sftp/ca-phl/import/order_message.hl7"))
+}
+
+func Test_partnerIdFromUrl_FileIsNotInPartnerFolder_ReturnsEmptyString(t *testing.T) {
+	assert.Equal(t, "", partnerIdFromUrl("http://127.0.0.1:12000/devstoreaccount1/sftp/import/order_message.hl7"))
+	assert.Equal(t, "", partnerIdFromUrl("https://example.com/this/that/another"))
+}
+
 func Test_ConvertToUtf8_ConvertsSuccessfully_ReturnsEncodedContent(t *testing.T) {
 	usecase := ReadAndSendUsecase{}
 	originalContent, _ := os.ReadFile(filepath.Join("..", "..", "mock_data", "ISO-8859-1.hl7"))
@@ -148,3 +240,13 @@ func (receiver *MockMessageSender) SendMessage(message []byte) (string, error) {
 	args := receiver.Called(message)
 	return args.Get(0).(string), args.Error(1)
 }
+
+// MockTrackedMessageSender is a sender whose report IDs have a ReportStream submission history
+type MockTrackedMessageSender struct {
+	MockMessageSender
+}
+
+func (receiver *MockTrackedMessageSender) GetSubmissionHistory(reportId string) (senders.SubmissionHistory, error) {
+	args := receiver.Called(reportId)
+	return args.Get(0).(senders.SubmissionHistory), args.Error(1)
+}
@@ -0,0 +1,235 @@
+package usecases
+
+import (
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/CDCgov/reportstream-sftp-ingestion/senders"
+	"github.com/CDCgov/reportstream-sftp-ingestion/storage"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With the default 5-minute delay between checks, this gives ReportStream a day to finish with a report
+const maxSubmissionStatusChecks = 288
+
+var finalSubmissionStatuses = []string{utils.ReportStreamStatusDelivered, utils.ReportStreamStatusNotDelivering, utils.ReportStreamStatusError}
+var failedSubmissionStatuses = []string{utils.ReportStreamStatusNotDelivering, utils.ReportStreamStatusError}
+
+// SubmissionStatusCheck is the content of a queue message asking us to check on a report we sent to ReportStream
+type SubmissionStatusCheck struct {
+	ReportId string `json:"reportId"`
+	// SourceUrl is the message's blob URL in the `success` folder
+	SourceUrl string `json:"sourceUrl"`
+	Attempt   int    `json:"attempt"`
+	// ReportStreamUrl is the base URL of the ReportStream we sent the report to. Checks queued before we recorded it
+	// use REPORT_STREAM_URL_PREFIX
+	ReportStreamUrl string `json:"reportStreamUrl,omitempty"`
+}
+
+// The SubmissionStatusQueue interface is about scheduling a SubmissionStatusCheck to run later
+type SubmissionStatusQueue interface {
+	QueueStatusCheck(check SubmissionStatusCheck) error
+}
+
+type CheckSubmissionStatus interface {
+	CheckSubmissionStatus(check SubmissionStatusCheck) error
+}
+
+type CheckSubmissionStatusUsecase struct {
+	blobHandler          BlobHandler
+	historyGetter        senders.SubmissionHistoryGetter
+	statusQueue          SubmissionStatusQueue
+	acknowledgementQueue AcknowledgementQueue
+	// historyGetterForUrl checks on reports sent to a ReportStream other than REPORT_STREAM_URL_PREFIX
+	historyGetterForUrl func(baseUrl string) senders.SubmissionHistoryGetter
+}
+
+func NewCheckSubmissionStatusUsecase(statusQueue SubmissionStatusQueue, acknowledgementQueue AcknowledgementQueue) (CheckSubmissionStatusUsecase, error) {
+	blobHandler, err := storage.NewAzureBlobHandler()
+	if err != nil {
+		slog.Error("Failed to init Azure blob client", slog.Any(utils.ErrorKey, err))
+		return CheckSubmissionStatusUsecase{}, err
+	}
+
+	historyGetter, err := senders.NewSender()
+	if err != nil {
+		slog.Warn("Failed to construct the ReportStream senders", slog.Any(utils.ErrorKey, err))
+		return CheckSubmissionStatusUsecase{}, err
+	}
+
+	return CheckSubmissionStatusUsecase{
+		blobHandler:          blobHandler,
+		historyGetter:        historyGetter,
+		statusQueue:          statusQueue,
+		acknowledgementQueue: acknowledgementQueue,
+		historyGetterForUrl: func(baseUrl string) senders.SubmissionHistoryGetter {
+			return historyGetter.WithBaseUrl(baseUrl)
+		},
+	}, nil
+}
+
+// CheckSubmissionStatus asks ReportStream for the submission history of a report we've sent. If the report hasn't
+// reached a final status, we queue another check for later. Once it has, we record the status on the message's blob
+// metadata, and if the report failed after being accepted we move the message to `success/failure` and save the
+// history next to it, and queue an acknowledgement that the file was rejected. Returning an error leaves the queue
+// message in place so we'll retry the check
+func (receiver *CheckSubmissionStatusUsecase) CheckSubmissionStatus(check SubmissionStatusCheck) error {
+	history, err := receiver.historyGetterFor(check).GetSubmissionHistory(check.ReportId)
+	if err != nil {
+		slog.Error("Failed to get submission history", slog.Any(utils.ErrorKey, err), slog.String("reportId", check.ReportId))
+		return err
+	}
+
+	if !slices.Contains(finalSubmissionStatuses, history.OverallStatus) {
+		if check.Attempt >= maxSubmissionStatusChecks {
+			slog.Warn("Report did not reach a final status, no longer checking", slog.String("reportId", check.ReportId), slog.String("status", history.OverallStatus), slog.String("sourceUrl", check.SourceUrl))
+			receiver.recordStatus(check.SourceUrl, check.ReportId, history)
+			return nil
+		}
+
+		slog.Info("Report has not reached a final status, checking again later", slog.String("reportId", check.ReportId), slog.String("status", history.OverallStatus))
+		check.Attempt++
+		return receiver.statusQueue.QueueStatusCheck(check)
+	}
+
+	slog.Info("Report reached a final status", slog.String("reportId", check.ReportId), slog.String("status", history.OverallStatus))
+
+	messageUrl := check.SourceUrl
+	if slices.Contains(failedSubmissionStatuses, history.OverallStatus) {
+		messageUrl, err = receiver.moveToDeliveryFailure(check.SourceUrl, history)
+		if err != nil {
+			return err
+		}
+		receiver.acknowledgeDeliveryFailure(messageUrl, check.ReportId, history)
+	}
+
+	receiver.recordStatus(messageUrl, check.ReportId, history)
+
+	return nil
+}
+
+// historyGetterFor asks the ReportStream the report was sent to, which a partner's destination can override
+func (receiver *CheckSubmissionStatusUsecase) historyGetterFor(check SubmissionStatusCheck) senders.SubmissionHistoryGetter {
+	if check.ReportStreamUrl == "" || receiver.historyGetterForUrl == nil {
+		return receiver.historyGetter
+	}
+	return receiver.historyGetterForUrl(check.ReportStreamUrl)
+}
+
+// moveToDeliveryFailure moves a message from `success` to `success/failure` and uploads its submission history
+// alongside it as `<message name>.history.json`. A check that's redelivered after the move finds the message already
+// moved, and carries on from there
+func (receiver *CheckSubmissionStatusUsecase) moveToDeliveryFailure(sourceUrl string, history senders.SubmissionHistory) (string, error) {
+	successPath := "/" + utils.SuccessFolder + "/"
+	destinationUrl := strings.Replace(sourceUrl, successPath, successPath+utils.DeliveryFailureFolder+"/", 1)
+
+	if destinationUrl == sourceUrl {
+		slog.Error("Unexpected source URL, did not move", slog.String("sourceUrl", sourceUrl))
+		return sourceUrl, nil
+	}
+
+	err := receiver.blobHandler.MoveFile(sourceUrl, destinationUrl)
+	if err != nil && !receiver.alreadyMoved(sourceUrl, destinationUrl) {
+		slog.Error("Failed to move file after delivery failure", slog.Any(utils.ErrorKey, err), slog.String("sourceUrl", sourceUrl))
+		return "", err
+	}
+
+	historyBytes, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		slog.Error("Failed to marshal submission history", slog.Any(utils.ErrorKey, err), slog.String("destinationUrl", destinationUrl))
+		return destinationUrl, nil
+	}
+
+	destinationUrlParts, err := azblob.ParseURL(destinationUrl)
+	if err != nil {
+		slog.Error("Unable to parse destination URL", slog.String("destinationUrl", destinationUrl), slog.Any(utils.ErrorKey, err))
+		return destinationUrl, nil
+	}
+
+	err = receiver.blobHandler.UploadFile(historyBytes, destinationUrlParts.BlobName+".history.json")
+	if err != nil {
+		slog.Error("Failed to upload submission history", slog.Any(utils.ErrorKey, err), slog.String("destinationUrl", destinationUrl))
+	}
+
+	return destinationUrl, nil
+}
+
+// alreadyMoved checks whether a move we're retrying already happened, i.e. the source is gone and the destination is
+// there. If we can't tell, we assume it didn't, so the move is retried
+func (receiver *CheckSubmissionStatusUsecase) alreadyMoved(sourceUrl string, destinationUrl string) bool {
+	sourceExists, err := receiver.blobHandler.FileExists(sourceUrl)
+	if err != nil || sourceExists {
+		return false
+	}
+
+	destinationExists, err := receiver.blobHandler.FileExists(destinationUrl)
+	if err != nil || !destinationExists {
+		return false
+	}
+
+	slog.Info("File was already moved", slog.String("sourceUrl", sourceUrl), slog.String("destinationUrl", destinationUrl))
+	return true
+}
+
+// acknowledgeDeliveryFailure tells the partner their file was rejected after ReportStream accepted it, using the
+// errors from the submission history as reasons
+func (receiver *CheckSubmissionStatusUsecase) acknowledgeDeliveryFailure(messageUrl string, reportId string, history senders.SubmissionHistory) {
+	partnerId := partnerIdAboveFolder(messageUrl, utils.SuccessFolder)
+	if receiver.acknowledgementQueue == nil || partnerId == "" {
+		return
+	}
+
+	reasons := []string{"ReportStream status is " + history.OverallStatus}
+	for _, historyError := range history.Errors {
+		reasons = append(reasons, historyError.Message)
+	}
+
+	// The message's control IDs are only needed for HL7 ACKs, so an ACK without them is better than none
+	content, err := receiver.blobHandler.FetchFileByUrl(messageUrl)
+	if err != nil {
+		slog.Warn("Unable to read message for its acknowledgement", slog.Any(utils.ErrorKey, err), slog.String("messageUrl", messageUrl))
+	}
+
+	queueMessageAcknowledgement(receiver.acknowledgementQueue, receiver.blobHandler, Acknowledgement{
+		PartnerId: partnerId,
+		SourceUrl: messageUrl,
+		Status:    AcknowledgementStatusRejected,
+		ReportId:  reportId,
+		Reasons:   reasons,
+	}, content)
+}
+
+// recordStatus adds the submission status to the message's blob metadata. Setting metadata replaces all of it, so we
+// read what's there first to keep things like the zip the message came from. We only log failures here because the
+// report has already been handled by ReportStream and there's nothing to retry
+func (receiver *CheckSubmissionStatusUsecase) recordStatus(sourceUrl string, reportId string, history senders.SubmissionHistory) {
+	metadata, err := receiver.blobHandler.GetMetadata(sourceUrl)
+	if err != nil {
+		slog.Error("Failed to record submission status", slog.Any(utils.ErrorKey, err), slog.String("sourceUrl", sourceUrl))
+		return
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	var destinations []string
+	for _, destination := range history.Destinations {
+		destinations = append(destinations, destination.OrganizationId+"."+destination.Service)
+	}
+
+	metadata["reportstream_report_id"] = reportId
+	metadata["reportstream_status"] = history.OverallStatus
+	metadata["reportstream_destinations"] = strings.Join(destinations, ",")
+	metadata["reportstream_error_count"] = strconv.Itoa(history.ErrorCount)
+	metadata["reportstream_warning_count"] = strconv.Itoa(history.WarningCount)
+	metadata["reportstream_checked_at"] = time.Now().UTC().Format(time.RFC3339)
+
+	err = receiver.blobHandler.SetMetadata(sourceUrl, metadata)
+	if err != nil {
+		slog.Error("Failed to record submission status", slog.Any(utils.ErrorKey, err), slog.String("sourceUrl", sourceUrl))
+	}
+}
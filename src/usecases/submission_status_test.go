@@ -0,0 +1,322 @@
+package usecases
+
+import (
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/senders"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"log/slog"
+	"testing"
+)
+
+const reportId = "78809588-1193-4861-a6a7-52493f7dd254"
+const deliveryFailureUrl = "http://localhost/sftp/customer/success/failure/order_message.hl7"
+
+func Test_CheckSubmissionStatus_FailsToGetHistory_ReturnsError(t *testing.T) {
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{}, errors.New("500 Internal Server Error"))
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.Error(t, err)
+	mockBlobHandler.AssertNotCalled(t, "SetMetadata", mock.Anything, mock.Anything)
+}
+
+func Test_CheckSubmissionStatus_ReportWentToAnotherReportStream_AsksThatReportStream(t *testing.T) {
+	defaultHistoryGetter := &MockSubmissionHistoryGetter{}
+	stagingHistoryGetter := &MockSubmissionHistoryGetter{}
+	stagingHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: "Received"}, nil)
+
+	mockStatusQueue := &MockSubmissionStatusQueue{}
+	mockStatusQueue.On("QueueStatusCheck", mock.Anything).Return(nil)
+
+	usecase := CheckSubmissionStatusUsecase{
+		historyGetter: defaultHistoryGetter,
+		statusQueue:   mockStatusQueue,
+		historyGetterForUrl: func(baseUrl string) senders.SubmissionHistoryGetter {
+			assert.Equal(t, "https://staging.prime.cdc.gov", baseUrl)
+			return stagingHistoryGetter
+		},
+	}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl, ReportStreamUrl: "https://staging.prime.cdc.gov"})
+
+	assert.NoError(t, err)
+	defaultHistoryGetter.AssertNotCalled(t, "GetSubmissionHistory", mock.Anything)
+	mockStatusQueue.AssertCalled(t, "QueueStatusCheck", SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl, ReportStreamUrl: "https://staging.prime.cdc.gov", Attempt: 1})
+}
+
+func Test_CheckSubmissionStatus_StatusIsNotFinal_QueuesAnotherCheck(t *testing.T) {
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: "Received"}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+
+	mockStatusQueue := &MockSubmissionStatusQueue{}
+	mockStatusQueue.On("QueueStatusCheck", mock.Anything).Return(nil)
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter, statusQueue: mockStatusQueue}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl, Attempt: 2})
+
+	assert.NoError(t, err)
+	mockStatusQueue.AssertCalled(t, "QueueStatusCheck", SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl, Attempt: 3})
+	mockBlobHandler.AssertNotCalled(t, "SetMetadata", mock.Anything, mock.Anything)
+}
+
+func Test_CheckSubmissionStatus_StatusIsNotFinalAndUnableToQueue_ReturnsError(t *testing.T) {
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: "Waiting to Deliver"}, nil)
+
+	mockStatusQueue := &MockSubmissionStatusQueue{}
+	mockStatusQueue.On("QueueStatusCheck", mock.Anything).Return(errors.New("queue is down"))
+
+	usecase := CheckSubmissionStatusUsecase{historyGetter: mockHistoryGetter, statusQueue: mockStatusQueue}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.Error(t, err)
+}
+
+func Test_CheckSubmissionStatus_StatusIsNotFinalAfterMaxChecks_RecordsStatusAndStopsChecking(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: "Received"}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string{}, nil)
+	mockBlobHandler.On("SetMetadata", utils.SuccessSourceUrl, mock.Anything).Return(nil)
+
+	mockStatusQueue := &MockSubmissionStatusQueue{}
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter, statusQueue: mockStatusQueue}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl, Attempt: maxSubmissionStatusChecks})
+
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), "Report did not reach a final status, no longer checking")
+	mockStatusQueue.AssertNotCalled(t, "QueueStatusCheck", mock.Anything)
+	mockBlobHandler.AssertCalled(t, "SetMetadata", utils.SuccessSourceUrl, mock.Anything)
+}
+
+func Test_CheckSubmissionStatus_StatusIsDelivered_RecordsStatusWithoutMovingFile(t *testing.T) {
+	history := senders.SubmissionHistory{
+		OverallStatus: utils.ReportStreamStatusDelivered,
+		Destinations:  []senders.SubmissionDestination{{OrganizationId: "flexion", Service: "simulated-lab"}},
+	}
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(history, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string{}, nil)
+	mockBlobHandler.On("SetMetadata", utils.SuccessSourceUrl, mock.Anything).Return(nil)
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNotCalled(t, "MoveFile", mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "SetMetadata", utils.SuccessSourceUrl, mock.MatchedBy(func(metadata map[string]string) bool {
+		return metadata["reportstream_status"] == utils.ReportStreamStatusDelivered &&
+			metadata["reportstream_report_id"] == reportId &&
+			metadata["reportstream_destinations"] == "flexion.simulated-lab"
+	}))
+}
+
+func Test_CheckSubmissionStatus_StatusIsError_MovesFileAndUploadsHistory(t *testing.T) {
+	history := senders.SubmissionHistory{
+		OverallStatus: utils.ReportStreamStatusError,
+		ErrorCount:    1,
+		Errors:        []senders.SubmissionHistoryEntry{{Scope: "item", Message: "Invalid observation"}},
+	}
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(history, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("MoveFile", utils.SuccessSourceUrl, deliveryFailureUrl).Return(nil)
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("GetMetadata", deliveryFailureUrl).Return(map[string]string{}, nil)
+	mockBlobHandler.On("SetMetadata", deliveryFailureUrl, mock.Anything).Return(nil)
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertCalled(t, "MoveFile", utils.SuccessSourceUrl, deliveryFailureUrl)
+	mockBlobHandler.AssertCalled(t, "UploadFile", mock.Anything, "customer/success/failure/order_message.hl7.history.json")
+	mockBlobHandler.AssertCalled(t, "SetMetadata", deliveryFailureUrl, mock.Anything)
+}
+
+func Test_CheckSubmissionStatus_StatusIsError_QueuesRejectedAcknowledgement(t *testing.T) {
+	setUpAcknowledgementConfig(t, "customer")
+
+	history := senders.SubmissionHistory{
+		OverallStatus: utils.ReportStreamStatusError,
+		Errors:        []senders.SubmissionHistoryEntry{{Scope: "item", Message: "Invalid observation"}},
+	}
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(history, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("MoveFile", utils.SuccessSourceUrl, deliveryFailureUrl).Return(nil)
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("GetMetadata", deliveryFailureUrl).Return(map[string]string{}, nil)
+	mockBlobHandler.On("SetMetadata", deliveryFailureUrl, mock.Anything).Return(nil)
+	mockBlobHandler.On("FetchFileByUrl", deliveryFailureUrl).Return([]byte(ackMessage), nil)
+
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	mockAcknowledgementQueue.On("QueueAcknowledgement", mock.Anything).Return(nil)
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter, acknowledgementQueue: mockAcknowledgementQueue}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.NoError(t, err)
+	acknowledgement := mockAcknowledgementQueue.Calls[0].Arguments.Get(0).(Acknowledgement)
+	assert.Equal(t, "customer", acknowledgement.PartnerId)
+	assert.Equal(t, AcknowledgementStatusRejected, acknowledgement.Status)
+	assert.Equal(t, reportId, acknowledgement.ReportId)
+	assert.Equal(t, []string{"ReportStream status is " + utils.ReportStreamStatusError, "Invalid observation"}, acknowledgement.Reasons)
+	assert.Equal(t, []string{"control-1", "control-2"}, acknowledgement.MessageControlIds)
+}
+
+func Test_CheckSubmissionStatus_StatusIsNotDeliveringAndUnableToMoveFile_ReturnsError(t *testing.T) {
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: utils.ReportStreamStatusNotDelivering}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("MoveFile", utils.SuccessSourceUrl, deliveryFailureUrl).Return(errors.New("failed to move the file"))
+	mockBlobHandler.On("FileExists", utils.SuccessSourceUrl).Return(true, nil)
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.Error(t, err)
+	mockBlobHandler.AssertNotCalled(t, "SetMetadata", mock.Anything, mock.Anything)
+}
+
+func Test_CheckSubmissionStatus_CheckIsRedeliveredAfterFileWasMoved_RecordsStatus(t *testing.T) {
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: utils.ReportStreamStatusNotDelivering}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("MoveFile", utils.SuccessSourceUrl, deliveryFailureUrl).Return(errors.New("source blob not found"))
+	mockBlobHandler.On("FileExists", utils.SuccessSourceUrl).Return(false, nil)
+	mockBlobHandler.On("FileExists", deliveryFailureUrl).Return(true, nil)
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("GetMetadata", deliveryFailureUrl).Return(map[string]string{}, nil)
+	mockBlobHandler.On("SetMetadata", deliveryFailureUrl, mock.Anything).Return(nil)
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertCalled(t, "SetMetadata", deliveryFailureUrl, mock.Anything)
+}
+
+func Test_CheckSubmissionStatus_SourceAndDestinationAreBothMissing_ReturnsError(t *testing.T) {
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: utils.ReportStreamStatusNotDelivering}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("MoveFile", utils.SuccessSourceUrl, deliveryFailureUrl).Return(errors.New("source blob not found"))
+	mockBlobHandler.On("FileExists", mock.Anything).Return(false, nil)
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.Error(t, err)
+	mockBlobHandler.AssertNotCalled(t, "SetMetadata", mock.Anything, mock.Anything)
+}
+
+func Test_CheckSubmissionStatus_UnableToSetMetadata_LogsError(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: utils.ReportStreamStatusDelivered}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string{}, nil)
+	mockBlobHandler.On("SetMetadata", utils.SuccessSourceUrl, mock.Anything).Return(errors.New("failed to set metadata"))
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), "Failed to record submission status")
+}
+
+func Test_CheckSubmissionStatus_MessageCameFromZip_KeepsProvenanceMetadata(t *testing.T) {
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: utils.ReportStreamStatusDelivered}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string{
+		"source_archive":            "customer/unzip/orders.zip",
+		"source_archive_entry_path": "2024/order_message.hl7",
+	}, nil)
+	mockBlobHandler.On("SetMetadata", utils.SuccessSourceUrl, mock.Anything).Return(nil)
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertCalled(t, "SetMetadata", utils.SuccessSourceUrl, mock.MatchedBy(func(metadata map[string]string) bool {
+		return metadata["source_archive"] == "customer/unzip/orders.zip" &&
+			metadata["source_archive_entry_path"] == "2024/order_message.hl7" &&
+			metadata["reportstream_status"] == utils.ReportStreamStatusDelivered
+	}))
+}
+
+func Test_CheckSubmissionStatus_UnableToGetMetadata_DoesNotReplaceIt(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	mockHistoryGetter := &MockSubmissionHistoryGetter{}
+	mockHistoryGetter.On("GetSubmissionHistory", reportId).Return(senders.SubmissionHistory{OverallStatus: utils.ReportStreamStatusDelivered}, nil)
+
+	mockBlobHandler := &mocks.MockBlobHandler{}
+	mockBlobHandler.On("GetMetadata", utils.SuccessSourceUrl).Return(map[string]string(nil), errors.New("failed to get metadata"))
+
+	usecase := CheckSubmissionStatusUsecase{blobHandler: mockBlobHandler, historyGetter: mockHistoryGetter}
+
+	err := usecase.CheckSubmissionStatus(SubmissionStatusCheck{ReportId: reportId, SourceUrl: utils.SuccessSourceUrl})
+
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), "Failed to record submission status")
+	mockBlobHandler.AssertNotCalled(t, "SetMetadata", mock.Anything, mock.Anything)
+}
+
+type MockSubmissionHistoryGetter struct {
+	mock.Mock
+}
+
+func (receiver *MockSubmissionHistoryGetter) GetSubmissionHistory(reportId string) (senders.SubmissionHistory, error) {
+	args := receiver.Called(reportId)
+	return args.Get(0).(senders.SubmissionHistory), args.Error(1)
+}
+
+type MockSubmissionStatusQueue struct {
+	mock.Mock
+}
+
+func (receiver *MockSubmissionStatusQueue) QueueStatusCheck(check SubmissionStatusCheck) error {
+	args := receiver.Called(check)
+	return args.Error(0)
+}
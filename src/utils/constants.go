@@ -15,12 +15,39 @@ const SuccessFolder = "success"
 // we receive a failure response from ReportStream
 const FailureFolder = "failure"
 
+// HL7 messages are moved from the `SuccessFolder` to this subfolder when ReportStream's submission history
+// shows they failed after being accepted, e.g. `success/failure`. The submission history is saved next to the message
+const DeliveryFailureFolder = "failure"
+
 // Zip files are placed in this folder after being retrieved from an external SFTP site
 const UnzipFolder = "unzip"
 
+// Files are uploaded to this folder in the partner's folder to be checked before they go to `import` or `unzip`. It's
+// flat, so a partner folder named `import` can't make a staged file look like it's ready to process
+const StagingFolder = "staging"
+
+// Metadata on each file extracted from an archive, so it can be traced back to the archive it came from. The entry
+// path is URL-escaped, because metadata values can only be ASCII
+const SourceArchiveMetadataKey = "source_archive"
+const SourceArchiveEntryPathMetadataKey = "source_archive_entry_path"
+
+// Leases that make sure only one replica does something at a time are on empty blobs in this folder
+const LeaseFolder = "leases"
+
 // In read_and_send, move files to the `FailureFolder` when we get the below response from ReportStream
 const ReportStreamNonTransientFailure = "reportStreamNonTransientFailure"
 
+// A CompositeSender returns the below, along with the primary destination's report ID, when the primary destination
+// accepted a message that other destinations rejected. read_and_send treats the message as sent, since sending it
+// again would duplicate it at the primary destination
+const SecondaryDestinationNonTransientFailure = "secondaryDestinationNonTransientFailure"
+
+// ReportStream submission statuses that won't change any further. Any other status means ReportStream
+// is still working on the report, so we check again later
+const ReportStreamStatusDelivered = "Delivered"
+const ReportStreamStatusNotDelivering = "Not Delivering"
+const ReportStreamStatusError = "Error"
+
 // Use this when logging an error.
 // E.g. `slog.Warn("Failed to construct the ReportStream senders", slog.Any(utils.ErrorKey, err))`
 const ErrorKey = "error"
@@ -0,0 +1,323 @@
+package zip
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/google/uuid"
+	"github.com/yeka/zip"
+	"io"
+	"log/slog"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ArchiveFormatZip = "zip"
+const ArchiveFormatGzip = "gzip"
+const ArchiveFormatTar = "tar"
+
+const archiveHashLength = 12
+
+// archiveHeaderSize is enough bytes to find the tar magic number, which comes after the first file name
+const archiveHeaderSize = 262
+
+var emptyZipMagicNumber = []byte("PK\x05\x06")
+var gzipMagicNumber = []byte{0x1f, 0x8b}
+var tarMagicNumber = []byte("ustar")
+
+// DetectArchiveFormat looks at the first bytes of a file, rather than its name, to tell whether it's an archive we
+// can extract. It returns an empty string for anything else, e.g. an HL7 message
+func DetectArchiveFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, zipMagicNumber) || bytes.HasPrefix(header, emptyZipMagicNumber):
+		return ArchiveFormatZip
+	case bytes.HasPrefix(header, gzipMagicNumber):
+		return ArchiveFormatGzip
+	case len(header) >= archiveHeaderSize && bytes.Equal(header[257:262], tarMagicNumber):
+		return ArchiveFormatTar
+	default:
+		return ""
+	}
+}
+
+// extractZip checks a zip's headers against the partner's limits and then extracts each file. parentPath is where
+// the zip sits inside the archives we've already opened, and is empty for the zip we received
+func (zipHandler ZipHandler) extractZip(zipReader *zip.Reader, zipPassword string, zipFilePath string, parentPath string, budget *ExtractionBudget, errorList []FileError) []FileError {
+	archiveName := parentPath
+	if archiveName == "" {
+		archiveName = filepath.Base(zipFilePath)
+	}
+
+	// If the zip is too big overall, we don't extract any of it
+	archiveErrors := checkArchiveLimits(archiveName, zipReader.File, budget)
+	if len(archiveErrors) > 0 {
+		slog.Error("Zip is over the partner's limits", slog.String("archive", archiveName), slog.String("partnerId", zipHandler.partnerId))
+		return append(errorList, archiveErrors...)
+	}
+
+	for _, f := range zipReader.File {
+		errorList = zipHandler.extractZipEntry(f, zipPassword, zipFilePath, parentPath, budget, errorList)
+	}
+
+	return errorList
+}
+
+// extractGzip decompresses a gzip file. A `.tar.gz` is extracted as a tar, and anything else is treated as a single
+// file named after the gzip without its `.gz`
+func (zipHandler ZipHandler) extractGzip(reader io.Reader, zipPassword string, zipFilePath string, archivePath string, budget *ExtractionBudget, errorList []FileError) []FileError {
+	compressedReader := &countingReader{reader: reader}
+	gzipReader, err := gzip.NewReader(compressedReader)
+	if err != nil {
+		slog.Error("Failed to open gzip file", slog.String("archive", archivePath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		return append(errorList, FileError{Filename: archivePath, ErrorMessage: err.Error()})
+	}
+	defer gzipReader.Close()
+
+	decompressedReader := bufio.NewReader(gzipReader)
+	header, _ := decompressedReader.Peek(archiveHeaderSize)
+	if DetectArchiveFormat(header) == ArchiveFormatTar {
+		// Each file in the tar counts against the byte limit as we read it
+		return zipHandler.extractTar(decompressedReader, zipPassword, zipFilePath, archivePath, budget, errorList)
+	}
+
+	budget.entryCount++
+	name := strings.TrimSuffix(strings.TrimSuffix(path.Base(archivePath), ".gz"), ".gzip")
+	entryPath := path.Join(path.Dir(archivePath), name)
+
+	contents, err := io.ReadAll(io.LimitReader(decompressedReader, budget.remainingBytes+1))
+	if err != nil {
+		slog.Error("Failed to read gzip file", slog.String("archive", archivePath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		return append(errorList, FileError{Filename: archivePath, ErrorMessage: err.Error()})
+	}
+
+	return zipHandler.handleExtractedFile(entryPath, contents, uint64(compressedReader.count), zipPassword, zipFilePath, budget, errorList)
+}
+
+// extractTar extracts each regular file in a tar. Tars aren't compressed, so only the byte limit applies to their files
+func (zipHandler ZipHandler) extractTar(reader io.Reader, zipPassword string, zipFilePath string, archivePath string, budget *ExtractionBudget, errorList []FileError) []FileError {
+	tarReader := tar.NewReader(reader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return errorList
+		}
+		if err != nil {
+			slog.Error("Failed to read tar file", slog.String("archive", archivePath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+			return append(errorList, FileError{Filename: archivePath, ErrorMessage: err.Error()})
+		}
+
+		entryPath := path.Join(archivePath, header.Name)
+
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		budget.entryCount++
+		if budget.entryCount > budget.limits.MaxEntries {
+			slog.Error("Tar is over the partner's limits", slog.String("archive", archivePath), slog.String("partnerId", zipHandler.partnerId))
+			return append(errorList, FileError{Filename: archivePath, ErrorMessage: fmt.Sprintf("archive has more than the limit of %d files", budget.limits.MaxEntries)})
+		}
+
+		err = validateEntryName(header.Name)
+		if err == nil && header.Typeflag != tar.TypeReg {
+			err = fmt.Errorf("tar entry type %q isn't a regular file", header.Typeflag)
+		}
+		if err != nil {
+			slog.Error("Rejected file in tar", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+			errorList = append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
+			continue
+		}
+
+		contents, err := io.ReadAll(io.LimitReader(tarReader, budget.remainingBytes+1))
+		if err != nil {
+			slog.Error("Failed to read message file", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+			errorList = append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
+			continue
+		}
+
+		errorList = zipHandler.handleExtractedFile(entryPath, contents, uint64(len(contents)), zipPassword, zipFilePath, budget, errorList)
+	}
+}
+
+// handleExtractedFile checks a file's contents against the partner's limits. Nested archives are extracted in turn,
+// and other files are staged for the archive's folder in `import` (see extractedFolder), keeping their path inside
+// the archive
+func (zipHandler ZipHandler) handleExtractedFile(entryPath string, contents []byte, compressedBytes uint64, zipPassword string, zipFilePath string, budget *ExtractionBudget, errorList []FileError) []FileError {
+	err := checkExtractedContents(contents, int64(len(contents)) > budget.remainingBytes, compressedBytes, budget)
+	if err != nil {
+		slog.Error("Rejected file in zip", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		return append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
+	}
+
+	// entryPath starts with the archive's name for gzip and tar files, but the archive's folder already says that
+	archiveEntryPath := strings.TrimPrefix(strings.ReplaceAll(entryPath, "\\", "/"), path.Base(zipFilePath)+"/")
+
+	// Manifests list the files at the top of the archive, rather than files in nested archives
+	if zipHandler.extraction != nil && budget.depth == 1 {
+		if isManifestName(archiveEntryPath) {
+			zipHandler.addManifest(archiveEntryPath, contents, path.Base(zipFilePath))
+			return errorList
+		}
+		zipHandler.extraction.receivedEntries[archiveEntryPath] = newReceivedEntry(contents)
+	}
+
+	format := DetectArchiveFormat(contents)
+	if format != "" {
+		slog.Info("Extracting nested archive", slog.String(utils.FileNameKey, entryPath), slog.String("format", format), slog.String("zipFilePath", zipFilePath))
+		budget.depth++
+		defer func() { budget.depth-- }()
+
+		switch format {
+		case ArchiveFormatZip:
+			nestedZipReader, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+			if err != nil {
+				slog.Error("Failed to open nested zip", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+				return append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
+			}
+			return zipHandler.extractZip(nestedZipReader, zipPassword, zipFilePath, entryPath, budget, errorList)
+		case ArchiveFormatGzip:
+			return zipHandler.extractGzip(bytes.NewReader(contents), zipPassword, zipFilePath, entryPath, budget, errorList)
+		default:
+			return zipHandler.extractTar(bytes.NewReader(contents), zipPassword, zipFilePath, entryPath, budget, errorList)
+		}
+	}
+
+	metadata := map[string]string{
+		utils.SourceArchiveMetadataKey: zipFilePath,
+		// Metadata values have to be ASCII, and file names in archives don't
+		utils.SourceArchiveEntryPathMetadataKey: (&url.URL{Path: archiveEntryPath}).EscapedPath(),
+	}
+	destination := path.Join(zipHandler.extractedFolder(zipFilePath), archiveEntryPath)
+
+	if zipHandler.extraction != nil {
+		if !zipHandler.extraction.shouldUpload(entryPath) {
+			slog.Info("Skipping file that was already uploaded", slog.String(utils.FileNameKey, entryPath), slog.String("zipFilePath", zipFilePath))
+			return errorList
+		}
+		return zipHandler.stageExtractedFile(entryPath, contents, destination, metadata, zipFilePath, errorList)
+	}
+
+	return zipHandler.uploadExtractedFile(entryPath, contents, destination, metadata, zipFilePath, errorList)
+}
+
+func (zipHandler ZipHandler) uploadExtractedFile(entryPath string, contents []byte, destination string, metadata map[string]string, zipFilePath string, errorList []FileError) []FileError {
+	err := zipHandler.blobHandler.UploadFileWithMetadata(contents, destination, metadata)
+	if err != nil {
+		slog.Error("Failed to upload message file", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		return append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
+	}
+
+	slog.Info("uploaded file to blob for import", slog.String(utils.FileNameKey, entryPath), slog.String("zipFilePath", zipFilePath))
+	return errorList
+}
+
+// stageExtractedFile uploads a file to the partner's staging folder until we've checked the archive against its
+// manifests. Files in `import` start processing as soon as they're created, so they can't wait there
+func (zipHandler ZipHandler) stageExtractedFile(entryPath string, contents []byte, destination string, metadata map[string]string, zipFilePath string, errorList []FileError) []FileError {
+	stagingPath := path.Join(zipHandler.partnerId, utils.StagingFolder, uuid.NewString())
+	err := zipHandler.blobHandler.UploadFileWithMetadata(contents, stagingPath, metadata)
+	if err != nil {
+		slog.Error("Failed to upload message file", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		return append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
+	}
+
+	zipHandler.extraction.pendingUploads = append(zipHandler.extraction.pendingUploads, pendingUpload{entryPath: entryPath, stagingPath: stagingPath, destination: destination})
+	return errorList
+}
+
+// promotePendingFiles copies each staged file to its folder in `import`. The copy happens within blob storage and
+// keeps the staged file's metadata
+func (zipHandler ZipHandler) promotePendingFiles(zipFilePath string, errorList []FileError) []FileError {
+	for _, upload := range zipHandler.extraction.pendingUploads {
+		err := zipHandler.blobHandler.CopyFile(upload.stagingPath, upload.destination)
+		if err != nil {
+			slog.Error("Failed to upload message file", slog.String(utils.FileNameKey, upload.entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+			errorList = append(errorList, FileError{Filename: upload.entryPath, ErrorMessage: err.Error()})
+		} else {
+			slog.Info("uploaded file to blob for import", slog.String(utils.FileNameKey, upload.entryPath), slog.String("zipFilePath", zipFilePath))
+		}
+	}
+
+	zipHandler.discardPendingFiles(zipFilePath)
+	return errorList
+}
+
+// discardPendingFiles removes the staged files. One we can't remove only takes up space, so that's just a warning
+func (zipHandler ZipHandler) discardPendingFiles(zipFilePath string) {
+	for _, upload := range zipHandler.extraction.pendingUploads {
+		err := zipHandler.blobHandler.DeleteFile(upload.stagingPath)
+		if err != nil {
+			slog.Warn("Failed to remove staged file", slog.String("stagingPath", upload.stagingPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		}
+	}
+}
+
+// addManifest reads a manifest from in or next to the archive. We can't check an archive against a manifest we can't
+// read, so that fails the archive like a mismatch would
+func (zipHandler ZipHandler) addManifest(manifestName string, contents []byte, archiveName string) {
+	parsedManifest, err := parseManifest(manifestName, contents, archiveName)
+	if err != nil {
+		slog.Error("Failed to read manifest", slog.String("manifest", manifestName), slog.Any(utils.ErrorKey, err), slog.String("archive", archiveName))
+		zipHandler.extraction.manifestErrors = append(zipHandler.extraction.manifestErrors, FileError{Filename: manifestName, ErrorMessage: err.Error()})
+		return
+	}
+
+	slog.Info("Found manifest", slog.String("manifest", manifestName), slog.String("archive", archiveName))
+	zipHandler.extraction.manifests = append(zipHandler.extraction.manifests, parsedManifest)
+}
+
+// verifyManifests checks the archive against each of its manifests, and checks there is one if the partner requires it
+func (zipHandler ZipHandler) verifyManifests(archive io.ReaderAt, size int64, archiveName string) []FileError {
+	errorList := zipHandler.extraction.manifestErrors
+	if len(zipHandler.extraction.manifests) == 0 && len(errorList) == 0 && zipHandler.partnerSettings.RequireManifest {
+		return []FileError{{Filename: archiveName, ErrorMessage: "partner " + zipHandler.partnerId + " requires a manifest, but there isn't one in or next to the archive"}}
+	}
+
+	for _, archiveManifest := range zipHandler.extraction.manifests {
+		errorList = append(errorList, archiveManifest.verify(archive, size, archiveName, zipHandler.extraction.receivedEntries)...)
+	}
+	return errorList
+}
+
+// extractedFolder is the folder an archive's files are uploaded to, e.g. `ca-phl/import/results.zip-1a2b3c4d5e6f` for
+// `ca-phl/unzip/results.zip`, so files from a zip in a partner's folder stay in that partner's folder. Each archive
+// gets its own folder, and the archive's hash keeps two archives with the same name apart. Uploading the same archive
+// again overwrites its files rather than duplicating them
+func (zipHandler ZipHandler) extractedFolder(zipFilePath string) string {
+	importFolder := strings.Replace(path.Dir(zipFilePath), utils.UnzipFolder, utils.MessageStartingFolderPath, 1)
+	folderName := path.Base(zipFilePath)
+	if zipHandler.extraction != nil {
+		folderName += "-" + zipHandler.extraction.hash
+	}
+	return path.Join(importFolder, folderName)
+}
+
+// hashArchive returns the start of the archive's SHA-256, which is plenty to tell apart archives with the same name
+func hashArchive(archive io.ReaderAt, size int64) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, io.NewSectionReader(archive, 0, size))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:archiveHashLength], nil
+}
+
+// countingReader counts the bytes read through it, so we can work out a gzip file's compression ratio
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (receiver *countingReader) Read(p []byte) (int, error) {
+	n, err := receiver.reader.Read(p)
+	receiver.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,199 @@
+package zip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yeka/zip"
+	"testing"
+)
+
+func Test_DetectArchiveFormat_DetectsByContentsNotName(t *testing.T) {
+	assert.Equal(t, ArchiveFormatZip, DetectArchiveFormat(buildTestZip(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})))
+	assert.Equal(t, ArchiveFormatZip, DetectArchiveFormat(buildTestZip(t)))
+	assert.Equal(t, ArchiveFormatGzip, DetectArchiveFormat(buildTestGzip(t, []byte("MSH|order"))))
+	assert.Equal(t, ArchiveFormatTar, DetectArchiveFormat(buildTestTar(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})))
+	assert.Equal(t, "", DetectArchiveFormat([]byte("MSH|^~\\&|order.zip")))
+	assert.Equal(t, "", DetectArchiveFormat([]byte{}))
+}
+
+func Test_Unzip_FileIsGzip_UploadsDecompressedFile(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{}, new(mocks.MockCredentialGetter), buildTestGzip(t, []byte("MSH|order")))
+
+	err := zipHandler.Unzip(archive, archive.Size(), "unzip/order.hl7.gz", nil)
+
+	assert.NoError(t, err)
+	assertExtracted(t, mockBlobHandler, []byte("MSH|order"), extractedPath(archive, "unzip/order.hl7.gz", "order.hl7"))
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, "sftp/unzip/success/order.hl7.gz")
+}
+
+func Test_Unzip_FileIsTarGz_UploadsEachFile(t *testing.T) {
+	tarBytes := buildTestTar(t, testZipEntry{name: "orders/order.hl7", contents: []byte("MSH|order")}, testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{}, new(mocks.MockCredentialGetter), buildTestGzip(t, tarBytes))
+
+	err := zipHandler.Unzip(archive, archive.Size(), "unzip/messages.tar.gz", nil)
+
+	assert.NoError(t, err)
+	assertExtracted(t, mockBlobHandler, []byte("MSH|order"), extractedPath(archive, "unzip/messages.tar.gz", "orders/order.hl7"))
+	assertExtracted(t, mockBlobHandler, []byte("MSH|result"), extractedPath(archive, "unzip/messages.tar.gz", "result.hl7"))
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, "sftp/unzip/success/messages.tar.gz")
+}
+
+func Test_Unzip_TarHasUnsafeFileName_RecordsError(t *testing.T) {
+	tarBytes := buildTestTar(t, testZipEntry{name: "../order.hl7", contents: []byte("MSH|order")})
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{}, new(mocks.MockCredentialGetter), tarBytes)
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockBlobHandler.AssertNotCalled(t, "UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything)
+	assertErrorListContains(t, mockBlobHandler, "file name contains '..'")
+}
+
+func Test_Unzip_TarHasTooManyFiles_RecordsError(t *testing.T) {
+	tarBytes := buildTestTar(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")}, testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{ZipLimits: config.ZipLimitSettings{MaxEntries: 1}}, new(mocks.MockCredentialGetter), tarBytes)
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	assertErrorListContains(t, mockBlobHandler, "archive has more than the limit of 1 files")
+}
+
+func Test_Unzip_NestedArchivesWithinDepth_UploadsNestedFiles(t *testing.T) {
+	innerZip := buildTestZip(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+	innerGzip := buildTestGzip(t, []byte("MSH|result"))
+	outerZip := buildTestZip(t, testZipEntry{name: "orders.zip", contents: innerZip}, testZipEntry{name: "result.hl7.gz", contents: innerGzip})
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{ZipLimits: config.ZipLimitSettings{MaxNestingDepth: 2}}, new(mocks.MockCredentialGetter), outerZip)
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	assertExtracted(t, mockBlobHandler, []byte("MSH|order"), extractedPath(archive, blobPath, "orders.zip/order.hl7"))
+	assertExtracted(t, mockBlobHandler, []byte("MSH|result"), extractedPath(archive, blobPath, "result.hl7"))
+	mockBlobHandler.AssertNotCalled(t, "UploadFileWithMetadata", innerZip, mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipSuccessUrl)
+}
+
+func Test_Unzip_NestedArchivesBeyondDepth_RecordsError(t *testing.T) {
+	innermostZip := buildTestZip(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+	innerZip := buildTestZip(t, testZipEntry{name: "innermost.zip", contents: innermostZip})
+	outerZip := buildTestZip(t, testZipEntry{name: "inner.zip", contents: innerZip})
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{ZipLimits: config.ZipLimitSettings{MaxNestingDepth: 2}}, new(mocks.MockCredentialGetter), outerZip)
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockBlobHandler.AssertNotCalled(t, "UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything)
+	assertErrorListContains(t, mockBlobHandler, "inner.zip/innermost.zip: file is a nested archive, deeper than the limit of 2")
+}
+
+func Test_Unzip_FileIsAesEncrypted_UnzipsSuccessfully(t *testing.T) {
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+	writer, err := zipWriter.Encrypt("order.hl7", "test123", zip.AES256Encryption)
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte("MSH|order"))
+	assert.NoError(t, err)
+	assert.NoError(t, zipWriter.Close())
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockCredentialGetter.On("GetSecret", zipPasswordSecret).Return("test123", nil)
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{HasZipPassword: true}, mockCredentialGetter, buffer.Bytes())
+
+	err = zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	assertExtracted(t, mockBlobHandler, []byte("MSH|order"), extractedPath(archive, blobPath, "order.hl7"))
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipSuccessUrl)
+}
+
+func Test_Unzip_FileIsNotAnArchive_ReturnsError(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{}, new(mocks.MockCredentialGetter), []byte("MSH|order"))
+
+	err := zipHandler.Unzip(archive, archive.Size(), "unzip/order.zip.bak", nil)
+
+	assert.Error(t, err)
+	mockBlobHandler.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, "sftp/unzip/failure/order.zip.bak")
+}
+
+func Test_extractedFolder_ReturnsFolderForArchiveInPartnerImportFolder(t *testing.T) {
+	zipHandler := ZipHandler{extraction: &archiveExtraction{hash: "1a2b3c4d5e6f"}}
+
+	assert.Equal(t, "ca-phl/import/results.zip-1a2b3c4d5e6f", zipHandler.extractedFolder("ca-phl/unzip/results.zip"))
+}
+
+func Test_Unzip_ZipHasFolders_KeepsPathAndRecordsProvenance(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "results/lab résult.hl7", contents: []byte("MSH|result")})
+
+	err := zipHandler.Unzip(archive, archive.Size(), "ca-phl/unzip/cheeseburger.zip", nil)
+
+	assert.NoError(t, err)
+	assertExtracted(t, mockBlobHandler, []byte("MSH|result"), extractedPath(archive, "ca-phl/unzip/cheeseburger.zip", "results/lab résult.hl7"))
+	mockBlobHandler.AssertCalled(t, "UploadFileWithMetadata", []byte("MSH|result"), mock.MatchedBy(isStagingPath), map[string]string{
+		utils.SourceArchiveMetadataKey:          "ca-phl/unzip/cheeseburger.zip",
+		utils.SourceArchiveEntryPathMetadataKey: "results/lab%20r%C3%A9sult.hl7",
+	})
+}
+
+func Test_Unzip_ZipsWithSameNameHaveSameEntry_UploadsToDifferentFolders(t *testing.T) {
+	firstZipHandler, firstBlobHandler, firstArchive := zipHandlerForTestZip(t, config.ZipLimitSettings{}, testZipEntry{name: "results.hl7", contents: []byte("MSH|first")})
+	secondZipHandler, secondBlobHandler, secondArchive := zipHandlerForTestZip(t, config.ZipLimitSettings{}, testZipEntry{name: "results.hl7", contents: []byte("MSH|second")})
+
+	assert.NoError(t, firstZipHandler.Unzip(firstArchive, firstArchive.Size(), blobPath, nil))
+	assert.NoError(t, secondZipHandler.Unzip(secondArchive, secondArchive.Size(), blobPath, nil))
+
+	firstPath := extractedPath(firstArchive, blobPath, "results.hl7")
+	secondPath := extractedPath(secondArchive, blobPath, "results.hl7")
+	assert.NotEqual(t, firstPath, secondPath)
+	assertExtracted(t, firstBlobHandler, []byte("MSH|first"), firstPath)
+	assertExtracted(t, secondBlobHandler, []byte("MSH|second"), secondPath)
+}
+
+func Test_Unzip_FilesAreCopiedToImport_RemovesStagedFiles(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")},
+		testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "CopyFile", 2)
+	mockBlobHandler.AssertNumberOfCalls(t, "DeleteFile", 2)
+	for _, call := range mockBlobHandler.Calls {
+		if call.Method == "CopyFile" {
+			mockBlobHandler.AssertCalled(t, "DeleteFile", call.Arguments.String(0))
+		}
+	}
+}
+
+func buildTestGzip(t *testing.T, contents []byte) []byte {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	_, err := gzipWriter.Write(contents)
+	assert.NoError(t, err)
+	assert.NoError(t, gzipWriter.Close())
+	return buffer.Bytes()
+}
+
+func buildTestTar(t *testing.T, entries ...testZipEntry) []byte {
+	var buffer bytes.Buffer
+	tarWriter := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		err := tarWriter.WriteHeader(&tar.Header{Name: entry.name, Mode: 0600, Size: int64(len(entry.contents)), Typeflag: tar.TypeReg})
+		assert.NoError(t, err)
+		_, err = tarWriter.Write(entry.contents)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tarWriter.Close())
+	return buffer.Bytes()
+}
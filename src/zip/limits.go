@@ -0,0 +1,135 @@
+package zip
+
+import (
+	"errors"
+	"fmt"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/yeka/zip"
+	"path/filepath"
+	"strings"
+)
+
+// Defaults for partners that don't set their own `zipLimits`
+const defaultMaxUncompressedBytes = 1 << 30 // 1 GiB
+const defaultMaxCompressionRatio = 100
+const defaultMaxEntries = 1000
+const defaultMaxNestingDepth = 1
+
+// Every zip file with files in it starts with a local file header signature
+var zipMagicNumber = []byte("PK\x03\x04")
+
+// ExtractionBudget tracks what's left of a zip's limits as we extract its files
+type ExtractionBudget struct {
+	limits         config.ZipLimitSettings
+	remainingBytes int64
+	// entryCount includes files in nested archives
+	entryCount int
+	// depth is 1 for the zip we received
+	depth int
+}
+
+func NewExtractionBudget(zipLimits config.ZipLimitSettings) *ExtractionBudget {
+	limits := zipLimitsWithDefaults(zipLimits)
+	return &ExtractionBudget{limits: limits, remainingBytes: limits.MaxUncompressedBytes, depth: 1}
+}
+
+func zipLimitsWithDefaults(zipLimits config.ZipLimitSettings) config.ZipLimitSettings {
+	if zipLimits.MaxUncompressedBytes == 0 {
+		zipLimits.MaxUncompressedBytes = defaultMaxUncompressedBytes
+	}
+	if zipLimits.MaxCompressionRatio == 0 {
+		zipLimits.MaxCompressionRatio = defaultMaxCompressionRatio
+	}
+	if zipLimits.MaxEntries == 0 {
+		zipLimits.MaxEntries = defaultMaxEntries
+	}
+	if zipLimits.MaxNestingDepth == 0 {
+		zipLimits.MaxNestingDepth = defaultMaxNestingDepth
+	}
+	return zipLimits
+}
+
+// checkArchiveLimits uses the sizes in a zip's headers to catch zips that are too big before we extract anything.
+// The headers can lie, so we also count bytes as we extract
+func checkArchiveLimits(archiveName string, files []*zip.File, budget *ExtractionBudget) []FileError {
+	var errorList []FileError
+
+	budget.entryCount += len(files)
+	if budget.entryCount > budget.limits.MaxEntries {
+		errorList = append(errorList, FileError{Filename: archiveName, ErrorMessage: fmt.Sprintf("zip has %d files, more than the limit of %d", budget.entryCount, budget.limits.MaxEntries)})
+	}
+
+	var totalUncompressedBytes uint64
+	for _, f := range files {
+		totalUncompressedBytes += f.UncompressedSize64
+	}
+	if totalUncompressedBytes > uint64(budget.remainingBytes) {
+		errorList = append(errorList, FileError{Filename: archiveName, ErrorMessage: fmt.Sprintf("zip has %d uncompressed bytes, more than the limit of %d", totalUncompressedBytes, budget.limits.MaxUncompressedBytes)})
+	}
+
+	return errorList
+}
+
+// checkEntryHeader rejects a file based on its name and headers, before we open it
+func checkEntryHeader(f *zip.File, budget *ExtractionBudget) error {
+	err := validateEntryName(f.Name)
+	if err != nil {
+		return err
+	}
+
+	return checkCompressionRatio(f.UncompressedSize64, f.CompressedSize64, budget)
+}
+
+// validateEntryName rejects names that could write outside the folder we extract to
+func validateEntryName(name string) error {
+	normalizedName := strings.ReplaceAll(name, "\\", "/")
+
+	if strings.HasPrefix(normalizedName, "/") || filepath.IsAbs(name) || (len(normalizedName) > 1 && normalizedName[1] == ':') {
+		return errors.New("file name is an absolute path")
+	}
+
+	for _, segment := range strings.Split(normalizedName, "/") {
+		if segment == ".." {
+			return errors.New("file name contains '..'")
+		}
+	}
+
+	return nil
+}
+
+func checkCompressionRatio(uncompressedBytes uint64, compressedBytes uint64, budget *ExtractionBudget) error {
+	if compressedBytes == 0 {
+		if uncompressedBytes == 0 {
+			return nil
+		}
+		compressedBytes = 1
+	}
+
+	ratio := float64(uncompressedBytes) / float64(compressedBytes)
+	if ratio > budget.limits.MaxCompressionRatio {
+		return fmt.Errorf("compression ratio %.0f is more than the limit of %.0f", ratio, budget.limits.MaxCompressionRatio)
+	}
+
+	return nil
+}
+
+// checkExtractedContents checks a file's actual contents once we've read them. maxBytesExceeded means we stopped
+// reading at the zip's remaining byte limit
+func checkExtractedContents(contents []byte, maxBytesExceeded bool, compressedBytes uint64, budget *ExtractionBudget) error {
+	if maxBytesExceeded {
+		budget.remainingBytes = 0
+		return fmt.Errorf("zip has more than the limit of %d uncompressed bytes", budget.limits.MaxUncompressedBytes)
+	}
+	budget.remainingBytes -= int64(len(contents))
+
+	err := checkCompressionRatio(uint64(len(contents)), compressedBytes, budget)
+	if err != nil {
+		return err
+	}
+
+	if DetectArchiveFormat(contents) != "" && budget.depth >= budget.limits.MaxNestingDepth {
+		return fmt.Errorf("file is a nested archive, deeper than the limit of %d", budget.limits.MaxNestingDepth)
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package zip
+
+import (
+	stdzip "archive/zip"
+	"bytes"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"path"
+	"strings"
+	"testing"
+)
+
+type testZipEntry struct {
+	name     string
+	contents []byte
+}
+
+func Test_validateEntryName_NameIsUnsafe_ReturnsError(t *testing.T) {
+	for _, name := range []string{"../order.hl7", "orders/../../order.hl7", "..\\order.hl7", "/etc/passwd", "C:\\order.hl7"} {
+		assert.Error(t, validateEntryName(name), name)
+	}
+}
+
+func Test_validateEntryName_NameIsSafe_ReturnsNil(t *testing.T) {
+	for _, name := range []string{"order.hl7", "orders/order.hl7", "orders/..order.hl7"} {
+		assert.NoError(t, validateEntryName(name), name)
+	}
+}
+
+func Test_zipLimitsWithDefaults_LimitsAreNotSet_UsesDefaults(t *testing.T) {
+	limits := zipLimitsWithDefaults(config.ZipLimitSettings{MaxEntries: 5})
+
+	assert.Equal(t, config.ZipLimitSettings{
+		MaxUncompressedBytes: defaultMaxUncompressedBytes,
+		MaxCompressionRatio:  defaultMaxCompressionRatio,
+		MaxEntries:           5,
+		MaxNestingDepth:      defaultMaxNestingDepth,
+	}, limits)
+}
+
+func Test_Unzip_FileNameHasPathTraversal_RecordsErrorAndUploadsOtherFiles(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "../../order.hl7", contents: []byte("MSH|evil")},
+		testZipEntry{name: "result.hl7", contents: []byte("MSH|good")})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	assertExtracted(t, mockBlobHandler, []byte("MSH|good"), extractedPath(archive, blobPath, "result.hl7"))
+	mockBlobHandler.AssertNotCalled(t, "UploadFileWithMetadata", []byte("MSH|evil"), mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
+	assertErrorListContains(t, mockBlobHandler, "../../order.hl7: file name contains '..'")
+}
+
+func Test_Unzip_TooManyEntries_RecordsErrorWithoutExtracting(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{MaxEntries: 1},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")},
+		testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockBlobHandler.AssertNotCalled(t, "UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
+	assertErrorListContains(t, mockBlobHandler, "cheeseburger.zip: zip has 2 files, more than the limit of 1")
+}
+
+func Test_Unzip_TooManyUncompressedBytes_RecordsErrorWithoutExtracting(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{MaxUncompressedBytes: 10},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order|")},
+		testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockBlobHandler.AssertNotCalled(t, "UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything)
+	assertErrorListContains(t, mockBlobHandler, "zip has 20 uncompressed bytes, more than the limit of 10")
+}
+
+func Test_Unzip_CompressionRatioIsTooHigh_RecordsError(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "bomb.hl7", contents: bytes.Repeat([]byte{0}, 100000)})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockBlobHandler.AssertNotCalled(t, "UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything)
+	assertErrorListContains(t, mockBlobHandler, "bomb.hl7: compression ratio")
+}
+
+func Test_Unzip_FileIsNestedZip_RecordsError(t *testing.T) {
+	nestedZip := buildTestZip(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "nested.zip", contents: nestedZip})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFile", 1)
+	mockBlobHandler.AssertNotCalled(t, "UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything)
+	assertErrorListContains(t, mockBlobHandler, "nested.zip: file is a nested archive, deeper than the limit of 1")
+}
+
+func Test_checkExtractedContents_MaxBytesExceeded_ReturnsErrorAndUsesRemainingBudget(t *testing.T) {
+	budget := NewExtractionBudget(config.ZipLimitSettings{MaxUncompressedBytes: 10})
+
+	err := checkExtractedContents([]byte("MSH|order|1"), true, 11, budget)
+
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), budget.remainingBytes)
+}
+
+func Test_checkExtractedContents_WithinLimits_ReducesRemainingBudget(t *testing.T) {
+	budget := NewExtractionBudget(config.ZipLimitSettings{MaxUncompressedBytes: 10})
+
+	err := checkExtractedContents([]byte("MSH|order"), false, 9, budget)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), budget.remainingBytes)
+}
+
+func zipHandlerForTestZip(t *testing.T, zipLimits config.ZipLimitSettings, entries ...testZipEntry) (ZipHandler, *mocks.MockBlobHandler, *bytes.Reader) {
+	return zipHandlerForTestArchive(config.PartnerSettings{ZipLimits: zipLimits}, new(mocks.MockCredentialGetter), buildTestZip(t, entries...))
+}
+
+// zipHandlerForTestArchive returns a ZipHandler that reads real archives, along with a reader for archiveBytes to pass
+// to Unzip
+func zipHandlerForTestArchive(partnerSettings config.PartnerSettings, credentialGetter *mocks.MockCredentialGetter, archiveBytes []byte) (ZipHandler, *mocks.MockBlobHandler, *bytes.Reader) {
+	mockBlobHandler := new(mocks.MockBlobHandler)
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
+
+	return ZipHandler{
+		credentialGetter: credentialGetter,
+		blobHandler:      mockBlobHandler,
+		zipClient:        ZipClientWrapper{},
+		partnerId:        partnerId,
+		partnerSettings:  partnerSettings,
+	}, mockBlobHandler, bytes.NewReader(archiveBytes)
+}
+
+// assertExtracted checks Unzip staged a file from the archive and then copied it to destination
+func assertExtracted(t *testing.T, mockBlobHandler *mocks.MockBlobHandler, contents any, destination string) {
+	mockBlobHandler.AssertCalled(t, "UploadFileWithMetadata", contents, mock.MatchedBy(isStagingPath), mock.Anything)
+	mockBlobHandler.AssertCalled(t, "CopyFile", mock.MatchedBy(isStagingPath), destination)
+}
+
+func isStagingPath(blobPath string) bool {
+	return strings.Contains(blobPath, utils.StagingFolder+"/")
+}
+
+// extractedPath is where Unzip should upload a file from the archive
+func extractedPath(archive *bytes.Reader, zipFilePath string, archiveEntryPath string) string {
+	archiveHash, _ := hashArchive(archive, archive.Size())
+	return path.Join(ZipHandler{extraction: &archiveExtraction{hash: archiveHash}}.extractedFolder(zipFilePath), archiveEntryPath)
+}
+
+func buildTestZip(t *testing.T, entries ...testZipEntry) []byte {
+	var buffer bytes.Buffer
+	zipWriter := stdzip.NewWriter(&buffer)
+	for _, entry := range entries {
+		writer, err := zipWriter.Create(entry.name)
+		assert.NoError(t, err)
+		_, err = writer.Write(entry.contents)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zipWriter.Close())
+	return buffer.Bytes()
+}
+
+func assertErrorListContains(t *testing.T, mockBlobHandler *mocks.MockBlobHandler, expected string) {
+	mockBlobHandler.AssertCalled(t, "UploadFile", mock.MatchedBy(func(contents []byte) bool {
+		return strings.Contains(string(contents), expected)
+	}), unzipFailurePath+".txt")
+}
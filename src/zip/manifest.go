@@ -0,0 +1,263 @@
+package zip
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const manifestCsvName = "manifest.csv"
+const md5Extension = ".md5"
+const sha256Extension = ".sha256"
+
+// SidecarManifestExtensions are the suffixes for a manifest next to a file on SFTP, e.g. `results.zip.sha256`
+var SidecarManifestExtensions = []string{sha256Extension, md5Extension, "." + manifestCsvName}
+
+// ManifestFile is a manifest that arrived next to a file rather than inside an archive
+type ManifestFile struct {
+	Name     string
+	Contents []byte
+}
+
+// manifest is what a partner says is in an archive. A `.md5` or `.sha256` file has lines in the format `sha256sum`
+// writes (`<hash>  <file name>`), or just the archive's hash. A `manifest.csv` has a header row with a `file` column
+// and any of `sha256`, `md5`, and `message_count`. A line for the archive itself checks the whole archive
+type manifest struct {
+	name             string
+	archiveChecksums manifestEntry
+	entries          map[string]manifestEntry
+}
+
+type manifestEntry struct {
+	sha256          string
+	md5             string
+	messageCount    int
+	hasMessageCount bool
+}
+
+// receivedEntry is what we actually found for a file in the archive
+type receivedEntry struct {
+	sha256       string
+	md5          string
+	messageCount int
+}
+
+// IsSidecarManifestName reports whether an SFTP file is a manifest for a file next to it
+func IsSidecarManifestName(fileName string) bool {
+	for _, extension := range SidecarManifestExtensions {
+		if strings.HasSuffix(strings.ToLower(fileName), extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// isManifestName reports whether a file at the top of an archive is the archive's manifest
+func isManifestName(entryPath string) bool {
+	name := strings.ToLower(entryPath)
+	return name == manifestCsvName || strings.HasSuffix(name, md5Extension) || strings.HasSuffix(name, sha256Extension)
+}
+
+func parseManifest(name string, contents []byte, archiveName string) (*manifest, error) {
+	parsed := &manifest{name: name, entries: make(map[string]manifestEntry)}
+
+	var err error
+	switch lowerName := strings.ToLower(name); {
+	case strings.HasSuffix(lowerName, ".csv"):
+		err = parsed.parseCsv(contents, archiveName)
+	case strings.HasSuffix(lowerName, md5Extension):
+		err = parsed.parseChecksums(contents, archiveName, md5.Size)
+	case strings.HasSuffix(lowerName, sha256Extension):
+		err = parsed.parseChecksums(contents, archiveName, sha256.Size)
+	default:
+		err = errors.New("manifest isn't a .csv, .md5, or .sha256 file")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return parsed, nil
+}
+
+func (receiver *manifest) parseChecksums(contents []byte, archiveName string, hashSize int) error {
+	for lineNumber, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, fileName, _ := strings.Cut(line, " ")
+		hash = strings.ToLower(hash)
+		// `*` marks a file hashed in binary mode, which is the same thing for us
+		fileName = strings.TrimPrefix(strings.TrimSpace(fileName), "*")
+
+		hashBytes, err := hex.DecodeString(hash)
+		if err != nil || len(hashBytes) != hashSize {
+			return fmt.Errorf("line %d doesn't start with a %d character hash", lineNumber+1, hashSize*2)
+		}
+
+		entry := manifestEntry{}
+		if hashSize == md5.Size {
+			entry.md5 = hash
+		} else {
+			entry.sha256 = hash
+		}
+		receiver.addEntry(fileName, entry, archiveName)
+	}
+
+	return nil
+}
+
+func (receiver *manifest) parseCsv(contents []byte, archiveName string) error {
+	rows, err := csv.NewReader(bytes.NewReader(contents)).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return errors.New("manifest is empty")
+	}
+
+	header := make([]string, len(rows[0]))
+	for index, column := range rows[0] {
+		header[index] = strings.ToLower(strings.TrimSpace(column))
+	}
+	fileColumn := slices.Index(header, "file")
+	if fileColumn < 0 {
+		return errors.New("manifest has no file column")
+	}
+
+	for rowNumber, row := range rows[1:] {
+		entry := manifestEntry{}
+		for index, value := range row {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+
+			switch header[index] {
+			case "sha256":
+				entry.sha256 = strings.ToLower(value)
+			case "md5":
+				entry.md5 = strings.ToLower(value)
+			case "message_count":
+				entry.messageCount, err = strconv.Atoi(value)
+				if err != nil {
+					return fmt.Errorf("row %d has a message_count that isn't a number", rowNumber+2)
+				}
+				entry.hasMessageCount = true
+			}
+		}
+		receiver.addEntry(strings.TrimSpace(row[fileColumn]), entry, archiveName)
+	}
+
+	return nil
+}
+
+// addEntry adds a manifest line. A line with no file name or the archive's name is for the archive itself
+func (receiver *manifest) addEntry(fileName string, entry manifestEntry, archiveName string) {
+	fileName = strings.TrimPrefix(strings.ReplaceAll(fileName, "\\", "/"), "./")
+	if fileName == "" || fileName == archiveName {
+		receiver.archiveChecksums = entry
+		return
+	}
+	receiver.entries[fileName] = entry
+}
+
+func newReceivedEntry(contents []byte) receivedEntry {
+	sha256Hash := sha256.Sum256(contents)
+	md5Hash := md5.Sum(contents)
+	return receivedEntry{
+		sha256:       hex.EncodeToString(sha256Hash[:]),
+		md5:          hex.EncodeToString(md5Hash[:]),
+		messageCount: countMessages(contents),
+	}
+}
+
+// countMessages counts the HL7 messages in a file by their MSH segments
+func countMessages(contents []byte) int {
+	count := 0
+	for _, segment := range strings.FieldsFunc(string(contents), func(r rune) bool { return r == '\r' || r == '\n' }) {
+		if strings.HasPrefix(segment, "MSH") {
+			count++
+		}
+	}
+	return count
+}
+
+// verify compares the files we received against the manifest. It only looks for missing and extra files when the
+// manifest lists files, because a `.sha256` file next to an archive often only has the archive's own hash
+func (receiver *manifest) verify(archive io.ReaderAt, size int64, archiveName string, received map[string]receivedEntry) []FileError {
+	var errorList []FileError
+
+	if receiver.archiveChecksums != (manifestEntry{}) {
+		archiveContents, err := io.ReadAll(io.NewSectionReader(archive, 0, size))
+		if err != nil {
+			errorList = append(errorList, FileError{Filename: archiveName, ErrorMessage: "unable to read archive to check it against " + receiver.name + ": " + err.Error()})
+		} else {
+			errorList = append(errorList, compareEntry(archiveName, receiver.name, receiver.archiveChecksums, newReceivedEntry(archiveContents))...)
+		}
+	}
+
+	if len(receiver.entries) == 0 {
+		return errorList
+	}
+
+	for _, fileName := range slices.Sorted(maps.Keys(receiver.entries)) {
+		receivedFile, ok := received[fileName]
+		if !ok {
+			errorList = append(errorList, FileError{Filename: fileName, ErrorMessage: "file is listed in " + receiver.name + " but isn't in the archive"})
+			continue
+		}
+		errorList = append(errorList, compareEntry(fileName, receiver.name, receiver.entries[fileName], receivedFile)...)
+	}
+
+	for _, fileName := range slices.Sorted(maps.Keys(received)) {
+		if _, ok := receiver.entries[fileName]; !ok {
+			errorList = append(errorList, FileError{Filename: fileName, ErrorMessage: "file is in the archive but isn't listed in " + receiver.name})
+		}
+	}
+
+	return errorList
+}
+
+func compareEntry(fileName string, manifestName string, expected manifestEntry, actual receivedEntry) []FileError {
+	var errorList []FileError
+	if expected.sha256 != "" && expected.sha256 != actual.sha256 {
+		errorList = append(errorList, FileError{Filename: fileName, ErrorMessage: fmt.Sprintf("SHA-256 is %s but %s says %s", actual.sha256, manifestName, expected.sha256)})
+	}
+	if expected.md5 != "" && expected.md5 != actual.md5 {
+		errorList = append(errorList, FileError{Filename: fileName, ErrorMessage: fmt.Sprintf("MD5 is %s but %s says %s", actual.md5, manifestName, expected.md5)})
+	}
+	if expected.hasMessageCount && expected.messageCount != actual.messageCount {
+		errorList = append(errorList, FileError{Filename: fileName, ErrorMessage: fmt.Sprintf("file has %d messages but %s says %d", actual.messageCount, manifestName, expected.messageCount)})
+	}
+	return errorList
+}
+
+// VerifyFile checks a file that isn't an archive against its manifest from next to it on the SFTP server. The
+// manifest can only describe the file itself, by its name or with a line that's just a hash
+func VerifyFile(fileName string, contents []byte, sidecarManifest ManifestFile) []FileError {
+	fileManifest, err := parseManifest(sidecarManifest.Name, sidecarManifest.Contents, fileName)
+	if err != nil {
+		return []FileError{{Filename: sidecarManifest.Name, ErrorMessage: err.Error()}}
+	}
+
+	var errorList []FileError
+	for _, listedName := range slices.Sorted(maps.Keys(fileManifest.entries)) {
+		errorList = append(errorList, FileError{Filename: listedName, ErrorMessage: "file is listed in " + sidecarManifest.Name + " but " + fileName + " isn't an archive"})
+	}
+	if fileManifest.archiveChecksums == (manifestEntry{}) {
+		return append(errorList, FileError{Filename: fileName, ErrorMessage: sidecarManifest.Name + " doesn't have a line for the file"})
+	}
+
+	return append(errorList, compareEntry(fileName, sidecarManifest.Name, fileManifest.archiveChecksums, newReceivedEntry(contents))...)
+}
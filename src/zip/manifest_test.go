@@ -0,0 +1,189 @@
+package zip
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func Test_parseManifest_ChecksumFile_ReadsEntriesAndArchiveHash(t *testing.T) {
+	contents := "# from sha256sum\n" +
+		sha256Hex("MSH|order") + "  orders/order.hl7\n" +
+		sha256Hex("MSH|result") + " *result.hl7\n" +
+		sha256Hex("archive") + "  cheeseburger.zip\n"
+
+	parsed, err := parseManifest("checksums.sha256", []byte(contents), "cheeseburger.zip")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]manifestEntry{
+		"orders/order.hl7": {sha256: sha256Hex("MSH|order")},
+		"result.hl7":       {sha256: sha256Hex("MSH|result")},
+	}, parsed.entries)
+	assert.Equal(t, manifestEntry{sha256: sha256Hex("archive")}, parsed.archiveChecksums)
+}
+
+func Test_parseManifest_ChecksumFileHasOnlyHash_ChecksArchive(t *testing.T) {
+	parsed, err := parseManifest("cheeseburger.zip.md5", []byte(md5Hex("archive")+"\n"), "cheeseburger.zip")
+
+	assert.NoError(t, err)
+	assert.Empty(t, parsed.entries)
+	assert.Equal(t, manifestEntry{md5: md5Hex("archive")}, parsed.archiveChecksums)
+}
+
+func Test_parseManifest_HashIsWrongLength_ReturnsError(t *testing.T) {
+	_, err := parseManifest("checksums.sha256", []byte(md5Hex("MSH|order")+"  order.hl7\n"), "cheeseburger.zip")
+
+	assert.ErrorContains(t, err, "line 1 doesn't start with a 64 character hash")
+}
+
+func Test_parseManifest_Csv_ReadsEntries(t *testing.T) {
+	contents := "File,MD5,message_count\norder.hl7," + md5Hex("MSH|order") + ",1\nresult.hl7,,2\n"
+
+	parsed, err := parseManifest("manifest.csv", []byte(contents), "cheeseburger.zip")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]manifestEntry{
+		"order.hl7":  {md5: md5Hex("MSH|order"), messageCount: 1, hasMessageCount: true},
+		"result.hl7": {messageCount: 2, hasMessageCount: true},
+	}, parsed.entries)
+}
+
+func Test_parseManifest_CsvHasNoFileColumn_ReturnsError(t *testing.T) {
+	_, err := parseManifest("manifest.csv", []byte("name,sha256\norder.hl7,abc\n"), "cheeseburger.zip")
+
+	assert.ErrorContains(t, err, "manifest has no file column")
+}
+
+func Test_countMessages_CountsMshSegments(t *testing.T) {
+	assert.Equal(t, 2, countMessages([]byte("MSH|1\rPID|1\rMSH|2\nPID|2")))
+	assert.Equal(t, 0, countMessages([]byte("not HL7")))
+}
+
+func Test_IsSidecarManifestName(t *testing.T) {
+	assert.True(t, IsSidecarManifestName("results.zip.sha256"))
+	assert.True(t, IsSidecarManifestName("results.zip.MD5"))
+	assert.True(t, IsSidecarManifestName("results.zip.manifest.csv"))
+	assert.False(t, IsSidecarManifestName("results.zip"))
+	assert.False(t, IsSidecarManifestName("results.csv"))
+}
+
+func Test_Unzip_ArchiveMatchesManifest_UploadsFilesButNotManifest(t *testing.T) {
+	manifestCsv := "file,sha256,message_count\norder.hl7," + sha256Hex("MSH|order") + ",1\n"
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")},
+		testZipEntry{name: "manifest.csv", contents: []byte(manifestCsv)})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	assertExtracted(t, mockBlobHandler, []byte("MSH|order"), extractedPath(archive, blobPath, "order.hl7"))
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFileWithMetadata", 1)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipSuccessUrl)
+}
+
+func Test_Unzip_ArchiveDoesNotMatchManifest_RecordsErrorsWithoutUploading(t *testing.T) {
+	manifestChecksums := sha256Hex("MSH|expected") + "  order.hl7\n" + sha256Hex("MSH|missing") + "  missing.hl7\n"
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "checksums.sha256", contents: []byte(manifestChecksums)},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")},
+		testZipEntry{name: "extra.hl7", contents: []byte("MSH|extra")})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+	mockBlobHandler.AssertNumberOfCalls(t, "DeleteFile", 2)
+	mockBlobHandler.AssertCalled(t, "DeleteFile", mock.MatchedBy(isStagingPath))
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
+	assertErrorListContains(t, mockBlobHandler, "order.hl7: SHA-256 is "+sha256Hex("MSH|order")+" but checksums.sha256 says "+sha256Hex("MSH|expected"))
+	assertErrorListContains(t, mockBlobHandler, "missing.hl7: file is listed in checksums.sha256 but isn't in the archive")
+	assertErrorListContains(t, mockBlobHandler, "extra.hl7: file is in the archive but isn't listed in checksums.sha256")
+}
+
+func Test_Unzip_SidecarManifestHashDoesNotMatchArchive_RecordsError(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, &ManifestFile{Name: "cheeseburger.zip.md5", Contents: []byte(md5Hex("something else"))})
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
+	assertErrorListContains(t, mockBlobHandler, "cheeseburger.zip: MD5 is ")
+}
+
+func Test_Unzip_SidecarManifestHashMatchesArchive_UploadsFiles(t *testing.T) {
+	archiveBytes := buildTestZip(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{RequireManifest: true}, new(mocks.MockCredentialGetter), archiveBytes)
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, &ManifestFile{Name: "cheeseburger.zip.sha256", Contents: []byte(sha256Hex(string(archiveBytes)) + "  cheeseburger.zip\n")})
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFileWithMetadata", 1)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipSuccessUrl)
+}
+
+func Test_Unzip_PartnerRequiresManifestAndThereIsNone_RecordsError(t *testing.T) {
+	archiveBytes := buildTestZip(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestArchive(config.PartnerSettings{RequireManifest: true}, new(mocks.MockCredentialGetter), archiveBytes)
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
+	assertErrorListContains(t, mockBlobHandler, "requires a manifest, but there isn't one in or next to the archive")
+}
+
+func Test_Unzip_ManifestIsInvalid_RecordsError(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")},
+		testZipEntry{name: "manifest.csv", contents: []byte("sha256\nabc\n")})
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNotCalled(t, "CopyFile", mock.Anything, mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
+	assertErrorListContains(t, mockBlobHandler, "manifest.csv: invalid manifest: manifest has no file column")
+}
+
+func Test_VerifyFile_HashMatches_ReturnsNoErrors(t *testing.T) {
+	sidecarManifest := ManifestFile{Name: "order.hl7.sha256", Contents: []byte(sha256Hex("MSH|order") + "  order.hl7\n")}
+
+	assert.Empty(t, VerifyFile("order.hl7", []byte("MSH|order"), sidecarManifest))
+}
+
+func Test_VerifyFile_HashDoesNotMatch_ReturnsError(t *testing.T) {
+	sidecarManifest := ManifestFile{Name: "order.hl7.md5", Contents: []byte(md5Hex("MSH|result") + "\n")}
+
+	errorList := VerifyFile("order.hl7", []byte("MSH|order"), sidecarManifest)
+
+	assert.Equal(t, []FileError{{Filename: "order.hl7", ErrorMessage: "MD5 is " + md5Hex("MSH|order") + " but order.hl7.md5 says " + md5Hex("MSH|result")}}, errorList)
+}
+
+func Test_VerifyFile_ManifestListsOtherFiles_ReturnsErrors(t *testing.T) {
+	sidecarManifest := ManifestFile{Name: "order.hl7.manifest.csv", Contents: []byte("file,message_count\nresult.hl7,1\n")}
+
+	errorList := VerifyFile("order.hl7", []byte("MSH|order"), sidecarManifest)
+
+	assert.Equal(t, []FileError{
+		{Filename: "result.hl7", ErrorMessage: "file is listed in order.hl7.manifest.csv but order.hl7 isn't an archive"},
+		{Filename: "order.hl7", ErrorMessage: "order.hl7.manifest.csv doesn't have a line for the file"},
+	}, errorList)
+}
+
+func sha256Hex(contents string) string {
+	hash := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(hash[:])
+}
+
+func md5Hex(contents string) string {
+	hash := md5.Sum([]byte(contents))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,134 @@
+package zip
+
+import (
+	"bytes"
+	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// Reprocess extracts an archive in `unzip/failure` again, and uses its error file to only upload the files that
+// failed last time. blobPath is where the archive was first uploaded, e.g. `ca-phl/unzip/results.zip`, or where it is
+// now, e.g. `ca-phl/unzip/failure/results.zip`. Files go to the same place in `import` as last time, so re-processing
+// an archive more than once doesn't duplicate anything. Once every file has been uploaded, the archive moves to
+// `unzip/success`. Otherwise, its error file is replaced with the files that are still failing
+func (zipHandler ZipHandler) Reprocess(blobPath string) error {
+	blobPath = strings.Replace(blobPath, path.Join(utils.UnzipFolder, utils.FailureFolder), utils.UnzipFolder, 1)
+	failurePath := unzipSubfolderPath(blobPath, utils.FailureFolder)
+	slog.Info("Preparing to re-process archive", slog.String("blobPath", failurePath), slog.String("partnerId", zipHandler.partnerId))
+
+	archiveBytes, err := zipHandler.blobHandler.FetchFileByUrl(path.Join(utils.ContainerName, failurePath))
+	if err != nil {
+		slog.Error("Failed to fetch archive to re-process", slog.Any(utils.ErrorKey, err), slog.String("blobPath", failurePath))
+		return err
+	}
+
+	// There's no error file when we couldn't extract the archive at all, so we upload every file
+	var retryEntries []string
+	errorFileBytes, err := zipHandler.blobHandler.FetchFileByUrl(path.Join(utils.ContainerName, failurePath+".txt"))
+	hasErrorFile := err == nil
+	if !hasErrorFile {
+		slog.Warn("Unable to fetch error file, so re-processing every file in the archive", slog.Any(utils.ErrorKey, err), slog.String("blobPath", failurePath))
+	} else {
+		retryEntries = failedEntries(errorFileBytes, path.Base(blobPath))
+	}
+
+	sidecarManifest := zipHandler.findSidecarManifest(failurePath)
+
+	errorList, err := zipHandler.extractArchive(bytes.NewReader(archiveBytes), int64(len(archiveBytes)), blobPath, sidecarManifest, retryEntries)
+	if err != nil {
+		return err
+	}
+
+	if len(errorList) > 0 {
+		slog.Warn("Some files in the archive are still failing", slog.String("blobPath", failurePath), slog.Int("errorCount", len(errorList)))
+		return zipHandler.UploadErrorList(blobPath, errorList, nil)
+	}
+
+	slog.Info("Every file in the archive has been uploaded", slog.String("blobPath", failurePath))
+	successPath := unzipSubfolderPath(blobPath, utils.SuccessFolder)
+	err = zipHandler.blobHandler.MoveFile(path.Join(utils.ContainerName, failurePath), path.Join(utils.ContainerName, successPath))
+	if err != nil {
+		slog.Error("Unable to move re-processed archive to "+successPath, slog.Any(utils.ErrorKey, err))
+		return err
+	}
+
+	// Keep the old error file as a record of what was re-processed
+	if hasErrorFile {
+		zipHandler.moveToSuccess(failurePath+".txt", successPath+".reprocessed.txt")
+	}
+	if sidecarManifest != nil {
+		zipHandler.moveToSuccess(path.Join(path.Dir(failurePath), sidecarManifest.Name), path.Join(path.Dir(successPath), sidecarManifest.Name))
+	}
+
+	return nil
+}
+
+// shouldUpload is false for files a re-process doesn't need to upload again. When a nested archive failed, every file
+// in it is uploaded again
+func (extraction *archiveExtraction) shouldUpload(entryPath string) bool {
+	if extraction.retryEntries == nil {
+		return true
+	}
+
+	for _, retryEntry := range extraction.retryEntries {
+		if entryPath == retryEntry || strings.HasPrefix(entryPath, retryEntry+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// failedEntries reads the file names from an error file written by UploadErrorList. An error for the archive itself
+// means we might not have uploaded any of its files, so it returns nil to re-process every file
+func failedEntries(errorFileBytes []byte, archiveName string) []string {
+	retryEntries := []string{}
+	for _, line := range strings.Split(string(errorFileBytes), "\n") {
+		fileName, _, found := strings.Cut(line, ": ")
+		if !found {
+			continue
+		}
+		if fileName == archiveName {
+			return nil
+		}
+		retryEntries = append(retryEntries, fileName)
+	}
+	return retryEntries
+}
+
+// keepSidecarManifest saves a manifest from next to the archive on SFTP, which is about to be deleted, next to the
+// archive in `unzip/failure` so a re-process can check the archive against it
+func (zipHandler ZipHandler) keepSidecarManifest(blobPath string, sidecarManifest *ManifestFile) {
+	if sidecarManifest == nil {
+		return
+	}
+
+	manifestPath := path.Join(path.Dir(unzipSubfolderPath(blobPath, utils.FailureFolder)), sidecarManifest.Name)
+	err := zipHandler.blobHandler.UploadFile(sidecarManifest.Contents, manifestPath)
+	if err != nil {
+		slog.Error("Failed to upload manifest", slog.Any(utils.ErrorKey, err), slog.String("manifestPath", manifestPath))
+	}
+}
+
+func (zipHandler ZipHandler) findSidecarManifest(failurePath string) *ManifestFile {
+	for _, extension := range SidecarManifestExtensions {
+		contents, err := zipHandler.blobHandler.FetchFileByUrl(path.Join(utils.ContainerName, failurePath+extension))
+		if err == nil {
+			return &ManifestFile{Name: path.Base(failurePath) + extension, Contents: contents}
+		}
+	}
+	return nil
+}
+
+func (zipHandler ZipHandler) moveToSuccess(failurePath string, successPath string) {
+	err := zipHandler.blobHandler.MoveFile(path.Join(utils.ContainerName, failurePath), path.Join(utils.ContainerName, successPath))
+	if err != nil {
+		slog.Error("Unable to move file to "+successPath, slog.Any(utils.ErrorKey, err))
+	}
+}
+
+// unzipSubfolderPath is where an archive in `unzip` goes when we move it to a subfolder, e.g. `success`
+func unzipSubfolderPath(blobPath string, subfolder string) string {
+	return strings.Replace(blobPath, utils.UnzipFolder, path.Join(utils.UnzipFolder, subfolder), 1)
+}
@@ -0,0 +1,138 @@
+package zip
+
+import (
+	"bytes"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func Test_Reprocess_SomeFilesFailed_UploadsOnlyFailedFilesAndMovesToSuccess(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")},
+		testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+	mockReprocessFetches(mockBlobHandler, readArchive(t, archive), []byte("result.hl7: failed to upload file\n"), nil)
+
+	err := zipHandler.Reprocess(unzipFailurePath)
+
+	assert.NoError(t, err)
+	assertExtracted(t, mockBlobHandler, []byte("MSH|result"), extractedPath(archive, blobPath, "result.hl7"))
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFileWithMetadata", 1)
+	mockBlobHandler.AssertCalled(t, "MoveFile", unzipFailureUrl, unzipSuccessUrl)
+	mockBlobHandler.AssertCalled(t, "MoveFile", unzipFailureUrl+".txt", unzipSuccessUrl+".reprocessed.txt")
+	mockBlobHandler.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything)
+}
+
+func Test_Reprocess_FileStillFails_RewritesErrorFile(t *testing.T) {
+	archiveBytes := buildTestZip(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")}, testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+	zipHandler, _, _ := zipHandlerForTestArchive(config.PartnerSettings{}, new(mocks.MockCredentialGetter), archiveBytes)
+	mockBlobHandler := new(mocks.MockBlobHandler)
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("upload failed"))
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockReprocessFetches(mockBlobHandler, archiveBytes, []byte("order.hl7: failed to upload file\n"), nil)
+	zipHandler.blobHandler = mockBlobHandler
+
+	err := zipHandler.Reprocess(blobPath)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFileWithMetadata", 1)
+	mockBlobHandler.AssertNotCalled(t, "MoveFile", mock.Anything, mock.Anything)
+	assertErrorListContains(t, mockBlobHandler, "order.hl7: upload failed")
+}
+
+func Test_Reprocess_ArchiveFailed_UploadsEveryFile(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")},
+		testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+	mockReprocessFetches(mockBlobHandler, readArchive(t, archive), []byte("cheeseburger.zip: unable to read archive\n"), nil)
+
+	err := zipHandler.Reprocess(blobPath)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFileWithMetadata", 2)
+	mockBlobHandler.AssertCalled(t, "MoveFile", unzipFailureUrl, unzipSuccessUrl)
+}
+
+func Test_Reprocess_ThereIsNoErrorFile_UploadsEveryFile(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")},
+		testZipEntry{name: "result.hl7", contents: []byte("MSH|result")})
+	mockReprocessFetches(mockBlobHandler, readArchive(t, archive), nil, nil)
+
+	err := zipHandler.Reprocess(blobPath)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFileWithMetadata", 2)
+	mockBlobHandler.AssertCalled(t, "MoveFile", unzipFailureUrl, unzipSuccessUrl)
+	mockBlobHandler.AssertNotCalled(t, "MoveFile", unzipFailureUrl+".txt", mock.Anything)
+}
+
+func Test_Reprocess_SidecarManifestIsInFailureFolder_ChecksArchiveAndMovesManifest(t *testing.T) {
+	zipHandler, mockBlobHandler, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{},
+		testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+	archiveBytes := readArchive(t, archive)
+	mockReprocessFetches(mockBlobHandler, archiveBytes, nil, []byte(sha256Hex(string(archiveBytes))))
+
+	err := zipHandler.Reprocess(blobPath)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertNumberOfCalls(t, "UploadFileWithMetadata", 1)
+	mockBlobHandler.AssertCalled(t, "MoveFile", unzipFailureUrl+".sha256", unzipSuccessUrl+".sha256")
+}
+
+func Test_Reprocess_UnableToFetchArchive_ReturnsError(t *testing.T) {
+	zipHandler, mockBlobHandler, _ := zipHandlerForTestZip(t, config.ZipLimitSettings{})
+	mockBlobHandler.On("FetchFileByUrl", unzipFailureUrl).Return([]byte{}, errors.New("not found"))
+
+	err := zipHandler.Reprocess(blobPath)
+
+	assert.Error(t, err)
+	mockBlobHandler.AssertNotCalled(t, "MoveFile", mock.Anything, mock.Anything)
+}
+
+func Test_failedEntries_ReadsFileNames(t *testing.T) {
+	errorFile := []byte("order.hl7: failed to upload file\nnested.zip/result.hl7: file is too large\n")
+
+	assert.Equal(t, []string{"order.hl7", "nested.zip/result.hl7"}, failedEntries(errorFile, filename))
+}
+
+func Test_failedEntries_ArchiveFailed_ReturnsNil(t *testing.T) {
+	errorFile := []byte("order.hl7: failed to upload file\ncheeseburger.zip: no files were uploaded\n")
+
+	assert.Nil(t, failedEntries(errorFile, filename))
+}
+
+func Test_shouldUpload_MatchesFailedFilesAndNestedArchives(t *testing.T) {
+	extraction := &archiveExtraction{retryEntries: []string{"order.hl7", "nested.zip"}}
+
+	assert.True(t, extraction.shouldUpload("order.hl7"))
+	assert.True(t, extraction.shouldUpload("nested.zip/result.hl7"))
+	assert.False(t, extraction.shouldUpload("result.hl7"))
+	assert.False(t, extraction.shouldUpload("nested.zip.hl7"))
+	assert.True(t, (&archiveExtraction{}).shouldUpload("result.hl7"))
+}
+
+// mockReprocessFetches sets up the blobs Reprocess reads from `unzip/failure`. A nil error file or sidecar manifest
+// isn't there
+func mockReprocessFetches(mockBlobHandler *mocks.MockBlobHandler, archiveBytes []byte, errorFile []byte, sidecarSha256 []byte) {
+	mockBlobHandler.On("FetchFileByUrl", unzipFailureUrl).Return(archiveBytes, nil)
+	if errorFile != nil {
+		mockBlobHandler.On("FetchFileByUrl", unzipFailureUrl+".txt").Return(errorFile, nil)
+	}
+	if sidecarSha256 != nil {
+		mockBlobHandler.On("FetchFileByUrl", unzipFailureUrl+".sha256").Return(sidecarSha256, nil)
+	}
+	mockBlobHandler.On("FetchFileByUrl", mock.Anything).Return([]byte{}, errors.New("not found"))
+}
+
+func readArchive(t *testing.T, archive *bytes.Reader) []byte {
+	archiveBytes := make([]byte, archive.Size())
+	_, err := archive.ReadAt(archiveBytes, 0)
+	assert.NoError(t, err)
+	return archiveBytes
+}
@@ -1,6 +1,9 @@
 package zip
 
 import (
+	"bufio"
+	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
 	"github.com/CDCgov/reportstream-sftp-ingestion/secrets"
 	"github.com/CDCgov/reportstream-sftp-ingestion/storage"
 	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
@@ -8,6 +11,7 @@ import (
 	"github.com/yeka/zip"
 	"io"
 	"log/slog"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -16,11 +20,40 @@ type ZipHandler struct {
 	credentialGetter secrets.CredentialGetter
 	blobHandler      usecases.BlobHandler
 	zipClient        ZipClient
+	partnerId        string
+	partnerSettings  config.PartnerSettings
+	// acknowledgementQueue tells the partner about archives we couldn't unzip, and can be nil
+	acknowledgementQueue usecases.AcknowledgementQueue
+	// extraction is set by Unzip for the archive it's extracting
+	extraction *archiveExtraction
+}
+
+// archiveExtraction is what Unzip learns about an archive as it extracts it
+type archiveExtraction struct {
+	// hash keeps the files from two archives with the same name apart in the `import` folder
+	hash      string
+	manifests []*manifest
+	// manifestErrors are for manifests we couldn't read
+	manifestErrors []FileError
+	// receivedEntries are the files at the top of the archive, by their path in the archive, for checking manifests
+	receivedEntries map[string]receivedEntry
+	// pendingUploads are staged until we've checked the archive against its manifests
+	pendingUploads []pendingUpload
+	// retryEntries are the files a re-process uploads again, or nil to upload every file
+	retryEntries []string
+}
+
+// pendingUpload is a file from the archive that's waiting in stagingPath to be copied to destination, so we don't
+// hold every file in memory while we extract the rest of the archive
+type pendingUpload struct {
+	entryPath   string
+	stagingPath string
+	destination string
 }
 
 type ZipHandlerInterface interface {
-	Unzip(zipFilePath string, blobPath string) error
-	ExtractAndUploadSingleFile(f *zip.File, zipPassword string, zipFilePath string, errorList []FileError) []FileError
+	Unzip(archive io.ReaderAt, size int64, blobPath string, sidecarManifest *ManifestFile) error
+	ExtractAndUploadSingleFile(f *zip.File, zipPassword string, zipFilePath string, budget *ExtractionBudget, errorList []FileError) []FileError
 	UploadErrorList(zipFilePath string, errorList []FileError, err error) error
 }
 
@@ -29,7 +62,15 @@ type FileError struct {
 	ErrorMessage string
 }
 
-func NewZipHandler() (ZipHandler, error) {
+// NewZipHandler makes a ZipHandler for partnerId. acknowledgementQueue can be nil when nothing the handler does needs
+// an acknowledgement
+func NewZipHandler(partnerId string, acknowledgementQueue usecases.AcknowledgementQueue) (ZipHandler, error) {
+	partnerConfig := config.Configs[partnerId]
+	if partnerConfig == nil {
+		slog.Error("Partner not found in config", slog.String("partnerId", partnerId))
+		return ZipHandler{}, errors.New("no config found for partner " + partnerId)
+	}
+
 	blobHandler, err := storage.NewAzureBlobHandler()
 	if err != nil {
 		slog.Error("Failed to init Azure blob client", slog.Any(utils.ErrorKey, err))
@@ -43,49 +84,46 @@ func NewZipHandler() (ZipHandler, error) {
 	}
 
 	return ZipHandler{
-		credentialGetter: credentialGetter,
-		blobHandler:      blobHandler,
-		zipClient:        ZipClientWrapper{},
+		credentialGetter:     credentialGetter,
+		blobHandler:          blobHandler,
+		zipClient:            ZipClientWrapper{},
+		partnerId:            partnerId,
+		partnerSettings:      partnerConfig.PartnerSettings,
+		acknowledgementQueue: acknowledgementQueue,
 	}, nil
 }
 
-// Unzip opens a zip file (applying a password if necessary) and uploads each file within it to the `import` folder
-// to begin processing. It collects any errors with individual subfiles and uploads that information as well. An error
-// is only returned from the function when we cannot handle the main zip file for some reason or have failed to upload
-// the error list about the contents
-func (zipHandler ZipHandler) Unzip(zipFileName string, blobPath string) error {
-	slog.Info("Preparing to unzip", slog.String("zipFileName", zipFileName))
-	zipPasswordSecret := utils.CA_PHL + "-zip-password-" + utils.EnvironmentName() // pragma: allowlist secret
-	zipPassword, err := zipHandler.credentialGetter.GetSecret(zipPasswordSecret)
-
+// Unzip reads an archive (applying the partner's password to encrypted zip files) and uploads each file within it to
+// the `import` folder to begin processing. We support zip, gzip, and tar archives, and extract archives nested inside
+// them up to the partner's `maxNestingDepth`. The archive is read from memory, so nothing with PHI is written to the
+// container's disk. If there's a manifest in the archive or next to it (sidecarManifest, which can be nil), or the
+// partner requires one, we only upload the files once the archive matches its manifest. It collects any errors with
+// individual subfiles and uploads that information as well. An error is only returned from the function when we
+// cannot handle the main archive for some reason or have failed to upload the error list about the contents. Either
+// way, an archive that goes to `unzip/failure` gets a `rejected` acknowledgement if the partner wants them
+func (zipHandler ZipHandler) Unzip(archive io.ReaderAt, size int64, blobPath string, sidecarManifest *ManifestFile) error {
+	slog.Info("Preparing to unzip", slog.String("blobPath", blobPath), slog.String("partnerId", zipHandler.partnerId))
+
+	errorList, err := zipHandler.extractArchive(archive, size, blobPath, sidecarManifest, nil)
 	if err != nil {
-		slog.Error("Unable to get zip password", slog.Any(utils.ErrorKey, err), slog.String("KeyName", zipPasswordSecret))
-
 		// move zip file from unzip -> unzip/failure
 		zipHandler.MoveZip(blobPath, utils.FailureFolder)
+		zipHandler.keepSidecarManifest(blobPath, sidecarManifest)
+		zipHandler.acknowledgeRejectedArchive(blobPath, []string{err.Error()})
 		return err
 	}
 
-	zipReader, err := zipHandler.zipClient.OpenReader(zipFileName)
-
-	if err != nil {
-		slog.Error("Failed to open zip reader", slog.Any(utils.ErrorKey, err))
-		zipHandler.MoveZip(blobPath, utils.FailureFolder)
-		return err
-	}
-	defer zipReader.Close()
-
-	var errorList []FileError
-
-	// loop over contents
-	for _, f := range zipReader.File {
-		errorList = zipHandler.ExtractAndUploadSingleFile(f, zipPassword, zipFileName, errorList)
-	}
-
 	// if errorList has contents -> move zip file from unzip -> unzip/failure
 	if len(errorList) > 0 {
 		slog.Info("Error list length over zero")
 		zipHandler.MoveZip(blobPath, utils.FailureFolder)
+		zipHandler.keepSidecarManifest(blobPath, sidecarManifest)
+
+		var reasons []string
+		for _, fileError := range errorList {
+			reasons = append(reasons, fileError.Filename+": "+fileError.ErrorMessage)
+		}
+		zipHandler.acknowledgeRejectedArchive(blobPath, reasons)
 	} else {
 		// else -> move zip file from unzip -> unzip/success
 		slog.Info("Error list length is zero")
@@ -93,7 +131,7 @@ func (zipHandler ZipHandler) Unzip(zipFileName string, blobPath string) error {
 	}
 
 	// Upload error info if any
-	err = zipHandler.UploadErrorList(blobPath, errorList, err)
+	err = zipHandler.UploadErrorList(blobPath, errorList, nil)
 	if err != nil {
 		return err
 	}
@@ -101,6 +139,84 @@ func (zipHandler ZipHandler) Unzip(zipFileName string, blobPath string) error {
 	return nil
 }
 
+// extractArchive extracts an archive and uploads its files, see Unzip. retryEntries limits the uploads to the files
+// that failed last time, and is nil to upload every file. It returns an error when it can't extract the archive at all
+func (zipHandler ZipHandler) extractArchive(archive io.ReaderAt, size int64, blobPath string, sidecarManifest *ManifestFile, retryEntries []string) ([]FileError, error) {
+	zipPassword := ""
+	if zipHandler.partnerSettings.HasZipPassword {
+		zipPasswordSecret := zipHandler.partnerId + "-zip-password-" + utils.EnvironmentName() // pragma: allowlist secret
+		var err error
+		zipPassword, err = zipHandler.credentialGetter.GetSecret(zipPasswordSecret)
+
+		if err != nil {
+			slog.Error("Unable to get zip password", slog.Any(utils.ErrorKey, err), slog.String("KeyName", zipPasswordSecret))
+			return nil, err
+		}
+	}
+
+	archiveHash, err := hashArchive(archive, size)
+	if err != nil {
+		slog.Error("Failed to hash archive", slog.Any(utils.ErrorKey, err), slog.String("blobPath", blobPath))
+		return nil, err
+	}
+	zipHandler.extraction = &archiveExtraction{hash: archiveHash, receivedEntries: make(map[string]receivedEntry), retryEntries: retryEntries}
+
+	if sidecarManifest != nil {
+		zipHandler.addManifest(sidecarManifest.Name, sidecarManifest.Contents, path.Base(blobPath))
+	}
+
+	var errorList []FileError
+	archiveReader := bufio.NewReader(io.NewSectionReader(archive, 0, size))
+	header, _ := archiveReader.Peek(archiveHeaderSize)
+	format := DetectArchiveFormat(header)
+
+	budget := NewExtractionBudget(zipHandler.partnerSettings.ZipLimits)
+
+	switch format {
+	case ArchiveFormatZip:
+		zipReader, err := zipHandler.zipClient.NewReader(archive, size)
+		if err != nil {
+			slog.Error("Failed to open zip reader", slog.Any(utils.ErrorKey, err))
+			return nil, err
+		}
+
+		errorList = zipHandler.extractZip(zipReader, zipPassword, blobPath, "", budget, errorList)
+	case ArchiveFormatGzip:
+		errorList = zipHandler.extractGzip(archiveReader, zipPassword, blobPath, filepath.Base(blobPath), budget, errorList)
+	case ArchiveFormatTar:
+		errorList = zipHandler.extractTar(archiveReader, zipPassword, blobPath, filepath.Base(blobPath), budget, errorList)
+	default:
+		err = errors.New("file is not a zip, gzip, or tar archive")
+		slog.Error("Unsupported archive format", slog.Any(utils.ErrorKey, err), slog.String("blobPath", blobPath))
+		return nil, err
+	}
+
+	manifestErrors := zipHandler.verifyManifests(archive, size, path.Base(blobPath))
+	if len(manifestErrors) > 0 {
+		slog.Error("Archive doesn't match its manifest, so not uploading its files", slog.String("blobPath", blobPath), slog.Int("manifestErrorCount", len(manifestErrors)))
+		errorList = append(errorList, manifestErrors...)
+		// An error for the archive itself means a re-process has to upload every file, not just the ones listed
+		errorList = append(errorList, FileError{Filename: path.Base(blobPath), ErrorMessage: "no files were uploaded because the archive doesn't match its manifest"})
+		zipHandler.discardPendingFiles(blobPath)
+	} else {
+		errorList = zipHandler.promotePendingFiles(blobPath, errorList)
+	}
+
+	return errorList, nil
+}
+
+// acknowledgeRejectedArchive tells the partner their archive went to `unzip/failure`. Messages we did extract from it
+// get their own acknowledgements once they're sent
+func (zipHandler ZipHandler) acknowledgeRejectedArchive(blobPath string, reasons []string) {
+	usecases.QueueAcknowledgement(zipHandler.acknowledgementQueue, usecases.Acknowledgement{
+		PartnerId: zipHandler.partnerId,
+		FileName:  path.Base(blobPath),
+		SourceUrl: path.Join(utils.ContainerName, unzipSubfolderPath(blobPath, utils.FailureFolder)),
+		Status:    usecases.AcknowledgementStatusRejected,
+		Reasons:   reasons,
+	}, nil)
+}
+
 // MoveZip moves a file from 'unzip' into the specified subfolder e.g. 'success', 'failure'
 func (zipHandler ZipHandler) MoveZip(blobPath string, subfolder string) {
 	slog.Info("About to move file", slog.String("blobPath", blobPath), slog.String("destination subfolder", subfolder))
@@ -116,40 +232,56 @@ func (zipHandler ZipHandler) MoveZip(blobPath string, subfolder string) {
 	}
 }
 
-func (zipHandler ZipHandler) ExtractAndUploadSingleFile(f *zip.File, zipPassword string, zipFilePath string, errorList []FileError) []FileError {
-	slog.Info("Extracting file", slog.String(utils.FileNameKey, f.Name), slog.String("zipFilePath", zipFilePath))
+// ExtractAndUploadSingleFile extracts a file from the zip we received and uploads it, see handleExtractedFile. Files
+// that break the partner's zip limits or have unsafe names are recorded in the error list instead
+func (zipHandler ZipHandler) ExtractAndUploadSingleFile(f *zip.File, zipPassword string, zipFilePath string, budget *ExtractionBudget, errorList []FileError) []FileError {
+	return zipHandler.extractZipEntry(f, zipPassword, zipFilePath, "", budget, errorList)
+}
 
-	// Apply the partner's Zip password if needed
-	if f.IsEncrypted() {
-		slog.Info("setting password for file", slog.String(utils.FileNameKey, f.Name), slog.String("zipFilePath", zipFilePath))
-		f.SetPassword(zipPassword)
+func (zipHandler ZipHandler) extractZipEntry(f *zip.File, zipPassword string, zipFilePath string, parentPath string, budget *ExtractionBudget, errorList []FileError) []FileError {
+	entryPath := path.Join(parentPath, f.Name)
+	slog.Info("Extracting file", slog.String(utils.FileNameKey, entryPath), slog.String("zipFilePath", zipFilePath))
+
+	if f.FileInfo().IsDir() {
+		return errorList
 	}
 
-	fileReader, err := f.Open()
+	err := checkEntryHeader(f, budget)
 	if err != nil {
-		slog.Error("Failed to open message file", slog.String(utils.FileNameKey, f.Name), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
-		errorList = append(errorList, FileError{Filename: f.Name, ErrorMessage: err.Error()})
+		slog.Error("Rejected file in zip", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		errorList = append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
 		return errorList
 	}
-	defer fileReader.Close()
 
-	buf, err := io.ReadAll(fileReader)
+	// Apply the partner's Zip password if needed. yeka/zip handles both ZipCrypto and AES encryption
+	if f.IsEncrypted() {
+		if zipPassword == "" {
+			slog.Error("File is encrypted but the partner has no zip password", slog.String(utils.FileNameKey, entryPath), slog.String("partnerId", zipHandler.partnerId), slog.String("zipFilePath", zipFilePath))
+			errorList = append(errorList, FileError{Filename: entryPath, ErrorMessage: "file is encrypted but no zip password is configured for partner " + zipHandler.partnerId})
+			return errorList
+		}
+
+		slog.Info("setting password for file", slog.String(utils.FileNameKey, entryPath), slog.String("zipFilePath", zipFilePath))
+		f.SetPassword(zipPassword)
+	}
+
+	fileReader, err := f.Open()
 	if err != nil {
-		slog.Error("Failed to read message file", slog.String(utils.FileNameKey, f.Name), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
-		errorList = append(errorList, FileError{Filename: f.Name, ErrorMessage: err.Error()})
+		slog.Error("Failed to open message file", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		errorList = append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
 		return errorList
 	}
+	defer fileReader.Close()
 
-	err = zipHandler.blobHandler.UploadFile(buf, filepath.Join(utils.MessageStartingFolderPath, f.FileInfo().Name()))
-
+	// Read one byte past the remaining limit so we can tell when a file goes over it
+	buf, err := io.ReadAll(io.LimitReader(fileReader, budget.remainingBytes+1))
 	if err != nil {
-		slog.Error("Failed to upload message file", slog.String(utils.FileNameKey, f.Name), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
-		errorList = append(errorList, FileError{Filename: f.Name, ErrorMessage: err.Error()})
+		slog.Error("Failed to read message file", slog.String(utils.FileNameKey, entryPath), slog.Any(utils.ErrorKey, err), slog.String("zipFilePath", zipFilePath))
+		errorList = append(errorList, FileError{Filename: entryPath, ErrorMessage: err.Error()})
 		return errorList
 	}
 
-	slog.Info("uploaded file to blob for import", slog.String(utils.FileNameKey, f.Name), slog.String("zipFilePath", zipFilePath))
-	return errorList
+	return zipHandler.handleExtractedFile(entryPath, buf, f.CompressedSize64, zipPassword, zipFilePath, budget, errorList)
 }
 
 // UploadErrorList takes a list of file-specific errors and uploads them to a single file named after the containing zip
@@ -173,12 +305,12 @@ func (zipHandler ZipHandler) UploadErrorList(zipFilePath string, errorList []Fil
 }
 
 type ZipClient interface {
-	OpenReader(name string) (*zip.ReadCloser, error)
+	NewReader(archive io.ReaderAt, size int64) (*zip.Reader, error)
 }
 
 type ZipClientWrapper struct {
 }
 
-func (zipClientWrapper ZipClientWrapper) OpenReader(zipFilePath string) (*zip.ReadCloser, error) {
-	return zip.OpenReader(zipFilePath)
+func (zipClientWrapper ZipClientWrapper) NewReader(archive io.ReaderAt, size int64) (*zip.Reader, error) {
+	return zip.NewReader(archive, size)
 }
@@ -1,14 +1,20 @@
 package zip
 
 import (
+	"bytes"
 	"errors"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config"
+	"github.com/CDCgov/reportstream-sftp-ingestion/config/configtest"
 	"github.com/CDCgov/reportstream-sftp-ingestion/mocks"
+	"github.com/CDCgov/reportstream-sftp-ingestion/usecases"
 	"github.com/CDCgov/reportstream-sftp-ingestion/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/yeka/zip"
+	"io"
 	"log/slog"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -18,6 +24,15 @@ var unzipFailurePath = "unzip/failure/cheeseburger.zip"
 var unzipFailureUrl = "sftp/unzip/failure/cheeseburger.zip"
 var blobPath = "unzip/cheeseburger.zip"
 
+const partnerId = "flexion"
+const zipPasswordSecret = "flexion-zip-password-local" // pragma: allowlist secret
+
+func Test_NewZipHandler_PartnerHasNoConfig_ReturnsError(t *testing.T) {
+	_, err := NewZipHandler("unknown-partner", nil)
+
+	assert.Error(t, err)
+}
+
 func Test_Unzip_FileIsPasswordProtected_UnzipsSuccessfully(t *testing.T) {
 
 	buffer, defaultLogger := utils.SetupLogger()
@@ -27,23 +42,28 @@ func Test_Unzip_FileIsPasswordProtected_UnzipsSuccessfully(t *testing.T) {
 	mockBlobHandler := new(mocks.MockBlobHandler)
 	mockZipClient := new(MockZipClient)
 
-	mockCredentialGetter.On("GetSecret", mock.Anything).Return("test123", nil)
+	mockCredentialGetter.On("GetSecret", zipPasswordSecret).Return("test123", nil)
 
 	zipPath := filepath.Join("..", "mocks", "test_data", "passworded.zip")
 	zipReader, err := zip.OpenReader(zipPath)
 
-	mockZipClient.On("OpenReader", mock.Anything).Return(zipReader, nil)
+	mockZipClient.On("NewReader", mock.Anything, mock.Anything).Return(&zipReader.Reader, nil)
 
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
 	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
 
 	zipHandler := ZipHandler{
 		credentialGetter: mockCredentialGetter,
 		blobHandler:      mockBlobHandler,
 		zipClient:        mockZipClient,
+		partnerId:        partnerId,
+		partnerSettings:  config.PartnerSettings{HasZipPassword: true},
 	}
 
-	err = zipHandler.Unzip(filename, blobPath)
+	err = zipHandler.Unzip(zipMagicReader(), zipMagicSize, blobPath, nil)
 
 	assert.Contains(t, buffer.String(), "setting password for file")
 	assert.Contains(t, buffer.String(), "Extracting file")
@@ -64,9 +84,12 @@ func Test_Unzip_FileIsNotProtected_UnzipsSuccessfully(t *testing.T) {
 	zipPath := filepath.Join("..", "mocks", "test_data", "unprotected.zip")
 	zipReader, err := zip.OpenReader(zipPath)
 
-	mockZipClient.On("OpenReader", mock.Anything).Return(zipReader, nil)
+	mockZipClient.On("NewReader", mock.Anything, mock.Anything).Return(&zipReader.Reader, nil)
 
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
 	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
 
 	zipHandler := ZipHandler{
@@ -75,7 +98,7 @@ func Test_Unzip_FileIsNotProtected_UnzipsSuccessfully(t *testing.T) {
 		zipClient:        mockZipClient,
 	}
 
-	err = zipHandler.Unzip(filename, blobPath)
+	err = zipHandler.Unzip(zipMagicReader(), zipMagicSize, blobPath, nil)
 
 	assert.NotContains(t, buffer.String(), "setting password for file")
 	assert.Contains(t, buffer.String(), "Extracting file")
@@ -83,6 +106,39 @@ func Test_Unzip_FileIsNotProtected_UnzipsSuccessfully(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func Test_Unzip_ZipIsInPartnerFolder_UploadsToPartnerImportFolder(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockBlobHandler := new(mocks.MockBlobHandler)
+	mockZipClient := new(MockZipClient)
+
+	mockCredentialGetter.On("GetSecret", mock.Anything).Return("test123", nil)
+
+	zipPath := filepath.Join("..", "mocks", "test_data", "unprotected.zip")
+	zipReader, err := zip.OpenReader(zipPath)
+
+	mockZipClient.On("NewReader", mock.Anything, mock.Anything).Return(&zipReader.Reader, nil)
+
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
+
+	zipHandler := ZipHandler{
+		credentialGetter: mockCredentialGetter,
+		blobHandler:      mockBlobHandler,
+		zipClient:        mockZipClient,
+	}
+
+	err = zipHandler.Unzip(zipMagicReader(), zipMagicSize, "ca-phl/unzip/cheeseburger.zip", nil)
+
+	assert.NoError(t, err)
+	mockBlobHandler.AssertCalled(t, "CopyFile", mock.Anything, mock.MatchedBy(func(path string) bool {
+		return strings.HasPrefix(path, "ca-phl/import/cheeseburger.zip-")
+	}))
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, "sftp/ca-phl/unzip/success/cheeseburger.zip")
+}
+
 func Test_Unzip_UnableToGetPassword_ReturnsError(t *testing.T) {
 	buffer, defaultLogger := utils.SetupLogger()
 	defer slog.SetDefault(defaultLogger)
@@ -96,9 +152,11 @@ func Test_Unzip_UnableToGetPassword_ReturnsError(t *testing.T) {
 	zipHandler := ZipHandler{
 		credentialGetter: mockCredentialGetter,
 		blobHandler:      mockBlobHandler,
+		partnerId:        partnerId,
+		partnerSettings:  config.PartnerSettings{HasZipPassword: true},
 	}
 
-	err := zipHandler.Unzip(filename, blobPath)
+	err := zipHandler.Unzip(zipMagicReader(), zipMagicSize, blobPath, nil)
 
 	assert.NotContains(t, buffer.String(), "setting password for file")
 	assert.NotContains(t, buffer.String(), "Extracting file")
@@ -116,7 +174,7 @@ func Test_Unzip_FailsToOpenReader_ReturnsError(t *testing.T) {
 
 	mockCredentialGetter.On("GetSecret", mock.Anything).Return("test123", nil)
 
-	mockZipClient.On("OpenReader", mock.Anything).Return(&zip.ReadCloser{}, errors.New("error"))
+	mockZipClient.On("NewReader", mock.Anything, mock.Anything).Return(&zip.Reader{}, errors.New("error"))
 
 	mockBlobHandler := new(mocks.MockBlobHandler)
 	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
@@ -127,7 +185,7 @@ func Test_Unzip_FailsToOpenReader_ReturnsError(t *testing.T) {
 		blobHandler:      mockBlobHandler,
 	}
 
-	err := zipHandler.Unzip(filename, blobPath)
+	err := zipHandler.Unzip(zipMagicReader(), zipMagicSize, blobPath, nil)
 
 	assert.NotContains(t, buffer.String(), "setting password")
 	assert.NotContains(t, buffer.String(), "preparing to process file")
@@ -149,17 +207,22 @@ func Test_Unzip_FilePasswordIsWrong_UploadsErrorDocument(t *testing.T) {
 	zipPath := filepath.Join("..", "mocks", "test_data", "passworded.zip")
 	zipReader, err := zip.OpenReader(zipPath)
 
-	mockZipClient.On("OpenReader", mock.Anything).Return(zipReader, nil)
+	mockZipClient.On("NewReader", mock.Anything, mock.Anything).Return(&zipReader.Reader, nil)
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
 	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
 
 	zipHandler := ZipHandler{
 		credentialGetter: mockCredentialGetter,
 		zipClient:        mockZipClient,
 		blobHandler:      mockBlobHandler,
+		partnerId:        partnerId,
+		partnerSettings:  config.PartnerSettings{HasZipPassword: true},
 	}
 
-	err = zipHandler.Unzip(filename, blobPath)
+	err = zipHandler.Unzip(zipMagicReader(), zipMagicSize, blobPath, nil)
 
 	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
 	mockBlobHandler.AssertCalled(t, "UploadFile", mock.Anything, unzipFailurePath+".txt")
@@ -182,18 +245,23 @@ func Test_Unzip_UnzippedFileCannotBeUploaded_ReturnsError(t *testing.T) {
 	zipPath := filepath.Join("..", "mocks", "test_data", "passworded.zip")
 	zipReader, err := zip.OpenReader(zipPath)
 
-	mockZipClient.On("OpenReader", mock.Anything).Return(zipReader, nil)
+	mockZipClient.On("NewReader", mock.Anything, mock.Anything).Return(&zipReader.Reader, nil)
 
 	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(errors.New("error"))
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("error"))
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
 	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
 
 	zipHandler := ZipHandler{
 		credentialGetter: mockCredentialGetter,
 		blobHandler:      mockBlobHandler,
 		zipClient:        mockZipClient,
+		partnerId:        partnerId,
+		partnerSettings:  config.PartnerSettings{HasZipPassword: true},
 	}
 
-	err = zipHandler.Unzip(filename, blobPath)
+	err = zipHandler.Unzip(zipMagicReader(), zipMagicSize, blobPath, nil)
 
 	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
 	assert.Contains(t, buffer.String(), "setting password")
@@ -202,6 +270,107 @@ func Test_Unzip_UnzippedFileCannotBeUploaded_ReturnsError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func Test_Unzip_PartnerHasNoZipPassword_DoesNotGetSecret(t *testing.T) {
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockBlobHandler := new(mocks.MockBlobHandler)
+	mockZipClient := new(MockZipClient)
+
+	zipPath := filepath.Join("..", "mocks", "test_data", "unprotected.zip")
+	zipReader, err := zip.OpenReader(zipPath)
+
+	mockZipClient.On("NewReader", mock.Anything, mock.Anything).Return(&zipReader.Reader, nil)
+
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
+
+	zipHandler := ZipHandler{
+		credentialGetter: mockCredentialGetter,
+		blobHandler:      mockBlobHandler,
+		zipClient:        mockZipClient,
+		partnerId:        partnerId,
+	}
+
+	err = zipHandler.Unzip(zipMagicReader(), zipMagicSize, blobPath, nil)
+
+	assert.NoError(t, err)
+	mockCredentialGetter.AssertNotCalled(t, "GetSecret", mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipSuccessUrl)
+}
+
+func Test_Unzip_FileIsEncryptedAndPartnerHasNoZipPassword_UploadsErrorDocument(t *testing.T) {
+	buffer, defaultLogger := utils.SetupLogger()
+	defer slog.SetDefault(defaultLogger)
+
+	mockCredentialGetter := new(mocks.MockCredentialGetter)
+	mockBlobHandler := new(mocks.MockBlobHandler)
+	mockZipClient := new(MockZipClient)
+
+	zipPath := filepath.Join("..", "mocks", "test_data", "passworded.zip")
+	zipReader, err := zip.OpenReader(zipPath)
+
+	mockZipClient.On("NewReader", mock.Anything, mock.Anything).Return(&zipReader.Reader, nil)
+
+	mockBlobHandler.On("UploadFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("UploadFileWithMetadata", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("CopyFile", mock.Anything, mock.Anything).Return(nil)
+	mockBlobHandler.On("DeleteFile", mock.Anything).Return(nil)
+	mockBlobHandler.On("MoveFile", mock.Anything, mock.Anything).Return(nil)
+
+	zipHandler := ZipHandler{
+		credentialGetter: mockCredentialGetter,
+		blobHandler:      mockBlobHandler,
+		zipClient:        mockZipClient,
+		partnerId:        partnerId,
+	}
+
+	err = zipHandler.Unzip(zipMagicReader(), zipMagicSize, blobPath, nil)
+
+	assert.NoError(t, err)
+	assert.Contains(t, buffer.String(), "File is encrypted but the partner has no zip password")
+	assert.NotContains(t, buffer.String(), "setting password for file")
+	mockCredentialGetter.AssertNotCalled(t, "GetSecret", mock.Anything)
+	mockBlobHandler.AssertCalled(t, "MoveFile", mock.Anything, unzipFailureUrl)
+	mockBlobHandler.AssertCalled(t, "UploadFile", mock.MatchedBy(func(contents []byte) bool {
+		return strings.Contains(string(contents), "no zip password is configured for partner flexion")
+	}), unzipFailurePath+".txt")
+}
+
+func Test_Unzip_ArchiveGoesToFailure_QueuesRejectedAcknowledgement(t *testing.T) {
+	configtest.SetUpConfigs(t, map[string]config.PartnerSettings{partnerId: {
+		Acknowledgements: config.AcknowledgementSettings{Format: config.AcknowledgementFormatCsv, Directory: "acks"},
+	}})
+
+	archiveBytes := buildTestZip(t, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+	zipHandler, _, archive := zipHandlerForTestArchive(config.PartnerSettings{RequireManifest: true}, new(mocks.MockCredentialGetter), archiveBytes)
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	mockAcknowledgementQueue.On("QueueAcknowledgement", mock.Anything).Return(nil)
+	zipHandler.acknowledgementQueue = mockAcknowledgementQueue
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	acknowledgement := mockAcknowledgementQueue.Calls[0].Arguments.Get(0).(usecases.Acknowledgement)
+	assert.Equal(t, partnerId, acknowledgement.PartnerId)
+	assert.Equal(t, filename, acknowledgement.FileName)
+	assert.Equal(t, unzipFailureUrl, acknowledgement.SourceUrl)
+	assert.Equal(t, usecases.AcknowledgementStatusRejected, acknowledgement.Status)
+	assert.Contains(t, acknowledgement.Reasons, filename+": partner flexion requires a manifest, but there isn't one in or next to the archive")
+}
+
+func Test_Unzip_ArchiveIsUnzipped_DoesNotQueueAcknowledgement(t *testing.T) {
+	zipHandler, _, archive := zipHandlerForTestZip(t, config.ZipLimitSettings{}, testZipEntry{name: "order.hl7", contents: []byte("MSH|order")})
+	mockAcknowledgementQueue := &MockAcknowledgementQueue{}
+	zipHandler.acknowledgementQueue = mockAcknowledgementQueue
+
+	err := zipHandler.Unzip(archive, archive.Size(), blobPath, nil)
+
+	assert.NoError(t, err)
+	mockAcknowledgementQueue.AssertNotCalled(t, "QueueAcknowledgement", mock.Anything)
+}
+
 func Test_MoveZip_MoveZipSuccessful(t *testing.T) {
 	buffer, defaultLogger := utils.SetupLogger()
 	defer slog.SetDefault(defaultLogger)
@@ -250,7 +419,23 @@ type MockZipClient struct {
 	mock.Mock
 }
 
-func (mockZipClient *MockZipClient) OpenReader(name string) (*zip.ReadCloser, error) {
-	args := mockZipClient.Called(name)
-	return args.Get(0).(*zip.ReadCloser), args.Error(1)
+// zipMagicReader has just enough of a zip file for Unzip to detect its format
+func zipMagicReader() io.ReaderAt {
+	return bytes.NewReader(zipMagicNumber)
+}
+
+var zipMagicSize = int64(len(zipMagicNumber))
+
+func (mockZipClient *MockZipClient) NewReader(archive io.ReaderAt, size int64) (*zip.Reader, error) {
+	args := mockZipClient.Called(archive, size)
+	return args.Get(0).(*zip.Reader), args.Error(1)
+}
+
+type MockAcknowledgementQueue struct {
+	mock.Mock
+}
+
+func (receiver *MockAcknowledgementQueue) QueueAcknowledgement(acknowledgement usecases.Acknowledgement) error {
+	args := receiver.Called(acknowledgement)
+	return args.Error(0)
 }